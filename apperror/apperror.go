@@ -0,0 +1,178 @@
+package apperror
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// Kind classifies an error, it decides the status and the code clients see.
+type Kind string
+
+const (
+	KindNotFound         Kind = "not_found"
+	KindValidation       Kind = "validation"
+	KindConflict         Kind = "conflict"
+	KindStorage          Kind = "storage"
+	KindUnauthorized     Kind = "unauthorized"
+	KindForbidden        Kind = "forbidden"
+	KindMethodNotAllowed Kind = "method_not_allowed"
+	KindTooLarge         Kind = "too_large"
+	KindUpstream         Kind = "upstream"
+	KindTimeout          Kind = "timeout"
+	KindInternal         Kind = "internal"
+	// the kinds below answer the resumable uploads, in the statuses of the tus protocol
+	KindPrecondition     Kind = "precondition_failed"
+	KindUnsupportedMedia Kind = "unsupported_media_type"
+	KindLocked           Kind = "locked"
+	KindChecksumMismatch Kind = "checksum_mismatch"
+)
+
+// StatusChecksumMismatch is the status tus answers a chunk failing its checksum with.
+const StatusChecksumMismatch = 460
+
+var kindStatus = map[Kind]int{
+	KindNotFound:         http.StatusNotFound,
+	KindValidation:       http.StatusBadRequest,
+	KindConflict:         http.StatusConflict,
+	KindStorage:          http.StatusInternalServerError,
+	KindUnauthorized:     http.StatusUnauthorized,
+	KindForbidden:        http.StatusForbidden,
+	KindMethodNotAllowed: http.StatusMethodNotAllowed,
+	KindTooLarge:         http.StatusRequestEntityTooLarge,
+	KindUpstream:         http.StatusBadGateway,
+	KindTimeout:          http.StatusGatewayTimeout,
+	KindInternal:         http.StatusInternalServerError,
+	KindPrecondition:     http.StatusPreconditionFailed,
+	KindUnsupportedMedia: http.StatusUnsupportedMediaType,
+	KindLocked:           http.StatusLocked,
+	KindChecksumMismatch: StatusChecksumMismatch,
+}
+
+func (k Kind) Status() int {
+	if status, ok := kindStatus[k]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error clients can be told about. Message is written for them,
+// Err is the cause and only goes to the logs.
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+}
+
+// Sentinels to test the kind of an error with errors.Is.
+var (
+	ErrNotFound   = &Error{Kind: KindNotFound}
+	ErrValidation = &Error{Kind: KindValidation}
+	ErrConflict   = &Error{Kind: KindConflict}
+	ErrStorage    = &Error{Kind: KindStorage}
+)
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel of the same kind.
+func (e *Error) Is(target error) bool {
+	sentinel, ok := target.(*Error)
+	return ok && sentinel.Message == "" && sentinel.Err == nil && sentinel.Kind == e.Kind
+}
+
+func New(kind Kind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func NotFound(message string, err error) *Error {
+	return New(KindNotFound, message, err)
+}
+
+func Validation(message string, err error) *Error {
+	return New(KindValidation, message, err)
+}
+
+func Conflict(message string, err error) *Error {
+	return New(KindConflict, message, err)
+}
+
+// Storage reports a failure of the database or of the filesystem.
+func Storage(message string, err error) *Error {
+	return New(KindStorage, message, err)
+}
+
+func Unauthorized(message string) *Error {
+	return New(KindUnauthorized, message, nil)
+}
+
+func Forbidden(message string) *Error {
+	return New(KindForbidden, message, nil)
+}
+
+func MethodNotAllowed() *Error {
+	return New(KindMethodNotAllowed, "Method not allowed", nil)
+}
+
+// Lookup reports a failed lookup, a not found when the record or the file is
+// missing and a storage error otherwise.
+func Lookup(message string, err error) *Error {
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, os.ErrNotExist) {
+		return NotFound(message, err)
+	}
+	return Storage("Error reading storage", err)
+}
+
+// From maps any error to the one clients are told about. Errors of unknown
+// origin become internal errors, their text never reaches clients.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return New(KindTooLarge, "Request body too large", err)
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, os.ErrNotExist):
+		return NotFound("Not found", err)
+	}
+	return New(KindInternal, "Internal server error", err)
+}
+
+// Problem is the application/problem+json body of an error response (RFC 9457).
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      Kind   `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *Error) Problem(requestID string) Problem {
+	status := e.Kind.Status()
+	title := http.StatusText(status)
+	if status == StatusChecksumMismatch {
+		title = "Checksum Mismatch"
+	}
+	return Problem{
+		Type:      "about:blank",
+		Title:     title,
+		Status:    status,
+		Code:      e.Kind,
+		Message:   e.Message,
+		RequestID: requestID,
+	}
+}
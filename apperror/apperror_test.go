@@ -0,0 +1,124 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestKindStatus(t *testing.T) {
+	cases := map[Kind]int{
+		KindNotFound:         http.StatusNotFound,
+		KindValidation:       http.StatusBadRequest,
+		KindConflict:         http.StatusConflict,
+		KindStorage:          http.StatusInternalServerError,
+		KindUnauthorized:     http.StatusUnauthorized,
+		KindForbidden:        http.StatusForbidden,
+		KindMethodNotAllowed: http.StatusMethodNotAllowed,
+		KindTooLarge:         http.StatusRequestEntityTooLarge,
+		KindUpstream:         http.StatusBadGateway,
+		KindTimeout:          http.StatusGatewayTimeout,
+		KindInternal:         http.StatusInternalServerError,
+		KindPrecondition:     http.StatusPreconditionFailed,
+		KindUnsupportedMedia: http.StatusUnsupportedMediaType,
+		KindLocked:           http.StatusLocked,
+		KindChecksumMismatch: StatusChecksumMismatch,
+		Kind("unknown"):      http.StatusInternalServerError,
+	}
+	for kind, want := range cases {
+		if got := kind.Status(); got != want {
+			t.Errorf("%s: status %d, want %d", kind, got, want)
+		}
+	}
+	if len(kindStatus) != len(cases)-1 {
+		t.Errorf("%d kinds mapped, %d tested", len(kindStatus), len(cases)-1)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	cause := errors.New("disk full")
+	stored := Storage("Error saving the movie", cause)
+	tooLarge := &http.MaxBytesError{Limit: 10}
+
+	cases := []struct {
+		name    string
+		err     error
+		kind    Kind
+		message string
+	}{
+		{"an app error", stored, KindStorage, "Error saving the movie"},
+		{"a wrapped app error", fmt.Errorf("saving: %w", stored), KindStorage, "Error saving the movie"},
+		{"a body too large", fmt.Errorf("reading: %w", tooLarge), KindTooLarge, "Request body too large"},
+		{"a missing record", gorm.ErrRecordNotFound, KindNotFound, "Not found"},
+		{"a missing file", fmt.Errorf("open: %w", os.ErrNotExist), KindNotFound, "Not found"},
+		{"anything else", cause, KindInternal, "Internal server error"},
+	}
+	for _, c := range cases {
+		got := From(c.err)
+		if got.Kind != c.kind || got.Message != c.message {
+			t.Errorf("%s: %s %q, want %s %q", c.name, got.Kind, got.Message, c.kind, c.message)
+		}
+		if !errors.Is(got, c.err) && !errors.Is(c.err, got) {
+			t.Errorf("%s: the cause is lost", c.name)
+		}
+	}
+}
+
+func TestIs(t *testing.T) {
+	err := fmt.Errorf("saving: %w", Storage("Error saving the movie", errors.New("disk full")))
+	if !errors.Is(err, ErrStorage) {
+		t.Error("a storage error is not ErrStorage")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("a storage error is ErrNotFound")
+	}
+	if errors.Is(err, Storage("Error saving the movie", nil)) {
+		t.Error("errors with a message match each other")
+	}
+
+	if got := Lookup("No such movie", gorm.ErrRecordNotFound); got.Kind != KindNotFound || got.Message != "No such movie" {
+		t.Errorf("Lookup of a missing record: %+v", got)
+	}
+	if got := Lookup("No such movie", errors.New("connection refused")); got.Kind != KindStorage {
+		t.Errorf("Lookup of a failing database: %+v", got)
+	}
+}
+
+func TestProblem(t *testing.T) {
+	err := Conflict("The title is taken", errors.New("UNIQUE constraint failed: movies.title"))
+	body, jsonErr := json.Marshal(err.Problem("req-1"))
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"type":      "about:blank",
+		"title":     "Conflict",
+		"status":    float64(http.StatusConflict),
+		"code":      "conflict",
+		"message":   "The title is taken",
+		"requestId": "req-1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problem %s, want %v", body, want)
+	}
+	// the cause is for the logs only
+	if strings.Contains(string(body), "UNIQUE") {
+		t.Errorf("the cause reaches clients: %s", body)
+	}
+
+	checksum, _ := json.Marshal(New(KindChecksumMismatch, "Checksum mismatch", nil).Problem(""))
+	if !strings.Contains(string(checksum), `"title":"Checksum Mismatch"`) || strings.Contains(string(checksum), "requestId") {
+		t.Errorf("checksum problem %s", checksum)
+	}
+}
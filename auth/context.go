@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go-cinema/model"
+	repo "go-cinema/repository"
+)
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user *model.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// CurrentUser returns the user the request was authenticated as.
+func CurrentUser(ctx context.Context) (*model.User, bool) {
+	user, ok := ctx.Value(userKey{}).(*model.User)
+	return user, ok && user != nil
+}
+
+// Authenticate resolves the bearer token of r. A request without one is anonymous,
+// which is a nil user and no error.
+func Authenticate(r *http.Request) (*model.User, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := ParseAccessToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	id, err := claims.UserID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted users lose access before their tokens expire.
+	user, err := repo.UserRepository.FindByID(id)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return user, nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignores everything after 72 bytes, longer passwords are refused instead.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+var ErrWeakPassword = errors.New("password must be between 8 and 72 characters")
+
+// dummyHash is checked against when there is no user to check the password of.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
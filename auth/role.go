@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+
+	"go-cinema/model"
+	repo "go-cinema/repository"
+
+	"gorm.io/gorm"
+)
+
+// Permission is what a route requires of the user calling it.
+type Permission string
+
+const (
+	// PermWatch covers playback progress and the personal lists.
+	PermWatch Permission = "watch"
+	// PermCast sends media to renderers and controls them.
+	PermCast Permission = "cast"
+	// PermEdit adds and edits movies, series and episodes, and scans the library.
+	PermEdit Permission = "edit"
+	// PermDelete removes media, files included.
+	PermDelete Permission = "delete"
+	// PermAdmin runs system tasks and manages users.
+	PermAdmin Permission = "admin"
+)
+
+var rolePermissions = map[string][]Permission{
+	model.RoleAdmin:  {PermWatch, PermCast, PermEdit, PermDelete, PermAdmin},
+	model.RoleEditor: {PermWatch, PermCast, PermEdit},
+	model.RoleViewer: {PermWatch, PermCast},
+	model.RoleKid:    {PermWatch},
+}
+
+var (
+	ErrInvalidRole = errors.New("role must be one of admin, editor, viewer or kid")
+	ErrLastAdmin   = errors.New("the last admin cannot be demoted")
+)
+
+func Can(user *model.User, permission Permission) bool {
+	if user == nil {
+		return false
+	}
+	for _, granted := range rolePermissions[user.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// roleMu makes counting the admins and demoting one atomic, so two admins
+// demoting each other at once cannot leave none.
+var roleMu sync.Mutex
+
+// SetRole changes the role of a user, keeping at least one admin.
+func SetRole(id uint, role string) (*model.User, error) {
+	if !model.IsRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	roleMu.Lock()
+	defer roleMu.Unlock()
+
+	user, err := repo.UserRepository.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role == model.RoleAdmin && role != model.RoleAdmin {
+		admins, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+			return db.Where("role = ?", model.RoleAdmin)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if admins.Size() <= 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+
+	user.Role = role
+	if err := repo.UserRepository.Save(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go-cinema/database/dbtest"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSetRoleConcurrentDemotions(t *testing.T) {
+	dbtest.Open(t)
+
+	const n = 8
+	var ids []uint
+	for i := 0; i < n; i++ {
+		user, err := Register(model.UserRequest{
+			Username: fmt.Sprintf("admin%d", i),
+			Email:    fmt.Sprintf("admin%d@example.com", i),
+			Password: "secretsecret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SetRole(user.ID, model.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+
+	// every admin demotes itself at once, over a few rounds to meet the race
+	for round := 0; round < 20; round++ {
+		for _, id := range ids {
+			if err := repo.DB.Model(&model.User{}).Where("id = ?", id).Update("role", model.RoleAdmin).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		start := make(chan struct{})
+		for _, id := range ids {
+			wg.Add(1)
+			go func(id uint) {
+				defer wg.Done()
+				<-start
+				_, err := SetRole(id, model.RoleViewer)
+				errs <- err
+			}(id)
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+		refused := 0
+		for err := range errs {
+			switch {
+			case errors.Is(err, ErrLastAdmin):
+				refused++
+			case err != nil:
+				t.Fatalf("SetRole: %v", err)
+			}
+		}
+
+		admins, err := repo.Count[model.User](func(db *gorm.DB) *gorm.DB {
+			return db.Where("role = ?", model.RoleAdmin)
+		})
+		if err != nil || admins != 1 || refused != 1 {
+			t.Fatalf("round %d: %d admins, %d demotions refused, %v, want exactly one", round, admins, refused, err)
+		}
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	dbtest.Open(t)
+
+	admin, err := Register(model.UserRequest{Username: "alice", Email: "alice@example.com", Password: "secretsecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetRole(admin.ID, "owner"); err != ErrInvalidRole {
+		t.Errorf("unknown role: %v, want ErrInvalidRole", err)
+	}
+	if _, err := SetRole(admin.ID, model.RoleEditor); err != ErrLastAdmin {
+		t.Errorf("demoting the only admin: %v, want ErrLastAdmin", err)
+	}
+	if _, err := SetRole(admin.ID+1, model.RoleEditor); err == nil {
+		t.Error("changed the role of nobody")
+	}
+}
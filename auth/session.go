@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	entity "go-cinema/entities"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("username or email already taken")
+	ErrMissingFields      = errors.New("username, email and password are required")
+)
+
+var (
+	// refreshMu makes rotating a refresh token atomic, so one token cannot be traded twice.
+	refreshMu sync.Mutex
+	// registerMu makes checking the accounts and creating one atomic, so two
+	// registrations on a fresh install cannot both become admin.
+	registerMu sync.Mutex
+)
+
+func Register(request model.UserRequest) (*model.User, error) {
+	username := strings.TrimSpace(request.Username)
+	email := strings.TrimSpace(request.Email)
+	if username == "" || email == "" || request.Password == "" {
+		return nil, ErrMissingFields
+	}
+
+	hash, err := HashPassword(request.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	taken, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("username = ? OR email = ?", username, email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if taken.Size() > 0 {
+		return nil, ErrUserExists
+	}
+
+	// Whoever sets the server up first gets to administer it.
+	role := model.RoleViewer
+	existing, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Limit(1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing.Size() == 0 {
+		role = model.RoleAdmin
+	}
+
+	user := &model.User{Username: username, Email: email, Password: hash, Role: role}
+	if err := repo.UserRepository.Save(user); err != nil {
+		return nil, err
+	}
+	// The positions of a library migrated before anyone registered were kept for
+	// the first account.
+	if role == model.RoleAdmin {
+		if err := entity.MigrateProgress(repo.DB); err != nil {
+			golog.Warn("Cannot give the playback positions of the library to {}: {}", user.Username, err.Error())
+		}
+	}
+	return user, nil
+}
+
+// Login checks the credentials and opens a new session.
+func Login(username, password string) (*model.LoginResponse, error) {
+	users, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("username = ?", strings.TrimSpace(username))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if users.Size() == 0 {
+		// Spend the same time as for a wrong password, not to tell which usernames exist.
+		CheckPassword(string(dummyHash()), password)
+		return nil, ErrInvalidCredentials
+	}
+
+	user := users.ToSlice()[0]
+	if !CheckPassword(user.Password, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return Issue(&user)
+}
+
+// Issue creates an access token and a new refresh token for user.
+func Issue(user *model.User) (*model.LoginResponse, error) {
+	accessToken, err := NewAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.TokenRepository.Save(&model.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.LoginResponse{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// Refresh trades a refresh token for a new pair. The old token is revoked; seeing
+// it again means it was stolen, so every session of its user is closed.
+func Refresh(refreshToken string) (*model.LoginResponse, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	stored, err := findToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		golog.Warn("Revoked refresh token reused for user {}, closing all sessions", stored.UserID)
+		if err := revokeAll(stored.UserID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := repo.UserRepository.FindByID(stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := revoke(stored); err != nil {
+		return nil, err
+	}
+	return Issue(user)
+}
+
+// Logout revokes a refresh token. Access tokens stay valid until they expire.
+func Logout(refreshToken string) error {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	stored, err := findToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	if stored.RevokedAt != nil {
+		return nil
+	}
+	return revoke(stored)
+}
+
+func findToken(refreshToken string) (*model.RefreshToken, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	tokens, err := repo.TokenRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("token_hash = ?", hashToken(refreshToken))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tokens.Size() == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	token := tokens.ToSlice()[0]
+	return &token, nil
+}
+
+// SetPassword changes the password of a user and ends every session of theirs.
+func SetPassword(id uint, password string) (*model.User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := repo.UserRepository.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = hash
+	if err := repo.UserRepository.Save(user); err != nil {
+		return nil, err
+	}
+	return user, revokeAll(user.ID)
+}
+
+func revoke(token *model.RefreshToken) error {
+	now := time.Now()
+	token.RevokedAt = &now
+	return repo.TokenRepository.Save(token)
+}
+
+func revokeAll(userID uint) error {
+	tokens, err := repo.TokenRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND revoked_at IS NULL", userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens.ToSlice() {
+		if err := revoke(&token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
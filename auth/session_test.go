@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"fmt"
+	"go-cinema/database/dbtest"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestRegisterConcurrentFirstAdmin(t *testing.T) {
+	dbtest.Open(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := Register(model.UserRequest{
+				Username: fmt.Sprintf("user%d", i),
+				Email:    fmt.Sprintf("user%d@example.com", i),
+				Password: "secretsecret",
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+
+	admins, err := repo.Count[model.User](func(db *gorm.DB) *gorm.DB {
+		return db.Where("role = ?", model.RoleAdmin)
+	})
+	if err != nil || admins != 1 {
+		t.Fatalf("%d admins, %v, want exactly one", admins, err)
+	}
+}
+
+func TestRegisterTaken(t *testing.T) {
+	dbtest.Open(t)
+
+	request := model.UserRequest{Username: "alice", Email: "alice@example.com", Password: "secretsecret"}
+	if _, err := Register(request); err != nil {
+		t.Fatal(err)
+	}
+	request.Email = "other@example.com"
+	if _, err := Register(request); err != ErrUserExists {
+		t.Fatalf("second registration: %v, want ErrUserExists", err)
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// StreamTokenTTL is how long a stream token plays, a feature film with a pause or two.
+const StreamTokenTTL = 4 * time.Hour
+
+// streamAudience keeps stream tokens from passing as access tokens, and the other way round.
+const streamAudience = "stream"
+
+// Kinds of media a stream token plays.
+const (
+	StreamMovie   = "movie"
+	StreamEpisode = "episode"
+)
+
+func streamSubject(kind string, id uint) string {
+	return fmt.Sprintf("%s/%d", kind, id)
+}
+
+// NewStreamToken signs a token playing a single movie or episode, for the clients
+// which cannot send the Authorization header, as DLNA renderers and video elements.
+func NewStreamToken(kind string, id uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(StreamTokenTTL)
+	claims := jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   streamSubject(kind, id),
+		Audience:  jwt.ClaimStrings{streamAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey())
+	return token, expiresAt, err
+}
+
+// CheckStreamToken tells whether token plays the movie or episode id.
+func CheckStreamToken(token, kind string, id uint) error {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return signingKey(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(streamAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.Subject != streamSubject(kind, id) {
+		return ErrInvalidToken
+	}
+	return nil
+}
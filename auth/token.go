@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-cinema/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kashari/golog"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	issuer = "go-cinema"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+var (
+	secret     []byte
+	secretOnce sync.Once
+)
+
+// Claims are carried by the access tokens.
+type Claims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// SetSecret sets the key signing the access tokens. It must be called before
+// the first token is issued, an empty key leaves the random one.
+func SetSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretOnce.Do(func() {
+		secret = []byte(value)
+	})
+}
+
+// signingKey is the key of SetSecret, or a random key when none was set, in which
+// case every token is lost on restart.
+func signingKey() []byte {
+	secretOnce.Do(func() {
+		golog.Warn("No JWT secret configured, tokens will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	})
+	return secret
+}
+
+func NewAccessToken(user *model.User) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Username: user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey())
+}
+
+func ParseAccessToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return signingKey(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	// stream tokens carry an audience, access tokens none
+	if err != nil || len(claims.Audience) > 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
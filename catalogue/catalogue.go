@@ -0,0 +1,212 @@
+package catalogue
+
+import (
+	"encoding/json"
+	"fmt"
+	entity "go-cinema/entities"
+	"go-cinema/model"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Version is the format of the catalogues this build writes and reads.
+const Version = 1
+
+// Catalogue is the library, and optionally the accounts, as exported to JSON.
+// Rows keep their IDs, so the watch progress still points at the right movies
+// and episodes when imported into another database.
+type Catalogue struct {
+	Version    int                    `json:"version"`
+	ExportedAt time.Time              `json:"exportedAt"`
+	Movies     []Movie                `json:"movies"`
+	Series     []Series               `json:"series"`
+	Episodes   []Episode              `json:"episodes"`
+	Users      []User                 `json:"users,omitempty"`
+	Progress   []entity.WatchProgress `json:"progress,omitempty"`
+}
+
+// User is an account with its password hash, which model.User never serializes.
+type User struct {
+	model.User
+	PasswordHash string `json:"passwordHash"`
+}
+
+// Movie is a movie with the path of its file, which entity.Movie never serializes.
+type Movie struct {
+	entity.Movie
+	Path string `json:"Path"`
+}
+
+// Series is a series with its directory, which entity.Series never serializes.
+type Series struct {
+	entity.Series
+	BaseDir string `json:"BaseDir"`
+}
+
+// Episode is an episode with the path of its file, which entity.Episode never
+// serializes.
+type Episode struct {
+	entity.Episode
+	Path string `json:"Path"`
+}
+
+// Counts is how many rows of each kind were exported or imported.
+type Counts struct {
+	Movies, Series, Episodes, Users, Progress int
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%d movies, %d series, %d episodes, %d users and %d progress entries",
+		c.Movies, c.Series, c.Episodes, c.Users, c.Progress)
+}
+
+// Export writes the library to w, with the users and their progress when
+// withUsers is set. Deleted rows are left out.
+func Export(db *gorm.DB, w io.Writer, withUsers bool) (Counts, error) {
+	c := Catalogue{Version: Version, ExportedAt: time.Now().UTC()}
+
+	var movies []entity.Movie
+	if err := db.Order("id").Find(&movies).Error; err != nil {
+		return Counts{}, err
+	}
+	for _, movie := range movies {
+		c.Movies = append(c.Movies, Movie{Movie: movie, Path: movie.Path})
+	}
+	var series []entity.Series
+	if err := db.Order("id").Find(&series).Error; err != nil {
+		return Counts{}, err
+	}
+	for _, serie := range series {
+		c.Series = append(c.Series, Series{Series: serie, BaseDir: serie.BaseDir})
+	}
+	var episodes []entity.Episode
+	if err := db.Order("id").Find(&episodes).Error; err != nil {
+		return Counts{}, err
+	}
+	for _, episode := range episodes {
+		c.Episodes = append(c.Episodes, Episode{Episode: episode, Path: episode.Path})
+	}
+
+	if withUsers {
+		var users []model.User
+		if err := db.Order("id").Find(&users).Error; err != nil {
+			return Counts{}, err
+		}
+		for _, user := range users {
+			c.Users = append(c.Users, User{User: user, PasswordHash: user.Password})
+		}
+		if err := db.Order("id").Find(&c.Progress).Error; err != nil {
+			return Counts{}, err
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return Counts{}, err
+	}
+	return c.counts(), nil
+}
+
+func (c *Catalogue) counts() Counts {
+	return Counts{
+		Movies:   len(c.Movies),
+		Series:   len(c.Series),
+		Episodes: len(c.Episodes),
+		Users:    len(c.Users),
+		Progress: len(c.Progress),
+	}
+}
+
+// Import reads a catalogue from r and inserts its rows in one transaction. Rows
+// whose ID is already taken are skipped, so importing twice changes nothing.
+// It returns how many rows were inserted.
+func Import(db *gorm.DB, r io.Reader) (Counts, error) {
+	var c Catalogue
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return Counts{}, fmt.Errorf("reading the catalogue: %w", err)
+	}
+	if c.Version != Version {
+		return Counts{}, fmt.Errorf("catalogue version %d is not supported, expected %d", c.Version, Version)
+	}
+
+	for i := range c.Users {
+		c.Users[i].User.Password = c.Users[i].PasswordHash
+	}
+	movies := make([]entity.Movie, len(c.Movies))
+	for i, movie := range c.Movies {
+		movies[i] = movie.Movie
+		movies[i].Path = movie.Path
+	}
+	series := make([]entity.Series, len(c.Series))
+	for i, serie := range c.Series {
+		series[i] = serie.Series
+		series[i].BaseDir = serie.BaseDir
+		series[i].Episodes = nil
+	}
+	episodes := make([]entity.Episode, len(c.Episodes))
+	for i, episode := range c.Episodes {
+		episodes[i] = episode.Episode
+		episodes[i].Path = episode.Path
+	}
+
+	var counts Counts
+	err := db.Transaction(func(tx *gorm.DB) error {
+		insert := func(rows any) (int, error) {
+			result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 100)
+			return int(result.RowsAffected), result.Error
+		}
+
+		var err error
+		if len(c.Users) > 0 {
+			users := make([]model.User, len(c.Users))
+			for i, user := range c.Users {
+				users[i] = user.User
+			}
+			if counts.Users, err = insert(&users); err != nil {
+				return err
+			}
+		}
+		if len(movies) > 0 {
+			if counts.Movies, err = insert(&movies); err != nil {
+				return err
+			}
+		}
+		if len(series) > 0 {
+			if counts.Series, err = insert(&series); err != nil {
+				return err
+			}
+		}
+		if len(episodes) > 0 {
+			if counts.Episodes, err = insert(&episodes); err != nil {
+				return err
+			}
+		}
+		if len(c.Progress) > 0 {
+			if counts.Progress, err = insert(&c.Progress); err != nil {
+				return err
+			}
+		}
+		return resetSequences(tx)
+	})
+	return counts, err
+}
+
+// resetSequences moves the PostgreSQL ID sequences past the imported IDs, which
+// were inserted explicitly. MySQL and SQLite keep track by themselves.
+func resetSequences(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	for _, table := range []string{"users", "movies", "series", "episodes", "watch_progresses"} {
+		err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s", table)).Error
+		if err != nil {
+			return fmt.Errorf("resetting the sequence of %s: %w", table, err)
+		}
+	}
+	return nil
+}
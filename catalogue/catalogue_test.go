@@ -0,0 +1,65 @@
+package catalogue
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-cinema/database/dbtest"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	db := dbtest.Open(t)
+	serie := entity.Series{Title: "Serie", BaseDir: "/media/series/Serie"}
+	if err := repo.SeriesRepository.Save(&serie); err != nil {
+		t.Fatal(err)
+	}
+	movie := entity.Movie{Title: "Movie", Path: "/media/movies/movie.mkv"}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatal(err)
+	}
+	episode := entity.Episode{Path: "/media/series/Serie/e01.mkv", EpisodeIndex: 1, SeriesID: serie.ID}
+	if err := repo.EpisodeRepository.Save(&episode); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entities keep their paths to themselves, the catalogue does not
+	api, _ := json.Marshal(movie)
+	if strings.Contains(string(api), movie.Path) {
+		t.Errorf("movie serialized with its path: %s", api)
+	}
+
+	var out bytes.Buffer
+	if _, err := Export(db, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{movie.Path, serie.BaseDir, episode.Path} {
+		if !strings.Contains(out.String(), path) {
+			t.Errorf("%s missing from the catalogue", path)
+		}
+	}
+
+	dbtest.Open(t)
+	counts, err := Import(db, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Movies != 1 || counts.Series != 1 || counts.Episodes != 1 {
+		t.Fatalf("imported %s", counts)
+	}
+
+	foundMovie, err := repo.MovieRepository.FindByID(movie.ID)
+	if err != nil || foundMovie.Path != movie.Path {
+		t.Errorf("movie path %q, %v", foundMovie.Path, err)
+	}
+	foundSerie, err := repo.SeriesRepository.FindByID(serie.ID)
+	if err != nil || foundSerie.BaseDir != serie.BaseDir {
+		t.Errorf("serie directory %q, %v", foundSerie.BaseDir, err)
+	}
+	foundEpisode, err := repo.EpisodeRepository.FindByID(episode.ID)
+	if err != nil || foundEpisode.Path != episode.Path {
+		t.Errorf("episode path %q, %v", foundEpisode.Path, err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go-cinema/config"
+	"go-cinema/database"
+	repo "go-cinema/repository"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+)
+
+// runner runs a command with its positional arguments.
+type runner func(a *app, args []string) error
+
+// command is a subcommand of the CLI, or a group of subcommands when it has any.
+// setup registers the flags of the command and returns what runs it.
+type command struct {
+	name        string
+	args        string
+	summary     string
+	setup       func(fs *flag.FlagSet) runner
+	subcommands []*command
+}
+
+// errUsage makes the command print its usage.
+var errUsage = errors.New("invalid usage")
+
+// app is what commands share: the configuration and a database opened on first use.
+type app struct {
+	cfg   *config.Config
+	flags *config.Flags
+	db    *gorm.DB
+}
+
+func (a *app) database() (*gorm.DB, error) {
+	if a.db != nil {
+		return a.db, nil
+	}
+
+	db, err := database.Open(a.cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	repo.InitRepositories(db)
+	a.db = db
+	return db, nil
+}
+
+// close releases the database, if one was opened.
+func (a *app) close() error {
+	if a.db == nil {
+		return nil
+	}
+	sqlDB, err := a.db.DB()
+	a.db = nil
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+var program = filepath.Base(os.Args[0])
+
+func (c *command) find(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// resolve walks args down the command tree, returning the command they name,
+// its full name and the arguments left.
+func (c *command) resolve(args []string) (*command, string, []string) {
+	cmd, path := c, program
+	for len(args) > 0 && len(cmd.subcommands) > 0 {
+		sub := cmd.find(args[0])
+		if sub == nil {
+			break
+		}
+		cmd, path, args = sub, path+" "+sub.name, args[1:]
+	}
+	return cmd, path, args
+}
+
+func (c *command) usage(w io.Writer, path string, fs *flag.FlagSet) {
+	usage := "usage: " + path
+	if fs != nil && hasFlags(fs) {
+		usage += " [flags]"
+	}
+	if len(c.subcommands) > 0 {
+		usage += " COMMAND"
+	}
+	if c.args != "" {
+		usage += " " + c.args
+	}
+	fmt.Fprintln(w, usage)
+	if c.summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.summary)
+	}
+
+	if len(c.subcommands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.name, firstLine(sub.summary))
+		}
+		_ = tw.Flush()
+	}
+	if fs != nil && hasFlags(fs) {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// run runs the command args name, parsing its flags. It returns the exit status.
+func (c *command) run(a *app, args []string) int {
+	cmd, path, args := c.resolve(args)
+	if cmd.setup == nil {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", path, args[0])
+		}
+		cmd.usage(os.Stderr, path, nil)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	run := cmd.setup(fs)
+	fs.Usage = func() { cmd.usage(os.Stderr, path, fs) }
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			cmd.usage(os.Stdout, path, fs)
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n\n", path, err)
+		fs.Usage()
+		return 2
+	}
+
+	err := run(a, fs.Args())
+	switch {
+	case errors.Is(err, errUsage):
+		fs.Usage()
+		return 2
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	return 0
+}
+
+// helpCommand prints the usage of the command named by its arguments.
+func helpCommand(root *command) *command {
+	return &command{
+		name:    "help",
+		args:    "[COMMAND...]",
+		summary: "Show the help of a command.",
+		setup: func(fs *flag.FlagSet) runner {
+			return func(_ *app, args []string) error {
+				cmd, path, rest := root.resolve(args)
+				if len(rest) > 0 {
+					return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+				}
+				switch {
+				case cmd == root:
+					root.usage(os.Stdout, path, flag.CommandLine)
+					return nil
+				case cmd.setup == nil:
+					cmd.usage(os.Stdout, path, nil)
+					return nil
+				}
+
+				cmdFlags := flag.NewFlagSet(path, flag.ContinueOnError)
+				cmd.setup(cmdFlags)
+				cmd.usage(os.Stdout, path, cmdFlags)
+				return nil
+			}
+		},
+	}
+}
+
+// table writes aligned columns to stdout, the first row as header.
+func table(rows [][]string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read when no file is given, if it exists.
+const DefaultPath = "theatre.yaml"
+
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Media    Media    `yaml:"media"`
+	Auth     Auth     `yaml:"auth"`
+	DLNA     DLNA     `yaml:"dlna"`
+	Log      Log      `yaml:"log"`
+}
+
+type Server struct {
+	Port         int           `yaml:"port"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// ShutdownTimeout is how long open requests, streams included, get to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// Drivers of the supported databases.
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+)
+
+type Database struct {
+	Driver   string `yaml:"driver"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	// SSLMode is the sslmode of PostgreSQL connections
+	SSLMode string `yaml:"sslMode"`
+	// Path is the file of an SQLite database, :memory: for one lasting as long as the process
+	Path string `yaml:"path"`
+}
+
+// Media is where uploads are stored and the library is scanned.
+type Media struct {
+	Movies    string `yaml:"movies"`
+	Series    string `yaml:"series"`
+	UsageData string `yaml:"usageData"`
+	// Uploads is the staging directory of resumable uploads, best on the file system of the media
+	Uploads string `yaml:"uploads"`
+}
+
+type Auth struct {
+	// JWTSecret signs the access tokens, a random one is used when empty
+	JWTSecret string `yaml:"jwtSecret"`
+}
+
+// DLNA is who the media server answers. Browsing hands out stream links to the
+// whole library without an account, so only the local network does by default.
+type DLNA struct {
+	// Clients are the networks, in CIDR notation, of the devices allowed to browse
+	Clients []string `yaml:"clients"`
+}
+
+// Prefixes parses the networks of the allowed clients.
+func (d DLNA) Prefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(d.Clients))
+	for _, client := range d.Clients {
+		prefix, err := netip.ParsePrefix(client)
+		if err != nil {
+			return nil, fmt.Errorf("dlna.clients: %w", err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+type Log struct {
+	Path string `yaml:"path"`
+}
+
+// Default is the configuration of a server with no file, environment or flags.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:            9090,
+			ReadTimeout:     60 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: Database{
+			Driver:  Postgres,
+			Host:    "localhost",
+			User:    "theatre",
+			Name:    "theatre",
+			SSLMode: "disable",
+			Path:    "theatre.db",
+		},
+		Media: Media{
+			Movies:    "media",
+			Series:    filepath.Join("media", "Series"),
+			UsageData: filepath.Join("media", "usage_data.io"),
+			Uploads:   filepath.Join("media", ".uploads"),
+		},
+		DLNA: DLNA{
+			// loopback, private and link-local networks
+			Clients: []string{
+				"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+				"::1/128", "fc00::/7", "fe80::/10",
+			},
+		},
+		Log: Log{
+			Path: filepath.Join(os.TempDir(), "theatre.log"),
+		},
+	}
+}
+
+// Load reads the configuration: the defaults, overridden by the YAML file at path,
+// overridden by the THEATRE_* environment. An empty path reads DefaultPath when it exists.
+// Unknown keys in the file are errors, so a misspelt setting is not silently ignored.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath
+	}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		defer f.Close()
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		// an empty file keeps the defaults
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// env lists the variables overriding the configuration, by name.
+func (c *Config) env() map[string]any {
+	return map[string]any{
+		"THEATRE_PORT":             &c.Server.Port,
+		"THEATRE_READ_TIMEOUT":     &c.Server.ReadTimeout,
+		"THEATRE_WRITE_TIMEOUT":    &c.Server.WriteTimeout,
+		"THEATRE_SHUTDOWN_TIMEOUT": &c.Server.ShutdownTimeout,
+		"THEATRE_DB_DRIVER":        &c.Database.Driver,
+		"THEATRE_DB_HOST":          &c.Database.Host,
+		"THEATRE_DB_PORT":          &c.Database.Port,
+		"THEATRE_DB_USER":          &c.Database.User,
+		"THEATRE_DB_PASSWORD":      &c.Database.Password,
+		"THEATRE_DB_NAME":          &c.Database.Name,
+		"THEATRE_DB_SSLMODE":       &c.Database.SSLMode,
+		"THEATRE_DB_PATH":          &c.Database.Path,
+		"THEATRE_MOVIES_DIR":       &c.Media.Movies,
+		"THEATRE_SERIES_DIR":       &c.Media.Series,
+		"THEATRE_USAGE_DATA":       &c.Media.UsageData,
+		"THEATRE_UPLOADS_DIR":      &c.Media.Uploads,
+		"THEATRE_JWT_SECRET":       &c.Auth.JWTSecret,
+		"THEATRE_DLNA_CLIENTS":     &c.DLNA.Clients,
+		"THEATRE_LOG":              &c.Log.Path,
+	}
+}
+
+func (c *Config) loadEnv() error {
+	for name, field := range c.env() {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := set(field, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func set(field any, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field = parsed
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d is not a valid port", c.Server.Port))
+	}
+	if c.Server.ReadTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server timeouts must be positive"))
+	}
+	errs = append(errs, c.Database.validate()...)
+	if c.Media.Movies == "" || c.Media.Series == "" || c.Media.Uploads == "" {
+		errs = append(errs, errors.New("media.movies, media.series and media.uploads are required"))
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		errs = append(errs, errors.New("auth.jwtSecret must be at least 32 bytes"))
+	}
+	if _, err := c.DLNA.Prefixes(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Log.Path == "" {
+		errs = append(errs, errors.New("log.path is required"))
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Database) validate() []error {
+	var errs []error
+	switch d.Driver {
+	case Postgres, MySQL:
+		if d.Host == "" || d.User == "" || d.Name == "" {
+			errs = append(errs, fmt.Errorf("database.host, database.user and database.name are required by %s", d.Driver))
+		}
+		if d.Port < 0 || d.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port %d is not a valid port", d.Port))
+		}
+	case SQLite:
+		if d.Path == "" {
+			errs = append(errs, errors.New("database.path is required by sqlite"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q is not one of %s, %s or %s", d.Driver, Postgres, MySQL, SQLite))
+	}
+	return errs
+}
+
+// Flags are the command line flags overriding the file and the environment.
+type Flags struct {
+	fs   *flag.FlagSet
+	path string
+	cfg  Config
+}
+
+// BindFlags registers the configuration flags on fs, read them with Load once fs is parsed.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.path, "config", os.Getenv("THEATRE_CONFIG"), "The configuration file, "+DefaultPath+" when it exists.")
+	fs.IntVar(&f.cfg.Server.Port, "port", 0, "The port to listen on.")
+	fs.StringVar(&f.cfg.Database.Driver, "db-driver", "", "The database driver: postgres, mysql or sqlite.")
+	fs.StringVar(&f.cfg.Database.Host, "db-host", "", "The database host.")
+	fs.StringVar(&f.cfg.Database.User, "db-user", "", "The database user.")
+	fs.StringVar(&f.cfg.Database.Name, "db-name", "", "The database name.")
+	fs.StringVar(&f.cfg.Database.Path, "db-path", "", "The SQLite database file.")
+	fs.StringVar(&f.cfg.Media.Movies, "movies-dir", "", "The directory of the movies.")
+	fs.StringVar(&f.cfg.Media.Series, "series-dir", "", "The directory of the series.")
+	fs.StringVar(&f.cfg.Log.Path, "log", "", "The log file.")
+	return f
+}
+
+// Load reads the configuration of the -config flag and applies the flags set
+// on the command line, then validates it.
+func (f *Flags) Load() (*Config, error) {
+	cfg, err := Load(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "port":
+			cfg.Server.Port = f.cfg.Server.Port
+		case "db-driver":
+			cfg.Database.Driver = f.cfg.Database.Driver
+		case "db-host":
+			cfg.Database.Host = f.cfg.Database.Host
+		case "db-user":
+			cfg.Database.User = f.cfg.Database.User
+		case "db-name":
+			cfg.Database.Name = f.cfg.Database.Name
+		case "db-path":
+			cfg.Database.Path = f.cfg.Database.Path
+		case "movies-dir":
+			cfg.Media.Movies = f.cfg.Media.Movies
+		case "series-dir":
+			cfg.Media.Series = f.cfg.Media.Series
+		case "log":
+			cfg.Log.Path = f.cfg.Log.Path
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// Redacted is the configuration safe to log, the secrets masked.
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = "***"
+	}
+	if c.Auth.JWTSecret != "" {
+		c.Auth.JWTSecret = "***"
+	}
+	return c
+}
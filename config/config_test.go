@@ -0,0 +1,158 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// file writes content to a configuration file of the test's own.
+func file(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "theatre.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv unsets the THEATRE_* variables of the environment the tests run in.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for name := range Default().env() {
+		if value, ok := os.LookupEnv(name); ok {
+			t.Setenv(name, value)
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := file(t, `
+server:
+  port: 8000
+  readTimeout: 5s
+database:
+  driver: sqlite
+  host: from-file
+media:
+  movies: /file/movies
+`)
+	t.Setenv("THEATRE_PORT", "8001")
+	t.Setenv("THEATRE_DB_HOST", "from-env")
+	t.Setenv("THEATRE_DLNA_CLIENTS", "10.0.0.0/8, ,192.168.1.0/24")
+
+	fs := flag.NewFlagSet("theatre", flag.ContinueOnError)
+	flags := BindFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-port", "8002"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != 8002 {
+		t.Errorf("port %d, want the flag's", cfg.Server.Port)
+	}
+	if cfg.Database.Host != "from-env" {
+		t.Errorf("host %q, want the environment's", cfg.Database.Host)
+	}
+	if cfg.Server.ReadTimeout != 5*time.Second || cfg.Media.Movies != "/file/movies" || cfg.Database.Driver != SQLite {
+		t.Errorf("the file is not applied: %+v", cfg)
+	}
+	if want := Default(); cfg.Server.WriteTimeout != want.Server.WriteTimeout || cfg.Media.Series != want.Media.Series {
+		t.Errorf("the defaults are not kept: %+v", cfg)
+	}
+	if want := []string{"10.0.0.0/8", "192.168.1.0/24"}; !reflect.DeepEqual(cfg.DLNA.Clients, want) {
+		t.Errorf("clients %q, want %q", cfg.DLNA.Clients, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	clearEnv(t)
+
+	if _, err := Load(file(t, "server:\n  prot: 8000\n")); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("an unknown key: %v", err)
+	}
+	if _, err := Load(file(t, "server: [")); err == nil {
+		t.Error("invalid YAML accepted")
+	}
+	if cfg, err := Load(file(t, "")); err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("an empty file: %+v, %v", cfg, err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("a missing file given explicitly is accepted")
+	}
+	// the example shipped with the server loads as it is
+	if cfg, err := Load("../theatre.example.yaml"); err != nil {
+		t.Errorf("theatre.example.yaml: %v", err)
+	} else if err := cfg.Validate(); err != nil {
+		t.Errorf("theatre.example.yaml: %v", err)
+	}
+
+	t.Setenv("THEATRE_PORT", "eighty")
+	if _, err := Load(file(t, "")); err == nil || !strings.Contains(err.Error(), "THEATRE_PORT") {
+		t.Errorf("an invalid variable: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("the defaults are invalid: %v", err)
+	}
+
+	cases := map[string]func(*Config){
+		"server.port":     func(c *Config) { c.Server.Port = 70000 },
+		"timeouts":        func(c *Config) { c.Server.ShutdownTimeout = 0 },
+		"database.driver": func(c *Config) { c.Database.Driver = "oracle" },
+		"database.host":   func(c *Config) { c.Database.Host = "" },
+		"database.port":   func(c *Config) { c.Database.Port = -1 },
+		"database.path":   func(c *Config) { c.Database.Driver, c.Database.Path = SQLite, "" },
+		"media.uploads":   func(c *Config) { c.Media.Uploads = "" },
+		"auth.jwtSecret":  func(c *Config) { c.Auth.JWTSecret = "short" },
+		"dlna.clients":    func(c *Config) { c.DLNA.Clients = []string{"10.0.0.0"} },
+		"log.path":        func(c *Config) { c.Log.Path = "" },
+	}
+	for want, invalidate := range cases {
+		cfg := Default()
+		invalidate(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v", want, err)
+		}
+	}
+
+	// every invalid setting is reported at once
+	cfg := Default()
+	cfg.Server.Port = 0
+	cfg.Log.Path = ""
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "server.port") || !strings.Contains(err.Error(), "log.path") {
+		t.Errorf("two invalid settings: %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "hunter2"
+	cfg.Auth.JWTSecret = strings.Repeat("s", 32)
+
+	redacted := cfg.Redacted()
+	if redacted.Database.Password != "***" || redacted.Auth.JWTSecret != "***" {
+		t.Errorf("secrets shown: %+v", redacted)
+	}
+	if cfg.Database.Password != "hunter2" {
+		t.Error("redacting changed the configuration")
+	}
+	if redacted.Database.Host != cfg.Database.Host {
+		t.Error("redacting changed more than the secrets")
+	}
+	if empty := Default().Redacted(); empty.Database.Password != "" || empty.Auth.JWTSecret != "" {
+		t.Errorf("unset secrets shown as set: %+v", empty)
+	}
+}
@@ -133,3 +133,16 @@ func startJob(stopChan chan struct{}, task func() error, interval string) {
 		}
 	}
 }
+
+// Stop stops the job if it is running, for the server shutting down.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !running {
+		return
+	}
+	close(stopTaskChan)
+	running = false
+	golog.Info("Job stopped")
+}
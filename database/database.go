@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"go-cinema/config"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// memory is the SQLite path of a database living in memory
+const memory = ":memory:"
+
+// Open connects to the database of cfg. SQLite databases are embedded, the
+// file is created with its directory when missing.
+func Open(cfg config.Database) (*gorm.DB, error) {
+	dialector, err := Dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// every connection to :memory: opens a database of its own
+	if cfg.Driver == config.SQLite && cfg.Path == memory {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func Dialector(cfg config.Database) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case config.Postgres:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.User, cfg.Password),
+			Host:     address(cfg.Host, cfg.Port),
+			Path:     "/" + cfg.Name,
+			RawQuery: url.Values{"sslmode": {cfg.SSLMode}}.Encode(),
+		}
+		return postgres.Open(dsn.String()), nil
+
+	case config.MySQL:
+		dsn := mysqldriver.NewConfig()
+		dsn.User = cfg.User
+		dsn.Passwd = cfg.Password
+		dsn.Net = "tcp"
+		dsn.Addr = address(cfg.Host, cfg.Port)
+		dsn.DBName = cfg.Name
+		dsn.ParseTime = true
+		dsn.Loc = time.Local
+		dsn.Params = map[string]string{"charset": "utf8mb4"}
+		return mysql.Open(dsn.FormatDSN()), nil
+
+	case config.SQLite:
+		if cfg.Path != memory {
+			if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+				return nil, err
+			}
+		}
+		// writers wait for each other instead of failing with SQLITE_BUSY
+		return sqlite.Open(cfg.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+}
+
+// address joins host and port, leaving the port to the driver default when 0.
+func address(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
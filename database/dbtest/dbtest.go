@@ -0,0 +1,84 @@
+// Package dbtest opens the database tests run against: SQLite in memory, or the
+// server of THEATRE_TEST_DB_DRIVER, configured by the THEATRE_DB_* variables, to
+// run the same tests on every backend. The rows of a server database are deleted
+// by each test, give it a database of its own.
+package dbtest
+
+import (
+	"fmt"
+	"go-cinema/config"
+	"go-cinema/database"
+	"go-cinema/migrations"
+	repo "go-cinema/repository"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+// tables lists the tables emptied before each test, referencing ones first.
+var tables = []string{"watch_progresses", "refresh_tokens", "episodes", "series", "movies", "users"}
+
+var (
+	once    sync.Once
+	db      *gorm.DB
+	openErr error
+)
+
+// Open returns the migrated test database, empty, with the repositories on it.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	once.Do(func() {
+		db, openErr = open()
+	})
+	if openErr != nil {
+		t.Fatalf("opening the test database: %v", openErr)
+	}
+	for _, table := range tables {
+		if err := db.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatalf("emptying %s: %v", table, err)
+		}
+	}
+	return db
+}
+
+// Driver is the backend the tests run against.
+func Driver() string {
+	if driver := os.Getenv("THEATRE_TEST_DB_DRIVER"); driver != "" {
+		return driver
+	}
+	return config.SQLite
+}
+
+func open() (*gorm.DB, error) {
+	// the code under test logs, to the console only
+	if err := golog.Init(os.DevNull); err != nil {
+		return nil, err
+	}
+
+	cfg := config.Database{Driver: config.SQLite, Path: ":memory:"}
+	if Driver() != config.SQLite {
+		loaded, err := config.Load("")
+		if err != nil {
+			return nil, err
+		}
+		cfg = loaded.Database
+		cfg.Driver = Driver()
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(); err != nil {
+		return nil, fmt.Errorf("migrating: %w", err)
+	}
+	repo.InitRepositories(db)
+	return db, nil
+}
@@ -0,0 +1,179 @@
+package dlna
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport states reported by GetTransportInfo.
+const (
+	StateStopped        = "STOPPED"
+	StatePlaying        = "PLAYING"
+	StatePaused         = "PAUSED_PLAYBACK"
+	StateTransitioning  = "TRANSITIONING"
+	StateNoMediaPresent = "NO_MEDIA_PRESENT"
+)
+
+// AVTransport is a SOAP client for the AVTransport service of a renderer.
+type AVTransport struct {
+	ControlURL string
+	Client     *http.Client
+}
+
+// PositionInfo is the subset of GetPositionInfo a control point needs to track progress.
+type PositionInfo struct {
+	TrackDuration time.Duration
+	RelTime       time.Duration
+}
+
+// AVTransport returns a client for the renderer's AVTransport service.
+func (cp *ControlPoint) AVTransport(renderer *Renderer) *AVTransport {
+	return &AVTransport{ControlURL: renderer.avTransportURL, Client: cp.Client}
+}
+
+// SetURI loads a media URL on the renderer. The metadata is a DIDL-Lite document,
+// many TVs refuse URIs without one.
+func (t *AVTransport) SetURI(ctx context.Context, uri, metadata string) error {
+	_, err := t.call(ctx, "SetAVTransportURI", []soapArg{
+		{"InstanceID", "0"},
+		{"CurrentURI", uri},
+		{"CurrentURIMetaData", metadata},
+	})
+	return err
+}
+
+func (t *AVTransport) Play(ctx context.Context) error {
+	_, err := t.call(ctx, "Play", []soapArg{{"InstanceID", "0"}, {"Speed", "1"}})
+	return err
+}
+
+func (t *AVTransport) Pause(ctx context.Context) error {
+	_, err := t.call(ctx, "Pause", []soapArg{{"InstanceID", "0"}})
+	return err
+}
+
+func (t *AVTransport) Stop(ctx context.Context) error {
+	_, err := t.call(ctx, "Stop", []soapArg{{"InstanceID", "0"}})
+	return err
+}
+
+// Seek jumps to an absolute position of the current track.
+func (t *AVTransport) Seek(ctx context.Context, position time.Duration) error {
+	_, err := t.call(ctx, "Seek", []soapArg{
+		{"InstanceID", "0"},
+		{"Unit", "REL_TIME"},
+		{"Target", FormatDuration(position)},
+	})
+	return err
+}
+
+func (t *AVTransport) GetPositionInfo(ctx context.Context) (PositionInfo, error) {
+	out, err := t.call(ctx, "GetPositionInfo", []soapArg{{"InstanceID", "0"}})
+	if err != nil {
+		return PositionInfo{}, err
+	}
+
+	// Renderers answer NOT_IMPLEMENTED or an empty string when they do not know.
+	duration, _ := ParseDuration(out["TrackDuration"])
+	position, _ := ParseDuration(out["RelTime"])
+	return PositionInfo{TrackDuration: duration, RelTime: position}, nil
+}
+
+// GetTransportState returns the CurrentTransportState, one of the State constants.
+func (t *AVTransport) GetTransportState(ctx context.Context) (string, error) {
+	out, err := t.call(ctx, "GetTransportInfo", []soapArg{{"InstanceID", "0"}})
+	if err != nil {
+		return "", err
+	}
+	return out["CurrentTransportState"], nil
+}
+
+type soapFault struct {
+	Body struct {
+		Fault struct {
+			FaultString string `xml:"faultstring"`
+			Detail      struct {
+				UPnPError struct {
+					Code        int    `xml:"errorCode"`
+					Description string `xml:"errorDescription"`
+				} `xml:"UPnPError"`
+			} `xml:"detail"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// call invokes a SOAP action and returns its output arguments.
+func (t *AVTransport) call(ctx context.Context, action string, args []soapArg) (map[string]string, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s="%s" s:encodingStyle="%s"><s:Body>`, soapEnvelopeNS, soapEncodingNS)
+	fmt.Fprintf(&b, `<u:%s xmlns:u="%s">`, action, AVTransportType)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg.Name)
+		_ = xml.EscapeText(&b, []byte(arg.Value))
+		fmt.Fprintf(&b, "</%s>", arg.Name)
+	}
+	fmt.Fprintf(&b, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.ControlURL, &b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("Soapaction", fmt.Sprintf(`"%s#%s"`, AVTransportType, action))
+	req.Header.Set("User-Agent", ServerHeader())
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var fault soapFault
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if xml.Unmarshal(body, &fault) == nil && fault.Body.Fault.Detail.UPnPError.Code != 0 {
+			upnp := fault.Body.Fault.Detail.UPnPError
+			return nil, fmt.Errorf("%s: %w", action, &upnpError{upnp.Code, upnp.Description})
+		}
+		return nil, fmt.Errorf("%s: renderer returned %s", action, resp.Status)
+	}
+
+	return readSOAPArgs(resp.Body)
+}
+
+// ParseDuration parses the H+:MM:SS[.F+] time format used by AVTransport.
+func ParseDuration(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// FormatDuration formats a duration as HH:MM:SS, the form renderers accept as a seek target.
+func FormatDuration(d time.Duration) string {
+	total := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60)
+}
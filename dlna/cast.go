@@ -0,0 +1,252 @@
+package dlna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	// pollInterval is how often the position of a running cast is queried.
+	pollInterval = 5 * time.Second
+	// maxPollFailures ends a cast once the renderer stops answering, e.g. the TV was switched off.
+	maxPollFailures = 6
+)
+
+var ErrNoSession = errors.New("nothing is being cast to this renderer")
+
+// CastRequest describes what to play on a renderer.
+type CastRequest struct {
+	Renderer string
+	Title    string
+	// Kind and ID name the movie or episode, auth.StreamMovie or auth.StreamEpisode
+	Kind string
+	ID   uint
+	Path string
+	// StartAt is the position playback resumes from.
+	StartAt time.Duration
+	// OnProgress is called with the position reported by the renderer while it plays.
+	OnProgress func(position, duration time.Duration)
+}
+
+// CastSession is a playback started by the control point on one renderer.
+type CastSession struct {
+	Renderer  Renderer  `json:"renderer"`
+	Title     string    `json:"title"`
+	URL       string    `json:"-"` // carries a stream token, for the renderer only
+	State     string    `json:"state"`
+	Position  string    `json:"position"`
+	Duration  string    `json:"duration"`
+	StartedAt time.Time `json:"startedAt"`
+
+	transport  *AVTransport
+	startAt    time.Duration
+	onProgress func(position, duration time.Duration)
+	cancel     context.CancelFunc
+}
+
+// Cast points the renderer at the stream URL of the media, starts playback and
+// follows it until the renderer stops.
+func (cp *ControlPoint) Cast(ctx context.Context, req CastRequest) (*CastSession, error) {
+	renderer, err := cp.Renderer(req.Renderer)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := localAddrFor(renderer)
+	if err != nil {
+		return nil, fmt.Errorf("no route to renderer: %w", err)
+	}
+
+	res := mediaResAt(net.JoinHostPort(ip.String(), strconv.Itoa(cp.HTTPPort)), req.Kind, req.ID, req.Path)
+	metadata, err := marshalDIDL(&didlItem{
+		ID:         "0",
+		ParentID:   "-1",
+		Restricted: "1",
+		Title:      req.Title,
+		Class:      classMovie,
+		Res:        res,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transport := cp.AVTransport(renderer)
+	if err := transport.SetURI(ctx, res.URL, string(metadata)); err != nil {
+		return nil, err
+	}
+	if err := transport.Play(ctx); err != nil {
+		return nil, err
+	}
+
+	pollCtx, cancel := context.WithCancel(context.Background())
+	session := &CastSession{
+		Renderer:   *renderer,
+		Title:      req.Title,
+		URL:        res.URL,
+		State:      StateTransitioning,
+		StartedAt:  time.Now(),
+		transport:  transport,
+		startAt:    req.StartAt,
+		onProgress: req.OnProgress,
+		cancel:     cancel,
+	}
+
+	cp.mu.Lock()
+	if previous, ok := cp.sessions[renderer.UDN]; ok {
+		previous.cancel()
+	}
+	cp.sessions[renderer.UDN] = session
+	snapshot := *session
+	cp.mu.Unlock()
+
+	golog.Info("Casting {} to {}", req.Title, renderer.FriendlyName)
+	go cp.poll(pollCtx, session)
+
+	return &snapshot, nil
+}
+
+// Sessions returns the casts currently followed by the control point.
+func (cp *ControlPoint) Sessions() []CastSession {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	list := make([]CastSession, 0, len(cp.sessions))
+	for _, session := range cp.sessions {
+		list = append(list, *session)
+	}
+	return list
+}
+
+func (cp *ControlPoint) session(udn string) (*CastSession, error) {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	session, ok := cp.sessions[udn]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return session, nil
+}
+
+func (cp *ControlPoint) Play(ctx context.Context, udn string) error {
+	session, err := cp.session(udn)
+	if err != nil {
+		return err
+	}
+	return session.transport.Play(ctx)
+}
+
+func (cp *ControlPoint) Pause(ctx context.Context, udn string) error {
+	session, err := cp.session(udn)
+	if err != nil {
+		return err
+	}
+	return session.transport.Pause(ctx)
+}
+
+func (cp *ControlPoint) Seek(ctx context.Context, udn string, position time.Duration) error {
+	session, err := cp.session(udn)
+	if err != nil {
+		return err
+	}
+	return session.transport.Seek(ctx, position)
+}
+
+// Stop stops playback on the renderer and ends the cast. The last known position
+// has already been reported through OnProgress.
+func (cp *ControlPoint) Stop(ctx context.Context, udn string) error {
+	session, err := cp.session(udn)
+	if err != nil {
+		return err
+	}
+
+	cp.report(ctx, session)
+	cp.end(session)
+	return session.transport.Stop(ctx)
+}
+
+func (cp *ControlPoint) end(session *CastSession) {
+	session.cancel()
+
+	cp.mu.Lock()
+	if cp.sessions[session.Renderer.UDN] == session {
+		delete(cp.sessions, session.Renderer.UDN)
+	}
+	cp.mu.Unlock()
+}
+
+// poll follows a cast, seeking to the resume position once playback has started
+// and feeding the reported position back until the renderer stops.
+func (cp *ControlPoint) poll(ctx context.Context, session *CastSession) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	started := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		state, err := session.transport.GetTransportState(ctx)
+		if err != nil {
+			failures++
+			golog.Warn("Polling {} failed: {}", session.Renderer.FriendlyName, err.Error())
+			if failures >= maxPollFailures {
+				golog.Warn("Renderer {} stopped answering, ending cast", session.Renderer.FriendlyName)
+				cp.end(session)
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		cp.mu.Lock()
+		session.State = state
+		cp.mu.Unlock()
+
+		switch state {
+		case StatePlaying, StatePaused:
+			if !started {
+				started = true
+				if session.startAt > 0 {
+					if err := session.transport.Seek(ctx, session.startAt); err != nil {
+						golog.Warn("Could not resume {} at {}: {}", session.Title, FormatDuration(session.startAt), err.Error())
+					}
+					continue
+				}
+			}
+			cp.report(ctx, session)
+		case StateStopped, StateNoMediaPresent:
+			if started {
+				golog.Info("Cast of {} to {} finished", session.Title, session.Renderer.FriendlyName)
+				cp.end(session)
+				return
+			}
+		}
+	}
+}
+
+func (cp *ControlPoint) report(ctx context.Context, session *CastSession) {
+	info, err := session.transport.GetPositionInfo(ctx)
+	if err != nil || info.RelTime == 0 {
+		return
+	}
+
+	cp.mu.Lock()
+	session.Position = FormatDuration(info.RelTime)
+	session.Duration = FormatDuration(info.TrackDuration)
+	cp.mu.Unlock()
+
+	if session.onProgress != nil {
+		session.onProgress(info.RelTime, info.TrackDuration)
+	}
+}
@@ -0,0 +1,60 @@
+package dlna
+
+import (
+	"go-cinema/stream"
+	"net/http"
+	"strings"
+
+	"github.com/kashari/golog"
+)
+
+var connectionManagerActions = map[string]actionHandler{
+	"GetProtocolInfo":          getProtocolInfo,
+	"GetCurrentConnectionIDs":  getCurrentConnectionIDs,
+	"GetCurrentConnectionInfo": getCurrentConnectionInfo,
+}
+
+// ConnectionManagerControl handles SOAP control requests for the ConnectionManager service.
+func ConnectionManagerControl(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /dlna/control/ConnectionManager handler, method: {}", r.Method)
+	serveSOAP(w, r, ConnectionManagerType, connectionManagerActions)
+}
+
+// ConnectionManagerSCPD serves the ConnectionManager service description.
+func ConnectionManagerSCPD(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", ServerHeader())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(connectionManagerSCPD))
+}
+
+// getProtocolInfo reports every format the server can deliver. A media server is
+// a pure source, so the sink list is always empty.
+func getProtocolInfo(r *http.Request, args map[string]string) ([]soapArg, error) {
+	return []soapArg{
+		{"Source", strings.Join(stream.ProtocolInfos(), ",")},
+		{"Sink", ""},
+	}, nil
+}
+
+// getCurrentConnectionIDs reports the single implicit connection, since the
+// server does not implement PrepareForConnection.
+func getCurrentConnectionIDs(r *http.Request, args map[string]string) ([]soapArg, error) {
+	return []soapArg{{"ConnectionIDs", "0"}}, nil
+}
+
+func getCurrentConnectionInfo(r *http.Request, args map[string]string) ([]soapArg, error) {
+	if args["ConnectionID"] != "0" {
+		return nil, &upnpError{errInvalidConnection, "Invalid connection reference"}
+	}
+
+	return []soapArg{
+		{"RcsID", "-1"},
+		{"AVTransportID", "-1"},
+		{"ProtocolInfo", ""},
+		{"PeerConnectionManager", ""},
+		{"PeerConnectionID", "-1"},
+		{"Direction", "Output"},
+		{"Status", "OK"},
+	}, nil
+}
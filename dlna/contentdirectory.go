@@ -0,0 +1,857 @@
+package dlna
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"go-cinema/sandbox"
+	"go-cinema/stream"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+const (
+	rootID   = "0"
+	moviesID = "movies"
+	seriesID = "series"
+
+	classFolder = "object.container.storageFolder"
+	classMovie  = "object.item.videoItem.movie"
+	classVideo  = "object.item.videoItem"
+)
+
+// systemUpdateID changes whenever the library does, letting renderers invalidate their caches.
+var systemUpdateID atomic.Uint32
+
+// mediaRoots are the only directories the sizes of the files are read in.
+var mediaRoots atomic.Pointer[sandbox.Set]
+
+// SetMediaRoots confines the files the media server reads to roots.
+func SetMediaRoots(roots *sandbox.Set) {
+	mediaRoots.Store(roots)
+}
+
+func init() {
+	systemUpdateID.Store(1)
+}
+
+// BumpSystemUpdateID signals control points that the library content has changed.
+func BumpSystemUpdateID() {
+	systemUpdateID.Add(1)
+}
+
+type didlLite struct {
+	XMLName  xml.Name `xml:"DIDL-Lite"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	DCNS     string   `xml:"xmlns:dc,attr"`
+	UPnPNS   string   `xml:"xmlns:upnp,attr"`
+	DLNANS   string   `xml:"xmlns:dlna,attr"`
+	Children []any
+}
+
+type didlContainer struct {
+	XMLName    xml.Name `xml:"container"`
+	ID         string   `xml:"id,attr"`
+	ParentID   string   `xml:"parentID,attr"`
+	Restricted string   `xml:"restricted,attr"`
+	Searchable string   `xml:"searchable,attr"`
+	ChildCount int      `xml:"childCount,attr"`
+	Title      string   `xml:"dc:title"`
+	Class      string   `xml:"upnp:class"`
+}
+
+type didlItem struct {
+	XMLName     xml.Name `xml:"item"`
+	ID          string   `xml:"id,attr"`
+	ParentID    string   `xml:"parentID,attr"`
+	Restricted  string   `xml:"restricted,attr"`
+	Title       string   `xml:"dc:title"`
+	Description string   `xml:"dc:description,omitempty"`
+	Class       string   `xml:"upnp:class"`
+	Res         didlRes  `xml:"res"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+func marshalDIDL(children ...any) ([]byte, error) {
+	return xml.Marshal(didlLite{
+		XMLNS:    "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		DCNS:     "http://purl.org/dc/elements/1.1/",
+		UPnPNS:   "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		DLNANS:   "urn:schemas-dlna-org:metadata-1-0/",
+		Children: children,
+	})
+}
+
+// object is a node of the browse tree before it is rendered to DIDL-Lite.
+type object struct {
+	container *didlContainer
+	item      *didlItem
+}
+
+func (o object) title() string {
+	if o.container != nil {
+		return o.container.Title
+	}
+	return o.item.Title
+}
+
+func (o object) class() string {
+	if o.container != nil {
+		return o.container.Class
+	}
+	return o.item.Class
+}
+
+func (o object) didl() any {
+	if o.container != nil {
+		return o.container
+	}
+	return o.item
+}
+
+var contentDirectoryActions = map[string]actionHandler{
+	"Browse":                browse,
+	"Search":                search,
+	"GetSearchCapabilities": getSearchCapabilities,
+	"GetSortCapabilities":   getSortCapabilities,
+	"GetSystemUpdateID":     getSystemUpdateID,
+}
+
+// ContentDirectoryControl handles SOAP control requests for the ContentDirectory service.
+func ContentDirectoryControl(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /dlna/control/ContentDirectory handler, method: {}", r.Method)
+	serveSOAP(w, r, ContentDirectoryType, contentDirectoryActions)
+}
+
+// ContentDirectorySCPD serves the ContentDirectory service description.
+func ContentDirectorySCPD(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", ServerHeader())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(contentDirectorySCPD))
+}
+
+// EventSubscription answers SUBSCRIBE/UNSUBSCRIBE requests. The server does not
+// push events, but several renderers refuse to browse until a subscription succeeds.
+func EventSubscription(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		sid := r.Header.Get("Sid")
+		if sid == "" {
+			sid = newSID()
+		}
+		w.Header().Set("Sid", sid)
+		w.Header().Set("Timeout", "Second-1800")
+		w.Header().Set("Server", ServerHeader())
+		w.WriteHeader(http.StatusOK)
+	case "UNSUBSCRIBE":
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func newSID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func getSearchCapabilities(r *http.Request, args map[string]string) ([]soapArg, error) {
+	return []soapArg{{"SearchCaps", "dc:title,upnp:class"}}, nil
+}
+
+func getSortCapabilities(r *http.Request, args map[string]string) ([]soapArg, error) {
+	return []soapArg{{"SortCaps", "dc:title"}}, nil
+}
+
+func getSystemUpdateID(r *http.Request, args map[string]string) ([]soapArg, error) {
+	return []soapArg{{"Id", strconv.FormatUint(uint64(systemUpdateID.Load()), 10)}}, nil
+}
+
+func browse(r *http.Request, args map[string]string) ([]soapArg, error) {
+	objectID := args["ObjectID"]
+	start, count, err := pageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []section
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		obj, err := lookupObject(r, objectID)
+		if err != nil {
+			return nil, err
+		}
+		sections = []section{fixedSection(obj)}
+	case "BrowseDirectChildren":
+		sections, err = children(r, objectID, parseSortCriteria(args["SortCriteria"]))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &upnpError{errInvalidArgs, "Invalid BrowseFlag"}
+	}
+
+	return didlResult(sections, start, count)
+}
+
+func search(r *http.Request, args map[string]string) ([]soapArg, error) {
+	start, count, err := pageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	criteria, err := parseSearchCriteria(args["SearchCriteria"])
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := descendants(r, args["ContainerID"], criteria, parseSortCriteria(args["SortCriteria"]))
+	if err != nil {
+		return nil, err
+	}
+
+	return didlResult(sections, start, count)
+}
+
+func pageArgs(args map[string]string) (int, int, error) {
+	start, err := optionalUint(args["StartingIndex"])
+	if err != nil {
+		return 0, 0, &upnpError{errInvalidArgs, "Invalid StartingIndex"}
+	}
+	count, err := optionalUint(args["RequestedCount"])
+	if err != nil {
+		return 0, 0, &upnpError{errInvalidArgs, "Invalid RequestedCount"}
+	}
+	return start, count, nil
+}
+
+func optionalUint(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 31)
+	return int(v), err
+}
+
+// section is a run of objects of one kind. Sections backed by a table are counted
+// and paged by the database, so a page never loads more rows than it returns.
+type section struct {
+	count func() (int, error)
+	fetch func(offset, limit int) ([]object, error)
+}
+
+// fixedSection lists objects that are not stored, the containers of the root.
+func fixedSection(objects ...object) section {
+	return section{
+		count: func() (int, error) {
+			return len(objects), nil
+		},
+		fetch: func(offset, limit int) ([]object, error) {
+			return objects[offset : offset+limit], nil
+		},
+	}
+}
+
+// didlResult answers the page of count objects from start, the sections laid end to end.
+func didlResult(sections []section, start, count int) ([]soapArg, error) {
+	var page []object
+	total := 0
+	for _, s := range sections {
+		n, err := s.count()
+		if err != nil {
+			return nil, err
+		}
+		offset := start - total
+		total += n
+		if offset < 0 {
+			offset = 0
+		}
+		limit := n - offset
+		if count > 0 && count-len(page) < limit {
+			limit = count - len(page)
+		}
+		if limit <= 0 {
+			continue
+		}
+
+		objects, err := s.fetch(offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, objects...)
+	}
+
+	children := make([]any, 0, len(page))
+	for _, obj := range page {
+		children = append(children, obj.didl())
+	}
+
+	result, err := marshalDIDL(children...)
+	if err != nil {
+		return nil, &upnpError{errCannotProcess, "Cannot encode DIDL-Lite"}
+	}
+
+	return []soapArg{
+		{"Result", string(result)},
+		{"NumberReturned", strconv.Itoa(len(page))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", strconv.FormatUint(uint64(systemUpdateID.Load()), 10)},
+	}, nil
+}
+
+// sortOrder is the order of a listing, by dc:title or else by the order of the library.
+type sortOrder struct {
+	title bool
+	desc  bool
+}
+
+// parseSortCriteria reads the dc:title sort key; other keys are ignored rather than
+// failing the request, several renderers always send upnp:originalTrackNumber.
+func parseSortCriteria(criteria string) sortOrder {
+	for _, field := range strings.Split(criteria, ",") {
+		field = strings.TrimSpace(field)
+		if strings.TrimLeft(field, "+-") == "dc:title" {
+			return sortOrder{title: true, desc: strings.HasPrefix(field, "-")}
+		}
+	}
+	return sortOrder{}
+}
+
+// by is the ORDER BY clause of a table: its title when sorting by title, then keys.
+func (o sortOrder) by(title string, keys ...string) string {
+	direction := " ASC"
+	if o.title {
+		keys = append([]string{title}, keys...)
+		if o.desc {
+			direction = " DESC"
+		}
+	}
+	for i := range keys {
+		keys[i] += direction
+	}
+	return strings.Join(keys, ", ")
+}
+
+// lookupObject resolves an object ID to its metadata.
+func lookupObject(r *http.Request, objectID string) (object, error) {
+	switch objectID {
+	case rootID:
+		return containerObject(rootID, "-1", FriendlyName, 2), nil
+	case moviesID:
+		movies, err := countOf[entity.Movie]("movies", everything)
+		if err != nil {
+			return object{}, err
+		}
+		return containerObject(moviesID, rootID, "Movies", movies), nil
+	case seriesID:
+		series, err := countOf[entity.Series]("series", everything)
+		if err != nil {
+			return object{}, err
+		}
+		return containerObject(seriesID, rootID, "Series", series), nil
+	}
+
+	kind, id, err := splitObjectID(objectID)
+	if err != nil {
+		return object{}, err
+	}
+
+	switch kind {
+	case "movie":
+		movie, err := repo.MovieRepository.FindByID(id)
+		if err != nil {
+			return object{}, &upnpError{errNoSuchObject, "No such object"}
+		}
+		return movieObject(r, movie), nil
+	case "series":
+		serie, err := repo.SeriesRepository.FindByID(id)
+		if err != nil {
+			return object{}, &upnpError{errNoSuchObject, "No such object"}
+		}
+		episodes, err := countOf[entity.Episode]("episodes", ofSeries(serie.ID))
+		if err != nil {
+			return object{}, err
+		}
+		return seriesObject(serie, episodes), nil
+	case "episode":
+		episode, err := repo.EpisodeRepository.FindByID(id)
+		if err != nil {
+			return object{}, &upnpError{errNoSuchObject, "No such object"}
+		}
+		serie, err := repo.SeriesRepository.FindByID(episode.SeriesID)
+		if err != nil {
+			return object{}, &upnpError{errNoSuchObject, "No such object"}
+		}
+		return episodeObject(r, serie, episode), nil
+	}
+
+	return object{}, &upnpError{errNoSuchObject, "No such object"}
+}
+
+// rootContainers are the Movies and Series containers.
+func rootContainers(r *http.Request) ([]object, error) {
+	root := make([]object, 0, 2)
+	for _, id := range []string{moviesID, seriesID} {
+		obj, err := lookupObject(r, id)
+		if err != nil {
+			return nil, err
+		}
+		root = append(root, obj)
+	}
+	return root, nil
+}
+
+// children lists the direct children of a container.
+func children(r *http.Request, objectID string, order sortOrder) ([]section, error) {
+	switch objectID {
+	case rootID:
+		root, err := rootContainers(r)
+		if err != nil {
+			return nil, err
+		}
+		if order.title {
+			sort.SliceStable(root, func(i, j int) bool {
+				return (strings.ToLower(root[i].title()) < strings.ToLower(root[j].title())) != order.desc
+			})
+		}
+		return []section{fixedSection(root...)}, nil
+	case moviesID:
+		return []section{movieSection(r, everything, order)}, nil
+	case seriesID:
+		return []section{seriesSection(everything, order)}, nil
+	}
+
+	kind, id, err := splitObjectID(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "series":
+		if _, err := repo.SeriesRepository.FindByID(id); err != nil {
+			return nil, &upnpError{errNoSuchObject, "No such object"}
+		}
+		return []section{episodeSection(r, ofSeries(id), order)}, nil
+	case "movie", "episode":
+		// Items have no children.
+		return nil, nil
+	}
+
+	return nil, &upnpError{errNoSuchObject, "No such object"}
+}
+
+// descendants lists the objects below a container that match the criteria, used by
+// Search. The kinds come one after the other, each sorted on its own.
+func descendants(r *http.Request, containerID string, criteria searchCriteria, order sortOrder) ([]section, error) {
+	var sections []section
+	add := func(class string, kind func() section) {
+		if criteria.wants(class) {
+			sections = append(sections, kind())
+		}
+	}
+	movies := func() section {
+		return movieSection(r, criteria.titled("LOWER(movies.title)"), order)
+	}
+	series := func() section {
+		return seriesSection(criteria.titled("LOWER(series.title)"), order)
+	}
+	episodes := func(base func(*gorm.DB) *gorm.DB) func() section {
+		return func() section {
+			titled := criteria.titled("LOWER(series.title)", episodeName)
+			return episodeSection(r, func(db *gorm.DB) *gorm.DB {
+				return titled(base(db))
+			}, order)
+		}
+	}
+
+	switch containerID {
+	case "", rootID:
+		root, err := rootContainers(r)
+		if err != nil {
+			return nil, err
+		}
+		var matching []object
+		for _, obj := range root {
+			if criteria.matches(obj) {
+				matching = append(matching, obj)
+			}
+		}
+		sections = append(sections, fixedSection(matching...))
+		add(classMovie, movies)
+		add(classFolder, series)
+		add(classVideo, episodes(everything))
+		return sections, nil
+	case moviesID:
+		add(classMovie, movies)
+		return sections, nil
+	case seriesID:
+		add(classFolder, series)
+		add(classVideo, episodes(everything))
+		return sections, nil
+	}
+
+	kind, id, err := splitObjectID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "series":
+		if _, err := repo.SeriesRepository.FindByID(id); err != nil {
+			return nil, &upnpError{errNoSuchObject, "No such object"}
+		}
+		add(classVideo, episodes(ofSeries(id)))
+		return sections, nil
+	case "movie", "episode":
+		return nil, nil
+	}
+
+	return nil, &upnpError{errNoSuchObject, "No such object"}
+}
+
+func splitObjectID(objectID string) (string, uint, error) {
+	kind, rawID, ok := strings.Cut(objectID, "/")
+	if !ok {
+		return "", 0, &upnpError{errNoSuchObject, "No such object"}
+	}
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		return "", 0, &upnpError{errNoSuchObject, "No such object"}
+	}
+	return kind, uint(id), nil
+}
+
+func everything(db *gorm.DB) *gorm.DB {
+	return db
+}
+
+func ofSeries(id uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("episodes.series_id = ?", id)
+	}
+}
+
+// withSeries joins the episodes to their series, whose title is part of theirs.
+func withSeries(filter func(*gorm.DB) *gorm.DB) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return filter(db.Joins("JOIN series ON series.id = episodes.series_id AND series.deleted_at IS NULL"))
+	}
+}
+
+// episodeName is the part of the path of an episode below the directory of its series.
+const episodeName = "SUBSTR(LOWER(episodes.path), LENGTH(series.base_dir) + 2)"
+
+func libraryError(table string, err error) error {
+	golog.Error("Error retrieving {}: {}", table, err)
+	return &upnpError{errCannotProcess, "Cannot read library"}
+}
+
+func countOf[T any](table string, filter func(*gorm.DB) *gorm.DB) (int, error) {
+	total, err := repo.Count[T](filter)
+	if err != nil {
+		return 0, libraryError(table, err)
+	}
+	return int(total), nil
+}
+
+func movieSection(r *http.Request, filter func(*gorm.DB) *gorm.DB, order sortOrder) section {
+	return section{
+		count: func() (int, error) {
+			return countOf[entity.Movie]("movies", filter)
+		},
+		fetch: func(offset, limit int) ([]object, error) {
+			movies, err := repo.MovieRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+				return filter(db).Order(order.by("LOWER(movies.title)", "movies.id")).Offset(offset).Limit(limit)
+			})
+			if err != nil {
+				return nil, libraryError("movies", err)
+			}
+
+			list := movies.ToSlice()
+			objects := make([]object, 0, len(list))
+			for i := range list {
+				objects = append(objects, movieObject(r, &list[i]))
+			}
+			return objects, nil
+		},
+	}
+}
+
+func seriesSection(filter func(*gorm.DB) *gorm.DB, order sortOrder) section {
+	return section{
+		count: func() (int, error) {
+			return countOf[entity.Series]("series", filter)
+		},
+		fetch: func(offset, limit int) ([]object, error) {
+			series, err := repo.SeriesRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+				return filter(db).Order(order.by("LOWER(series.title)", "series.id")).Offset(offset).Limit(limit)
+			})
+			if err != nil {
+				return nil, libraryError("series", err)
+			}
+
+			list := series.ToSlice()
+			ids := make([]uint, 0, len(list))
+			for _, serie := range list {
+				ids = append(ids, serie.ID)
+			}
+			episodes, err := episodeCounts(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			objects := make([]object, 0, len(list))
+			for i := range list {
+				objects = append(objects, seriesObject(&list[i], episodes[list[i].ID]))
+			}
+			return objects, nil
+		},
+	}
+}
+
+// episodeCounts counts the episodes of a page of series in one query.
+func episodeCounts(ids []uint) (map[uint]int, error) {
+	counts := make(map[uint]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		SeriesID uint
+		Episodes int
+	}
+	err := repo.DB.Model(&entity.Episode{}).
+		Select("series_id, COUNT(*) AS episodes").
+		Where("series_id IN ?", ids).
+		Group("series_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, libraryError("episodes", err)
+	}
+	for _, row := range rows {
+		counts[row.SeriesID] = row.Episodes
+	}
+	return counts, nil
+}
+
+func episodeSection(r *http.Request, filter func(*gorm.DB) *gorm.DB, order sortOrder) section {
+	filter = withSeries(filter)
+	return section{
+		count: func() (int, error) {
+			return countOf[entity.Episode]("episodes", filter)
+		},
+		fetch: func(offset, limit int) ([]object, error) {
+			episodes, err := repo.EpisodeRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+				return filter(db).Select("episodes.*").
+					Order(order.by("LOWER(series.title)", "episodes.series_id", "episodes.episode_index", "episodes.id")).
+					Offset(offset).
+					Limit(limit)
+			})
+			if err != nil {
+				return nil, libraryError("episodes", err)
+			}
+
+			list := episodes.ToSlice()
+			if len(list) == 0 {
+				return nil, nil
+			}
+			ids := make([]uint, 0, len(list))
+			for _, episode := range list {
+				ids = append(ids, episode.SeriesID)
+			}
+			series, err := repo.SeriesRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+				return db.Where("id IN ?", ids)
+			})
+			if err != nil {
+				return nil, libraryError("series", err)
+			}
+			byID := make(map[uint]*entity.Series, len(ids))
+			for _, serie := range series.ToSlice() {
+				serie := serie
+				byID[serie.ID] = &serie
+			}
+
+			objects := make([]object, 0, len(list))
+			for i := range list {
+				if serie, ok := byID[list[i].SeriesID]; ok {
+					objects = append(objects, episodeObject(r, serie, &list[i]))
+				}
+			}
+			return objects, nil
+		},
+	}
+}
+
+func containerObject(id, parentID, title string, childCount int) object {
+	return object{container: &didlContainer{
+		ID:         id,
+		ParentID:   parentID,
+		Restricted: "1",
+		Searchable: "1",
+		ChildCount: childCount,
+		Title:      title,
+		Class:      classFolder,
+	}}
+}
+
+func seriesObject(serie *entity.Series, episodes int) object {
+	return containerObject(fmt.Sprintf("series/%d", serie.ID), seriesID, serie.Title, episodes)
+}
+
+func movieObject(r *http.Request, movie *entity.Movie) object {
+	return object{item: &didlItem{
+		ID:          fmt.Sprintf("movie/%d", movie.ID),
+		ParentID:    moviesID,
+		Restricted:  "1",
+		Title:       movie.Title,
+		Description: movie.Description,
+		Class:       classMovie,
+		Res:         mediaRes(r, auth.StreamMovie, movie.ID, movie.Path),
+	}}
+}
+
+func episodeObject(r *http.Request, serie *entity.Series, episode *entity.Episode) object {
+	name := strings.TrimSuffix(filepath.Base(episode.Path), filepath.Ext(episode.Path))
+	return object{item: &didlItem{
+		ID:         fmt.Sprintf("episode/%d", episode.ID),
+		ParentID:   fmt.Sprintf("series/%d", serie.ID),
+		Restricted: "1",
+		Title:      fmt.Sprintf("%s - %d. %s", serie.Title, episode.EpisodeIndex, name),
+		Class:      classVideo,
+		Res:        mediaRes(r, auth.StreamEpisode, episode.ID, episode.Path),
+	}}
+}
+
+// mediaRes points a DIDL resource at the stream of a movie or an episode, on the host the renderer used.
+func mediaRes(r *http.Request, kind string, id uint, path string) didlRes {
+	return mediaResAt(r.Host, kind, id, path)
+}
+
+func mediaResAt(host, kind string, id uint, path string) didlRes {
+	res := didlRes{
+		ProtocolInfo: stream.ProtocolInfo(path),
+		URL:          streamURL(host, kind, id),
+	}
+	if roots := mediaRoots.Load(); roots != nil {
+		if resolved, err := roots.Resolve(path); err == nil {
+			if info, err := os.Stat(resolved); err == nil {
+				res.Size = info.Size()
+			}
+		}
+	}
+	return res
+}
+
+// streamURL is where renderers fetch a movie or an episode. They send no
+// Authorization header, a stream token stands in for it. Anyone browsing gets
+// one, which is why the server only answers the networks of dlna.clients.
+func streamURL(host, kind string, id uint) string {
+	target := fmt.Sprintf("http://%s/api/v1/stream/%s/%d", host, kind, id)
+	token, _, err := auth.NewStreamToken(kind, id)
+	if err != nil {
+		golog.Error("Cannot sign the stream token of {} {}: {}", kind, id, err.Error())
+		return target
+	}
+	return target + "?token=" + url.QueryEscape(token)
+}
+
+// searchCriteria is the subset of the UPnP search grammar renderers actually send:
+// class restrictions and title substring matches.
+type searchCriteria struct {
+	all     bool
+	classes []string
+	titles  []string
+}
+
+var (
+	classCriteria = regexp.MustCompile(`upnp:class\s+(derivedfrom|=)\s+"([^"]*)"`)
+	titleCriteria = regexp.MustCompile(`dc:title\s+(contains|=)\s+"((?:[^"\\]|\\.)*)"`)
+)
+
+func parseSearchCriteria(s string) (searchCriteria, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "*" {
+		return searchCriteria{all: true}, nil
+	}
+
+	var criteria searchCriteria
+	for _, m := range classCriteria.FindAllStringSubmatch(s, -1) {
+		criteria.classes = append(criteria.classes, m[2])
+	}
+	for _, m := range titleCriteria.FindAllStringSubmatch(s, -1) {
+		criteria.titles = append(criteria.titles, strings.ToLower(strings.ReplaceAll(m[2], `\"`, `"`)))
+	}
+
+	if len(criteria.classes) == 0 && len(criteria.titles) == 0 {
+		return searchCriteria{}, &upnpError{errInvalidSearch, "Unsupported SearchCriteria"}
+	}
+	return criteria, nil
+}
+
+// wants tells whether objects of a class can match.
+func (c searchCriteria) wants(class string) bool {
+	if c.all || len(c.classes) == 0 {
+		return true
+	}
+	for _, criterion := range c.classes {
+		if strings.HasPrefix(class, criterion) {
+			return true
+		}
+	}
+	return false
+}
+
+// titled restricts a query to the rows whose title, made of columns, contains every term.
+func (c searchCriteria) titled(columns ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if c.all {
+			return db
+		}
+		for _, term := range c.titles {
+			conditions := make([]string, 0, len(columns))
+			patterns := make([]any, 0, len(columns))
+			for _, column := range columns {
+				conditions = append(conditions, repo.Like(column))
+				patterns = append(patterns, "%"+repo.EscapeLike(term)+"%")
+			}
+			db = db.Where("("+strings.Join(conditions, " OR ")+")", patterns...)
+		}
+		return db
+	}
+}
+
+// matches tells whether an object that is not stored, a container of the root, matches.
+func (c searchCriteria) matches(obj object) bool {
+	if !c.wants(obj.class()) {
+		return false
+	}
+
+	title := strings.ToLower(obj.title())
+	for _, term := range c.titles {
+		if !strings.Contains(title, term) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-cinema/database/dbtest"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+
+	"gorm.io/gorm"
+)
+
+// library saves movies and series, the series with the names of their episode files.
+func library(t *testing.T, movies []string, series map[string][]string) (map[string]uint, map[string]uint) {
+	t.Helper()
+	movieIDs := make(map[string]uint)
+	for _, title := range movies {
+		movie := entity.Movie{Title: title, Path: "/srv/media/movies/" + title + ".mkv"}
+		if err := repo.MovieRepository.Save(&movie); err != nil {
+			t.Fatal(err)
+		}
+		movieIDs[title] = movie.ID
+	}
+	seriesIDs := make(map[string]uint)
+	for title, files := range series {
+		serie := entity.Series{Title: title, BaseDir: "/srv/media/series/" + title}
+		if err := repo.SeriesRepository.Save(&serie); err != nil {
+			t.Fatal(err)
+		}
+		seriesIDs[title] = serie.ID
+		for i, file := range files {
+			episode := entity.Episode{Path: serie.BaseDir + "/" + file, EpisodeIndex: i + 1, SeriesID: serie.ID}
+			if err := repo.EpisodeRepository.Save(&episode); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return movieIDs, seriesIDs
+}
+
+// result is what an action answered: the ids and titles of the objects in order.
+type result struct {
+	ids      []string
+	titles   []string
+	returned int
+	total    int
+}
+
+func call(t *testing.T, action actionHandler, args map[string]string) result {
+	t.Helper()
+	out, err := action(httptest.NewRequest("POST", "http://192.168.1.10:8080/dlna/control/ContentDirectory", nil), args)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+
+	var res result
+	values := make(map[string]string)
+	for _, arg := range out {
+		values[arg.Name] = arg.Value
+	}
+	res.returned, _ = strconv.Atoi(values["NumberReturned"])
+	res.total, _ = strconv.Atoi(values["TotalMatches"])
+
+	decoder := xml.NewDecoder(strings.NewReader(values["Result"]))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "container", "item":
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					res.ids = append(res.ids, attr.Value)
+				}
+			}
+		case "title":
+			var title string
+			if err := decoder.DecodeElement(&title, &start); err == nil {
+				res.titles = append(res.titles, title)
+			}
+		}
+	}
+	if len(res.ids) != res.returned {
+		t.Errorf("%v: NumberReturned %d for %d objects", args, res.returned, len(res.ids))
+	}
+	return res
+}
+
+// countQueries counts the queries run on db until the returned func is called.
+func countQueries(t *testing.T, db *gorm.DB) func() int {
+	t.Helper()
+	queries := 0
+	name := "dlna_test:count"
+	if err := db.Callback().Query().After("gorm:query").Register(name, func(*gorm.DB) { queries++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register(name, func(*gorm.DB) { queries++ }); err != nil {
+		t.Fatal(err)
+	}
+	return func() int {
+		_ = db.Callback().Query().Remove(name)
+		_ = db.Callback().Row().Remove(name)
+		return queries
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	dbtest.Open(t)
+	movies, series := library(t, []string{"bravo", "Alpha", "charlie"}, map[string][]string{
+		"Lost": {"Pilot.mkv", "Tabula Rasa.mkv", "Walkabout.mkv"},
+		"Dark": {"Secrets.mkv"},
+	})
+
+	root := call(t, browse, map[string]string{"ObjectID": rootID, "BrowseFlag": "BrowseDirectChildren"})
+	if !reflect.DeepEqual(root.titles, []string{"Movies", "Series"}) || root.total != 2 {
+		t.Errorf("root %+v", root)
+	}
+
+	page := call(t, browse, map[string]string{
+		"ObjectID": moviesID, "BrowseFlag": "BrowseDirectChildren",
+		"SortCriteria": "+dc:title", "StartingIndex": "1", "RequestedCount": "1",
+	})
+	if !reflect.DeepEqual(page.ids, []string{fmt.Sprintf("movie/%d", movies["bravo"])}) || page.total != 3 {
+		t.Errorf("second movie by title %+v", page)
+	}
+
+	desc := call(t, browse, map[string]string{"ObjectID": moviesID, "BrowseFlag": "BrowseDirectChildren", "SortCriteria": "-dc:title"})
+	if !reflect.DeepEqual(desc.titles, []string{"charlie", "bravo", "Alpha"}) {
+		t.Errorf("movies by title descending %v", desc.titles)
+	}
+
+	beyond := call(t, browse, map[string]string{"ObjectID": moviesID, "BrowseFlag": "BrowseDirectChildren", "StartingIndex": "5"})
+	if beyond.returned != 0 || beyond.total != 3 {
+		t.Errorf("page past the end %+v", beyond)
+	}
+
+	lost := fmt.Sprintf("series/%d", series["Lost"])
+	episodes := call(t, browse, map[string]string{"ObjectID": lost, "BrowseFlag": "BrowseDirectChildren", "SortCriteria": "+dc:title"})
+	want := []string{"Lost - 1. Pilot", "Lost - 2. Tabula Rasa", "Lost - 3. Walkabout"}
+	if !reflect.DeepEqual(episodes.titles, want) {
+		t.Errorf("episodes %v, want %v", episodes.titles, want)
+	}
+
+	metadata := call(t, browse, map[string]string{"ObjectID": lost, "BrowseFlag": "BrowseMetadata"})
+	if !reflect.DeepEqual(metadata.ids, []string{lost}) {
+		t.Errorf("metadata %+v", metadata)
+	}
+
+	if _, err := browse(httptest.NewRequest("POST", "/", nil), map[string]string{"ObjectID": "series/999", "BrowseFlag": "BrowseDirectChildren"}); err == nil {
+		t.Error("browsed a series that does not exist")
+	}
+}
+
+func TestBrowseSeriesCountsEpisodesInOneQuery(t *testing.T) {
+	db := dbtest.Open(t)
+	shows := make(map[string][]string)
+	for i := 0; i < 20; i++ {
+		shows[fmt.Sprintf("Show %02d", i)] = []string{"a.mkv", "b.mkv"}
+	}
+	library(t, nil, shows)
+
+	queries := countQueries(t, db)
+	page := call(t, browse, map[string]string{"ObjectID": seriesID, "BrowseFlag": "BrowseDirectChildren", "SortCriteria": "+dc:title"})
+	// the count, the page, and the episodes of the page
+	if n := queries(); n != 3 {
+		t.Errorf("browsing %d series ran %d queries", page.total, n)
+	}
+	if page.total != 20 || page.returned != 20 || page.titles[0] != "Show 00" {
+		t.Errorf("series %+v", page)
+	}
+
+	var decoded struct {
+		Containers []struct {
+			ChildCount int `xml:"childCount,attr"`
+		} `xml:"container"`
+	}
+	out, _ := browse(httptest.NewRequest("POST", "/", nil), map[string]string{"ObjectID": seriesID, "BrowseFlag": "BrowseDirectChildren"})
+	if err := xml.Unmarshal([]byte(out[0].Value), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, container := range decoded.Containers {
+		if container.ChildCount != 2 {
+			t.Errorf("series with %d episodes, want 2", container.ChildCount)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dbtest.Open(t)
+	movies, series := library(t, []string{"Lost in Translation", "Heat", "100% Wolf"}, map[string][]string{
+		"Lost":   {"Pilot.mkv", "Tabula Rasa.mkv"},
+		"Series": {"Heat Wave.mkv"},
+	})
+
+	lost := call(t, search, map[string]string{"ContainerID": rootID, "SearchCriteria": `dc:title contains "lost"`})
+	if lost.total != 4 {
+		t.Errorf("lost %+v", lost)
+	}
+	for _, id := range []string{fmt.Sprintf("movie/%d", movies["Lost in Translation"]), fmt.Sprintf("series/%d", series["Lost"])} {
+		if !strings.Contains(strings.Join(lost.ids, " "), id) {
+			t.Errorf("%s not found by title: %v", id, lost.ids)
+		}
+	}
+
+	// episodes match on their file name, not on the directories above it
+	pilot := call(t, search, map[string]string{"ContainerID": rootID, "SearchCriteria": `upnp:class derivedfrom "object.item" and dc:title contains "pilot"`})
+	if !reflect.DeepEqual(pilot.titles, []string{"Lost - 1. Pilot"}) {
+		t.Errorf("pilot %+v", pilot)
+	}
+	srv := call(t, search, map[string]string{"ContainerID": rootID, "SearchCriteria": `dc:title contains "srv"`})
+	if srv.total != 0 {
+		t.Errorf("matched the media directory %+v", srv)
+	}
+
+	percent := call(t, search, map[string]string{"ContainerID": moviesID, "SearchCriteria": `dc:title contains "0%"`})
+	if !reflect.DeepEqual(percent.titles, []string{"100% Wolf"}) {
+		t.Errorf("wildcard in the search %+v", percent)
+	}
+
+	// pages run across the movies and the episodes
+	videos := map[string]string{"ContainerID": rootID, "SearchCriteria": `upnp:class derivedfrom "object.item.videoItem"`, "SortCriteria": "+dc:title"}
+	all := call(t, search, videos)
+	want := []string{"100% Wolf", "Heat", "Lost in Translation", "Lost - 1. Pilot", "Lost - 2. Tabula Rasa", "Series - 1. Heat Wave"}
+	if !reflect.DeepEqual(all.titles, want) || all.total != len(want) {
+		t.Errorf("videos %v, want %v", all.titles, want)
+	}
+	videos["StartingIndex"], videos["RequestedCount"] = "2", "3"
+	page := call(t, search, videos)
+	if !reflect.DeepEqual(page.titles, want[2:5]) || page.total != len(want) {
+		t.Errorf("videos 2 to 5 %v, want %v", page.titles, want[2:5])
+	}
+
+	inSeries := call(t, search, map[string]string{"ContainerID": fmt.Sprintf("series/%d", series["Series"]), "SearchCriteria": "*"})
+	if !reflect.DeepEqual(inSeries.titles, []string{"Series - 1. Heat Wave"}) {
+		t.Errorf("search in a series %+v", inSeries)
+	}
+
+	folders := call(t, search, map[string]string{"ContainerID": rootID, "SearchCriteria": `upnp:class = "object.container.storageFolder" and dc:title contains "series"`})
+	if !reflect.DeepEqual(folders.titles, []string{"Series", "Series"}) {
+		t.Errorf("folders %v", folders.titles)
+	}
+}
+
+func TestParseSearchCriteria(t *testing.T) {
+	cases := map[string]searchCriteria{
+		"":    {all: true},
+		" * ": {all: true},
+		`upnp:class derivedfrom "object.item.videoItem"`: {classes: []string{"object.item.videoItem"}},
+		`dc:title contains "Lost"`:                       {titles: []string{"lost"}},
+		`dc:title = "Say \"Hi\""`:                        {titles: []string{`say "hi"`}},
+		`(upnp:class = "object.container.storageFolder" or upnp:class derivedfrom "object.item") and dc:title contains "A" and dc:title contains "b"`: {
+			classes: []string{"object.container.storageFolder", "object.item"},
+			titles:  []string{"a", "b"},
+		},
+	}
+	for s, want := range cases {
+		got, err := parseSearchCriteria(s)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseSearchCriteria(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{`dc:creator = "someone"`, "garbage", `dc:title contains lost`} {
+		_, err := parseSearchCriteria(s)
+		if upnpErr, ok := err.(*upnpError); !ok || upnpErr.Code != errInvalidSearch {
+			t.Errorf("parseSearchCriteria(%q): %v, want an invalid search error", s, err)
+		}
+	}
+
+	videos, _ := parseSearchCriteria(`upnp:class derivedfrom "object.item.videoItem"`)
+	if !videos.wants(classMovie) || videos.wants(classFolder) {
+		t.Errorf("video criteria want movies %v, folders %v", videos.wants(classMovie), videos.wants(classFolder))
+	}
+	titled, _ := parseSearchCriteria(`dc:title contains "x"`)
+	if !titled.wants(classMovie) || !titled.wants(classFolder) {
+		t.Error("criteria without a class want every class")
+	}
+}
+
+func TestParseSortCriteria(t *testing.T) {
+	cases := map[string]struct {
+		order sortOrder
+		by    string
+	}{
+		"":                         {sortOrder{}, "id ASC"},
+		"+dc:title":                {sortOrder{title: true}, "title ASC, id ASC"},
+		"dc:title":                 {sortOrder{title: true}, "title ASC, id ASC"},
+		"-dc:date,-dc:title":       {sortOrder{title: true, desc: true}, "title DESC, id DESC"},
+		"+upnp:class, +dc:creator": {sortOrder{}, "id ASC"},
+	}
+	for criteria, want := range cases {
+		order := parseSortCriteria(criteria)
+		if order != want.order {
+			t.Errorf("parseSortCriteria(%q) = %+v, want %+v", criteria, order, want.order)
+		}
+		if by := order.by("title", "id"); by != want.by {
+			t.Errorf("%q orders by %q, want %q", criteria, by, want.by)
+		}
+	}
+}
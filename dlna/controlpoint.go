@@ -0,0 +1,343 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	MediaRendererType = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	AVTransportType   = "urn:schemas-upnp-org:service:AVTransport:1"
+
+	// rendererTTL is how long a renderer stays listed without answering a search.
+	rendererTTL = 30 * time.Minute
+)
+
+var ErrRendererNotFound = errors.New("renderer not found")
+
+// Renderer is a MediaRenderer found on the network, typically a TV or a speaker.
+type Renderer struct {
+	UDN          string    `json:"udn"`
+	FriendlyName string    `json:"friendlyName"`
+	Manufacturer string    `json:"manufacturer"`
+	ModelName    string    `json:"modelName"`
+	Location     string    `json:"location"`
+	LastSeen     time.Time `json:"lastSeen"`
+
+	avTransportURL string
+}
+
+// ControlPoint discovers renderers and drives playback on them ("casting").
+type ControlPoint struct {
+	// Addr is the SSDP multicast group searches are sent to, defaults to SSDPAddr.
+	Addr string
+	// HTTPPort is the port of this server, used to build URLs renderers can fetch.
+	HTTPPort int
+	// Client performs description fetches and SOAP calls.
+	Client *http.Client
+
+	mu        sync.RWMutex
+	renderers map[string]*Renderer
+	sessions  map[string]*CastSession
+}
+
+// NewControlPoint creates a control point that hands out media URLs on the given HTTP port.
+func NewControlPoint(httpPort int) *ControlPoint {
+	return &ControlPoint{
+		Addr:     SSDPAddr,
+		HTTPPort: httpPort,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			// a redirect would lead away from the renderer that answered the search
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		renderers: make(map[string]*Renderer),
+		sessions:  make(map[string]*CastSession),
+	}
+}
+
+// remoteDevice is the part of a device description the control point cares about.
+type remoteDevice struct {
+	DeviceType   string         `xml:"deviceType"`
+	FriendlyName string         `xml:"friendlyName"`
+	Manufacturer string         `xml:"manufacturer"`
+	ModelName    string         `xml:"modelName"`
+	UDN          string         `xml:"UDN"`
+	Services     []Service      `xml:"serviceList>service"`
+	Devices      []remoteDevice `xml:"deviceList>device"`
+}
+
+type remoteDescription struct {
+	URLBase string       `xml:"URLBase"`
+	Device  remoteDevice `xml:"device"`
+}
+
+// Discover multicasts an M-SEARCH for MediaRenderer devices and records every
+// renderer that answers within the timeout.
+func (cp *ControlPoint) Discover(ctx context.Context, timeout time.Duration) error {
+	group, err := net.ResolveUDPAddr("udp4", cp.Addr)
+	if err != nil {
+		return fmt.Errorf("ssdp: resolve group: %w", err)
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return fmt.Errorf("ssdp: listen: %w", err)
+	}
+	defer conn.Close()
+
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\n\r\n", cp.Addr, mx, MediaRendererType)
+
+	// UDP is lossy, sending the search twice is what most control points do.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteTo([]byte(search), group); err != nil {
+			return fmt.Errorf("ssdp: send search: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	locations := make(map[string]net.IP)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return fmt.Errorf("ssdp: read: %w", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		source, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			locations[location] = source.IP
+		}
+	}
+
+	var wg sync.WaitGroup
+	for location, source := range locations {
+		wg.Add(1)
+		go func(location string, source net.IP) {
+			defer wg.Done()
+			if err := cp.addRenderer(ctx, location, source); err != nil {
+				golog.Warn("Ignoring renderer at {}: {}", location, err.Error())
+			}
+		}(location, source)
+	}
+	wg.Wait()
+
+	cp.expire()
+	return nil
+}
+
+// Run keeps the renderer list fresh until the context is cancelled.
+func (cp *ControlPoint) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cp.Discover(ctx, 3*time.Second); err != nil {
+			golog.Error("Renderer discovery failed: {}", err.Error())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Renderers returns the known renderers sorted by name.
+func (cp *ControlPoint) Renderers() []Renderer {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	list := make([]Renderer, 0, len(cp.renderers))
+	for _, renderer := range cp.renderers {
+		list = append(list, *renderer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FriendlyName < list[j].FriendlyName
+	})
+	return list
+}
+
+// Renderer looks a renderer up by UDN.
+func (cp *ControlPoint) Renderer(udn string) (*Renderer, error) {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	renderer, ok := cp.renderers[udn]
+	if !ok {
+		return nil, ErrRendererNotFound
+	}
+	copied := *renderer
+	return &copied, nil
+}
+
+// addRenderer fetches the description of a renderer that answered a search from source.
+// Anyone on the network can answer, so the description and the control URL must live on
+// the host that answered: otherwise a reply could make the server fetch, and later POST
+// to, any URL it can reach.
+func (cp *ControlPoint) addRenderer(ctx context.Context, location string, source net.IP) error {
+	if err := sameHost(location, source); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cp.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("device description returned %s", resp.Status)
+	}
+
+	var desc remoteDescription
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return fmt.Errorf("invalid device description: %w", err)
+	}
+
+	device, service, ok := findService(desc.Device, AVTransportType)
+	if !ok {
+		return errors.New("no AVTransport service")
+	}
+
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	controlURL, err := resolveURL(base, service.ControlURL)
+	if err != nil {
+		return err
+	}
+	if err := sameHost(controlURL, source); err != nil {
+		return err
+	}
+
+	cp.mu.Lock()
+	cp.renderers[device.UDN] = &Renderer{
+		UDN:            device.UDN,
+		FriendlyName:   device.FriendlyName,
+		Manufacturer:   device.Manufacturer,
+		ModelName:      device.ModelName,
+		Location:       location,
+		LastSeen:       time.Now(),
+		avTransportURL: controlURL,
+	}
+	cp.mu.Unlock()
+	return nil
+}
+
+func (cp *ControlPoint) expire() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for udn, renderer := range cp.renderers {
+		if time.Since(renderer.LastSeen) > rendererTTL {
+			delete(cp.renderers, udn)
+		}
+	}
+}
+
+// findService walks a device and its embedded devices for a service type.
+func findService(device remoteDevice, serviceType string) (remoteDevice, Service, bool) {
+	for _, service := range device.Services {
+		if strings.HasPrefix(service.Type, strings.TrimSuffix(serviceType, "1")) {
+			return device, service, true
+		}
+	}
+	for _, embedded := range device.Devices {
+		if found, service, ok := findService(embedded, serviceType); ok {
+			return found, service, true
+		}
+	}
+	return remoteDevice{}, Service{}, false
+}
+
+// sameHost checks that an http URL points at the given address.
+func sameHost(rawURL string, source net.IP) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip == nil || !ip.Equal(source) {
+		return fmt.Errorf("%s does not point at %s, the address that answered", rawURL, source)
+	}
+	return nil
+}
+
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", base, err)
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid control URL %q: %w", ref, err)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// localAddrFor returns the address of the local interface used to reach a renderer,
+// which is the address the renderer must use to fetch media from us.
+func localAddrFor(renderer *Renderer) (net.IP, error) {
+	location, err := url.Parse(renderer.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	host := location.Host
+	if location.Port() == "" {
+		host = net.JoinHostPort(location.Hostname(), "80")
+	}
+
+	conn, err := net.Dial("udp4", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,154 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+func init() {
+	_ = golog.Init(os.DevNull)
+}
+
+const rendererDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <URLBase>%s</URLBase>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>%s</friendlyName>
+    <UDN>uuid:%s</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:AVTransport:1</serviceType>
+        <controlURL>/AVTransport/control</controlURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+// renderer serves a device description on the given loopback address and counts fetches.
+func renderer(t *testing.T, ip, name, urlBase string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp4", ip+":0")
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	fetches := new(atomic.Int32)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = fmt.Fprintf(w, rendererDescription, urlBase, name, name)
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, fetches
+}
+
+func TestAddRendererChecksTheSource(t *testing.T) {
+	loopback := net.ParseIP("127.0.0.1")
+
+	good, _ := renderer(t, "127.0.0.1", "good", "")
+	cp := NewControlPoint(8080)
+	if err := cp.addRenderer(context.Background(), good.URL+"/desc.xml", loopback); err != nil {
+		t.Fatalf("renderer on the answering host refused: %v", err)
+	}
+	found := cp.Renderers()
+	if len(found) != 1 || found[0].FriendlyName != "good" {
+		t.Fatalf("renderers %+v", found)
+	}
+	if want := good.URL + "/AVTransport/control"; found[0].avTransportURL != want {
+		t.Errorf("control URL %s, want %s", found[0].avTransportURL, want)
+	}
+
+	other, fetches := renderer(t, "127.0.0.1", "other", "")
+	if err := cp.addRenderer(context.Background(), other.URL+"/desc.xml", net.ParseIP("10.1.2.3")); err == nil {
+		t.Error("accepted a location on another host than the one that answered")
+	}
+	if fetches.Load() != 0 {
+		t.Error("fetched a location on another host than the one that answered")
+	}
+
+	rebased, _ := renderer(t, "127.0.0.1", "rebased", "http://10.1.2.3:8080/")
+	if err := cp.addRenderer(context.Background(), rebased.URL+"/desc.xml", loopback); err == nil {
+		t.Error("accepted a URLBase on another host than the one that answered")
+	}
+
+	redirect := httptest.NewServer(http.RedirectHandler("http://10.1.2.3/desc.xml", http.StatusFound))
+	defer redirect.Close()
+	if err := cp.addRenderer(context.Background(), redirect.URL+"/desc.xml", loopback); err == nil {
+		t.Error("followed a redirect away from the host that answered")
+	}
+
+	for _, location := range []string{"file:///etc/passwd", "http://localhost/desc.xml", "://"} {
+		if err := cp.addRenderer(context.Background(), location, loopback); err == nil {
+			t.Errorf("accepted location %q", location)
+		}
+	}
+	if len(cp.Renderers()) != 1 {
+		t.Errorf("renderers %+v", cp.Renderers())
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	good, _ := renderer(t, "127.0.0.1", "good", "")
+	// 127.0.0.2 is reachable on loopback but is not the address that answers
+	spoofed, fetches := renderer(t, "127.0.0.2", "spoofed", "")
+
+	group, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Close()
+
+	cp := NewControlPoint(8080)
+	cp.Addr = group.LocalAddr().String()
+
+	hosts := make(chan string, 2)
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, from, err := group.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+			if err != nil {
+				continue
+			}
+			select {
+			case hosts <- req.Host:
+			default:
+			}
+			for _, server := range []*httptest.Server{good, spoofed} {
+				reply := fmt.Sprintf("HTTP/1.1 200 OK\r\nLOCATION: %s/desc.xml\r\nST: %s\r\n\r\n", server.URL, MediaRendererType)
+				_, _ = group.WriteTo([]byte(reply), from)
+			}
+		}
+	}()
+
+	if err := cp.Discover(context.Background(), 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if host := <-hosts; host != cp.Addr {
+		t.Errorf("searched with HOST %s, want %s", host, cp.Addr)
+	}
+	found := cp.Renderers()
+	if len(found) != 1 || !strings.HasPrefix(found[0].Location, good.URL) {
+		t.Errorf("renderers %+v", found)
+	}
+	if fetches.Load() != 0 {
+		t.Error("fetched a location the answering host does not serve")
+	}
+}
@@ -0,0 +1,140 @@
+package dlna
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	MediaServerType       = "urn:schemas-upnp-org:device:MediaServer:1"
+	ContentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	ConnectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+var (
+	// FriendlyName is the name TVs show in their source list.
+	FriendlyName = defaultFriendlyName()
+
+	udnOnce sync.Once
+	udn     string
+)
+
+// Service describes one UPnP service exposed by the media server.
+type Service struct {
+	Type        string `xml:"serviceType"`
+	ID          string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+var services = []Service{
+	{
+		Type:        ContentDirectoryType,
+		ID:          "urn:upnp-org:serviceId:ContentDirectory",
+		SCPDURL:     "/dlna/ContentDirectory.xml",
+		ControlURL:  "/dlna/control/ContentDirectory",
+		EventSubURL: "/dlna/event/ContentDirectory",
+	},
+	{
+		Type:        ConnectionManagerType,
+		ID:          "urn:upnp-org:serviceId:ConnectionManager",
+		SCPDURL:     "/dlna/ConnectionManager.xml",
+		ControlURL:  "/dlna/control/ConnectionManager",
+		EventSubURL: "/dlna/event/ConnectionManager",
+	},
+}
+
+type specVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+type deviceDescription struct {
+	XMLName     xml.Name    `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	DLNANS      string      `xml:"xmlns:dlna,attr"`
+	SpecVersion specVersion `xml:"specVersion"`
+	Device      device      `xml:"device"`
+}
+
+type device struct {
+	DeviceType       string    `xml:"deviceType"`
+	FriendlyName     string    `xml:"friendlyName"`
+	Manufacturer     string    `xml:"manufacturer"`
+	ManufacturerURL  string    `xml:"manufacturerURL"`
+	ModelDescription string    `xml:"modelDescription"`
+	ModelName        string    `xml:"modelName"`
+	ModelNumber      string    `xml:"modelNumber"`
+	UDN              string    `xml:"UDN"`
+	DLNADoc          string    `xml:"dlna:X_DLNADOC"`
+	ServiceList      []Service `xml:"serviceList>service"`
+	PresentationURL  string    `xml:"presentationURL,omitempty"`
+}
+
+func defaultFriendlyName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "go-cinema"
+	}
+	return "go-cinema on " + host
+}
+
+// DeviceUDN returns the unique device name. It is derived from the hostname so
+// renderers keep recognising the server across restarts.
+func DeviceUDN() string {
+	udnOnce.Do(func() {
+		host, _ := os.Hostname()
+		sum := sha1.Sum([]byte("go-cinema:" + host))
+		// Shape the hash like a name based (version 5) UUID.
+		sum[6] = (sum[6] & 0x0f) | 0x50
+		sum[8] = (sum[8] & 0x3f) | 0x80
+		udn = fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+	})
+	return udn
+}
+
+// DeviceDescription serves the root device description referenced by the SSDP LOCATION header.
+func DeviceDescription(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /dlna/device.xml handler, method: {}", r.Method)
+
+	desc := deviceDescription{
+		DLNANS:      "urn:schemas-dlna-org:device-1-0",
+		SpecVersion: specVersion{Major: 1, Minor: 0},
+		Device: device{
+			DeviceType:       MediaServerType,
+			FriendlyName:     FriendlyName,
+			Manufacturer:     "go-cinema",
+			ManufacturerURL:  "https://github.com/kashari/go-cinema",
+			ModelDescription: "go-cinema DLNA media server",
+			ModelName:        "go-cinema",
+			ModelNumber:      "1",
+			UDN:              DeviceUDN(),
+			DLNADoc:          "DMS-1.50",
+			ServiceList:      services,
+			PresentationURL:  "/",
+		},
+	}
+
+	writeXML(w, http.StatusOK, desc)
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		golog.Error("Error encoding XML: {}", err)
+		http.Error(w, "Error encoding XML", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", ServerHeader())
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(body)
+}
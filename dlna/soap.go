@@ -0,0 +1,178 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	soapEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+	soapEncodingNS = "http://schemas.xmlsoap.org/soap/encoding/"
+)
+
+// UPnP error codes returned in SOAP faults.
+const (
+	errInvalidAction = 401
+	errInvalidArgs   = 402
+	errActionFailed  = 501
+	errNoSuchObject  = 701
+	errInvalidSearch = 708
+	errCannotProcess = 720
+
+	errInvalidConnection = 706
+)
+
+// soapArg is a single named argument of a SOAP action, kept ordered as UPnP requires.
+type soapArg struct {
+	Name  string
+	Value string
+}
+
+// upnpError is returned by action handlers and rendered as a SOAP fault.
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+// soapAction splits the SOAPACTION header into service type and action name.
+func soapAction(r *http.Request) (string, string, error) {
+	header := strings.Trim(r.Header.Get("Soapaction"), `"`)
+	serviceType, action, ok := strings.Cut(header, "#")
+	if !ok || action == "" {
+		return "", "", errors.New("missing or malformed SOAPACTION header")
+	}
+	return serviceType, action, nil
+}
+
+// readSOAPArgs decodes the arguments of the action found in the SOAP body.
+func readSOAPArgs(body io.Reader) (map[string]string, error) {
+	decoder := xml.NewDecoder(io.LimitReader(body, 1<<20))
+	args := make(map[string]string)
+
+	depth := 0
+	var current string
+	var value bytes.Buffer
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			// Envelope > Body > Action > Argument
+			if depth == 4 {
+				current = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 4 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 4 {
+				args[current] = value.String()
+			}
+			depth--
+		}
+	}
+
+	return args, nil
+}
+
+// writeSOAPResponse writes the <u:ActionResponse> envelope for a successful action.
+func writeSOAPResponse(w http.ResponseWriter, serviceType, action string, args []soapArg) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s="%s" s:encodingStyle="%s"><s:Body>`, soapEnvelopeNS, soapEncodingNS)
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action, serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg.Name)
+		_ = xml.EscapeText(&b, []byte(arg.Value))
+		fmt.Fprintf(&b, "</%s>", arg.Name)
+	}
+	fmt.Fprintf(&b, `</u:%sResponse></s:Body></s:Envelope>`, action)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	w.Header().Set("Server", ServerHeader())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b.Bytes())
+}
+
+// writeSOAPFault writes a UPnPError fault, as described by the UPnP device architecture.
+func writeSOAPFault(w http.ResponseWriter, code int, description string) {
+	golog.Error("SOAP fault {}: {}", code, description)
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s="%s" s:encodingStyle="%s"><s:Body><s:Fault>`, soapEnvelopeNS, soapEncodingNS)
+	b.WriteString(`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`)
+	b.WriteString(`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`)
+	fmt.Fprintf(&b, "<errorCode>%d</errorCode><errorDescription>", code)
+	_ = xml.EscapeText(&b, []byte(description))
+	b.WriteString(`</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", ServerHeader())
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write(b.Bytes())
+}
+
+// actionHandler executes one SOAP action and returns its ordered output arguments.
+type actionHandler func(r *http.Request, args map[string]string) ([]soapArg, error)
+
+// serveSOAP dispatches a control request to the handler registered for its action.
+func serveSOAP(w http.ResponseWriter, r *http.Request, serviceType string, actions map[string]actionHandler) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reqType, action, err := soapAction(r)
+	if err != nil || reqType != serviceType {
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+		return
+	}
+
+	handler, ok := actions[action]
+	if !ok {
+		writeSOAPFault(w, errInvalidAction, "Invalid Action")
+		return
+	}
+
+	args, err := readSOAPArgs(r.Body)
+	if err != nil {
+		writeSOAPFault(w, errInvalidArgs, "Invalid Args")
+		return
+	}
+
+	out, err := handler(r, args)
+	if err != nil {
+		var uerr *upnpError
+		if errors.As(err, &uerr) {
+			writeSOAPFault(w, uerr.Code, uerr.Description)
+			return
+		}
+		writeSOAPFault(w, errActionFailed, err.Error())
+		return
+	}
+
+	writeSOAPResponse(w, serviceType, action, out)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package dlna
+
+import "syscall"
+
+// reuseAddr lets the SSDP socket share port 1900 with other UPnP stacks on the host.
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,383 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kashari/golog"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// SSDPAddr is the well-known SSDP multicast group and port.
+	SSDPAddr = "239.255.255.250:1900"
+
+	defaultMaxAge         = 1800
+	defaultNotifyInterval = 10 * time.Minute
+	maxDatagramSize       = 2048
+)
+
+var ErrServerClosed = errors.New("ssdp: server closed")
+
+// SSDPServer announces the media server on the local network and answers
+// M-SEARCH discovery requests coming from control points (TVs, apps).
+type SSDPServer struct {
+	// Addr is the multicast group the server joins, defaults to SSDPAddr.
+	// Tests can point it at a different port on the loopback interface.
+	Addr string
+	// Interfaces restricts the interfaces the server announces on. When empty
+	// every multicast capable interface that is up is used.
+	Interfaces []net.Interface
+	// HTTPPort is the port the device description is served on.
+	HTTPPort int
+	// NotifyInterval is how often ssdp:alive is re-sent, defaults to 10 minutes.
+	NotifyInterval time.Duration
+	// MaxAge is advertised in CACHE-CONTROL, defaults to 1800 seconds.
+	MaxAge int
+
+	mu        sync.Mutex
+	sendMu    sync.Mutex
+	conn      *ipv4.PacketConn
+	group     *net.UDPAddr
+	ifaces    []net.Interface
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSSDPServer creates an SSDP server advertising a description on the given HTTP port.
+func NewSSDPServer(httpPort int) *SSDPServer {
+	return &SSDPServer{
+		Addr:           SSDPAddr,
+		HTTPPort:       httpPort,
+		NotifyInterval: defaultNotifyInterval,
+		MaxAge:         defaultMaxAge,
+	}
+}
+
+// notificationTypes lists every NT/ST value this device answers to, paired with its USN.
+func notificationTypes() [][2]string {
+	udn := DeviceUDN()
+	types := [][2]string{
+		{"upnp:rootdevice", udn + "::upnp:rootdevice"},
+		{udn, udn},
+		{MediaServerType, udn + "::" + MediaServerType},
+	}
+	for _, service := range services {
+		types = append(types, [2]string{service.Type, udn + "::" + service.Type})
+	}
+	return types
+}
+
+// ListenAndServe joins the multicast group, sends the initial ssdp:alive burst
+// and serves discovery requests until Close is called.
+func (s *SSDPServer) ListenAndServe() error {
+	if s.Addr == "" {
+		s.Addr = SSDPAddr
+	}
+	if s.NotifyInterval == 0 {
+		s.NotifyInterval = defaultNotifyInterval
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = defaultMaxAge
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", s.Addr)
+	if err != nil {
+		return fmt.Errorf("ssdp: resolve group: %w", err)
+	}
+
+	ifaces := s.Interfaces
+	if len(ifaces) == 0 {
+		ifaces, err = multicastInterfaces()
+		if err != nil {
+			return err
+		}
+	}
+
+	lc := net.ListenConfig{Control: reuseAddr}
+	c, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", group.Port))
+	if err != nil {
+		return fmt.Errorf("ssdp: listen: %w", err)
+	}
+
+	conn := ipv4.NewPacketConn(c)
+	joined := make([]net.Interface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		iface := iface
+		if err := conn.JoinGroup(&iface, &net.UDPAddr{IP: group.IP}); err != nil {
+			golog.Warn("SSDP: cannot join group on {}: {}", iface.Name, err.Error())
+			continue
+		}
+		joined = append(joined, iface)
+	}
+	if len(joined) == 0 {
+		c.Close()
+		return errors.New("ssdp: no interface could join the multicast group")
+	}
+
+	_ = conn.SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst, true)
+	_ = conn.SetMulticastLoopback(true)
+	_ = conn.SetMulticastTTL(2)
+
+	s.mu.Lock()
+	s.conn = conn
+	s.group = group
+	s.ifaces = joined
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+
+	golog.Info("SSDP: announcing {} on {} interface(s)", DeviceUDN(), len(joined))
+
+	s.notifyAll("ssdp:alive")
+	go s.notifyLoop()
+
+	return s.serve()
+}
+
+// Close sends ssdp:byebye on every interface and stops the server. It is safe
+// to call more than once, and from several goroutines.
+func (s *SSDPServer) Close() error {
+	s.mu.Lock()
+	conn, done := s.conn, s.done
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	s.closeOnce.Do(func() {
+		s.notifyAll("ssdp:byebye")
+		close(done)
+		s.closeErr = conn.Close()
+	})
+	return s.closeErr
+}
+
+func (s *SSDPServer) serve() error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, cm, src, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			return fmt.Errorf("ssdp: read: %w", err)
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" {
+			continue
+		}
+
+		if req.Header.Get("Man") != `"ssdp:discover"` {
+			continue
+		}
+
+		udpSrc, ok := src.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		go s.answer(req, udpSrc, s.localIP(cm, udpSrc))
+	}
+}
+
+// answer replies to a single M-SEARCH, waiting a random delay bounded by MX
+// as the UPnP device architecture requires.
+func (s *SSDPServer) answer(req *http.Request, dst *net.UDPAddr, local net.IP) {
+	if local == nil {
+		return
+	}
+
+	st := req.Header.Get("St")
+	var matches [][2]string
+	for _, nt := range notificationTypes() {
+		if st == "ssdp:all" || st == nt[0] {
+			matches = append(matches, nt)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	mx, err := strconv.Atoi(req.Header.Get("Mx"))
+	if err != nil || mx < 1 {
+		mx = 1
+	}
+	if mx > 5 {
+		mx = 5
+	}
+
+	select {
+	case <-time.After(time.Duration(rand.Int63n(int64(mx) * int64(time.Second)))):
+	case <-s.done:
+		return
+	}
+
+	for _, nt := range matches {
+		msg := s.message("HTTP/1.1 200 OK", [][2]string{
+			{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", s.MaxAge)},
+			{"DATE", time.Now().UTC().Format(http.TimeFormat)},
+			{"EXT", ""},
+			{"LOCATION", s.location(local)},
+			{"SERVER", ServerHeader()},
+			{"ST", nt[0]},
+			{"USN", nt[1]},
+		})
+		if _, err := s.conn.WriteTo(msg, nil, dst); err != nil {
+			golog.Error("SSDP: cannot answer M-SEARCH from {}: {}", dst.String(), err.Error())
+			return
+		}
+	}
+}
+
+func (s *SSDPServer) notifyLoop() {
+	ticker := time.NewTicker(s.NotifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.notifyAll("ssdp:alive")
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// notifyAll multicasts a NOTIFY for every notification type on every interface.
+func (s *SSDPServer) notifyAll(nts string) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	for _, iface := range s.ifaces {
+		iface := iface
+		local := interfaceIPv4(&iface)
+		if local == nil {
+			continue
+		}
+
+		if err := s.conn.SetMulticastInterface(&iface); err != nil {
+			golog.Warn("SSDP: cannot select interface {}: {}", iface.Name, err.Error())
+			continue
+		}
+
+		for _, nt := range notificationTypes() {
+			headers := [][2]string{
+				{"HOST", s.group.String()},
+				{"NT", nt[0]},
+				{"NTS", nts},
+				{"USN", nt[1]},
+			}
+			if nts == "ssdp:alive" {
+				headers = append(headers,
+					[2]string{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", s.MaxAge)},
+					[2]string{"LOCATION", s.location(local)},
+					[2]string{"SERVER", ServerHeader()},
+				)
+			}
+
+			if _, err := s.conn.WriteTo(s.message("NOTIFY * HTTP/1.1", headers), nil, s.group); err != nil {
+				golog.Error("SSDP: cannot send {} on {}: {}", nts, iface.Name, err.Error())
+			}
+		}
+	}
+}
+
+func (s *SSDPServer) message(startLine string, headers [][2]string) []byte {
+	var b bytes.Buffer
+	b.WriteString(startLine + "\r\n")
+	for _, h := range headers {
+		b.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func (s *SSDPServer) location(ip net.IP) string {
+	return fmt.Sprintf("http://%s/dlna/device.xml", net.JoinHostPort(ip.String(), strconv.Itoa(s.HTTPPort)))
+}
+
+// localIP picks the address of the interface a request arrived on, falling back
+// to the first joined interface sharing a subnet with the sender.
+func (s *SSDPServer) localIP(cm *ipv4.ControlMessage, src *net.UDPAddr) net.IP {
+	if cm != nil && cm.IfIndex != 0 {
+		if iface, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
+			if ip := interfaceIPv4(iface); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	for _, iface := range s.ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && ipnet.Contains(src.IP) {
+				return ipnet.IP.To4()
+			}
+		}
+	}
+
+	if len(s.ifaces) > 0 {
+		return interfaceIPv4(&s.ifaces[0])
+	}
+	return nil
+}
+
+func multicastInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("ssdp: list interfaces: %w", err)
+	}
+
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if interfaceIPv4(&iface) == nil {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	if len(ifaces) == 0 {
+		return nil, errors.New("ssdp: no multicast capable interface found")
+	}
+	return ifaces, nil
+}
+
+func interfaceIPv4(iface *net.Interface) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip := ipnet.IP.To4(); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// ServerHeader is the SERVER value used in SSDP messages and SOAP responses.
+func ServerHeader() string {
+	return "Linux/1.0 UPnP/1.0 go-cinema/1.0"
+}
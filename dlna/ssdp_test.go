@@ -0,0 +1,135 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// loopbackGroup joins an SSDP group on a free port of the loopback interface, the
+// way a control point listening for NOTIFY messages would.
+func loopbackGroup(t *testing.T) (*ipv4.PacketConn, *net.UDPAddr, net.Interface) {
+	t.Helper()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	free, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: port}
+	lc := net.ListenConfig{Control: reuseAddr}
+	c, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	conn := ipv4.NewPacketConn(c)
+	if err := conn.JoinGroup(lo, &net.UDPAddr{IP: group.IP}); err != nil {
+		t.Skipf("cannot join a multicast group on loopback: %v", err)
+	}
+	return conn, group, *lo
+}
+
+// readUntil reads SSDP messages until one satisfies match or the timeout expires.
+func readUntil(t *testing.T, conn net.PacketConn, timeout time.Duration, match func(start string, header http.Header) bool) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("no matching SSDP message: %v", err)
+		}
+		reader := bufio.NewReader(bytes.NewReader(buf[:n]))
+		if req, err := http.ReadRequest(reader); err == nil {
+			if match(req.Method, req.Header) {
+				return
+			}
+			continue
+		}
+		if resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil); err == nil {
+			if match(resp.Status, resp.Header) {
+				return
+			}
+		}
+	}
+}
+
+func TestSSDPLoopback(t *testing.T) {
+	listener, group, lo := loopbackGroup(t)
+
+	server := NewSSDPServer(8200)
+	server.Addr = group.String()
+	server.Interfaces = []net.Interface{lo}
+
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+
+	location := "http://127.0.0.1:8200/dlna/device.xml"
+	readUntil(t, listener.PacketConn, 3*time.Second, func(start string, header http.Header) bool {
+		return start == "NOTIFY" && header.Get("Nts") == "ssdp:alive" &&
+			header.Get("Nt") == "upnp:rootdevice" && header.Get("Location") == location
+	})
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: %s\r\n\r\n", group, MediaServerType)
+	if _, err := client.WriteTo([]byte(search), group); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, client, 3*time.Second, func(start string, header http.Header) bool {
+		return start == "200 OK" && header.Get("St") == MediaServerType &&
+			header.Get("Usn") == DeviceUDN()+"::"+MediaServerType && header.Get("Location") == location
+	})
+
+	// closing from several goroutines at once used to close done twice
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := server.Close(); err != nil {
+				t.Errorf("close: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if err := server.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+
+	readUntil(t, listener.PacketConn, 3*time.Second, func(start string, header http.Header) bool {
+		return start == "NOTIFY" && header.Get("Nts") == "ssdp:byebye"
+	})
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("ListenAndServe returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("ListenAndServe did not return after Close")
+	}
+}
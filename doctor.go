@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-cinema/config"
+	"go-cinema/migrations"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+type check struct {
+	status, name, detail string
+}
+
+var doctorCommand = &command{
+	name:    "doctor",
+	summary: "Check the configuration, the database, the migrations and the media directories.",
+	setup: func(fs *flag.FlagSet) runner {
+		return func(a *app, args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+
+			checks := []check{{checkOK, "config", "valid"}}
+			checks = append(checks, checkDatabase(a)...)
+			checks = append(checks,
+				checkDir("movies", a.cfg.Media.Movies),
+				checkDir("series", a.cfg.Media.Series),
+				checkParent("usage data", a.cfg.Media.UsageData),
+				checkParent("uploads", a.cfg.Media.Uploads),
+				checkSecret(a.cfg.Auth),
+				checkPort(a.cfg.Server.Port),
+			)
+
+			failed := 0
+			rows := make([][]string, 0, len(checks))
+			for _, c := range checks {
+				if c.status == checkFail {
+					failed++
+				}
+				rows = append(rows, []string{c.status, c.name, c.detail})
+			}
+			if err := table(rows); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			return nil
+		}
+	},
+}
+
+func checkDatabase(a *app) []check {
+	cfg := a.cfg.Database
+	where := cfg.Path
+	if cfg.Driver != config.SQLite {
+		where = fmt.Sprintf("%s@%s/%s", cfg.User, cfg.Host, cfg.Name)
+	}
+
+	db, err := a.database()
+	if err != nil {
+		return []check{{checkFail, "database", err.Error()}}
+	}
+	sqlDB, err := db.DB()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = sqlDB.PingContext(ctx)
+		cancel()
+	}
+	if err != nil {
+		return []check{{checkFail, "database", err.Error()}}
+	}
+	checks := []check{{checkOK, "database", cfg.Driver + " " + where}}
+
+	// doctor only looks, New would create the table
+	if !db.Migrator().HasTable(&migrations.SchemaMigration{}) {
+		return append(checks, check{checkFail, "migrations", "none applied, run migrate up"})
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return append(checks, check{checkFail, "migrations", err.Error()})
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		return append(checks, check{checkFail, "migrations", err.Error()})
+	}
+
+	pending, latest := 0, 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		} else {
+			latest = status.Version
+		}
+	}
+	if pending > 0 {
+		return append(checks, check{checkFail, "migrations", fmt.Sprintf("%d pending, run migrate up", pending)})
+	}
+	return append(checks, check{checkOK, "migrations", fmt.Sprintf("up to date at %04d", latest)})
+}
+
+// checkDir checks that a media directory exists and can be written to.
+func checkDir(name, dir string) check {
+	info, err := os.Stat(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return check{checkFail, name, dir + " does not exist"}
+	case err != nil:
+		return check{checkFail, name, err.Error()}
+	case !info.IsDir():
+		return check{checkFail, name, dir + " is not a directory"}
+	}
+
+	file, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return check{checkFail, name, dir + " is not writable"}
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return check{checkOK, name, dir}
+}
+
+func checkParent(name, path string) check {
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return check{checkWarn, name, "the directory of " + path + " does not exist"}
+	}
+	return check{checkOK, name, path}
+}
+
+func checkSecret(cfg config.Auth) check {
+	if cfg.JWTSecret == "" {
+		return check{checkWarn, "jwt secret", "not set, sessions end on every restart"}
+	}
+	return check{checkOK, "jwt secret", "set"}
+}
+
+// checkPort tells whether the server could listen, a running server holds the port.
+func checkPort(port int) check {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return check{checkWarn, "port", fmt.Sprintf("%d is in use, by a running server or another program", port)}
+	}
+	listener.Close()
+	return check{checkOK, "port", fmt.Sprintf("%d is free", port)}
+}
@@ -1,7 +1,10 @@
 package entity
 
 import (
+	"errors"
+	"go-cinema/probe"
 	"os"
+	"time"
 
 	"github.com/kashari/golog"
 	"gorm.io/gorm"
@@ -9,10 +12,15 @@ import (
 
 type Movie struct {
 	gorm.Model
-	Title       string `json:"Title" gorm:"not null"`
-	Description string `json:"Description"`
-	Path        string `json:"Path" gorm:"not null"`
-	ResumeAt    string `json:"ResumeAt"`
+	Title        string     `json:"Title" gorm:"not null"`
+	Description  string     `json:"Description"`
+	Path         string     `json:"-" gorm:"not null"`
+	Year         int        `json:"Year"`
+	Missing      bool       `json:"Missing" gorm:"index"`
+	MissingSince *time.Time `json:"MissingSince"`
+	Checksum     string     `json:"Checksum" gorm:"size:64;index"`
+	Progress     Progress   `json:"Progress" gorm:"-"`
+	MediaInfo
 }
 
 type MovieRequest struct {
@@ -24,17 +32,58 @@ type Series struct {
 	gorm.Model
 	Title        string    `json:"Title"`
 	Description  string    `json:"Description"`
-	BaseDir      string    `json:"BaseDir" gorm:"not null"`
+	BaseDir      string    `json:"-" gorm:"not null"`
 	Episodes     []Episode `json:"Episodes"`
 	CurrentIndex uint      `json:"CurrentIndex" gorm:"not null"`
 }
 
 type Episode struct {
 	gorm.Model
-	Path         string `json:"Path" gorm:"not null"`
-	ResumeAt     string `json:"ResumeAt"`
-	EpisodeIndex int    `json:"EpisodeIndex" gorm:"not null"`
-	SeriesID     uint   `json:"series_id"`
+	Path         string     `json:"-" gorm:"not null"`
+	EpisodeIndex int        `json:"EpisodeIndex" gorm:"not null"`
+	Season       int        `json:"Season"`
+	Number       int        `json:"Number"`
+	SeriesID     uint       `json:"series_id"`
+	Missing      bool       `json:"Missing" gorm:"index"`
+	MissingSince *time.Time `json:"MissingSince"`
+	Checksum     string     `json:"Checksum" gorm:"size:64;index"`
+	Progress     Progress   `json:"Progress" gorm:"-"`
+	MediaInfo
+}
+
+// MediaInfo is what the probe read from the headers of a media file.
+type MediaInfo struct {
+	DurationMs int64         `json:"DurationMs"`
+	Width      int           `json:"Width"`
+	Height     int           `json:"Height"`
+	Container  string        `json:"Container"`
+	VideoCodec string        `json:"VideoCodec"`
+	AudioCodec string        `json:"AudioCodec"`
+	Tracks     []probe.Track `json:"Tracks" gorm:"serializer:json;type:text"`
+	ProbedAt   *time.Time    `json:"ProbedAt"`
+}
+
+// Probe fills the media info from the file at path. Files the probe does not
+// understand are still marked as probed so they are not read again.
+func (m *MediaInfo) Probe(path string) error {
+	info, err := probe.File(path)
+	if err != nil {
+		if errors.Is(err, probe.ErrUnknownFormat) || errors.Is(err, probe.ErrInvalid) {
+			now := time.Now()
+			m.ProbedAt = &now
+		}
+		return err
+	}
+
+	now := time.Now()
+	m.ProbedAt = &now
+
+	m.DurationMs = info.Duration.Milliseconds()
+	m.Width, m.Height = info.Width, info.Height
+	m.Container = info.Container
+	m.VideoCodec, m.AudioCodec = info.VideoCodec, info.AudioCodec
+	m.Tracks = info.Tracks
+	return nil
 }
 
 type SeriesRequest struct {
@@ -42,15 +91,6 @@ type SeriesRequest struct {
 	Description string `json:"Description"`
 }
 
-func ServeVideo(name string) (*os.File, error) {
-	file, err := os.Open(name)
-	if err != nil {
-		golog.Error("Error opening video file", err)
-		return nil, err
-	}
-	return file, nil
-}
-
 func GetFileSize(file *os.File) int64 {
 	info, err := file.Stat()
 	if err != nil {
@@ -0,0 +1,229 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+// watchedPercent is how much of an item has to be played for it to count as
+// watched, which leaves the end credits out.
+const watchedPercent = 90
+
+var ErrInvalidPosition = errors.New("invalid playback position")
+
+// Progress is how far a movie or episode has been played.
+type Progress struct {
+	PositionMs int64      `json:"PositionMs"`
+	DurationMs int64      `json:"DurationMs"`
+	Percent    float64    `json:"Percent"`
+	Watched    bool       `json:"Watched" gorm:"index"`
+	UpdatedAt  *time.Time `json:"UpdatedAt"`
+}
+
+// Track records a playback position. A zero duration keeps the one already known.
+func (p *Progress) Track(position, duration time.Duration) {
+	if duration > 0 {
+		p.DurationMs = duration.Milliseconds()
+	}
+	if position < 0 {
+		position = 0
+	}
+
+	p.PositionMs = position.Milliseconds()
+	if p.DurationMs > 0 && p.PositionMs > p.DurationMs {
+		p.PositionMs = p.DurationMs
+	}
+
+	p.Percent = 0
+	if p.DurationMs > 0 {
+		p.Percent = math.Round(float64(p.PositionMs)*1000/float64(p.DurationMs)) / 10
+	}
+	p.Watched = Finished(p.Position(), p.Duration())
+
+	now := time.Now()
+	p.UpdatedAt = &now
+}
+
+func (p Progress) Position() time.Duration {
+	return time.Duration(p.PositionMs) * time.Millisecond
+}
+
+func (p Progress) Duration() time.Duration {
+	return time.Duration(p.DurationMs) * time.Millisecond
+}
+
+// ResumeAt is where playback should start again, from the beginning once watched.
+func (p Progress) ResumeAt() time.Duration {
+	if p.Watched {
+		return 0
+	}
+	return p.Position()
+}
+
+// Finished reports whether playing up to position counts as having watched the item.
+// Without a duration nothing is ever finished.
+func Finished(position, duration time.Duration) bool {
+	return duration > 0 && position*100 >= duration*watchedPercent
+}
+
+// ParsePosition reads a playback position given in seconds ("754", "754.5"),
+// as mm:ss or as hh:mm:ss.
+func ParsePosition(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrInvalidPosition)
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+	}
+
+	// Only the seconds may have a fraction, the other parts are whole numbers.
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+	}
+	if len(parts) > 1 && seconds >= 60 {
+		return 0, fmt.Errorf("%w: %q has more than 59 seconds", ErrInvalidPosition, s)
+	}
+
+	// Whatever comes before the seconds adds up to minutes.
+	var minutes time.Duration
+	for i, part := range parts[:len(parts)-1] {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidPosition, s)
+		}
+		if i > 0 && value >= 60 {
+			return 0, fmt.Errorf("%w: %q has more than 59 minutes", ErrInvalidPosition, s)
+		}
+		minutes = minutes*60 + time.Duration(value)
+	}
+
+	return minutes*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// WatchProgress is how far a user got into a movie or an episode. One of MovieID
+// and EpisodeID is set, the other is zero so the pair stays unique.
+type WatchProgress struct {
+	ID        uint      `json:"ID" gorm:"primarykey"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UserID    uint      `json:"UserID" gorm:"not null;uniqueIndex:idx_watch_progress_item"`
+	MovieID   uint      `json:"MovieID" gorm:"not null;default:0;uniqueIndex:idx_watch_progress_item"`
+	EpisodeID uint      `json:"EpisodeID" gorm:"not null;default:0;uniqueIndex:idx_watch_progress_item"`
+	Progress  Progress  `json:"Progress" gorm:"embedded;embeddedPrefix:progress_"`
+}
+
+// legacyProgressColumns held the progress on the movie and episode rows, shared by everyone.
+var legacyProgressColumns = []string{
+	"resume_at",
+	"progress_position_ms",
+	"progress_duration_ms",
+	"progress_percent",
+	"progress_watched",
+	"progress_updated_at",
+}
+
+// MigrateProgress moves the positions stored on the movie and episode rows, either
+// the old mm:ss resume_at strings or the shared progress columns, to the progress
+// of every user, then drops those columns. Without users there is nobody to give
+// the positions to: the columns are kept, and the first account registered gets
+// them, as registering runs this again. Once the columns are gone it does nothing.
+func MigrateProgress(db *gorm.DB) error {
+	var users []uint
+	if err := db.Table("users").Where("deleted_at IS NULL").Pluck("id", &users).Error; err != nil {
+		return err
+	}
+
+	for _, model := range []any{&Movie{}, &Episode{}} {
+		migrator := db.Migrator()
+		hasResumeAt := migrator.HasColumn(model, "resume_at")
+		hasProgress := migrator.HasColumn(model, "progress_position_ms")
+		if !hasResumeAt && !hasProgress {
+			continue
+		}
+		if len(users) == 0 {
+			golog.Warn("No users to migrate playback positions to, keeping the old columns")
+			return nil
+		}
+
+		columns := []string{"id", "duration_ms"}
+		if hasResumeAt {
+			columns = append(columns, "resume_at")
+		}
+		if hasProgress {
+			columns = append(columns, "progress_position_ms", "progress_duration_ms", "progress_updated_at")
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var rows []struct {
+				ID                 uint
+				DurationMs         int64
+				ResumeAt           string
+				ProgressPositionMs int64
+				ProgressDurationMs int64
+				ProgressUpdatedAt  *time.Time
+			}
+			if err := tx.Model(model).Select(columns).Scan(&rows).Error; err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				position := time.Duration(row.ProgressPositionMs) * time.Millisecond
+				if position == 0 && row.ResumeAt != "" {
+					position, _ = ParsePosition(row.ResumeAt)
+				}
+				if position == 0 {
+					continue
+				}
+
+				duration := time.Duration(row.ProgressDurationMs) * time.Millisecond
+				if duration == 0 {
+					duration = time.Duration(row.DurationMs) * time.Millisecond
+				}
+
+				var progress Progress
+				progress.Track(position, duration)
+				if row.ProgressUpdatedAt != nil {
+					progress.UpdatedAt = row.ProgressUpdatedAt
+				}
+
+				for _, user := range users {
+					watch := WatchProgress{UserID: user, Progress: progress}
+					if _, ok := model.(*Movie); ok {
+						watch.MovieID = row.ID
+					} else {
+						watch.EpisodeID = row.ID
+					}
+					err := tx.Where(WatchProgress{UserID: user, MovieID: watch.MovieID, EpisodeID: watch.EpisodeID}).
+						FirstOrCreate(&watch).Error
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			for _, column := range legacyProgressColumns {
+				if !tx.Migrator().HasColumn(model, column) {
+					continue
+				}
+				if err := tx.Migrator().DropColumn(model, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,17 +1,16 @@
 import React, { useCallback, useEffect, useState } from "react";
 import "./App.css";
 import { Button } from "react-bootstrap";
-import axios from "./utils/axios";
+import axios, { streamUrl } from "./utils/axios";
 import Modal from "./components/Modal";
 import MoviePlayer from "./components/movies/MoviePlayer";
 import { Link } from "react-router-dom";
+import { Progress, resumeAt } from "./types/progress";
 
 type SerieData = {
   ID: string;
   Title: string;
-  Path: string;
   Description: string;
-  ResumeAt: string;
   CurrentIndex: string;
   Type: string;
   Episodes: Episode[];
@@ -19,14 +18,12 @@ type SerieData = {
 
 type Episode = {
   EpisodeIndex: string;
-  Path: string;
-  ResumeAt: string;
+  Progress: Progress;
 };
 
 type MovieData = {
   ID: string;
-  Path: string;
-  ResumeAt: string;
+  Progress: Progress;
   Title: string;
   Description: string;
   Type: string;
@@ -41,20 +38,35 @@ const App: React.FC = () => {
   );
 
   const [isMoviePlayerOpen, setIsMoviePlayerOpen] = useState<boolean>(false);
+  const [movieStream, setMovieStream] = useState<string>("");
 
-  const openMoviePlayer = () => {
+  const openMoviePlayer = async () => {
+    if (lastAccessMovie) {
+      setMovieStream(await streamUrl("movie", lastAccessMovie.ID));
+    }
     setIsMoviePlayerOpen(true);
   };
 
   const handleLastVideoOpenData = useCallback(async () => {
-    axios.get("http://192.168.3.200:9090/left-at").then((response) => {
-      const item = response.data;
-      if (item.Type === "Movie") {
-        setLastAccessMovie(item);
-      } else {
-        setLastAccessSerie(item);
-      }
-    });
+    axios
+      .get("http://192.168.3.200:9090/api/v1/me/continue-watching?limit=1")
+      .then((response) => {
+        const item = response.data[0];
+        // Nothing is left half watched.
+        if (!item) {
+          return;
+        }
+        if (item.Type === "Movie") {
+          setLastAccessMovie({ ...item.Movie, Type: item.Type });
+        } else {
+          setLastAccessSerie({
+            ...item.Series,
+            Type: item.Type,
+            CurrentIndex: item.Episode.EpisodeIndex,
+            Episodes: [item.Episode],
+          });
+        }
+      });
   }, []);
 
   const closeMoviePlayer = () => {
@@ -73,7 +85,7 @@ const App: React.FC = () => {
             <div>
               <h1 className="fw-boldest multicolor">
                 Last time you left {lastAccessMovie.Title} at{" "}
-                {lastAccessMovie.ResumeAt}
+                {resumeAt(lastAccessMovie.Progress)}
               </h1>
               <p className="text-muted">{lastAccessMovie.Description}</p>
             </div>
@@ -98,7 +110,7 @@ const App: React.FC = () => {
                 )}{" "}
                 at{" "}
                 {lastAccessSerie.Episodes &&
-                  lastAccessSerie.Episodes[0].ResumeAt}
+                  resumeAt(lastAccessSerie.Episodes[0].Progress)}
               </h1>
               <p className="text-muted">{lastAccessSerie?.Description}</p>
             </div>
@@ -115,13 +127,11 @@ const App: React.FC = () => {
         )}
 
         <Modal isOpen={isMoviePlayerOpen} onClose={closeMoviePlayer}>
-          {/* && (lastAccess as Movie).Path.includes("Movies") */}
           {lastAccessMovie ? (
             <MoviePlayer
-              leftAt={lastAccessMovie.ResumeAt || "00:00"}
+              leftAt={resumeAt(lastAccessMovie.Progress)}
               movieId={lastAccessMovie?.ID || ""}
-              videoEndpoint={"http://192.168.3.200:9090/video"}
-              fileName={lastAccessMovie.Path || ""}
+              src={movieStream}
               onClose={closeMoviePlayer}
             />
           ) : (
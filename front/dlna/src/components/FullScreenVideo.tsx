@@ -2,6 +2,7 @@ import React, { useState, useRef, useCallback, useEffect } from "react";
 import styled from "styled-components";
 import "@fortawesome/fontawesome-free/css/all.css";
 import axios from "../utils/axios";
+import { playbackQuery } from "../types/progress";
 
 const LeftIcon = styled.i<{ rotated: boolean }>`
   ${({ rotated }) => rotated && "transform: rotate(-85deg);"}
@@ -253,15 +254,12 @@ const FullScreenVideo: React.FC<FullScreenVideoProps> = ({
 
   const handleLastVideoOpenData = useCallback(
     (videoTime: number) => {
-      const fileMinute = `${String(Math.floor(videoTime / 60)).padStart(
-        2,
-        "0"
-      )}:${String(Math.floor(videoTime % 60)).padStart(2, "0")}`;
+      const query = playbackQuery(videoTime, videoRef.current?.duration);
 
-      console.debug("updating video data...", fileMinute);
+      console.debug("updating video data...", query);
       axios
         .post(
-          `http://192.168.3.200:9090/episodes/${episodeId}/last-access?time=${fileMinute}`
+          `http://192.168.3.200:9090/episodes/${episodeId}/last-access?${query}`
         )
         .then(() => {
           console.debug("video data updated...");
@@ -0,0 +1,69 @@
+import React, { useState } from "react";
+import "../App.css";
+
+import { useForm, SubmitHandler } from "react-hook-form";
+import axios from "../utils/axios";
+import { useNavigate } from "react-router-dom";
+
+type LoginInputs = {
+  username: string;
+  password: string;
+};
+
+const Login: React.FC = () => {
+  const {
+    register,
+    handleSubmit,
+    formState: { errors },
+  } = useForm<LoginInputs>();
+
+  const navigate = useNavigate();
+  const [loginError, setLoginError] = useState<string>("");
+
+  const onSubmit: SubmitHandler<LoginInputs> = (data) => {
+    axios
+      .post("/api/v1/auth/login", data)
+      .then((response) => {
+        localStorage.setItem("accessToken", response.data.access_token);
+        localStorage.setItem("refreshToken", response.data.refresh_token);
+        navigate("/");
+      })
+      .catch(() => {
+        setLoginError("Invalid username or password");
+      });
+  };
+
+  return (
+    <div className="container mt-5">
+      <form onSubmit={handleSubmit(onSubmit)}>
+        <div className="mb-3">
+          <input
+            className="form-control"
+            placeholder="Username"
+            {...register("username", { required: true })}
+          />
+          {errors.username && (
+            <span className="text-danger">Username is required</span>
+          )}
+        </div>
+        <div className="mb-3">
+          <input
+            className="form-control"
+            type="password"
+            placeholder="Password"
+            {...register("password", { required: true })}
+          />
+          {errors.password && (
+            <span className="text-danger">Password is required</span>
+          )}
+        </div>
+        {loginError && <p className="text-danger">{loginError}</p>}
+        <button type="submit" className="btn btn-primary">
+          Login
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default Login;
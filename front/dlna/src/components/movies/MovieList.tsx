@@ -1,10 +1,11 @@
 import React, { useEffect, useState } from "react";
 import { Movie } from "../../types/movie";
-import axios from "../../utils/axios";
+import axios, { streamUrl } from "../../utils/axios";
 import play from "../../assets/play.svg";
 import Modal from "../Modal";
 import MoviePlayer from "./MoviePlayer";
 import { SubmitHandler, useForm } from "react-hook-form";
+import { resumeAt } from "../../types/progress";
 
 type MovieInputs = {
   Title: string;
@@ -34,18 +35,22 @@ const MovieList: React.FC = () => {
   const [movies, setMovies] = useState<Movie[]>([]);
   const [updatingMovie, setUpdatingMovie] = useState<Movie | null>(null);
   const [isMoviePlayerOpen, setIsMoviePlayerOpen] = useState<boolean>(false);
-  const [currentFilePlaying, setCurrentFilePlaying] = useState<string>("");
+  const [currentStream, setCurrentStream] = useState<string>("");
   const [movieId, setMovieId] = useState<string>("0");
   const [movieLeftAt, setMovieLeftAt] = useState<string>("00:00");
   const [editModal, setEditModal] = useState<boolean>(false);
 
-  const openMoviePlayer = (endpoint: string, id: string, leftAt: string) => {
+  const openMoviePlayer = async (id: string, leftAt: string) => {
     setMovieLeftAt(leftAt);
     setMovieId(id);
-    setCurrentFilePlaying(endpoint);
+    setCurrentStream(await streamUrl("movie", id));
     setIsMoviePlayerOpen(true);
   };
 
+  const handleDownload = async (id: string) => {
+    window.open(await streamUrl("movie", id), "_blank", "noreferrer");
+  };
+
   const closeMoviePlayer = () => {
     setIsMoviePlayerOpen(false);
   };
@@ -70,7 +75,7 @@ const MovieList: React.FC = () => {
   };
 
   const handleFetchMovies = () => {
-    axios.get("http://192.168.3.200:9090/movies").then((response) => {
+    axios.get("http://192.168.3.200:9090/movies?limit=200").then((response) => {
       setMovies(response.data);
     });
   };
@@ -104,7 +109,7 @@ const MovieList: React.FC = () => {
               <br />
               <img
                 onClick={() => {
-                  openMoviePlayer(movie.Path, movie.ID, movie.ResumeAt);
+                  openMoviePlayer(movie.ID, resumeAt(movie.Progress));
                 }}
                 className="card-img-top mt-6"
                 style={{ cursor: "pointer" }}
@@ -116,10 +121,11 @@ const MovieList: React.FC = () => {
               <p className="p-2 text-center">{movie.Description}</p>
 
               <div className="card-body d-flex justify-content-around">
-                <a
-                  href={`http://192.168.3.200:9090/video/download?file=${movie.Path}`}
-                  target="_blank"
-                  rel="noreferrer"
+                <span
+                  style={{ cursor: "pointer" }}
+                  onClick={() => {
+                    handleDownload(movie.ID);
+                  }}
                 >
                   <svg
                     xmlns="http://www.w3.org/2000/svg"
@@ -132,7 +138,7 @@ const MovieList: React.FC = () => {
                       d="M288 32c0-17.7-14.3-32-32-32s-32 14.3-32 32V274.7l-73.4-73.4c-12.5-12.5-32.8-12.5-45.3 0s-12.5 32.8 0 45.3l128 128c12.5 12.5 32.8 12.5 45.3 0l128-128c12.5-12.5 12.5-32.8 0-45.3s-32.8-12.5-45.3 0L288 274.7V32zM64 352c-35.3 0-64 28.7-64 64v32c0 35.3 28.7 64 64 64H448c35.3 0 64-28.7 64-64V416c0-35.3-28.7-64-64-64H346.5l-45.3 45.3c-25 25-65.5 25-90.5 0L165.5 352H64zm368 56a24 24 0 1 1 0 48 24 24 0 1 1 0-48z"
                     />
                   </svg>
-                </a>
+                </span>
                 <span
                   style={{ cursor: "pointer" }}
                   onClick={() => {
@@ -181,8 +187,7 @@ const MovieList: React.FC = () => {
         <MoviePlayer
           leftAt={movieLeftAt}
           movieId={movieId}
-          videoEndpoint={"http://192.168.3.200:9090/video"}
-          fileName={currentFilePlaying}
+          src={currentStream}
           onClose={closeMoviePlayer}
         />
       </Modal>
@@ -1,40 +1,36 @@
 import axios from "../../utils/axios";
 import React, { useCallback, useEffect, useRef } from "react";
+import { playbackQuery } from "../../types/progress";
 
 interface VideoPlayerProps {
   leftAt: string;
   movieId: string;
-  videoEndpoint: string;
-  fileName: string;
+  src: string;
   onClose: () => void;
 }
 
 const MoviePlayer: React.FC<VideoPlayerProps> = ({
   leftAt,
   movieId,
-  videoEndpoint,
-  fileName,
+  src,
   onClose,
 }) => {
   const videoRef = useRef<HTMLVideoElement>(null);
 
   const handleLastVideoOpenData = useCallback(
     (videoTime: number) => {
-      const fileMinute = `${String(Math.floor(videoTime / 60)).padStart(
-        2,
-        "0"
-      )}:${String(Math.floor(videoTime % 60)).padStart(2, "0")}`;
+      const query = playbackQuery(videoTime, videoRef.current?.duration);
 
-      console.debug("updating video data...", fileName, fileMinute);
+      console.debug("updating video data...", movieId, query);
       axios
         .post(
-          `http://192.168.3.200:9090/last-access/${movieId}?time=${fileMinute}`
+          `http://192.168.3.200:9090/last-access/${movieId}?${query}`
         )
         .then(() => {
           console.debug("video data updated...");
         });
     },
-    [fileName, movieId]
+    [movieId]
   );
 
   const handleBackGoroutine = useCallback(async () => {
@@ -96,8 +92,7 @@ const MoviePlayer: React.FC<VideoPlayerProps> = ({
       }, 1000);
     };
   }, [
-    videoEndpoint,
-    fileName,
+    src,
     handleLastVideoOpenData,
     onClose,
     handleSkipToWhereYouLeft,
@@ -112,7 +107,7 @@ const MoviePlayer: React.FC<VideoPlayerProps> = ({
         controls
         preload="metadata"
         >
-        <source src={`${videoEndpoint}?file=${fileName}`} type="video/mp4" />
+        <source src={src} type="video/mp4" />
         Your browser does not support the video tag.
       </video>
     </div>
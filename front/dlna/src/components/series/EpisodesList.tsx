@@ -1,13 +1,14 @@
 import React, { useCallback, useEffect, useState } from "react";
 import { Episode } from "../../types/series";
 import { AxiosProgressEvent } from "axios";
-import axios from "../../utils/axios";
+import axios, { streamUrl } from "../../utils/axios";
 import { useLocation, useParams } from "react-router-dom";
 import play from "../../assets/play.svg";
 import Modal from "../Modal";
 import { SubmitHandler, useForm } from "react-hook-form";
 import "../../App.css";
 import FullScreenVideo from "../FullScreenVideo";
+import { resumeAt } from "../../types/progress";
 
 type EpisodeInputs = {
   File: File;
@@ -80,10 +81,8 @@ const EpisodesList: React.FC = () => {
       });
   };
 
-  const handleOpenVideoModal = (index: number) => {
-    setVideoEndpoint(
-      `http://192.168.3.200:9090/video?file=${episodes[index - 1].Path}`
-    );
+  const handleOpenVideoModal = async (index: number) => {
+    setVideoEndpoint(await streamUrl("episode", episodes[index - 1].ID));
     handleSetCurrentEpisodeIndex(index);
     setCurrentIndex(index);
     setCurrentEpisodePlaying(episodes[index - 1]);
@@ -129,7 +128,7 @@ const EpisodesList: React.FC = () => {
 
   const handleFetchEpisodes = useCallback(() => {
     axios
-      .get(`http://192.168.3.200:9090/series/${id}/episodes`)
+      .get(`http://192.168.3.200:9090/series/${id}/episodes?limit=200`)
       .then((response) => {
         setEpisodes(response.data);
       });
@@ -214,7 +213,7 @@ const EpisodesList: React.FC = () => {
                 </div>
                 <br />
                 <small className="text-muted p-4 text-center">
-                  {episode.ResumeAt}
+                  {resumeAt(episode.Progress)}
                 </small>
 
                 <div className="card-body d-flex justify-content-around"></div>
@@ -285,7 +284,7 @@ const EpisodesList: React.FC = () => {
         title={state.title}
         episode={currentIndex.toString()}
         leftAt={
-          startOver ? "00:00" : currentEpisodePlaying?.ResumeAt ?? "00:00"
+          startOver ? "00:00" : resumeAt(currentEpisodePlaying?.Progress)
         }
         onEnded={switchToNextEpisode}
       />
@@ -55,7 +55,7 @@ const SerieList: React.FC = () => {
   };
 
   const handleFetchSeries = () => {
-    axios.get("http://192.168.3.200:9090/series").then((response) => {
+    axios.get("http://192.168.3.200:9090/series?limit=200").then((response) => {
       setSeries(response.data);
     });
   };
@@ -1,5 +1,6 @@
 import axios from "../../utils/axios";
 import React, { useCallback, useEffect, useRef, useState } from "react";
+import { playbackQuery } from "../../types/progress";
 
 interface VideoPlayerProps {
   leftAt: string;
@@ -21,15 +22,12 @@ const SeriePlayer: React.FC<VideoPlayerProps> = ({
 
   const handleLastVideoOpenData = useCallback(
     (videoTime: number) => {
-      const fileMinute = `${String(Math.floor(videoTime / 60)).padStart(
-        2,
-        "0"
-      )}:${String(Math.floor(videoTime % 60)).padStart(2, "0")}`;
+      const query = playbackQuery(videoTime, videoRef.current?.duration);
 
-      console.debug("updating video data...", fileMinute);
+      console.debug("updating video data...", query);
       axios
         .post(
-          `http://192.168.3.200:9090/episodes/${episodeId}/last-access?time=${fileMinute}`
+          `http://192.168.3.200:9090/episodes/${episodeId}/last-access?${query}`
         )
         .then(() => {
           console.debug("video data updated...");
@@ -8,6 +8,7 @@ import Management from "./components/Management";
 import SerieList from "./components/series/SerieList";
 import MovieList from "./components/movies/MovieList";
 import EpisodesList from "./components/series/EpisodesList";
+import Login from "./components/Login";
 
 export const RootLayout: React.FC = () => {
   return (
@@ -28,6 +29,7 @@ const router = createBrowserRouter([
       { path: "movies", element: <MovieList />},
       { path: "management", element: <Management />},
       { path: "series/:id/episodes", element: <EpisodesList />},
+      { path: "login", element: <Login />},
     ],
   },
 ]);
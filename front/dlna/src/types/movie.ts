@@ -1,7 +1,8 @@
+import { Progress } from "./progress";
+
 export interface Movie {
   ID: string;
   Title: string;
   Description: string;
-  Path: string;
-  ResumeAt: string;
+  Progress: Progress;
 }
@@ -0,0 +1,27 @@
+export interface Progress {
+  PositionMs: number;
+  DurationMs: number;
+  Percent: number;
+  Watched: boolean;
+  UpdatedAt: string | null;
+}
+
+// resumeAt formats where to continue playing as mm:ss, from the start once watched.
+export const resumeAt = (progress?: Progress | null): string => {
+  if (!progress || progress.Watched) {
+    return "00:00";
+  }
+  const seconds = Math.floor(progress.PositionMs / 1000);
+  return `${String(Math.floor(seconds / 60)).padStart(2, "0")}:${String(
+    seconds % 60
+  ).padStart(2, "0")}`;
+};
+
+// playbackQuery is the ?time=&duration= query the last-access endpoints take, in seconds.
+export const playbackQuery = (videoTime: number, duration?: number): string => {
+  const query = `time=${Math.floor(videoTime)}`;
+  if (duration && Number.isFinite(duration)) {
+    return `${query}&duration=${Math.floor(duration)}`;
+  }
+  return query;
+};
@@ -1,9 +1,10 @@
+import { Progress } from "./progress";
+
 export interface Episode {
   ID: string;
   Title: string;
   Description: string;
-  Path: string;
-  ResumeAt: string;
+  Progress: Progress;
   EpisodeIndex: number;
   SeriesID: string;
 }
@@ -12,7 +13,6 @@ export interface Series {
   ID: string;
   Title: string;
   Description: string;
-  BaseDir: string;
   Episodes: Episode[];
   CurrentIndex: number;
 }
@@ -1,7 +1,7 @@
 import axios from "axios";
 
 const axiosInstance = axios.create({
-  baseURL: "http://192.168.3.150:8080",
+  baseURL: "http://192.168.3.200:9090",
 });
 
 axiosInstance.interceptors.request.use(
@@ -19,16 +19,23 @@ axiosInstance.interceptors.response.use(
   (response) => response,
   async (error) => {
     const originalRequest = error.config;
-    if (error.response.status === 401 && !originalRequest._retry) {
+    if (
+      error.response?.status === 401 &&
+      !originalRequest._retry &&
+      !originalRequest.url?.endsWith("/api/v1/auth/refresh") &&
+      !originalRequest.url?.endsWith("/api/v1/auth/login")
+    ) {
       originalRequest._retry = true;
       const refreshToken = localStorage.getItem("refreshToken");
       if (refreshToken) {
         try {
-          const { data } = await axiosInstance.post("/refresh-token", {
+          const { data } = await axiosInstance.post("/api/v1/auth/refresh", {
             refreshToken,
           });
+          // Refresh tokens are rotated, the old one is no longer valid.
           localStorage.setItem("accessToken", data.access_token);
-          originalRequest.headers.Authorization = `Bearer ${data.acccess_token}`;
+          localStorage.setItem("refreshToken", data.refresh_token);
+          originalRequest.headers.Authorization = `Bearer ${data.access_token}`;
           return axiosInstance(originalRequest);
         } catch (err) {
           localStorage.removeItem("accessToken");
@@ -44,4 +51,14 @@ axiosInstance.interceptors.response.use(
   }
 );
 
+// streamUrl signs a short-lived link to the file of a movie or an episode, for
+// the video element and downloads, which cannot send the Authorization header.
+export const streamUrl = async (
+  kind: "movie" | "episode",
+  id: string | number
+): Promise<string> => {
+  const { data } = await axiosInstance.post(`/api/v1/stream/${kind}/${id}/token`);
+  return `${axiosInstance.defaults.baseURL}${data.url}`;
+};
+
 export default axiosInstance;
\ No newline at end of file
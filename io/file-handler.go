@@ -3,25 +3,61 @@ package filehandler
 import (
 	"encoding/json"
 	"fmt"
+	"go-cinema/apperror"
 	logger "go-cinema/file-logger"
+	"go-cinema/sandbox"
+	"go-cinema/stream"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 
+	"github.com/kashari/golog"
 	"golang.org/x/sync/syncmap"
 )
 
 var (
 	downloadProgress = syncmap.Map{}
-	usageData        = "/application/logs/usage_data.io"
+	usageData        atomic.Value
 )
 
+// SetUsageDataPath sets the file the usage data is kept in.
+func SetUsageDataPath(path string) {
+	usageData.Store(path)
+}
+
+func usageDataPath() string {
+	path, _ := usageData.Load().(string)
+	return path
+}
+
 type FileHandler struct {
 	// Path to the directory to upload files
 	Root string
 }
 
+// root confines the files of the handler to Root, names cannot climb out of it.
+func (f *FileHandler) root() (*sandbox.Root, error) {
+	return sandbox.NewRoot(f.Root)
+}
+
+func (f *FileHandler) open(name string) (*os.File, error) {
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+	return root.Open(name)
+}
+
+func (f *FileHandler) create(name string) (*os.File, error) {
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+	return root.Create(name)
+}
+
 type FileRow struct {
 	Name string
 	Size string
@@ -89,7 +125,10 @@ func (f *FileHandler) ListFiles() []FileRow {
 
 func (f *FileHandler) DeleteFile(fileName string) error {
 	logger.Info("Deleting file", fileName)
-	err := os.Remove(f.Root + "/" + fileName)
+	root, err := f.root()
+	if err == nil {
+		err = root.Remove(fileName)
+	}
 	if err != nil {
 		logger.Error("Error deleting file", err)
 		return err
@@ -99,7 +138,7 @@ func (f *FileHandler) DeleteFile(fileName string) error {
 
 func (f *FileHandler) GetFile(fileName string) (*os.File, error) {
 	logger.Info("Opening file", fileName)
-	file, err := os.Open(f.Root + "/" + fileName)
+	file, err := f.open(fileName)
 	if err != nil {
 		logger.Error("Error opening file", err)
 		return nil, err
@@ -118,7 +157,7 @@ func (f *FileHandler) DownloadFromInternet(url string) error {
 
 	fileName := strings.Split(url, "/")[len(strings.Split(url, "/"))-1]
 
-	out, err := os.Create(f.Root + "/" + fileName)
+	out, err := f.create(fileName)
 	if err != nil {
 		logger.Error("Error creating file", err)
 		return err
@@ -162,30 +201,40 @@ func (f *FileHandler) DownloadFromInternet(url string) error {
 	return nil
 }
 
-func UpdateUsageData(data []byte) {
-	file, err := os.Create(usageData)
+// UpdateUsageData replaces the usage data with data.
+func UpdateUsageData(data []byte) error {
+	file, err := os.Create(usageDataPath())
 	if err != nil {
-		fmt.Println("Something went wrong updating the data: ", err)
+		golog.Error("Cannot create the usage data: {}", err.Error())
+		return apperror.Storage("Error updating the usage data", err)
 	}
-
-	file.Write(data)
-	fmt.Println("Last access watching updated.")
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		golog.Error("Cannot write the usage data: {}", err.Error())
+		return apperror.Storage("Error updating the usage data", err)
+	}
+	golog.Info("Usage data updated")
+	return nil
 }
 
-func GetUsageData() map[string]string {
-	file, err := os.Open(usageData)
+// GetUsageData reads the usage data, a not found error when there is none yet.
+func GetUsageData() (map[string]string, error) {
+	file, err := os.Open(usageDataPath())
 	if err != nil {
-		fmt.Println("Something went wrong reading the data: ", err)
+		golog.Error("Cannot open the usage data: {}", err.Error())
+		return nil, apperror.Lookup("No usage data", err)
 	}
+	defer file.Close()
 
 	data := make(map[string]string)
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&data)
-	if err != nil {
-		fmt.Println("Something went wrong decoding the data: ", err)
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		golog.Error("Cannot decode the usage data: {}", err.Error())
+		return nil, apperror.Storage("Error reading the usage data", err)
 	}
-
-	return data
+	return data, nil
 }
 
 func (f *FileHandler) PercentagePollerOnFile(url string) int64 {
@@ -195,7 +244,7 @@ func (f *FileHandler) PercentagePollerOnFile(url string) int64 {
 
 func (f *FileHandler) ServeVideoFile(name string) (*os.File, error) {
 	logger.Info("Serving video file", name)
-	file, err := os.Open(f.Root + "/" + name)
+	file, err := f.open(name)
 	if err != nil {
 		logger.Error("Error opening video file", err)
 		return nil, err
@@ -227,9 +276,6 @@ func (f *FileHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", GetFileSize(file)))
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", GetFileSize(file)-1, GetFileSize(file)))
 	w.Header().Set("Content-Transfer-Encoding", "binary")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -251,9 +297,13 @@ func (f *FileHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	_, err = io.Copy(w, file)
+	src, err := stream.NewFile(file)
 	if err != nil {
-		http.Error(w, "Error writing file to response", http.StatusInternalServerError)
+		http.Error(w, "Error getting file info", http.StatusInternalServerError)
+		return
+	}
+	if err := stream.Serve(w, r, src); err != nil {
+		logger.Error("Error writing file to response", err)
 		return
 	}
 
@@ -280,7 +330,7 @@ func (f *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	out, err := os.Create(f.Root + "/" + r.FormValue("filename"))
+	out, err := f.create(r.FormValue("filename"))
 	if err != nil {
 		http.Error(w, "Error creating file", http.StatusInternalServerError)
 		return
@@ -314,8 +364,6 @@ func (f *FileHandler) ServeContentViaHttp(w http.ResponseWriter, r *http.Request
 
 	w.Header().Set("Content-Disposition", "inline; filename="+fileName)
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", GetFileSize(file)))
-	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Accept-Encoding", "identity")
 	w.Header().Set("Content-Encoding", "identity")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -331,27 +379,22 @@ func (f *FileHandler) ServeContentViaHttp(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Access-Control-Max-Age", "86400")
 	w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", GetFileSize(file)-1, GetFileSize(file)))
 	w.Header().Set("Content-Transfer-Encoding", "binary")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
 
-	stat, err := os.Stat(file.Name())
+	src, err := stream.NewFile(file)
 	if err != nil {
 		http.Error(w, "Error getting file info", http.StatusInternalServerError)
 		return
 	}
-
-	mod_time := stat.ModTime()
-	if mod_time.IsZero() {
-		http.Error(w, "Error getting file info", http.StatusInternalServerError)
+	if err := stream.Serve(w, r, src); err != nil {
+		logger.Error("Error writing file to response", err)
 		return
 	}
 
-	http.ServeContent(w, r, fileName, mod_time, file)
-
 	logger.Info("File served successfully")
 	return
 }
@@ -366,8 +409,8 @@ type Dlna interface {
 	DeleteFile(fileName string) error
 	GetFile(fileName string) (*os.File, error)
 	PercentagePollerOnFile(url string) int64
-	UpdateUsageData(data []byte)
-	GetUsageData() map[string]string
+	UpdateUsageData(data []byte) error
+	GetUsageData() (map[string]string, error)
 }
 
 func NewFileHandler(root string) *FileHandler {
@@ -0,0 +1,60 @@
+package filehandler
+
+import (
+	"errors"
+	"go-cinema/apperror"
+	logger "go-cinema/file-logger"
+	"go-cinema/stream/streamtest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kashari/golog"
+)
+
+func TestServeFileConformance(t *testing.T) {
+	logger.Setup(os.DevNull)
+	dir := t.TempDir()
+	streamtest.WriteFile(t, dir)
+	handler := &FileHandler{Root: dir}
+
+	streamtest.Run(t, http.HandlerFunc(handler.ServeFile), "/file?file="+streamtest.Name)
+
+	w := httptest.NewRecorder()
+	handler.ServeFile(w, httptest.NewRequest(http.MethodGet, "/file?file=../"+streamtest.Name, nil))
+	if w.Code == http.StatusOK {
+		t.Error("served a file outside the root")
+	}
+}
+
+func TestUsageData(t *testing.T) {
+	_ = golog.Init(os.DevNull)
+	dir := t.TempDir()
+	SetUsageDataPath(filepath.Join(dir, "usage_data.io"))
+	defer SetUsageDataPath("")
+
+	if _, err := GetUsageData(); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("no usage data yet: %v", err)
+	}
+	if err := UpdateUsageData([]byte(`{"1":"1:30"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := GetUsageData(); err != nil || !reflect.DeepEqual(data, map[string]string{"1": "1:30"}) {
+		t.Errorf("usage data %v, %v", data, err)
+	}
+
+	if err := UpdateUsageData([]byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUsageData(); !errors.Is(err, apperror.ErrStorage) {
+		t.Errorf("corrupt usage data: %v", err)
+	}
+
+	SetUsageDataPath(filepath.Join(dir, "missing", "usage_data.io"))
+	if err := UpdateUsageData([]byte("{}")); !errors.Is(err, apperror.ErrStorage) {
+		t.Errorf("usage data in a missing directory: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-cinema/catalogue"
+	"go-cinema/library"
+	"io"
+	"os"
+
+	"github.com/kashari/golog"
+)
+
+var scanCommand = &command{
+	name:    "scan",
+	args:    "[PATH...]",
+	summary: "Import the video files of the media directories, or only the given files.",
+	setup: func(fs *flag.FlagSet) runner {
+		return func(a *app, args []string) error {
+			if _, err := a.database(); err != nil {
+				return err
+			}
+
+			scanner := library.NewScanner(library.Roots(a.cfg.Media))
+			var (
+				report *library.Report
+				err    error
+			)
+			if len(args) > 0 {
+				report, err = scanner.Import(args)
+			} else {
+				report, err = scanner.Run()
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Added %d movies, %d series and %d episodes, updated %d episodes, %d files unchanged\n",
+				report.MoviesAdded, report.SeriesAdded, report.EpisodesAdded, report.EpisodesUpdated, report.Unchanged)
+			for _, path := range report.Skipped {
+				golog.Warn("Skipped {}", path)
+			}
+			if len(report.Errors) > 0 {
+				return fmt.Errorf("%d files could not be imported", len(report.Errors))
+			}
+			return nil
+		}
+	},
+}
+
+var exportCommand = &command{
+	name:    "export",
+	summary: "Write the library as JSON, to move it to another database or keep a backup.",
+	setup: func(fs *flag.FlagSet) runner {
+		output := fs.String("o", "-", "The file to write, - for the standard output.")
+		withUsers := fs.Bool("users", false, "Include the users, with their password hashes, and their watch progress.")
+		return func(a *app, args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+			db, err := a.database()
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if *output != "-" {
+				file, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			counts, err := catalogue.Export(db, w, *withUsers)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Exported %s\n", counts)
+			return nil
+		}
+	},
+}
+
+var importCommand = &command{
+	name:    "import",
+	args:    "[FILE]",
+	summary: "Load a library written by export, from FILE or the standard input. Rows already present are kept.",
+	setup: func(fs *flag.FlagSet) runner {
+		return func(a *app, args []string) error {
+			if len(args) > 1 {
+				return errUsage
+			}
+			db, err := a.database()
+			if err != nil {
+				return err
+			}
+
+			var r io.Reader = os.Stdin
+			if len(args) == 1 && args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+
+			counts, err := catalogue.Import(db, r)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Imported %s\n", counts)
+			return nil
+		}
+	},
+}
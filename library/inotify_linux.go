@@ -0,0 +1,159 @@
+//go:build linux
+
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotify watches directories through the inotify syscalls. The descriptor is
+// non-blocking so the runtime poller can wake up a pending read on Close.
+type inotify struct {
+	// fd is kept next to file because file.Fd() would switch it back to blocking mode.
+	fd   int
+	file *os.File
+
+	mu      sync.Mutex
+	watches map[int32]string
+	dirs    map[string]int32
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	return &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]string),
+		dirs:    make(map[string]int32),
+	}, nil
+}
+
+func (n *inotify) add(dir string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.dirs[dir]; ok {
+		return nil
+	}
+
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, watchMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	n.watches[int32(wd)] = dir
+	n.dirs[dir] = int32(wd)
+	return nil
+}
+
+// remove forgets dir and everything below it. The kernel drops the watches of
+// deleted directories by itself.
+func (n *inotify) remove(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for path, wd := range n.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			_, _ = syscall.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.dirs, path)
+			delete(n.watches, wd)
+		}
+	}
+}
+
+// rename keeps the watches of a moved directory tree, only their paths change.
+func (n *inotify) rename(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for path, wd := range n.dirs {
+		if path != from && !strings.HasPrefix(path, from+string(filepath.Separator)) {
+			continue
+		}
+		moved := to + strings.TrimPrefix(path, from)
+		delete(n.dirs, path)
+		n.dirs[moved] = wd
+		n.watches[wd] = moved
+	}
+}
+
+func (n *inotify) read() ([]fsEvent, error) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	count, err := n.file.Read(buf)
+	if err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			return nil, errNotifierClosed
+		}
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var events []fsEvent
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+		offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+		if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			events = append(events, fsEvent{Op: opOverflow})
+			continue
+		}
+
+		dir, ok := n.watches[raw.Wd]
+		if !ok {
+			continue
+		}
+
+		if raw.Mask&syscall.IN_IGNORED != 0 {
+			delete(n.watches, raw.Wd)
+			delete(n.dirs, dir)
+			continue
+		}
+		if raw.Mask&syscall.IN_DELETE_SELF != 0 {
+			continue
+		}
+
+		event := fsEvent{
+			Path:   filepath.Join(dir, strings.TrimRight(string(nameBytes), "\x00")),
+			Dir:    raw.Mask&syscall.IN_ISDIR != 0,
+			Cookie: raw.Cookie,
+		}
+		switch {
+		case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+			event.Op = opWrite
+		case raw.Mask&syscall.IN_CREATE != 0:
+			event.Op = opCreate
+		case raw.Mask&syscall.IN_DELETE != 0:
+			event.Op = opRemove
+		case raw.Mask&syscall.IN_MOVED_FROM != 0:
+			event.Op = opMoveFrom
+		case raw.Mask&syscall.IN_MOVED_TO != 0:
+			event.Op = opMoveTo
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (n *inotify) close() error {
+	return n.file.Close()
+}
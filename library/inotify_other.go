@@ -0,0 +1,9 @@
+//go:build !linux
+
+package library
+
+import "errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("library: watching folders needs inotify, which is only available on Linux")
+}
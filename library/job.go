@@ -0,0 +1,105 @@
+package library
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	// maxJobs is how many finished scans are kept for their reports.
+	maxJobs = 20
+)
+
+var ErrScanRunning = errors.New("a library scan is already running")
+
+// Job is a scan started in the background.
+type Job struct {
+	ID         int64      `json:"id"`
+	Status     string     `json:"status"`
+	Roots      []Root     `json:"roots"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Report     *Report    `json:"report,omitempty"`
+}
+
+var (
+	jobsMu    sync.Mutex
+	jobs      []*Job
+	lastJobID int64
+)
+
+// StartScan scans the roots in the background. Only one scan runs at a time,
+// when one is in progress it is returned along with ErrScanRunning.
+func StartScan(roots []Root) (Job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for _, job := range jobs {
+		if job.Status == JobRunning {
+			return *job, ErrScanRunning
+		}
+	}
+
+	lastJobID++
+	job := &Job{ID: lastJobID, Status: JobRunning, Roots: roots, StartedAt: time.Now()}
+	jobs = append(jobs, job)
+	if len(jobs) > maxJobs {
+		jobs = jobs[len(jobs)-maxJobs:]
+	}
+
+	go runJob(job)
+	return *job, nil
+}
+
+func runJob(job *Job) {
+	report, err := NewScanner(job.Roots).Run()
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Report = report
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		golog.Error("Library scan {} failed: {}", job.ID, err.Error())
+		return
+	}
+
+	job.Status = JobDone
+	golog.Info("Library scan {} done: {} movies, {} series, {} episodes added", job.ID, report.MoviesAdded, report.SeriesAdded, report.EpisodesAdded)
+}
+
+// FindJob returns the scan with the given ID, if it is still remembered.
+func FindJob(id int64) (Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for _, job := range jobs {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Jobs returns the remembered scans, most recent first.
+func Jobs() []Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	list := make([]Job, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		list = append(list, *jobs[i])
+	}
+	return list
+}
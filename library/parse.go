@@ -0,0 +1,69 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Show.Name.S02E05, Show Name - s2e5, Show_Name_S02.E05
+	seasonEpisode = regexp.MustCompile(`(?i)^(.*?)[\s._-]*s(\d{1,2})[\s._-]*e(\d{1,3})`)
+	// Show Name 2x05
+	crossEpisode = regexp.MustCompile(`(?i)^(.*?)[\s._-]*\b(\d{1,2})x(\d{2,3})\b`)
+	// Movie (1999), Movie.1999.1080p, Movie [1999]; the last year wins, as in "Blade Runner 2049 (2017)"
+	movieYear = regexp.MustCompile(`^(.*)[\s._-]+[(\[]?((?:19|20)\d{2})[)\]]?(?:[\s._-]|$)`)
+)
+
+// EpisodeName is what can be told about an episode from its file name.
+type EpisodeName struct {
+	Show    string
+	Season  int
+	Episode int
+}
+
+// ParseEpisode recognises SxxEyy and NxNN style episode file names.
+func ParseEpisode(path string) (EpisodeName, bool) {
+	name := baseName(path)
+
+	match := seasonEpisode.FindStringSubmatch(name)
+	if match == nil {
+		match = crossEpisode.FindStringSubmatch(name)
+	}
+	if match == nil {
+		return EpisodeName{}, false
+	}
+
+	season, _ := strconv.Atoi(match[2])
+	episode, _ := strconv.Atoi(match[3])
+	return EpisodeName{Show: cleanTitle(match[1]), Season: season, Episode: episode}, true
+}
+
+// ParseMovie extracts the title and, when present, the release year of a movie file.
+func ParseMovie(path string) (string, int) {
+	name := baseName(path)
+
+	match := movieYear.FindStringSubmatch(name)
+	// A name that is only a year ("1917") is a title, not a release date.
+	if match == nil || strings.TrimSpace(match[1]) == "" {
+		return cleanTitle(name), 0
+	}
+
+	year, _ := strconv.Atoi(match[2])
+	return cleanTitle(match[1]), year
+}
+
+func baseName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// cleanTitle turns scene style names ("The.Big_Movie") into titles ("The Big Movie").
+func cleanTitle(s string) string {
+	if !strings.Contains(s, " ") {
+		s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, " -")
+}
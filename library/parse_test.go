@@ -0,0 +1,75 @@
+package library
+
+import "testing"
+
+func TestParseEpisode(t *testing.T) {
+	cases := map[string]EpisodeName{
+		"/srv/Lost/Lost.S01E02.720p.mkv":           {Show: "Lost", Season: 1, Episode: 2},
+		"The.Office.US.s02e05.HDTV.mkv":            {Show: "The Office US", Season: 2, Episode: 5},
+		"Breaking Bad - s5e14 - Ozymandias.mp4":    {Show: "Breaking Bad", Season: 5, Episode: 14},
+		"Show_Name_S02.E05.avi":                    {Show: "Show Name", Season: 2, Episode: 5},
+		"Doctor Who 2005 S10E101.mkv":              {Show: "Doctor Who 2005", Season: 10, Episode: 101},
+		"Friends 3x07.mkv":                         {Show: "Friends", Season: 3, Episode: 7},
+		"Twin.Peaks.1x008.mkv":                     {Show: "Twin Peaks", Season: 1, Episode: 8},
+		"S03E04.mkv":                               {Show: "", Season: 3, Episode: 4},
+		"/srv/series/Dark/dark.S01E01.Secrets.mp4": {Show: "dark", Season: 1, Episode: 1},
+	}
+	for path, want := range cases {
+		got, ok := ParseEpisode(path)
+		if !ok || got != want {
+			t.Errorf("ParseEpisode(%q) = %+v, %v, want %+v", path, got, ok, want)
+		}
+	}
+
+	for _, path := range []string{
+		"The Matrix (1999).mkv",
+		"Blade Runner 2049 (2017).mkv",
+		"1920x1080.mkv",
+		"Season 1/Pilot.mkv",
+		"notes.txt",
+	} {
+		if got, ok := ParseEpisode(path); ok {
+			t.Errorf("ParseEpisode(%q) = %+v, want no episode", path, got)
+		}
+	}
+}
+
+func TestParseMovie(t *testing.T) {
+	cases := map[string]struct {
+		title string
+		year  int
+	}{
+		"/srv/movies/The Matrix (1999).mkv":      {"The Matrix", 1999},
+		"The.Big.Lebowski.1998.1080p.BluRay.mkv": {"The Big Lebowski", 1998},
+		"Alien [1979].avi":                       {"Alien", 1979},
+		"Blade Runner 2049 (2017).mkv":           {"Blade Runner 2049", 2017},
+		"2001 A Space Odyssey (1968).mkv":        {"2001 A Space Odyssey", 1968},
+		"Some_Home_Video.mp4":                    {"Some Home Video", 0},
+		"1917.mkv":                               {"1917", 0},
+		"Heat - 1995.mkv":                        {"Heat", 1995},
+		"Brazil 1985":                            {"Brazil", 1985},
+		"Movie 1850.mkv":                         {"Movie 1850", 0},
+	}
+	for path, want := range cases {
+		title, year := ParseMovie(path)
+		if title != want.title || year != want.year {
+			t.Errorf("ParseMovie(%q) = %q, %d, want %q, %d", path, title, year, want.title, want.year)
+		}
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	cases := map[string]string{
+		"The.Big_Movie":    "The Big Movie",
+		"  Spaced   out  ": "Spaced out",
+		"Mr. Smith Goes":   "Mr. Smith Goes",
+		"Title -":          "Title",
+		"- Dashed -":       "Dashed",
+		"":                 "",
+	}
+	for in, want := range cases {
+		if got := cleanTitle(in); got != want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
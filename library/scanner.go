@@ -0,0 +1,599 @@
+package library
+
+import (
+	"fmt"
+	"go-cinema/config"
+	"go-cinema/dlna"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"go-cinema/sandbox"
+	"go-cinema/upload"
+	"go-cinema/utils"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kashari/golog"
+)
+
+type Kind string
+
+const (
+	// Movies roots hold movie files at any depth. Files named like episodes are
+	// still attached to the series they belong to.
+	Movies Kind = "movies"
+	// Series roots hold one directory per series, episodes may sit in season folders.
+	Series Kind = "series"
+)
+
+// Root is a directory the scanner imports media from.
+type Root struct {
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+}
+
+// Roots mirrors where uploads are stored. The directories are resolved like the
+// paths of the uploads, so the scanner and the watcher name files the same way.
+func Roots(media config.Media) []Root {
+	return []Root{
+		{Path: canonical(media.Movies), Kind: Movies},
+		{Path: canonical(media.Series), Kind: Series},
+	}
+}
+
+// canonical is the form paths are stored and compared in.
+func canonical(path string) string {
+	if resolved, err := sandbox.Canonical(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// Report summarises what a scan changed.
+type Report struct {
+	MoviesAdded     int      `json:"moviesAdded"`
+	SeriesAdded     int      `json:"seriesAdded"`
+	EpisodesAdded   int      `json:"episodesAdded"`
+	EpisodesUpdated int      `json:"episodesUpdated"`
+	Relocated       int      `json:"relocated"`
+	Restored        int      `json:"restored"`
+	Probed          int      `json:"probed"`
+	Hashed          int      `json:"hashed"`
+	Unchanged       int      `json:"unchanged"`
+	Skipped         []string `json:"skipped"`
+	Errors          []string `json:"errors"`
+}
+
+func (r *Report) changed() bool {
+	return r.MoviesAdded+r.SeriesAdded+r.EpisodesAdded+r.EpisodesUpdated+r.Relocated+r.Restored > 0
+}
+
+func (r *Report) fail(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	golog.Error("Library scan: {}", msg)
+	r.Errors = append(r.Errors, msg)
+}
+
+// Scanner imports the video files found under its roots. Files are matched to
+// existing rows by path, so running it again only picks up what is new.
+type Scanner struct {
+	Roots []Root
+
+	movies        map[string]*entity.Movie
+	episodes      map[string]*entity.Episode
+	series        map[string]*entity.Series
+	seriesByTitle map[string]*entity.Series
+	bySeries      map[uint][]*entity.Episode
+	touched       map[uint]bool
+	report        *Report
+	// allowed confines the files read to the roots, symlinks followed
+	allowed *sandbox.Set
+}
+
+func NewScanner(roots []Root) *Scanner {
+	return &Scanner{Roots: roots}
+}
+
+// Run walks every root and returns what was imported. It only fails when the
+// library could not be loaded, problems with single files end up in the report.
+func (s *Scanner) Run() (*Report, error) {
+	s.report = &Report{Skipped: []string{}, Errors: []string{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, root := range s.Roots {
+		golog.Info("Scanning {} library at {}", string(root.Kind), root.Path)
+		if err := s.walk(root); err != nil {
+			s.report.fail("cannot scan %s: %s", root.Path, err.Error())
+		}
+	}
+
+	s.finish()
+	return s.report, nil
+}
+
+// Import adds single files, as reported by the watcher. A file that matches a
+// missing movie or episode by name is taken as that one having moved.
+func (s *Scanner) Import(paths []string) (*Report, error) {
+	s.report = &Report{Skipped: []string{}, Errors: []string{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		root, ok := s.rootOf(path)
+		if !ok {
+			s.report.Skipped = append(s.report.Skipped, path)
+			continue
+		}
+		// a symlink inside a root may point anywhere
+		if _, err := s.allowed.Resolve(path); err != nil {
+			s.report.Skipped = append(s.report.Skipped, path)
+			continue
+		}
+		if s.relocateMissing(path) {
+			continue
+		}
+
+		if root.Kind == Series {
+			s.addSeriesFile(filepath.Clean(root.Path), path)
+		} else {
+			s.addMovieFile(path)
+		}
+	}
+
+	s.finish()
+	return s.report, nil
+}
+
+func (s *Scanner) finish() {
+	for id := range s.touched {
+		s.renumber(id)
+	}
+
+	if s.report.changed() {
+		dlna.BumpSystemUpdateID()
+	}
+}
+
+// rootOf returns the innermost root containing path.
+func (s *Scanner) rootOf(path string) (Root, bool) {
+	var found Root
+	ok := false
+	for _, root := range s.Roots {
+		rootPath := filepath.Clean(root.Path)
+		if !strings.HasPrefix(path, rootPath+string(filepath.Separator)) {
+			continue
+		}
+		if !ok || len(rootPath) > len(filepath.Clean(found.Path)) {
+			found, ok = root, true
+		}
+	}
+	return found, ok
+}
+
+func (s *Scanner) relocateMissing(path string) bool {
+	if _, ok := s.movies[path]; ok {
+		return false
+	}
+	if _, ok := s.episodes[path]; ok {
+		return false
+	}
+
+	name := filepath.Base(path)
+	for oldPath, movie := range s.movies {
+		if !movie.Missing || filepath.Base(oldPath) != name {
+			continue
+		}
+		movie.Path, movie.Missing, movie.MissingSince = path, false, nil
+		if err := repo.MovieRepository.Save(movie); err != nil {
+			s.report.fail("cannot relocate movie %s: %s", oldPath, err.Error())
+			return true
+		}
+		delete(s.movies, oldPath)
+		s.movies[path] = movie
+		s.report.Relocated++
+		return true
+	}
+
+	for oldPath, episode := range s.episodes {
+		if !episode.Missing || filepath.Base(oldPath) != name {
+			continue
+		}
+		episode.Path, episode.Missing, episode.MissingSince = path, false, nil
+		if err := repo.EpisodeRepository.Save(episode); err != nil {
+			s.report.fail("cannot relocate episode %s: %s", oldPath, err.Error())
+			return true
+		}
+		delete(s.episodes, oldPath)
+		s.episodes[path] = episode
+		s.report.Relocated++
+		return true
+	}
+
+	return false
+}
+
+func (s *Scanner) load() error {
+	dirs := make([]string, len(s.Roots))
+	for i, root := range s.Roots {
+		dirs[i] = root.Path
+	}
+	allowed, err := sandbox.NewSet(dirs...)
+	if err != nil {
+		return err
+	}
+	s.allowed = allowed
+
+	s.movies = make(map[string]*entity.Movie)
+	s.episodes = make(map[string]*entity.Episode)
+	s.series = make(map[string]*entity.Series)
+	s.seriesByTitle = make(map[string]*entity.Series)
+	s.bySeries = make(map[uint][]*entity.Episode)
+	s.touched = make(map[uint]bool)
+
+	movies, err := repo.MovieRepository.FindAll()
+	if err != nil {
+		return fmt.Errorf("loading movies: %w", err)
+	}
+	for _, movie := range movies.ToSlice() {
+		movie := movie
+		if path := canonical(movie.Path); path != movie.Path {
+			movie.Path = path
+			s.rewrite(repo.MovieRepository.Save(&movie), path)
+		}
+		s.movies[movie.Path] = &movie
+	}
+
+	series, err := repo.SeriesRepository.FindAll()
+	if err != nil {
+		return fmt.Errorf("loading series: %w", err)
+	}
+	for _, serie := range series.ToSlice() {
+		serie := serie
+		if dir := canonical(serie.BaseDir); dir != serie.BaseDir {
+			serie.BaseDir = dir
+			s.rewrite(repo.SeriesRepository.Save(&serie), dir)
+		}
+		s.series[serie.BaseDir] = &serie
+		s.seriesByTitle[titleKey(serie.Title)] = &serie
+	}
+
+	episodes, err := repo.EpisodeRepository.FindAll()
+	if err != nil {
+		return fmt.Errorf("loading episodes: %w", err)
+	}
+	for _, episode := range episodes.ToSlice() {
+		episode := episode
+		if path := canonical(episode.Path); path != episode.Path {
+			episode.Path = path
+			s.rewrite(repo.EpisodeRepository.Save(&episode), path)
+		}
+		s.episodes[episode.Path] = &episode
+		s.bySeries[episode.SeriesID] = append(s.bySeries[episode.SeriesID], &episode)
+	}
+
+	return nil
+}
+
+func (s *Scanner) walk(root Root) error {
+	rootPath := filepath.Clean(root.Path)
+
+	return filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == rootPath {
+				return err
+			}
+			s.report.fail("cannot read %s: %s", path, err.Error())
+			return nil
+		}
+
+		if d.IsDir() {
+			if path != rootPath && (strings.HasPrefix(d.Name(), ".") || s.isOtherRoot(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !utils.IsVideoFormat(d.Name()) {
+			return nil
+		}
+
+		if root.Kind == Series {
+			s.addSeriesFile(rootPath, path)
+		} else {
+			s.addMovieFile(path)
+		}
+		return nil
+	})
+}
+
+// isOtherRoot keeps nested roots, such as the Series folder inside the movies
+// folder, from being scanned twice with the wrong kind.
+func (s *Scanner) isOtherRoot(path string) bool {
+	for _, root := range s.Roots {
+		if filepath.Clean(root.Path) == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scanner) addMovieFile(path string) {
+	if movie, ok := s.movies[path]; ok {
+		restored := movie.Missing
+		// Movies imported before media info, or checksums, existed.
+		unprobed := movie.ProbedAt == nil
+		unhashed := movie.Checksum == ""
+		if !restored && !unprobed && !unhashed {
+			s.report.Unchanged++
+			return
+		}
+
+		movie.Missing, movie.MissingSince = false, nil
+		if unprobed {
+			s.probe(&movie.MediaInfo, path)
+		}
+		if unhashed {
+			unhashed = s.hash(&movie.Checksum, path)
+		}
+		if err := repo.MovieRepository.Save(movie); err != nil {
+			s.report.fail("cannot update movie %s: %s", path, err.Error())
+			return
+		}
+
+		if restored {
+			s.report.Restored++
+		}
+		if unprobed {
+			s.report.Probed++
+		}
+		if unhashed {
+			s.report.Hashed++
+		}
+		return
+	}
+
+	if name, ok := ParseEpisode(path); ok && name.Show != "" {
+		serie := s.seriesFor(filepath.Dir(path), name.Show)
+		if serie != nil {
+			s.addEpisode(serie, path)
+		}
+		return
+	}
+
+	if _, ok := s.episodes[path]; ok {
+		s.report.Unchanged++
+		return
+	}
+
+	title, year := ParseMovie(path)
+	movie := &entity.Movie{Title: title, Path: path, Year: year}
+	s.probe(&movie.MediaInfo, path)
+	s.hash(&movie.Checksum, path)
+	if err := repo.MovieRepository.Save(movie); err != nil {
+		s.report.fail("cannot save movie %s: %s", path, err.Error())
+		return
+	}
+
+	golog.Info("Library scan: added movie {}", title)
+	s.movies[path] = movie
+	s.report.MoviesAdded++
+}
+
+func (s *Scanner) addSeriesFile(rootPath, path string) {
+	rel, err := filepath.Rel(rootPath, path)
+	if err != nil {
+		s.report.fail("cannot resolve %s: %s", path, err.Error())
+		return
+	}
+
+	dir, _, nested := strings.Cut(rel, string(filepath.Separator))
+	if !nested {
+		// A loose file in the series root only tells its series by name.
+		name, ok := ParseEpisode(path)
+		if !ok || name.Show == "" {
+			s.report.Skipped = append(s.report.Skipped, path)
+			return
+		}
+		if serie := s.seriesFor(filepath.Join(rootPath, name.Show), name.Show); serie != nil {
+			s.addEpisode(serie, path)
+		}
+		return
+	}
+
+	if serie := s.seriesFor(filepath.Join(rootPath, dir), dir); serie != nil {
+		s.addEpisode(serie, path)
+	}
+}
+
+// seriesFor finds the series stored in baseDir or called title, creating it when neither exists.
+func (s *Scanner) seriesFor(baseDir, title string) *entity.Series {
+	if serie, ok := s.series[baseDir]; ok {
+		return serie
+	}
+	if serie, ok := s.seriesByTitle[titleKey(title)]; ok {
+		return serie
+	}
+
+	serie := &entity.Series{Title: cleanTitle(title), BaseDir: baseDir, CurrentIndex: 0}
+	if err := repo.SeriesRepository.Save(serie); err != nil {
+		s.report.fail("cannot save series %s: %s", serie.Title, err.Error())
+		return nil
+	}
+
+	golog.Info("Library scan: added series {}", serie.Title)
+	s.series[baseDir] = serie
+	s.seriesByTitle[titleKey(serie.Title)] = serie
+	s.report.SeriesAdded++
+	return serie
+}
+
+func (s *Scanner) addEpisode(serie *entity.Series, path string) {
+	name, parsed := ParseEpisode(path)
+
+	if episode, ok := s.episodes[path]; ok {
+		restored := episode.Missing
+		// Episodes added by hand have no season information yet.
+		backfill := parsed && episode.Season == 0 && episode.Number == 0
+		unprobed := episode.ProbedAt == nil
+		unhashed := episode.Checksum == ""
+		if !restored && !backfill && !unprobed && !unhashed {
+			s.report.Unchanged++
+			return
+		}
+
+		episode.Missing, episode.MissingSince = false, nil
+		if backfill {
+			episode.Season, episode.Number = name.Season, name.Episode
+		}
+		if unprobed {
+			s.probe(&episode.MediaInfo, path)
+		}
+		if unhashed {
+			unhashed = s.hash(&episode.Checksum, path)
+		}
+		if err := repo.EpisodeRepository.Save(episode); err != nil {
+			s.report.fail("cannot update episode %s: %s", path, err.Error())
+			return
+		}
+
+		if restored {
+			s.report.Restored++
+		}
+		if backfill {
+			s.touched[episode.SeriesID] = true
+			s.report.EpisodesUpdated++
+		}
+		if unprobed {
+			s.report.Probed++
+		}
+		if unhashed {
+			s.report.Hashed++
+		}
+		return
+	}
+
+	episode := &entity.Episode{
+		Path:         path,
+		EpisodeIndex: s.nextIndex(serie.ID),
+		SeriesID:     serie.ID,
+	}
+	if parsed {
+		episode.Season, episode.Number = name.Season, name.Episode
+	}
+	s.probe(&episode.MediaInfo, path)
+	s.hash(&episode.Checksum, path)
+
+	if err := repo.EpisodeRepository.Save(episode); err != nil {
+		s.report.fail("cannot save episode %s: %s", path, err.Error())
+		return
+	}
+
+	s.episodes[path] = episode
+	s.bySeries[serie.ID] = append(s.bySeries[serie.ID], episode)
+	s.touched[serie.ID] = true
+	s.report.EpisodesAdded++
+}
+
+// rewrite reports a path stored before paths were resolved that could not be
+// updated. The row is still matched by the resolved path, until the next scan.
+func (s *Scanner) rewrite(err error, path string) {
+	if err != nil {
+		s.report.fail("cannot update the path of %s: %s", path, err.Error())
+	}
+}
+
+// probe reads the media info of the file at path, only once resolved inside the roots.
+func (s *Scanner) probe(info *entity.MediaInfo, path string) {
+	resolved, err := s.allowed.Resolve(path)
+	if err == nil {
+		err = info.Probe(resolved)
+	}
+	if err != nil {
+		golog.Warn("Cannot read media info of {}: {}", path, err.Error())
+	}
+}
+
+// hash fills in the checksum uploads are compared against, from the file at
+// path resolved inside the roots. It tells whether it could.
+func (s *Scanner) hash(sum *string, path string) bool {
+	resolved, err := s.allowed.Resolve(path)
+	if err == nil {
+		*sum, err = upload.SumFile(resolved)
+	}
+	if err != nil {
+		golog.Warn("Cannot compute the checksum of {}: {}", path, err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Scanner) nextIndex(serieID uint) int {
+	next := 1
+	for _, episode := range s.bySeries[serieID] {
+		if episode.EpisodeIndex >= next {
+			next = episode.EpisodeIndex + 1
+		}
+	}
+	return next
+}
+
+// renumber orders the episodes of a series by season and episode number, so an
+// episode found later still lands in its place. Series with episodes the scanner
+// cannot place keep the order they were appended in. The current index follows
+// the episode it pointed at.
+func (s *Scanner) renumber(serieID uint) {
+	episodes := s.bySeries[serieID]
+	for _, episode := range episodes {
+		if episode.Season == 0 && episode.Number == 0 {
+			return
+		}
+	}
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if episodes[i].Season != episodes[j].Season {
+			return episodes[i].Season < episodes[j].Season
+		}
+		if episodes[i].Number != episodes[j].Number {
+			return episodes[i].Number < episodes[j].Number
+		}
+		return episodes[i].Path < episodes[j].Path
+	})
+
+	moved := make(map[int]int)
+	for i, episode := range episodes {
+		if episode.EpisodeIndex == i+1 {
+			continue
+		}
+		moved[episode.EpisodeIndex] = i + 1
+		episode.EpisodeIndex = i + 1
+		if err := repo.EpisodeRepository.Save(episode); err != nil {
+			s.report.fail("cannot renumber episode %s: %s", episode.Path, err.Error())
+		}
+	}
+
+	if len(moved) == 0 {
+		return
+	}
+
+	for _, serie := range s.series {
+		if serie.ID != serieID {
+			continue
+		}
+		if index, ok := moved[int(serie.CurrentIndex)]; ok {
+			serie.CurrentIndex = uint(index)
+			if err := repo.SeriesRepository.Save(serie); err != nil {
+				s.report.fail("cannot update series %s: %s", serie.Title, err.Error())
+			}
+		}
+		return
+	}
+}
+
+func titleKey(title string) string {
+	return strings.ToLower(cleanTitle(title))
+}
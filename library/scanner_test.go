@@ -0,0 +1,85 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go-cinema/config"
+	"go-cinema/database/dbtest"
+	repo "go-cinema/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanChecksums(t *testing.T) {
+	dbtest.Open(t)
+	dir := t.TempDir()
+	roots := Roots(mediaDirs(t, dir))
+
+	write := func(name, data string) string {
+		path := filepath.Join(roots[0].Path, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	newSum := write("New Movie (2001).mkv", "new")
+	oldSum := write("Old Movie (1999).mkv", "old")
+	// a movie stored before checksums, under a relative path
+	old := saveMovie(t, relative(t, filepath.Join(roots[0].Path, "Old Movie (1999).mkv")))
+
+	report, err := NewScanner(roots).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.MoviesAdded != 1 || report.Hashed != 1 || len(report.Errors) != 0 {
+		t.Errorf("report %+v", report)
+	}
+
+	movies, err := repo.MovieRepository.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string]string{}
+	for _, movie := range movies.ToSlice() {
+		sums[movie.Title] = movie.Checksum
+		if movie.ID == old.ID && movie.Path != filepath.Join(roots[0].Path, "Old Movie (1999).mkv") {
+			t.Errorf("old movie still at %s", movie.Path)
+		}
+	}
+	if sums["New Movie"] != newSum || sums[old.Title] != oldSum {
+		t.Errorf("checksums %v", sums)
+	}
+
+	report, err = NewScanner(roots).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchanged != 2 || report.changed() || report.Hashed != 0 {
+		t.Errorf("second scan %+v", report)
+	}
+}
+
+func mediaDirs(t *testing.T, dir string) config.Media {
+	t.Helper()
+	media := config.Media{Movies: dir, Series: filepath.Join(dir, "Series")}
+	if err := os.MkdirAll(media.Series, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return media
+}
+
+// relative is path from the working directory, as older versions stored it.
+func relative(t *testing.T, path string) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
@@ -0,0 +1,149 @@
+package library
+
+import (
+	"go-cinema/dlna"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// underPath selects the rows whose path is the given file, or for a directory,
+// any file below it.
+func underPath(column, path string, dir bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if dir {
+			return db.Where(repo.Like(column), repo.EscapeLike(path+string(filepath.Separator))+"%")
+		}
+		return db.Where(column+" = ?", path)
+	}
+}
+
+// movedPath rewrites path when it is from, or below from, as a directory.
+func movedPath(path, from, to string) (string, bool) {
+	if path == from {
+		return to, true
+	}
+	if strings.HasPrefix(path, from+string(filepath.Separator)) {
+		return to + strings.TrimPrefix(path, from), true
+	}
+	return path, false
+}
+
+// markMissing flags the movies and episodes stored at path, or below it for a
+// directory, as missing. They stay in the library so their progress survives
+// the file coming back.
+func markMissing(path string, dir bool) error {
+	now := time.Now()
+	changed := false
+
+	movies, err := repo.MovieRepository.FindByQuery(underPath("path", path, dir))
+	if err != nil {
+		return err
+	}
+	for _, movie := range movies.ToSlice() {
+		if movie.Missing {
+			continue
+		}
+		movie.Missing, movie.MissingSince = true, &now
+		if err := repo.MovieRepository.Save(&movie); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	episodes, err := repo.EpisodeRepository.FindByQuery(underPath("path", path, dir))
+	if err != nil {
+		return err
+	}
+	for _, episode := range episodes.ToSlice() {
+		if episode.Missing {
+			continue
+		}
+		episode.Missing, episode.MissingSince = true, &now
+		if err := repo.EpisodeRepository.Save(&episode); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if changed {
+		dlna.BumpSystemUpdateID()
+	}
+	return nil
+}
+
+// relocate follows a rename inside the library. It reports whether any record
+// was stored at the old path.
+func relocate(from, to string, dir bool) (bool, error) {
+	found := false
+
+	movies, err := repo.MovieRepository.FindByQuery(underPath("path", from, dir))
+	if err != nil {
+		return false, err
+	}
+	for _, movie := range movies.ToSlice() {
+		movie.Path, _ = movedPath(movie.Path, from, to)
+		movie.Missing, movie.MissingSince = false, nil
+		if err := repo.MovieRepository.Save(&movie); err != nil {
+			return found, err
+		}
+		found = true
+	}
+
+	episodes, err := repo.EpisodeRepository.FindByQuery(underPath("path", from, dir))
+	if err != nil {
+		return found, err
+	}
+	for _, episode := range episodes.ToSlice() {
+		episode.Path, _ = movedPath(episode.Path, from, to)
+		episode.Missing, episode.MissingSince = false, nil
+		if err := repo.EpisodeRepository.Save(&episode); err != nil {
+			return found, err
+		}
+		found = true
+	}
+
+	if dir {
+		series, err := repo.SeriesRepository.FindAll()
+		if err != nil {
+			return found, err
+		}
+		for _, serie := range series.ToSlice() {
+			baseDir, moved := movedPath(filepath.Clean(serie.BaseDir), from, to)
+			if !moved {
+				continue
+			}
+			serie.BaseDir = baseDir
+			if err := repo.SeriesRepository.Save(&serie); err != nil {
+				return found, err
+			}
+			found = true
+		}
+	}
+
+	if found {
+		dlna.BumpSystemUpdateID()
+	}
+	return found, nil
+}
+
+// MissingMedia lists the movies and episodes whose files have disappeared.
+func MissingMedia() ([]entity.Movie, []entity.Episode, error) {
+	missing := func(db *gorm.DB) *gorm.DB {
+		return db.Where("missing = ?", true).Order("missing_since")
+	}
+
+	movies, err := repo.MovieRepository.FindByQuery(missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	episodes, err := repo.EpisodeRepository.FindByQuery(missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	return movies.ToSlice(), episodes.ToSlice(), nil
+}
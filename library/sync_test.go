@@ -0,0 +1,61 @@
+package library
+
+import (
+	"go-cinema/database/dbtest"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"path/filepath"
+	"testing"
+)
+
+func saveMovie(t *testing.T, path string) *entity.Movie {
+	t.Helper()
+	movie := entity.Movie{Title: filepath.Base(path), Path: path}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatalf("saving %s: %v", path, err)
+	}
+	return &movie
+}
+
+func TestMarkMissingDirectory(t *testing.T) {
+	dbtest.Open(t)
+	inside := saveMovie(t, filepath.Join("media", "50%_off", "a.mkv"))
+	lookalike := saveMovie(t, filepath.Join("media", "50x-off", "a.mkv"))
+
+	if err := markMissing(filepath.Join("media", "50%_off"), true); err != nil {
+		t.Fatalf("markMissing: %v", err)
+	}
+
+	for _, c := range []struct {
+		movie   *entity.Movie
+		missing bool
+	}{{inside, true}, {lookalike, false}} {
+		found, err := repo.MovieRepository.FindByID(c.movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Missing != c.missing {
+			t.Errorf("%s missing = %v, want %v", found.Path, found.Missing, c.missing)
+		}
+	}
+}
+
+func TestRelocateDirectory(t *testing.T) {
+	dbtest.Open(t)
+	movie := saveMovie(t, filepath.Join("media", `it's!`, "a.mkv"))
+	other := saveMovie(t, filepath.Join("media", "its", "a.mkv"))
+
+	found, err := relocate(filepath.Join("media", `it's!`), filepath.Join("media", "moved"), true)
+	if err != nil || !found {
+		t.Fatalf("relocate = %v, %v", found, err)
+	}
+
+	moved, _ := repo.MovieRepository.FindByID(movie.ID)
+	if want := filepath.Join("media", "moved", "a.mkv"); moved.Path != want {
+		t.Errorf("moved path = %s, want %s", moved.Path, want)
+	}
+	untouched, _ := repo.MovieRepository.FindByID(other.ID)
+	if untouched.Path != other.Path {
+		t.Errorf("unrelated path changed to %s", untouched.Path)
+	}
+}
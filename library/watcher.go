@@ -0,0 +1,267 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"go-cinema/utils"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+type fsOp int
+
+const (
+	opCreate fsOp = iota
+	opWrite
+	opRemove
+	opMoveFrom
+	opMoveTo
+	opOverflow
+)
+
+// fsEvent is a change below a watched directory.
+type fsEvent struct {
+	Path   string
+	Op     fsOp
+	Dir    bool
+	Cookie uint32
+}
+
+var errNotifierClosed = errors.New("notifier closed")
+
+// notifier is implemented per platform, see inotify_linux.go.
+type notifier interface {
+	add(dir string) error
+	remove(dir string)
+	rename(from, to string)
+	read() ([]fsEvent, error)
+	close() error
+}
+
+// Watcher keeps the database in sync with the library roots while the server runs.
+// Events are collected until the folders have been quiet for Debounce, so a copy
+// or a batch of renames is applied in one go.
+type Watcher struct {
+	Roots    []Root
+	Debounce time.Duration
+
+	notifier notifier
+}
+
+func NewWatcher(roots []Root) *Watcher {
+	return &Watcher{Roots: roots, Debounce: 2 * time.Second}
+}
+
+// batch is what happened between two quiet periods.
+type batch struct {
+	movedFrom map[uint32]fsEvent
+	renames   [][2]fsEvent
+	removed   []fsEvent
+	added     map[string]bool
+	rescan    bool
+}
+
+func newBatch() *batch {
+	return &batch{movedFrom: make(map[uint32]fsEvent), added: make(map[string]bool)}
+}
+
+func (b *batch) empty() bool {
+	return len(b.movedFrom) == 0 && len(b.renames) == 0 && len(b.removed) == 0 && len(b.added) == 0 && !b.rescan
+}
+
+// Run watches the roots until the context is cancelled.
+func (w *Watcher) Run(ctx context.Context) error {
+	n, err := newNotifier()
+	if err != nil {
+		return err
+	}
+	w.notifier = n
+	defer n.close()
+
+	for _, root := range w.Roots {
+		if err := w.watchTree(root.Path); err != nil {
+			return err
+		}
+		golog.Info("Watching {} library at {}", string(root.Kind), root.Path)
+	}
+
+	events := make(chan []fsEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			batch, err := n.read()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(w.Debounce)
+	timer.Stop()
+	pending := newBatch()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !pending.empty() {
+				w.apply(pending)
+			}
+			return nil
+		case err := <-errs:
+			if errors.Is(err, errNotifierClosed) {
+				return nil
+			}
+			return err
+		case list := <-events:
+			for _, event := range list {
+				w.collect(pending, event)
+			}
+			timer.Reset(w.Debounce)
+		case <-timer.C:
+			w.apply(pending)
+			pending = newBatch()
+		}
+	}
+}
+
+func (w *Watcher) watchTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			golog.Warn("Cannot watch {}: {}", path, err.Error())
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.notifier.add(path)
+	})
+}
+
+func (w *Watcher) collect(b *batch, event fsEvent) {
+	switch event.Op {
+	case opOverflow:
+		golog.Warn("Too many library changes at once, a full scan will follow")
+		b.rescan = true
+	case opCreate:
+		// Files are picked up once written, directories have to be watched right
+		// away to see what gets copied into them.
+		if event.Dir {
+			if err := w.watchTree(event.Path); err != nil {
+				golog.Warn("Cannot watch {}: {}", event.Path, err.Error())
+			}
+			b.added[event.Path] = true
+		}
+	case opWrite:
+		b.added[event.Path] = true
+	case opRemove:
+		delete(b.added, event.Path)
+		b.removed = append(b.removed, event)
+	case opMoveFrom:
+		delete(b.added, event.Path)
+		b.movedFrom[event.Cookie] = event
+	case opMoveTo:
+		if from, ok := b.movedFrom[event.Cookie]; ok {
+			delete(b.movedFrom, event.Cookie)
+			if event.Dir {
+				w.notifier.rename(from.Path, event.Path)
+			}
+			b.renames = append(b.renames, [2]fsEvent{from, event})
+			return
+		}
+		// Moved in from outside the library.
+		if event.Dir {
+			if err := w.watchTree(event.Path); err != nil {
+				golog.Warn("Cannot watch {}: {}", event.Path, err.Error())
+			}
+		}
+		b.added[event.Path] = true
+	}
+}
+
+func (w *Watcher) apply(b *batch) {
+	if b.rescan {
+		report, err := NewScanner(w.Roots).Run()
+		if err != nil {
+			golog.Error("Library scan failed: {}", err.Error())
+			return
+		}
+		golog.Info("Library scan done: {} movies, {} series, {} episodes added", report.MoviesAdded, report.SeriesAdded, report.EpisodesAdded)
+		return
+	}
+
+	for _, rename := range b.renames {
+		from, to := rename[0], rename[1]
+		if !from.Dir && !utils.IsVideoFormat(to.Path) {
+			b.removed = append(b.removed, from)
+			continue
+		}
+		if _, err := relocate(from.Path, to.Path, from.Dir); err != nil {
+			golog.Error("Cannot follow rename of {}: {}", from.Path, err.Error())
+			continue
+		}
+		// Also picks up files that only now got a video name, like a finished download.
+		b.added[to.Path] = true
+	}
+
+	// Moved out of the library, which for us is the same as deleted.
+	for _, event := range b.movedFrom {
+		b.removed = append(b.removed, event)
+	}
+	for _, event := range b.removed {
+		if event.Dir {
+			w.notifier.remove(event.Path)
+		}
+		if err := markMissing(event.Path, event.Dir); err != nil {
+			golog.Error("Cannot mark {} as missing: {}", event.Path, err.Error())
+		}
+	}
+
+	var files []string
+	for path := range b.added {
+		files = append(files, videoFiles(path)...)
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	report, err := NewScanner(w.Roots).Import(files)
+	if err != nil {
+		golog.Error("Cannot import new library files: {}", err.Error())
+		return
+	}
+	golog.Info("Library watcher: {} movies, {} episodes added, {} relocated", report.MoviesAdded, report.EpisodesAdded, report.Relocated)
+}
+
+// videoFiles lists the video files at path, which may be a file or a directory tree.
+func videoFiles(path string) []string {
+	var files []string
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() && utils.IsVideoFormat(p) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files
+}
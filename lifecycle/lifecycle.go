@@ -0,0 +1,132 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager runs the parts of the server until SIGINT or SIGTERM, or until one
+// of them fails, then stops them in the reverse order they were registered.
+// SIGHUP runs the reload functions instead.
+type Manager struct {
+	timeout time.Duration
+	hooks   []hook
+	reloads []func() error
+	failed  chan error
+	// signals receives SIGINT, SIGTERM and SIGHUP while Run runs
+	signals chan os.Signal
+}
+
+// New returns a manager giving each stop hook timeout to finish.
+func New(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout, failed: make(chan error, 1), signals: make(chan os.Signal, 1)}
+}
+
+// Go runs a part of the server in its own goroutine. An error it returns shuts
+// the server down, return nil when it ends because it was stopped.
+func (m *Manager) Go(name string, run func() error) {
+	go func() {
+		if err := run(); err != nil {
+			select {
+			case m.failed <- fmt.Errorf("%s: %w", name, err):
+			default:
+			}
+		}
+	}()
+}
+
+// OnStop registers what stops a part of the server. Register a part after the
+// ones it depends on, so it stops before them.
+func (m *Manager) OnStop(name string, stop func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// OnReload registers what SIGHUP does.
+func (m *Manager) OnReload(reload func() error) {
+	m.reloads = append(m.reloads, reload)
+}
+
+// Run blocks until the server has to stop, then stops it. It returns the error
+// of the part which failed, and those of the stop hooks.
+func (m *Manager) Run(ctx context.Context) error {
+	signal.Notify(m.signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(m.signals)
+
+	var cause error
+wait:
+	for {
+		select {
+		case sig := <-m.signals:
+			if sig == syscall.SIGHUP {
+				m.reload()
+				continue
+			}
+			golog.Info("Received {}, shutting down", sig.String())
+			break wait
+		case cause = <-m.failed:
+			golog.Error("Shutting down, {}", cause.Error())
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	return errors.Join(cause, m.shutdown())
+}
+
+func (m *Manager) reload() {
+	golog.Info("Received hangup, reloading")
+	for _, reload := range m.reloads {
+		if err := reload(); err != nil {
+			golog.Error("Reload failed: {}", err.Error())
+		}
+	}
+}
+
+// shutdown runs the stop hooks, newest first. A second signal cuts the wait short.
+func (m *Manager) shutdown() error {
+	forced, force := context.WithCancel(context.Background())
+	defer force()
+	go func() {
+		for {
+			select {
+			case sig := <-m.signals:
+				if sig != syscall.SIGHUP {
+					golog.Warn("Received {} again, not waiting any longer", sig.String())
+					force()
+					return
+				}
+			case <-forced.Done():
+				return
+			}
+		}
+	}()
+
+	var errs []error
+	for i := len(m.hooks) - 1; i >= 0; i-- {
+		h := m.hooks[i]
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(forced, m.timeout)
+		err := h.stop(ctx)
+		cancel()
+		if err != nil {
+			golog.Error("Stopping {} failed: {}", h.name, err.Error())
+			errs = append(errs, fmt.Errorf("stopping %s: %w", h.name, err))
+			continue
+		}
+		golog.Info("Stopped {} in {}", h.name, time.Since(start).Round(time.Millisecond).String())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+func init() {
+	_ = golog.Init(os.DevNull)
+}
+
+// run runs m in the background, its error is sent once it returns.
+func run(m *Manager, ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+	return done
+}
+
+func wait(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestStopInReverseOrder(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	m := New(timeout)
+	var mu sync.Mutex
+	var stopped []string
+	record := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+	m.OnStop("database", record("database"))
+	m.OnStop("http", record("http"))
+	// a stream which does not end is cut at the deadline
+	m.OnStop("stream", func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > timeout {
+			t.Errorf("stopping without the deadline of the drain, %v", deadline)
+		}
+		<-ctx.Done()
+		_ = record("stream")(ctx)
+		return ctx.Err()
+	})
+
+	done := run(m, context.Background())
+	m.signals <- syscall.SIGTERM
+	err := wait(t, done)
+
+	if want := []string{"stream", "http", "database"}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("stopped %v, want %v", stopped, want)
+	}
+	// the hooks after the one which failed still run
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run: %v, want the deadline of the stream", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	m := New(time.Second)
+	reloaded := make(chan struct{}, 2)
+	m.OnReload(func() error {
+		reloaded <- struct{}{}
+		return errors.New("cannot reload")
+	})
+	m.OnReload(func() error {
+		reloaded <- struct{}{}
+		return nil
+	})
+	stopped := false
+	m.OnStop("http", func(context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	done := run(m, context.Background())
+	m.signals <- syscall.SIGHUP
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reloaded:
+		case <-time.After(5 * time.Second):
+			t.Fatal("SIGHUP did not reload")
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("SIGHUP stopped the server: %v", err)
+	default:
+	}
+
+	m.signals <- syscall.SIGINT
+	if err := wait(t, done); err != nil || !stopped {
+		t.Errorf("Run: %v, stopped %v", err, stopped)
+	}
+}
+
+func TestSecondSignalForcesExit(t *testing.T) {
+	m := New(time.Minute)
+	draining := make(chan struct{})
+	m.OnStop("database", func(ctx context.Context) error { return ctx.Err() })
+	m.OnStop("stream", func(ctx context.Context) error {
+		close(draining)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	done := run(m, context.Background())
+	m.signals <- syscall.SIGTERM
+	<-draining
+	// a hangup while draining does not cut it short
+	m.signals <- syscall.SIGHUP
+	m.signals <- syscall.SIGINT
+	start := time.Now()
+	err := wait(t, done)
+	if !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Errorf("Run: %v after %v, want it cancelled at once", err, time.Since(start))
+	}
+}
+
+func TestFailure(t *testing.T) {
+	m := New(time.Second)
+	failed := errors.New("address in use")
+	stopped := false
+	m.OnStop("http", func(context.Context) error {
+		stopped = true
+		return nil
+	})
+	m.Go("http", func() error { return failed })
+	// a part stopped on purpose does not shut the server down
+	m.Go("dlna", func() error { return nil })
+
+	err := wait(t, run(m, context.Background()))
+	if !errors.Is(err, failed) || !stopped {
+		t.Errorf("Run: %v, stopped %v", err, stopped)
+	}
+
+	// the context of Run ending stops the server without an error
+	m = New(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := wait(t, run(m, ctx)); err != nil {
+		t.Errorf("Run after the context ended: %v", err)
+	}
+}
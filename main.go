@@ -2,78 +2,62 @@ package main
 
 import (
 	"flag"
-	entity "go-cinema/entities"
-	"go-cinema/model"
-	repo "go-cinema/repository"
+	"fmt"
+	"go-cinema/config"
+	filehandler "go-cinema/io"
 	"go-cinema/theatre"
-	"net/http"
-	"time"
+	"os"
 
 	"github.com/kashari/golog"
 )
 
-type functionsMap map[string]func()
-
 func main() {
-	golog.Init("/tmp/theatre.log")
-	db, err := theatre.InitDB()
-	if err != nil {
-		golog.Error("Cannot connect to the database due to: {}", err.Error())
-		return
-	}
-
-	functions := functionsMap{
-		"migrate": func() {
-			golog.Info("Running migration")
-
-			err = db.AutoMigrate(&model.User{}, &entity.Movie{}, &entity.Series{}, &entity.Episode{})
-			if err != nil {
-				golog.Error("Failed to run migration: {}", err.Error())
-				return
-			}
+	root := &command{
+		name:    program,
+		summary: "A media server for movies and series, with a web frontend and DLNA.",
+		subcommands: []*command{
+			serveCommand,
+			migrateCommand,
+			scanCommand,
+			importCommand,
+			exportCommand,
+			userCommand,
+			movieCommand,
+			seriesCommand,
+			doctorCommand,
 		},
 	}
+	root.subcommands = append(root.subcommands, helpCommand(root))
 
-	funcName := flag.String("f", "", "The name of the function to execute.")
+	flags := config.BindFlags(flag.CommandLine)
+	flag.Usage = func() { root.usage(os.Stderr, program, flag.CommandLine) }
 	flag.Parse()
 
-	function := functions[*funcName]
-
-	if function != nil {
-		golog.Info("Executing function: {}", *funcName)
-		// here the function gets executed if everything went well
-		function()
-		golog.Info("Function executed successfully")
-		return
+	cfg, err := flags.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	repo.InitRepositories(db)
-
-	router := theatre.SetupRoutes()
-
-	logo := `
-            __       .__  .__               .__                          
-   ____    |__|____  |  | |  | _____ _______|  |__   ___________  ____   
-  / ___\   |  \__  \ |  | |  | \__  \\_  __ \  |  \ /  _ \_  __ \/    \  
- / /_/  >  |  |/ __ \|  |_|  |__/ __ \|  | \/   Y  (  <_> )  | \/   |  \ 
- \___  /\__|  (____  /____/____(____  /__|  |___|  /\____/|__|  |___|  / 
-/_____/\______|    \/               \/           \/                  \/  
-
-			gjållårhðrñ - A simple HTTP router for Go
-	`
-
-	port := "9090"
-	golog.Info(logo)
-	golog.Info("Started server on port {}", port)
+	if err := golog.Init(cfg.Log.Path); err != nil {
+		fmt.Fprintf(os.Stderr, "opening the log %s: %v\n", cfg.Log.Path, err)
+		os.Exit(1)
+	}
 
-	server := &http.Server{
-		Addr:         ":9090",
-		Handler:      router, // Use the ServeMux
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
+	if err := theatre.Configure(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	filehandler.SetUsageDataPath(cfg.Media.UsageData)
 
-	if err := server.ListenAndServe(); err != nil {
-		golog.Error("Failed to start server: {}", err.Error())
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{serveCommand.name}
 	}
+
+	a := &app{cfg: cfg, flags: flags}
+	status := root.run(a, args)
+	_ = a.close()
+	golog.Close()
+	os.Exit(status)
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	entity "go-cinema/entities"
+	"go-cinema/library"
+	repo "go-cinema/repository"
+	"go-cinema/sandbox"
+	"go-cinema/upload"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+var movieCommand = &command{
+	name:    "movie",
+	summary: "Manage the movies.",
+	subcommands: []*command{
+		{
+			name:    "add",
+			args:    "PATH",
+			summary: "Add the movie of a video file, titled after the file unless -title is given.",
+			setup: func(fs *flag.FlagSet) runner {
+				title := fs.String("title", "", "The title.")
+				description := fs.String("description", "", "The description.")
+				year := fs.Int("year", 0, "The release year.")
+				return func(a *app, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					path, err := sandbox.Canonical(args[0])
+					if err != nil {
+						return err
+					}
+					if info, err := os.Stat(path); err != nil {
+						return err
+					} else if info.IsDir() {
+						return fmt.Errorf("%s is a directory", path)
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					movie := entity.Movie{Title: *title, Description: *description, Path: path, Year: *year}
+					parsedTitle, parsedYear := library.ParseMovie(path)
+					if movie.Title == "" {
+						movie.Title = parsedTitle
+					}
+					if movie.Year == 0 {
+						movie.Year = parsedYear
+					}
+					if err := movie.Probe(path); err != nil {
+						golog.Warn("Cannot read media info of {}: {}", path, err)
+					}
+					if movie.Checksum, err = upload.SumFile(path); err != nil {
+						return err
+					}
+
+					if err := repo.MovieRepository.Save(&movie); err != nil {
+						return err
+					}
+					fmt.Printf("Added movie %s with ID %d\n", movie.Title, movie.ID)
+					return nil
+				}
+			},
+		},
+		{
+			name:    "rm",
+			args:    "ID...",
+			summary: "Delete movies and their files.",
+			setup: func(fs *flag.FlagSet) runner {
+				keepFiles := fs.Bool("keep-files", false, "Keep the video files, only forget the movies.")
+				return func(a *app, args []string) error {
+					ids, err := parseIDs(args)
+					if err != nil {
+						return err
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					for _, id := range ids {
+						movie, err := repo.MovieRepository.FindByID(id)
+						if err != nil {
+							return fmt.Errorf("movie %d: %w", id, err)
+						}
+						if err := repo.MovieRepository.DeleteByID(id); err != nil {
+							return err
+						}
+						if !*keepFiles {
+							if err := os.Remove(movie.Path); err != nil && !os.IsNotExist(err) {
+								golog.Error("Error deleting movie file: {}", err)
+							}
+						}
+						fmt.Printf("Deleted movie %d %s\n", movie.ID, movie.Title)
+					}
+					return nil
+				}
+			},
+		},
+		{
+			name:    "ls",
+			summary: "List the movies.",
+			setup: func(fs *flag.FlagSet) runner {
+				missing := fs.Bool("missing", false, "Only list the movies whose file is missing.")
+				return func(a *app, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					movies, err := repo.MovieRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+						if *missing {
+							db = db.Where("missing = ?", true)
+						}
+						return db.Order("title")
+					})
+					if err != nil {
+						return err
+					}
+
+					rows := [][]string{{"ID", "TITLE", "YEAR", "DURATION", "PATH"}}
+					for _, movie := range movies.ToSlice() {
+						year := ""
+						if movie.Year > 0 {
+							year = strconv.Itoa(movie.Year)
+						}
+						path := movie.Path
+						if movie.Missing {
+							path += " (missing)"
+						}
+						rows = append(rows, []string{
+							strconv.FormatUint(uint64(movie.ID), 10), movie.Title, year,
+							formatDuration(movie.DurationMs), path,
+						})
+					}
+					return table(rows)
+				}
+			},
+		},
+	},
+}
+
+var seriesCommand = &command{
+	name:    "series",
+	summary: "Manage the series.",
+	subcommands: []*command{
+		{
+			name:    "add",
+			args:    "TITLE",
+			summary: "Add a series, with a directory for its episodes under the series media directory unless -dir is given.",
+			setup: func(fs *flag.FlagSet) runner {
+				description := fs.String("description", "", "The description.")
+				dir := fs.String("dir", "", "The directory of the episodes, created when missing.")
+				return func(a *app, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					baseDir := *dir
+					if baseDir == "" {
+						baseDir = filepath.Join(a.cfg.Media.Series, args[0])
+					}
+					baseDir, err := sandbox.Canonical(baseDir)
+					if err != nil {
+						return err
+					}
+					if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
+						return err
+					}
+
+					series := entity.Series{Title: args[0], Description: *description, BaseDir: baseDir}
+					if err := repo.SeriesRepository.Save(&series); err != nil {
+						return err
+					}
+					fmt.Printf("Added series %s with ID %d in %s\n", series.Title, series.ID, series.BaseDir)
+					return nil
+				}
+			},
+		},
+		{
+			name:    "ls",
+			summary: "List the series.",
+			setup: func(fs *flag.FlagSet) runner {
+				return func(a *app, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					db, err := a.database()
+					if err != nil {
+						return err
+					}
+
+					var rows []struct {
+						ID       uint
+						Title    string
+						BaseDir  string
+						Episodes int
+					}
+					err = db.Table("series").
+						Select("series.id, series.title, series.base_dir, COUNT(episodes.id) AS episodes").
+						Joins("LEFT JOIN episodes ON episodes.series_id = series.id AND episodes.deleted_at IS NULL").
+						Where("series.deleted_at IS NULL").
+						Group("series.id, series.title, series.base_dir").
+						Order("series.title").
+						Scan(&rows).Error
+					if err != nil {
+						return err
+					}
+
+					out := [][]string{{"ID", "TITLE", "EPISODES", "DIRECTORY"}}
+					for _, row := range rows {
+						out = append(out, []string{
+							strconv.FormatUint(uint64(row.ID), 10), row.Title, strconv.Itoa(row.Episodes), row.BaseDir,
+						})
+					}
+					return table(out)
+				}
+			},
+		},
+	},
+}
+
+func parseIDs(args []string) ([]uint, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+	ids := make([]uint, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", arg)
+		}
+		ids[i] = uint(id)
+	}
+	return ids, nil
+}
+
+func formatDuration(ms int64) string {
+	if ms <= 0 {
+		return ""
+	}
+	return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+}
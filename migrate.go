@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-cinema/migrations"
+	"strconv"
+
+	"github.com/kashari/golog"
+)
+
+var migrateCommand = &command{
+	name:    "migrate",
+	summary: "Apply, roll back and create schema migrations.",
+	subcommands: []*command{
+		{
+			name:    "up",
+			summary: "Apply every pending migration.",
+			setup: func(fs *flag.FlagSet) runner {
+				return func(a *app, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					migrator, err := newMigrator(a)
+					if err != nil {
+						return err
+					}
+					count, err := migrator.Up()
+					golog.Info("Applied {} migrations", count)
+					return err
+				}
+			},
+		},
+		{
+			name:    "down",
+			args:    "[N]",
+			summary: "Roll back the last N applied migrations, 1 by default.",
+			setup: func(fs *flag.FlagSet) runner {
+				return func(a *app, args []string) error {
+					n := 1
+					switch len(args) {
+					case 0:
+					case 1:
+						parsed, err := strconv.Atoi(args[0])
+						if err != nil || parsed < 1 {
+							return errUsage
+						}
+						n = parsed
+					default:
+						return errUsage
+					}
+
+					migrator, err := newMigrator(a)
+					if err != nil {
+						return err
+					}
+					count, err := migrator.Down(n)
+					golog.Info("Rolled back {} migrations", count)
+					return err
+				}
+			},
+		},
+		{
+			name:    "status",
+			summary: "List the migrations and when they were applied.",
+			setup: func(fs *flag.FlagSet) runner {
+				return func(a *app, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					migrator, err := newMigrator(a)
+					if err != nil {
+						return err
+					}
+					statuses, err := migrator.Status()
+					if err != nil {
+						return err
+					}
+
+					rows := [][]string{{"VERSION", "NAME", "APPLIED"}}
+					for _, status := range statuses {
+						applied := "pending"
+						if status.AppliedAt != nil {
+							applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+						}
+						rows = append(rows, []string{fmt.Sprintf("%04d", status.Version), status.Name, applied})
+					}
+					return table(rows)
+				}
+			},
+		},
+		{
+			name:    "create",
+			args:    "NAME",
+			summary: "Add the up and down SQL files of a new migration, the binary has to be rebuilt to apply it.",
+			setup: func(fs *flag.FlagSet) runner {
+				dir := fs.String("dir", migrations.SQLDir, "The directory of the SQL migrations.")
+				return func(_ *app, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					paths, err := migrations.Create(*dir, args[0])
+					for _, path := range paths {
+						fmt.Println("Created", path)
+					}
+					return err
+				}
+			},
+		},
+	},
+}
+
+func newMigrator(a *app) (*migrations.Migrator, error) {
+	db, err := a.database()
+	if err != nil {
+		return nil, err
+	}
+	return migrations.New(db)
+}
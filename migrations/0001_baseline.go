@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The tables as they were when migrations were introduced. These copies stay
+// frozen, later changes to the entities come with migrations of their own.
+// Databases created by AutoMigrate before then already match and are left as they are.
+
+type baselineUser struct {
+	gorm.Model
+	Username string `gorm:"size:191;unique;not null"`
+	Password string `gorm:"not null"`
+	Email    string `gorm:"size:191;unique;not null"`
+	Role     string `gorm:"size:32;not null;default:viewer"`
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineRefreshToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+}
+
+func (baselineRefreshToken) TableName() string { return "refresh_tokens" }
+
+type baselineMediaInfo struct {
+	DurationMs int64
+	Width      int
+	Height     int
+	Container  string
+	VideoCodec string
+	AudioCodec string
+	Tracks     string `gorm:"type:text"`
+	ProbedAt   *time.Time
+}
+
+type baselineMovie struct {
+	gorm.Model
+	Title        string `gorm:"not null"`
+	Description  string
+	Path         string `gorm:"not null"`
+	Year         int
+	Missing      bool `gorm:"index"`
+	MissingSince *time.Time
+	MediaInfo    baselineMediaInfo `gorm:"embedded"`
+}
+
+func (baselineMovie) TableName() string { return "movies" }
+
+type baselineSeries struct {
+	gorm.Model
+	Title        string
+	Description  string
+	BaseDir      string            `gorm:"not null"`
+	Episodes     []baselineEpisode `gorm:"foreignKey:SeriesID"`
+	CurrentIndex uint              `gorm:"not null"`
+}
+
+func (baselineSeries) TableName() string { return "series" }
+
+type baselineEpisode struct {
+	gorm.Model
+	Path         string `gorm:"not null"`
+	EpisodeIndex int    `gorm:"not null"`
+	Season       int
+	Number       int
+	SeriesID     uint
+	Missing      bool `gorm:"index"`
+	MissingSince *time.Time
+	MediaInfo    baselineMediaInfo `gorm:"embedded"`
+}
+
+func (baselineEpisode) TableName() string { return "episodes" }
+
+type baselineProgress struct {
+	PositionMs int64
+	DurationMs int64
+	Percent    float64
+	Watched    bool `gorm:"index"`
+	UpdatedAt  *time.Time
+}
+
+type baselineWatchProgress struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint             `gorm:"not null;uniqueIndex:idx_watch_progress_item"`
+	MovieID   uint             `gorm:"not null;default:0;uniqueIndex:idx_watch_progress_item"`
+	EpisodeID uint             `gorm:"not null;default:0;uniqueIndex:idx_watch_progress_item"`
+	Progress  baselineProgress `gorm:"embedded;embeddedPrefix:progress_"`
+}
+
+func (baselineWatchProgress) TableName() string { return "watch_progresses" }
+
+// baselineTables in creation order, series before the episodes referencing them.
+var baselineTables = []any{
+	&baselineUser{},
+	&baselineRefreshToken{},
+	&baselineMovie{},
+	&baselineSeries{},
+	&baselineEpisode{},
+	&baselineWatchProgress{},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(baselineTables...)
+		},
+		Down: func(tx *gorm.DB) error {
+			for i := len(baselineTables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(baselineTables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"strconv"
+
+	entity "go-cinema/entities"
+
+	"gorm.io/gorm"
+)
+
+// The positions once stored on the movie and episode rows move to the progress
+// of every user, and the old columns are dropped. Rolling back brings resume_at
+// back, holding the position last played by anyone, in seconds.
+
+type resumeMovie struct {
+	ID       uint
+	ResumeAt string
+}
+
+func (resumeMovie) TableName() string { return "movies" }
+
+type resumeEpisode struct {
+	ID       uint
+	ResumeAt string
+}
+
+func (resumeEpisode) TableName() string { return "episodes" }
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "watch_progress",
+		Up:      entity.MigrateProgress,
+		Down: func(tx *gorm.DB) error {
+			tables := map[string]any{"movie_id": &resumeMovie{}, "episode_id": &resumeEpisode{}}
+			for key, table := range tables {
+				if !tx.Migrator().HasColumn(table, "ResumeAt") {
+					if err := tx.Migrator().AddColumn(table, "ResumeAt"); err != nil {
+						return err
+					}
+				}
+
+				var rows []struct {
+					ItemID     uint
+					PositionMs int64
+				}
+				err := tx.Table("watch_progresses").
+					Select(key + " AS item_id, progress_position_ms AS position_ms").
+					Where(key + " <> 0 AND progress_position_ms > 0").
+					Order("progress_updated_at").
+					Scan(&rows).Error
+				if err != nil {
+					return err
+				}
+				// the last one played wins
+				positions := make(map[uint]int64, len(rows))
+				for _, row := range rows {
+					positions[row.ItemID] = row.PositionMs
+				}
+				for id, position := range positions {
+					resumeAt := strconv.FormatInt(position/1000, 10)
+					if err := tx.Model(table).Where("id = ?", id).Update("resume_at", resumeAt).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"go-cinema/model"
+
+	"gorm.io/gorm"
+)
+
+// Accounts created before roles existed get an admin, the oldest of them.
+// Rolling back leaves the roles as they are.
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "first_admin",
+		Up:      model.PromoteFirstAdmin,
+		Down: func(*gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// The SHA-256 of uploaded files, hex encoded, to find an upload already in the
+// library. Files added before stay without one.
+
+type checksumMovie struct {
+	Checksum string `gorm:"size:64;index"`
+}
+
+func (checksumMovie) TableName() string { return "movies" }
+
+type checksumEpisode struct {
+	Checksum string `gorm:"size:64;index"`
+}
+
+func (checksumEpisode) TableName() string { return "episodes" }
+
+var checksumTables = []any{&checksumMovie{}, &checksumEpisode{}}
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "checksums",
+		Up: func(tx *gorm.DB) error {
+			for _, table := range checksumTables {
+				// databases created by AutoMigrate may have the column already
+				if !tx.Migrator().HasColumn(table, "Checksum") {
+					if err := tx.Migrator().AddColumn(table, "Checksum"); err != nil {
+						return err
+					}
+				}
+				if !tx.Migrator().HasIndex(table, "Checksum") {
+					if err := tx.Migrator().CreateIndex(table, "Checksum"); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, table := range checksumTables {
+				if err := tx.Migrator().DropIndex(table, "Checksum"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(table, "Checksum"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
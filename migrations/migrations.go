@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+// Migration changes the schema from the previous version to Version. Down undoes
+// Up, a migration without Down cannot be rolled back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255;not null"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+var ErrIrreversible = errors.New("migration cannot be rolled back")
+
+var registry []Migration
+
+// register adds a Go migration, from the init of its file.
+func register(m Migration) {
+	registry = append(registry, m)
+}
+
+// All returns the Go and SQL migrations, in version order.
+func All() ([]Migration, error) {
+	sqlMigrations, err := loadSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	all := append(slices.Clone(registry), sqlMigrations...)
+	slices.SortFunc(all, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	for i := 1; i < len(all); i++ {
+		if all[i].Version == all[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", all[i-1].Name, all[i].Name, all[i].Version)
+		}
+	}
+	return all, nil
+}
+
+// Status is a known migration and when it was applied, nil when pending.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the migrations to a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func New(db *gorm.DB) (*Migrator, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	return &Migrator{db: db, migrations: all}, nil
+}
+
+func (m *Migrator) applied() (map[int]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Up applies every pending migration, each in a transaction of its own, and
+// returns how many it applied.
+func (m *Migrator) Up() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		golog.Info("Applying migration {} {}", migration.Version, migration.Name)
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down rolls back the last n applied migrations, newest first.
+func (m *Migrator) Down(n int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < n; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, ErrIrreversible)
+		}
+
+		golog.Info("Rolling back migration {} {}", migration.Version, migration.Name)
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status lists the known migrations, and the applied ones this binary does not
+// know about, as a newer binary left them.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		statuses = append(statuses, Status{Migration: Migration{Version: row.Version, Name: row.Name}, AppliedAt: &row.AppliedAt})
+	}
+
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return statuses, nil
+}
@@ -0,0 +1,334 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-cinema/auth"
+	"go-cinema/config"
+	"go-cinema/database"
+	entity "go-cinema/entities"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+func init() {
+	_ = golog.Init(os.DevNull)
+}
+
+// memory opens an empty SQLite database of the test's own.
+func memory(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(config.Database{Driver: config.SQLite, Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// migrator applies the known migrations up to version.
+func migrator(t *testing.T, db *gorm.DB, version int) *Migrator {
+	t.Helper()
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upTo []Migration
+	for _, migration := range m.migrations {
+		if migration.Version <= version {
+			upTo = append(upTo, migration)
+		}
+	}
+	m.migrations = upTo
+	return m
+}
+
+func applied(t *testing.T, m *Migrator) []int {
+	t.Helper()
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			versions = append(versions, status.Version)
+		}
+	}
+	return versions
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db := memory(t)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for i, migration := range all {
+		if i > 0 && migration.Version <= all[i-1].Version {
+			t.Fatalf("migrations out of order: %d after %d", migration.Version, all[i-1].Version)
+		}
+		versions = append(versions, migration.Version)
+	}
+
+	if got := applied(t, m); len(got) != 0 {
+		t.Fatalf("applied on an empty database: %v", got)
+	}
+	if n, err := m.Up(); err != nil || n != len(all) {
+		t.Fatalf("Up = %d, %v, want %d", n, err, len(all))
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, versions) {
+		t.Errorf("applied %v, want %v", got, versions)
+	}
+	if n, err := m.Up(); err != nil || n != 0 {
+		t.Errorf("Up again = %d, %v", n, err)
+	}
+	for _, table := range []string{"users", "movies", "series", "episodes", "watch_progresses"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("no %s table", table)
+		}
+	}
+
+	if n, err := m.Down(2); err != nil || n != 2 {
+		t.Fatalf("Down(2) = %d, %v", n, err)
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, versions[:len(versions)-2]) {
+		t.Errorf("applied after rolling back 2: %v", got)
+	}
+
+	// every migration rolls back, down to an empty database
+	if n, err := m.Down(len(all)); err != nil || n != len(all)-2 {
+		t.Fatalf("Down(all) = %d, %v", n, err)
+	}
+	if db.Migrator().HasTable("movies") {
+		t.Error("the tables are left after rolling everything back")
+	}
+	if n, err := m.Up(); err != nil || n != len(all) {
+		t.Errorf("Up after rolling back = %d, %v", n, err)
+	}
+
+	// a newer binary applied a migration this one does not know
+	if err := db.Create(&SchemaMigration{Version: 999, Name: "from_the_future"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != 999 || last.AppliedAt == nil {
+		t.Errorf("unknown migration listed as %+v", last)
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	db := memory(t)
+	m := migrator(t, db, 1)
+	m.migrations = append(m.migrations, Migration{Version: 2, Name: "one_way", Up: func(*gorm.DB) error { return nil }})
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Down(2); !errors.Is(err, ErrIrreversible) || n != 0 {
+		t.Errorf("Down = %d, %v, want %v", n, err, ErrIrreversible)
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("applied %v", got)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	db := memory(t)
+	m := migrator(t, db, 1)
+	failed := errors.New("failed")
+	m.migrations = append(m.migrations, Migration{Version: 2, Name: "failing", Up: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE half_done (id integer)").Error; err != nil {
+			return err
+		}
+		return failed
+	}})
+	if n, err := m.Up(); !errors.Is(err, failed) || n != 1 {
+		t.Fatalf("Up = %d, %v", n, err)
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("applied %v", got)
+	}
+	if db.Migrator().HasTable("half_done") {
+		t.Error("the failed migration was not rolled back")
+	}
+}
+
+func TestDuplicateVersions(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+	register(Migration{Version: 1, Name: "again", Up: func(*gorm.DB) error { return nil }})
+	if _, err := All(); err == nil {
+		t.Error("two migrations of version 1 accepted")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := `-- a comment; not a statement
+CREATE TABLE a (id integer);
+
+CREATE INDEX idx_a
+    ON a (id);
+INSERT INTO a VALUES (1); INSERT INTO a VALUES (2);
+  -- indented comment
+UPDATE a SET id = 3`
+	want := []string{
+		"CREATE TABLE a (id integer);",
+		"CREATE INDEX idx_a\n    ON a (id);",
+		"INSERT INTO a VALUES (1); INSERT INTO a VALUES (2);",
+		"UPDATE a SET id = 3",
+	}
+	if got := statements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+	if got := statements("-- nothing\n\n"); len(got) != 0 {
+		t.Errorf("statements of comments = %q", got)
+	}
+}
+
+func TestSQLDialects(t *testing.T) {
+	db := memory(t)
+	run := func(files map[string]string) {
+		t.Helper()
+		m := &sqlMigration{name: "test", files: map[string]map[string]string{"up": files, "down": {}}}
+		if err := m.run("up")(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(map[string]string{"": "CREATE TABLE portable (id integer);", "postgres": "CREATE TABLE for_postgres (id integer);"})
+	if !db.Migrator().HasTable("portable") || db.Migrator().HasTable("for_postgres") {
+		t.Error("the portable file is not the one run on SQLite")
+	}
+	run(map[string]string{"": "CREATE TABLE portable_too (id integer);", "sqlite": "CREATE TABLE for_sqlite (id integer);"})
+	if !db.Migrator().HasTable("for_sqlite") || db.Migrator().HasTable("portable_too") {
+		t.Error("the SQLite file does not replace the portable one")
+	}
+	// a migration for other databases only does nothing here
+	run(map[string]string{"postgres": "CREATE TABLE only_postgres (id integer);"})
+	if db.Migrator().HasTable("only_postgres") {
+		t.Error("ran the file of another database")
+	}
+
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range all {
+		if migration.Version == 4 && (migration.Name != "search_indexes" || migration.Down == nil) {
+			t.Errorf("the search_indexes files load as %+v", migration)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := all[len(all)-1].Version + 1
+
+	dir := t.TempDir()
+	paths, err := Create(dir, "Add Genres, to Movies!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, fmt.Sprintf("%04d_add_genres_to_movies.up.sql", next)),
+		filepath.Join(dir, fmt.Sprintf("%04d_add_genres_to_movies.down.sql", next)),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("created %v, want %v", paths, want)
+	}
+	for _, path := range paths {
+		if !sqlName.MatchString(filepath.Base(path)) {
+			t.Errorf("%s would not load", path)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || len(statements(string(content))) != 0 {
+			t.Errorf("%s holds %q, %v", path, content, err)
+		}
+	}
+
+	if _, err := Create(dir, " -!- "); err == nil {
+		t.Error("created a migration without a name")
+	}
+}
+
+// TestWatchProgressWaitsForUsers migrates a library whose positions are stored
+// on its rows, before anyone registered.
+func TestWatchProgressWaitsForUsers(t *testing.T) {
+	db := memory(t)
+	repo.InitRepositories(db)
+	m := migrator(t, db, 1)
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	// the columns AutoMigrate added before migrations existed
+	for _, table := range []any{&resumeMovie{}, &resumeEpisode{}} {
+		if err := db.Migrator().AddColumn(table, "ResumeAt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.Exec("INSERT INTO movies (title, path, resume_at, duration_ms) VALUES ('Heat', '/srv/heat.mkv', '1:30', 600000)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = migrator(t, db, 2)
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasColumn("movies", "resume_at") {
+		t.Fatal("the positions were dropped with nobody to give them to")
+	}
+
+	user, err := auth.Register(model.UserRequest{Username: "alice", Email: "alice@example.com", Password: "long enough secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress []entity.WatchProgress
+	if err := db.Where("user_id = ?", user.ID).Find(&progress).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 1 || progress[0].Progress.Position().Seconds() != 90 {
+		t.Fatalf("the first account got %+v", progress)
+	}
+	if db.Migrator().HasColumn("movies", "resume_at") {
+		t.Error("the old column is kept once moved")
+	}
+
+	// rolling back brings the column back, with the position
+	if n, err := m.Down(1); err != nil || n != 1 {
+		t.Fatalf("Down = %d, %v", n, err)
+	}
+	var resumeAt string
+	if err := db.Table("movies").Select("resume_at").Where("title = ?", "Heat").Scan(&resumeAt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if resumeAt != "90" {
+		t.Errorf("resume_at %q after rolling back, want 90", resumeAt)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up after rolling back: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SQL migrations are files named 0002_name.up.sql and 0002_name.down.sql. A file
+// named 0002_name.postgres.up.sql replaces the portable one on PostgreSQL, a
+// migration with files for other databases only does nothing on this one.
+//
+//go:embed sql
+var sqlFiles embed.FS
+
+// SQLDir is where the SQL migrations are kept in the source tree.
+const SQLDir = "migrations/sql"
+
+var sqlName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+?)(?:\.(postgres|mysql|sqlite))?\.(up|down)\.sql$`)
+
+// sqlMigration holds the statements of a migration by direction, then by driver,
+// the portable ones under "".
+type sqlMigration struct {
+	name  string
+	files map[string]map[string]string
+}
+
+func loadSQL() ([]Migration, error) {
+	entries, err := fs.ReadDir(sqlFiles, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*sqlMigration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := sqlName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		name, driver, direction := match[2], match[3], match[4]
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &sqlMigration{name: name, files: map[string]map[string]string{"up": {}, "down": {}}}
+			byVersion[version] = m
+		}
+		if m.name != name {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.name, name)
+		}
+
+		content, err := sqlFiles.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.files[direction][driver] = string(content)
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, m := range byVersion {
+		migration := Migration{Version: version, Name: m.name, Up: m.run("up")}
+		if len(m.files["down"]) > 0 {
+			migration.Down = m.run("down")
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+func (m *sqlMigration) run(direction string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		files := m.files[direction]
+		script, ok := files[tx.Dialector.Name()]
+		if !ok {
+			script = files[""]
+		}
+
+		for _, statement := range statements(script) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// statements splits a script on the semicolons ending a line, not every driver
+// runs several statements at once.
+func statements(script string) []string {
+	var (
+		result  []string
+		current strings.Builder
+	)
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+		if strings.HasSuffix(trimmed, ";") {
+			result = append(result, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		result = append(result, rest)
+	}
+	return result
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Create writes the empty up and down files of a new SQL migration to dir,
+// numbered after the last known migration, and returns their paths. The files
+// are embedded in the binary, it has to be rebuilt to apply them.
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("a migration needs a name made of letters or digits")
+	}
+
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if len(all) > 0 {
+		version = all[len(all)-1].Version + 1
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		file := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+		content := fmt.Sprintf("-- %s %s, statements end with a semicolon at the end of a line.\n", name, direction)
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, file)
+	}
+	return paths, nil
+}
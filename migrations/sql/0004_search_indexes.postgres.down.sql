@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_movies_search;
+DROP INDEX IF EXISTS idx_series_search;
+DROP INDEX IF EXISTS idx_episodes_search;
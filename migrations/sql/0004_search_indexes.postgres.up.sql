@@ -0,0 +1,17 @@
+-- The full-text vectors of search, word for word, the planner only uses an
+-- index for the expression it was built on.
+CREATE INDEX IF NOT EXISTS idx_movies_search ON movies USING GIN ((
+    setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('simple', translate(regexp_replace(coalesce(path, ''), '^.*/', ''), '._-', '   ')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+));
+
+CREATE INDEX IF NOT EXISTS idx_series_search ON series USING GIN ((
+    setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('simple', translate(regexp_replace(coalesce(base_dir, ''), '^.*/', ''), '._-', '   ')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+));
+
+CREATE INDEX IF NOT EXISTS idx_episodes_search ON episodes USING GIN ((
+    setweight(to_tsvector('simple', translate(regexp_replace(coalesce(path, ''), '^.*/', ''), '._-', '   ')), 'B')
+));
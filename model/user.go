@@ -1,12 +1,55 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+	RoleKid    = "kid"
+)
 
 type User struct {
 	gorm.Model
-	Username string `json:"username" gorm:"unique;not null"`
+	Username string `json:"username" gorm:"size:191;unique;not null"`
 	Password string `json:"-" gorm:"not null"`
-	Email    string `json:"email" gorm:"unique;not null"`
+	Email    string `json:"email" gorm:"size:191;unique;not null"`
+	Role     string `json:"role" gorm:"size:32;not null;default:viewer"`
+}
+
+func IsRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleEditor, RoleViewer, RoleKid:
+		return true
+	}
+	return false
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// PromoteFirstAdmin makes the oldest user an admin when there is none, as for
+// accounts created before roles existed.
+func PromoteFirstAdmin(db *gorm.DB) error {
+	var admins int64
+	if err := db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	var first User
+	err := db.Order("id").Limit(1).Find(&first).Error
+	if err != nil || first.ID == 0 {
+		return err
+	}
+	return db.Model(&first).Update("role", RoleAdmin).Error
 }
 
 type LoginRequest struct {
@@ -29,3 +72,17 @@ type LoginResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
+
+// RefreshToken is a session that can be traded for a new access token. Only a hash
+// of the token is stored, and every use replaces it with a new one.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint       `json:"-" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
@@ -0,0 +1,436 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var ebmlMagic = []byte{0x1a, 0x45, 0xdf, 0xa3}
+
+// EBML element IDs used by the probe, see the Matroska specification.
+const (
+	idEBML          = 0x1a45dfa3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114d9b74
+	idSeek          = 0x4dbb
+	idSeekID        = 0x53ab
+	idSeekPosition  = 0x53ac
+	idInfo          = 0x1549a966
+	idTimecodeScale = 0x2ad7b1
+	idDuration      = 0x4489
+	idTracks        = 0x1654ae6b
+	idTrackEntry    = 0xae
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idName          = 0x536e
+	idLanguage      = 0x22b59c
+	idLanguageBCP47 = 0x22b59d
+	idFlagDefault   = 0x88
+	idVideo         = 0xe0
+	idPixelWidth    = 0xb0
+	idPixelHeight   = 0xba
+	idAudio         = 0xe1
+	idSampling      = 0xb5
+	idChannels      = 0x9f
+	idCluster       = 0x1f43b675
+)
+
+// unknownSize marks elements whose size is not known in advance, as in live streams.
+const unknownSize = -1
+
+var mkvTrackTypes = map[uint64]string{
+	1:  VideoTrack,
+	2:  AudioTrack,
+	17: SubtitleTrack,
+}
+
+// mkvCodecs maps Matroska codec IDs, or their prefix, to codec names.
+var mkvCodecs = []struct {
+	prefix string
+	codec  string
+}{
+	{"V_MPEG4/ISO/AVC", "h264"},
+	{"V_MPEGH/ISO/HEVC", "hevc"},
+	{"V_AV1", "av1"},
+	{"V_VP9", "vp9"},
+	{"V_VP8", "vp8"},
+	{"V_MPEG4/", "mpeg4"},
+	{"V_MPEG2", "mpeg2video"},
+	{"V_MS/VFW/FOURCC", "vfw"},
+	{"A_AAC", "aac"},
+	{"A_AC3", "ac3"},
+	{"A_EAC3", "eac3"},
+	{"A_DTS", "dts"},
+	{"A_TRUEHD", "truehd"},
+	{"A_OPUS", "opus"},
+	{"A_VORBIS", "vorbis"},
+	{"A_FLAC", "flac"},
+	{"A_MPEG/L3", "mp3"},
+	{"A_PCM", "pcm"},
+	{"S_TEXT/UTF8", "subrip"},
+	{"S_TEXT/ASS", "ass"},
+	{"S_TEXT/SSA", "ssa"},
+	{"S_TEXT/WEBVTT", "webvtt"},
+	{"S_HDMV/PGS", "hdmv_pgs"},
+	{"S_VOBSUB", "dvd_subtitle"},
+	{"S_DVBSUB", "dvb_subtitle"},
+}
+
+// element is an EBML element, located by the offset and size of its data.
+type element struct {
+	id     uint64
+	offset int64
+	size   int64
+}
+
+// readVint reads an EBML variable size integer. IDs keep their length marker,
+// sizes drop it.
+func readVint(r io.ReaderAt, offset int64, keepMarker bool) (uint64, int, error) {
+	first, err := readAt(r, offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, invalidf("bad variable size integer")
+	}
+
+	data, err := readAt(r, offset, length)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xff >> length)
+	}
+	for _, b := range data[1:] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+func readElement(r io.ReaderAt, offset int64) (element, int64, error) {
+	id, idLength, err := readVint(r, offset, true)
+	if err != nil {
+		return element{}, 0, err
+	}
+	size, sizeLength, err := readVint(r, offset+int64(idLength), false)
+	if err != nil {
+		return element{}, 0, err
+	}
+
+	header := int64(idLength + sizeLength)
+	e := element{id: id, offset: offset + header, size: int64(size)}
+	// All value bits set means unknown size.
+	if size == 1<<(7*uint(sizeLength))-1 {
+		e.size = unknownSize
+	}
+	return e, header, nil
+}
+
+// elements lists the children of [offset, end). It stops early at an element of
+// unknown size, which can only be skipped by parsing it.
+func elements(r io.ReaderAt, offset, end int64) ([]element, error) {
+	var list []element
+	for offset < end {
+		e, _, err := readElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if e.size == unknownSize || e.offset+e.size > end {
+			break
+		}
+		offset = e.offset + e.size
+	}
+	return list, nil
+}
+
+func readUint(r io.ReaderAt, e element) uint64 {
+	if e.size < 1 || e.size > 8 {
+		return 0
+	}
+	data, err := readAt(r, e.offset, int(e.size))
+	if err != nil {
+		return 0
+	}
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readFloat(r io.ReaderAt, e element) float64 {
+	if e.size != 4 && e.size != 8 {
+		return 0
+	}
+	data, err := readAt(r, e.offset, int(e.size))
+	if err != nil {
+		return 0
+	}
+	switch e.size {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func readString(r io.ReaderAt, e element) string {
+	if e.size <= 0 || e.size > 1<<16 {
+		return ""
+	}
+	data, err := readAt(r, e.offset, int(e.size))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\x00")
+}
+
+func probeMatroska(r io.ReaderAt, size int64) (*Info, error) {
+	top, err := elements(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(top) == 0 || top[0].id != idEBML {
+		return nil, ErrUnknownFormat
+	}
+
+	info := &Info{Container: "matroska"}
+	header, err := elements(r, top[0].offset, top[0].offset+top[0].size)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range header {
+		if e.id == idDocType && readString(r, e) == "webm" {
+			info.Container = "webm"
+		}
+	}
+
+	var segment element
+	found := false
+	for _, e := range top[1:] {
+		if e.id == idSegment {
+			segment, found = e, true
+			break
+		}
+	}
+	if !found {
+		return nil, invalidf("no Segment element")
+	}
+
+	segmentEnd := size
+	if segment.size != unknownSize && segment.offset+segment.size < size {
+		segmentEnd = segment.offset + segment.size
+	}
+
+	infoOffset, tracksOffset := int64(-1), int64(-1)
+	for offset := segment.offset; offset < segmentEnd; {
+		e, _, err := readElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.id {
+		case idSeekHead:
+			seekInfo, seekTracks := readSeekHead(r, e, segment.offset)
+			if infoOffset < 0 {
+				infoOffset = seekInfo
+			}
+			if tracksOffset < 0 {
+				tracksOffset = seekTracks
+			}
+		case idInfo:
+			infoOffset = offset
+		case idTracks:
+			tracksOffset = offset
+		}
+
+		// The headers come before the first cluster, or are pointed at by the SeekHead.
+		if e.id == idCluster || e.size == unknownSize {
+			break
+		}
+		offset = e.offset + e.size
+	}
+
+	if infoOffset >= 0 {
+		if e, _, err := readElement(r, infoOffset); err == nil && e.id == idInfo && e.size != unknownSize {
+			info.Duration = readSegmentInfo(r, e)
+		}
+	}
+	if tracksOffset >= 0 {
+		if e, _, err := readElement(r, tracksOffset); err == nil && e.id == idTracks && e.size != unknownSize {
+			tracks, err := readTracks(r, e)
+			if err != nil {
+				return nil, err
+			}
+			info.Tracks = tracks
+		}
+	}
+
+	return info, nil
+}
+
+// readSeekHead returns the absolute offsets of Info and Tracks, -1 when not listed.
+func readSeekHead(r io.ReaderAt, seekHead element, segmentStart int64) (int64, int64) {
+	infoOffset, tracksOffset := int64(-1), int64(-1)
+
+	seeks, err := elements(r, seekHead.offset, seekHead.offset+seekHead.size)
+	if err != nil {
+		return infoOffset, tracksOffset
+	}
+	for _, seek := range seeks {
+		if seek.id != idSeek {
+			continue
+		}
+		fields, err := elements(r, seek.offset, seek.offset+seek.size)
+		if err != nil {
+			continue
+		}
+
+		var id, position uint64
+		for _, field := range fields {
+			switch field.id {
+			case idSeekID:
+				id = readUint(r, field)
+			case idSeekPosition:
+				position = readUint(r, field)
+			}
+		}
+
+		switch id {
+		case idInfo:
+			infoOffset = segmentStart + int64(position)
+		case idTracks:
+			tracksOffset = segmentStart + int64(position)
+		}
+	}
+	return infoOffset, tracksOffset
+}
+
+func readSegmentInfo(r io.ReaderAt, info element) time.Duration {
+	fields, err := elements(r, info.offset, info.offset+info.size)
+	if err != nil {
+		return 0
+	}
+
+	scale := uint64(1000000)
+	var duration float64
+	for _, field := range fields {
+		switch field.id {
+		case idTimecodeScale:
+			if value := readUint(r, field); value > 0 {
+				scale = value
+			}
+		case idDuration:
+			duration = readFloat(r, field)
+		}
+	}
+	return time.Duration(duration * float64(scale))
+}
+
+func readTracks(r io.ReaderAt, tracks element) ([]Track, error) {
+	entries, err := elements(r, tracks.offset, tracks.offset+tracks.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Track
+	for _, entry := range entries {
+		if entry.id != idTrackEntry {
+			continue
+		}
+		fields, err := elements(r, entry.offset, entry.offset+entry.size)
+		if err != nil {
+			return nil, err
+		}
+
+		track := Track{Language: "eng", Default: true}
+		known := false
+		var bcp47 string
+		for _, field := range fields {
+			switch field.id {
+			case idTrackType:
+				track.Kind, known = mkvTrackTypes[readUint(r, field)]
+			case idCodecID:
+				track.Codec = mkvCodec(readString(r, field))
+			case idName:
+				track.Name = readString(r, field)
+			case idLanguage:
+				track.Language = readString(r, field)
+			case idLanguageBCP47:
+				bcp47 = readString(r, field)
+			case idFlagDefault:
+				track.Default = readUint(r, field) == 1
+			case idVideo:
+				readVideo(r, field, &track)
+			case idAudio:
+				readAudio(r, field, &track)
+			}
+		}
+		if !known {
+			continue
+		}
+		if bcp47 != "" {
+			track.Language = bcp47
+		}
+		if track.Language == "und" {
+			track.Language = ""
+		}
+		list = append(list, track)
+	}
+	return list, nil
+}
+
+func readVideo(r io.ReaderAt, video element, track *Track) {
+	fields, err := elements(r, video.offset, video.offset+video.size)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		switch field.id {
+		case idPixelWidth:
+			track.Width = int(readUint(r, field))
+		case idPixelHeight:
+			track.Height = int(readUint(r, field))
+		}
+	}
+}
+
+func readAudio(r io.ReaderAt, audio element, track *Track) {
+	track.Channels = 1
+	track.SampleRate = 8000
+
+	fields, err := elements(r, audio.offset, audio.offset+audio.size)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		switch field.id {
+		case idSampling:
+			track.SampleRate = int(readFloat(r, field))
+		case idChannels:
+			track.Channels = int(readUint(r, field))
+		}
+	}
+}
+
+func mkvCodec(id string) string {
+	for _, codec := range mkvCodecs {
+		if strings.HasPrefix(id, codec.prefix) {
+			return codec.codec
+		}
+	}
+	return strings.ToLower(id)
+}
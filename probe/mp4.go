@@ -0,0 +1,317 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// box is an ISO BMFF box, located by the offset and size of its payload.
+type box struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+// mp4Codecs maps sample entry types to codec names.
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"tx3g": "mov_text",
+	"wvtt": "webvtt",
+	"stpp": "ttml",
+	"c608": "eia_608",
+}
+
+var mp4Handlers = map[string]string{
+	"vide": VideoTrack,
+	"soun": AudioTrack,
+	"subt": SubtitleTrack,
+	"text": SubtitleTrack,
+	"sbtl": SubtitleTrack,
+	"clcp": SubtitleTrack,
+}
+
+func isMP4Box(typ []byte) bool {
+	switch string(typ) {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+// children lists the boxes inside [offset, end).
+func children(r io.ReaderAt, offset, end int64) ([]box, error) {
+	var boxes []box
+	for offset+8 <= end {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			large, err := readAt(r, offset+8, 8)
+			if err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			headerSize = 16
+		}
+		if size < headerSize || size > end-offset {
+			return nil, invalidf("box %q overruns its parent", typ)
+		}
+
+		boxes = append(boxes, box{typ: typ, offset: offset + headerSize, size: size - headerSize})
+		offset += size
+	}
+	return boxes, nil
+}
+
+func find(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// path descends through nested boxes, e.g. path(r, trak, "mdia", "minf", "stbl").
+func path(r io.ReaderAt, parent box, types ...string) (box, bool, error) {
+	current := parent
+	for _, typ := range types {
+		boxes, err := children(r, current.offset, current.offset+current.size)
+		if err != nil {
+			return box{}, false, err
+		}
+		next, ok := find(boxes, typ)
+		if !ok {
+			return box{}, false, nil
+		}
+		current = next
+	}
+	return current, true, nil
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	top, err := children(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	moov, ok := find(top, "moov")
+	if !ok {
+		return nil, invalidf("no moov box")
+	}
+
+	info := &Info{Container: "mp4"}
+	if ftyp, ok := find(top, "ftyp"); ok && ftyp.size >= 4 {
+		brand, err := readAt(r, ftyp.offset, 4)
+		if err == nil && string(brand) == "qt  " {
+			info.Container = "mov"
+		}
+	}
+
+	boxes, err := children(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return nil, err
+	}
+
+	if mvhd, ok := find(boxes, "mvhd"); ok {
+		if timescale, duration, err := readTimes(r, mvhd); err == nil && timescale > 0 {
+			info.Duration = scaled(duration, timescale)
+		}
+	}
+
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+		track, err := probeTrak(r, b)
+		if err != nil {
+			return nil, err
+		}
+		if track != nil {
+			info.Tracks = append(info.Tracks, *track)
+		}
+	}
+
+	return info, nil
+}
+
+func probeTrak(r io.ReaderAt, trak box) (*Track, error) {
+	hdlr, ok, err := path(r, trak, "mdia", "hdlr")
+	if err != nil || !ok || hdlr.size < 12 {
+		return nil, err
+	}
+	handler, err := readAt(r, hdlr.offset+8, 4)
+	if err != nil {
+		return nil, err
+	}
+	kind, ok := mp4Handlers[string(handler)]
+	if !ok {
+		// Timecode, hint and chapter tracks.
+		return nil, nil
+	}
+
+	track := &Track{Kind: kind}
+
+	if mdhd, ok, err := path(r, trak, "mdia", "mdhd"); err != nil {
+		return nil, err
+	} else if ok {
+		track.Language = readLanguage(r, mdhd)
+	}
+
+	if tkhd, ok, err := path(r, trak, "tkhd"); err != nil {
+		return nil, err
+	} else if ok {
+		readTrackHeader(r, tkhd, track)
+	}
+
+	stsd, ok, err := path(r, trak, "mdia", "minf", "stbl", "stsd")
+	if err != nil || !ok || stsd.size < 16 {
+		return track, err
+	}
+	readSampleEntry(r, stsd, track)
+
+	return track, nil
+}
+
+// readTimes reads timescale and duration from a full box laid out like mvhd and mdhd.
+func readTimes(r io.ReaderAt, b box) (uint32, uint64, error) {
+	version, err := readAt(r, b.offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if version[0] == 1 {
+		data, err := readAt(r, b.offset+4+16, 12)
+		if err != nil {
+			return 0, 0, err
+		}
+		return binary.BigEndian.Uint32(data), binary.BigEndian.Uint64(data[4:]), nil
+	}
+
+	data, err := readAt(r, b.offset+4+8, 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	return binary.BigEndian.Uint32(data), uint64(binary.BigEndian.Uint32(data[4:])), nil
+}
+
+// readLanguage decodes the packed ISO 639-2 code of an mdhd box.
+func readLanguage(r io.ReaderAt, mdhd box) string {
+	version, err := readAt(r, mdhd.offset, 1)
+	if err != nil {
+		return ""
+	}
+
+	offset := mdhd.offset + 4 + 16
+	if version[0] == 1 {
+		offset = mdhd.offset + 4 + 28
+	}
+	data, err := readAt(r, offset, 2)
+	if err != nil {
+		return ""
+	}
+
+	packed := binary.BigEndian.Uint16(data)
+	lang := string([]byte{
+		byte(packed>>10&0x1f) + 0x60,
+		byte(packed>>5&0x1f) + 0x60,
+		byte(packed&0x1f) + 0x60,
+	})
+	if lang == "und" || packed == 0 {
+		return ""
+	}
+	return lang
+}
+
+// readTrackHeader takes the display size and the enabled flag from tkhd.
+func readTrackHeader(r io.ReaderAt, tkhd box, track *Track) {
+	header, err := readAt(r, tkhd.offset, 4)
+	if err != nil {
+		return
+	}
+	track.Default = header[3]&0x1 != 0
+
+	if track.Kind != VideoTrack {
+		return
+	}
+	offset := tkhd.offset + 76
+	if header[0] == 1 {
+		offset = tkhd.offset + 88
+	}
+	size, err := readAt(r, offset, 8)
+	if err != nil {
+		return
+	}
+	track.Width = int(binary.BigEndian.Uint32(size) >> 16)
+	track.Height = int(binary.BigEndian.Uint32(size[4:]) >> 16)
+}
+
+// readSampleEntry reads the codec and its basic parameters from the first stsd entry.
+func readSampleEntry(r io.ReaderAt, stsd box, track *Track) {
+	entry, err := readAt(r, stsd.offset+8, 8)
+	if err != nil {
+		return
+	}
+	typ := string(entry[4:8])
+	start := stsd.offset + 8
+
+	// Encrypted entries keep the original format in sinf/frma.
+	if typ == "encv" || typ == "enca" {
+		track.Codec = "encrypted"
+		return
+	}
+
+	track.Codec = typ
+	if codec, ok := mp4Codecs[typ]; ok {
+		track.Codec = codec
+	}
+
+	switch track.Kind {
+	case VideoTrack:
+		// SampleEntry (16) + pre_defined and reserved (16), then width and height.
+		size, err := readAt(r, start+32, 4)
+		if err != nil {
+			return
+		}
+		if width := int(binary.BigEndian.Uint16(size)); width > 0 {
+			track.Width = width
+			track.Height = int(binary.BigEndian.Uint16(size[2:]))
+		}
+	case AudioTrack:
+		// SampleEntry (16) + reserved (8), channel count, sample size, 4 reserved, 16.16 rate.
+		data, err := readAt(r, start+24, 12)
+		if err != nil {
+			return
+		}
+		track.Channels = int(binary.BigEndian.Uint16(data))
+		track.SampleRate = int(binary.BigEndian.Uint32(data[8:]) >> 16)
+	}
+}
+
+func scaled(duration uint64, timescale uint32) time.Duration {
+	seconds := duration / uint64(timescale)
+	rest := duration % uint64(timescale)
+	return time.Duration(seconds)*time.Second + time.Duration(rest)*time.Second/time.Duration(timescale)
+}
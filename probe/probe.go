@@ -0,0 +1,126 @@
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrUnknownFormat = errors.New("probe: unknown container format")
+	ErrInvalid       = errors.New("probe: invalid or truncated header")
+)
+
+const (
+	VideoTrack    = "video"
+	AudioTrack    = "audio"
+	SubtitleTrack = "subtitle"
+)
+
+// Track is one stream of a container.
+type Track struct {
+	Kind       string `json:"kind"`
+	Codec      string `json:"codec"`
+	Language   string `json:"language,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Default    bool   `json:"default,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+}
+
+// Info describes a media file. Width, Height and the codecs are those of the
+// first video and audio track.
+type Info struct {
+	Container  string
+	Duration   time.Duration
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+	Tracks     []Track
+}
+
+// File reads the stream information of an MP4 or Matroska file from its headers,
+// without decoding any media and without external tools.
+func File(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Reader(file, stat.Size())
+}
+
+// Reader probes size bytes of media read through r.
+func Reader(r io.ReaderAt, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	var info *Info
+	var err error
+	switch {
+	case bytes.Equal(head[:4], ebmlMagic):
+		info, err = probeMatroska(r, size)
+	case isMP4Box(head[4:8]):
+		info, err = probeMP4(r, size)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.summarize()
+	return info, nil
+}
+
+func (info *Info) summarize() {
+	for _, track := range info.Tracks {
+		switch track.Kind {
+		case VideoTrack:
+			if info.VideoCodec == "" {
+				info.VideoCodec = track.Codec
+				info.Width, info.Height = track.Width, track.Height
+			}
+		case AudioTrack:
+			if info.AudioCodec == "" {
+				info.AudioCodec = track.Codec
+			}
+		}
+	}
+}
+
+// maxRead bounds the reads of header fields, a larger size is corrupt.
+const maxRead = 1 << 16
+
+// readAt reads exactly n bytes at off, turning short reads into ErrInvalid.
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	if n < 0 || n > maxRead {
+		return nil, invalidf("field of %d bytes", n)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
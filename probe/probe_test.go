@@ -0,0 +1,163 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// ebml encodes an element with a one byte size, or 8 bytes past 126.
+func ebml(id uint64, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if id>>shift != 0 {
+			b = append(b, byte(id>>shift))
+		}
+	}
+	if len(data) < 0x7f {
+		b = append(b, 0x80|byte(len(data)))
+	} else {
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(data)))
+		size[0] = 0x01
+		b = append(b, size...)
+	}
+	return append(b, data...)
+}
+
+// ebmlSized encodes an element with the raw size bytes given.
+func ebmlSized(id uint64, size []byte, payload []byte) []byte {
+	e := ebml(id)
+	e = append(e[:len(e)-1], size...)
+	return append(e, payload...)
+}
+
+func uintData(v uint64, n int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b[8-n:]
+}
+
+func floatData(v float64) []byte {
+	return uintData(math.Float64bits(v), 8)
+}
+
+func matroska(info, tracks []byte) []byte {
+	header := ebml(idEBML, ebml(idDocType, []byte("matroska")))
+	return append(header, ebml(idSegment, info, tracks)...)
+}
+
+func validMatroska() []byte {
+	info := ebml(idInfo,
+		ebml(idTimecodeScale, uintData(1000000, 3)),
+		ebml(idDuration, floatData(90000)),
+	)
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry,
+			ebml(idTrackType, uintData(1, 1)),
+			ebml(idCodecID, []byte("V_MPEG4/ISO/AVC")),
+			ebml(idVideo, ebml(idPixelWidth, uintData(1920, 2)), ebml(idPixelHeight, uintData(1080, 2))),
+		),
+		ebml(idTrackEntry,
+			ebml(idTrackType, uintData(2, 1)),
+			ebml(idCodecID, []byte("A_AAC")),
+			ebml(idLanguage, []byte("fre")),
+			ebml(idAudio, ebml(idSampling, floatData(48000)), ebml(idChannels, uintData(6, 1))),
+		),
+	)
+	return matroska(info, tracks)
+}
+
+// mp4Box encodes an ISO BMFF box.
+func mp4Box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, typ...), data...)
+}
+
+func validMP4() []byte {
+	// version 0 mvhd: flags, creation and modification times, timescale, duration
+	mvhd := make([]byte, 4+8+8)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 5500)
+	return append(mp4Box("ftyp", []byte("isom")), mp4Box("moov", mp4Box("mvhd", mvhd))...)
+}
+
+func TestMatroska(t *testing.T) {
+	data := validMatroska()
+	info, err := Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "matroska" || info.Duration != 90*time.Second {
+		t.Errorf("container %s, duration %s", info.Container, info.Duration)
+	}
+	if info.VideoCodec != "h264" || info.Width != 1920 || info.Height != 1080 || info.AudioCodec != "aac" {
+		t.Errorf("video %s %dx%d, audio %s", info.VideoCodec, info.Width, info.Height, info.AudioCodec)
+	}
+	if len(info.Tracks) != 2 || info.Tracks[1].SampleRate != 48000 || info.Tracks[1].Channels != 6 || info.Tracks[1].Language != "fre" {
+		t.Errorf("tracks %+v", info.Tracks)
+	}
+}
+
+func TestMP4(t *testing.T) {
+	data := validMP4()
+	info, err := Reader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "mp4" || info.Duration != 5500*time.Millisecond {
+		t.Errorf("container %s, duration %s", info.Container, info.Duration)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	unknown := []byte{0xff}
+	huge := []byte{0x01, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	cases := map[string][]byte{
+		"unknown size duration":  matroska(ebml(idInfo, ebmlSized(idDuration, unknown, nil)), nil),
+		"oversized duration":     matroska(ebml(idInfo, ebmlSized(idDuration, huge, floatData(1))), nil),
+		"odd sized duration":     matroska(ebml(idInfo, ebml(idDuration, make([]byte, 5))), nil),
+		"unknown size sampling":  matroska(nil, ebml(idTracks, ebml(idTrackEntry, ebml(idTrackType, uintData(2, 1)), ebml(idAudio, ebmlSized(idSampling, unknown, nil))))),
+		"oversized sampling":     matroska(nil, ebml(idTracks, ebml(idTrackEntry, ebml(idTrackType, uintData(2, 1)), ebml(idAudio, ebmlSized(idSampling, huge, nil))))),
+		"oversized codec":        matroska(nil, ebml(idTracks, ebml(idTrackEntry, ebml(idTrackType, uintData(1, 1)), ebmlSized(idCodecID, huge, nil)))),
+		"unknown size segment":   append(ebml(idEBML), ebmlSized(idSegment, unknown, ebml(idInfo, ebmlSized(idDuration, unknown, nil)))...),
+		"mp4 oversized box":      append(mp4Box("ftyp", []byte("isom")), 0, 0, 0, 1, 'm', 'o', 'o', 'v', 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff),
+		"mp4 negative large box": append(mp4Box("ftyp", []byte("isom")), 0, 0, 0, 1, 'm', 'o', 'o', 'v', 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			info, err := Reader(bytes.NewReader(data), int64(len(data)))
+			if err == nil && info.Duration != 0 {
+				t.Errorf("duration %s read from a malformed element", info.Duration)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, data := range [][]byte{validMatroska(), validMP4()} {
+		for n := 0; n < len(data); n++ {
+			// a file cut short, or a header claiming more than the file holds
+			for _, size := range []int64{int64(n), int64(len(data))} {
+				_, err := Reader(bytes.NewReader(data[:n]), size)
+				if err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrUnknownFormat) {
+					t.Errorf("%d of %d bytes: unexpected error %v", n, len(data), err)
+				}
+			}
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add(validMatroska())
+	f.Add(validMP4())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = Reader(bytes.NewReader(data), int64(len(data)))
+	})
+}
@@ -2,6 +2,8 @@ package repo
 
 import (
 	entity "go-cinema/entities"
+	"go-cinema/model"
+	"strings"
 	"sync"
 
 	"github.com/misenkashari/goutils/repository"
@@ -9,16 +11,46 @@ import (
 )
 
 var (
-	MovieRepository   *repository.GormRepository[entity.Movie, uint]
-	SeriesRepository  *repository.GormRepository[entity.Series, uint]
-	EpisodeRepository *repository.GormRepository[entity.Episode, uint]
-	once              sync.Once
+	MovieRepository    *repository.GormRepository[entity.Movie, uint]
+	SeriesRepository   *repository.GormRepository[entity.Series, uint]
+	EpisodeRepository  *repository.GormRepository[entity.Episode, uint]
+	UserRepository     *repository.GormRepository[model.User, uint]
+	TokenRepository    *repository.GormRepository[model.RefreshToken, uint]
+	ProgressRepository *repository.GormRepository[entity.WatchProgress, uint]
+	// DB is the connection behind the repositories, for queries they cannot express
+	DB   *gorm.DB
+	once sync.Once
 )
 
 func InitRepositories(db *gorm.DB) {
 	once.Do(func() {
+		DB = db
 		MovieRepository = repository.Gorm[entity.Movie, uint](db)
 		SeriesRepository = repository.Gorm[entity.Series, uint](db)
 		EpisodeRepository = repository.Gorm[entity.Episode, uint](db)
+		UserRepository = repository.Gorm[model.User, uint](db)
+		TokenRepository = repository.Gorm[model.RefreshToken, uint](db)
+		ProgressRepository = repository.Gorm[entity.WatchProgress, uint](db)
 	})
 }
+
+// Count counts the rows of T matched by query
+func Count[T any](query func(*gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	err := query(DB.Model(new(T))).Count(&total).Error
+	return total, err
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns with !, which needs no
+// escaping itself in the string literals of any backend, unlike a backslash in MySQL
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+// Like is the condition of column matching a pattern built with EscapeLike
+func Like(column string) string {
+	return column + " LIKE ? ESCAPE '!'"
+}
+
+// EscapeLike makes s match itself only in a Like pattern
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,101 @@
+package repo_test
+
+import (
+	"go-cinema/database/dbtest"
+	entity "go-cinema/entities"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRepositories(t *testing.T) {
+	dbtest.Open(t)
+
+	user := model.User{Username: "alice", Password: "hash", Email: "alice@example.com", Role: model.RoleAdmin}
+	if err := repo.UserRepository.Save(&user); err != nil {
+		t.Fatalf("saving the user: %v", err)
+	}
+	movie := entity.Movie{Title: "Heat", Path: "media/heat.mkv", Checksum: "ab12"}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatalf("saving the movie: %v", err)
+	}
+	serie := entity.Series{Title: "Show", BaseDir: "media/Series/Show"}
+	if err := repo.SeriesRepository.Save(&serie); err != nil {
+		t.Fatalf("saving the series: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		episode := entity.Episode{Path: "media/Series/Show/e.mkv", EpisodeIndex: i, SeriesID: serie.ID}
+		if err := repo.EpisodeRepository.Save(&episode); err != nil {
+			t.Fatalf("saving episode %d: %v", i, err)
+		}
+	}
+	now := time.Now()
+	progress := entity.WatchProgress{UserID: user.ID, MovieID: movie.ID}
+	progress.Progress.Track(time.Minute, time.Hour)
+	if err := repo.ProgressRepository.Save(&progress); err != nil {
+		t.Fatalf("saving the progress: %v", err)
+	}
+	token := model.RefreshToken{UserID: user.ID, TokenHash: "hash", ExpiresAt: now.Add(time.Hour)}
+	if err := repo.TokenRepository.Save(&token); err != nil {
+		t.Fatalf("saving the token: %v", err)
+	}
+
+	found, err := repo.MovieRepository.FindByID(movie.ID)
+	if err != nil || found.Title != "Heat" || found.Checksum != "ab12" {
+		t.Fatalf("FindByID = %+v, %v", found, err)
+	}
+	episodes, err := repo.EpisodeRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("series_id = ? AND episode_index > ?", serie.ID, 1).Order("episode_index")
+	})
+	if err != nil || episodes.Size() != 2 || episodes.ToSlice()[0].EpisodeIndex != 2 {
+		t.Fatalf("FindByQuery = %v, %v", episodes, err)
+	}
+	total, err := repo.Count[entity.Episode](func(db *gorm.DB) *gorm.DB {
+		return db.Where("series_id = ?", serie.ID)
+	})
+	if err != nil || total != 3 {
+		t.Fatalf("Count = %d, %v", total, err)
+	}
+
+	if err := repo.MovieRepository.DeleteByID(movie.ID); err != nil {
+		t.Fatalf("deleting the movie: %v", err)
+	}
+	if _, err := repo.MovieRepository.FindByID(movie.ID); err == nil {
+		t.Fatal("a deleted movie is still found")
+	}
+}
+
+func TestLike(t *testing.T) {
+	dbtest.Open(t)
+
+	paths := []string{
+		`media/100%/a.mkv`,
+		`media/100x/a.mkv`,
+		`media/a_b/a.mkv`,
+		`media/axb/a.mkv`,
+		`media/a!b/a.mkv`,
+		`media/a\b/a.mkv`,
+		`media/a'b/a.mkv`,
+	}
+	for _, path := range paths {
+		movie := entity.Movie{Title: path, Path: path}
+		if err := repo.MovieRepository.Save(&movie); err != nil {
+			t.Fatalf("saving %s: %v", path, err)
+		}
+	}
+
+	for _, dir := range []string{`media/100%/`, `media/a_b/`, `media/a!b/`, `media/a\b/`, `media/a'b/`} {
+		movies, err := repo.MovieRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+			return db.Where(repo.Like("path"), repo.EscapeLike(dir)+"%")
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		if movies.Size() != 1 || movies.ToSlice()[0].Path != dir+"a.mkv" {
+			t.Errorf("%s matched %d movies, want only %sa.mkv", dir, movies.Size(), dir)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrOutside is returned for paths leading out of the media roots, symlinks followed.
+	ErrOutside = errors.New("path is outside the media roots")
+	// ErrInvalidName is returned for names which are empty, absolute or climb with "..".
+	ErrInvalidName = errors.New("invalid file name")
+)
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// hasDotDot tells whether a path climbs up, with either separator so a name
+// sent by a Windows client cannot either.
+func hasDotDot(path string) bool {
+	for _, part := range strings.FieldsFunc(path, isSeparator) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckName validates a file name sent by a client, to be used relative to a directory.
+func CheckName(name string) error {
+	switch {
+	case name == "", strings.ContainsRune(name, 0):
+	case filepath.IsAbs(name), filepath.VolumeName(name) != "", isSeparator(rune(name[0])):
+	case hasDotDot(name), filepath.Clean(name) == ".":
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidName, name)
+}
+
+// resolve makes path absolute and resolves the symlinks of its longest existing
+// prefix. The rest does not exist yet, so it holds no symlinks.
+func resolve(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		// a dangling symlink, creating through it would write wherever it points
+		if _, err := os.Lstat(path); err == nil {
+			return "", fmt.Errorf("%w: %s is a broken symlink", ErrOutside, path)
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// Canonical is path absolute with its symlinks resolved, the form the library
+// stores paths in, whether the file exists or not.
+func Canonical(path string) (string, error) {
+	return resolve(path)
+}
+
+// Root is a directory the files of a handler are confined to.
+type Root struct {
+	dir string
+}
+
+// NewRoot confines to dir, which does not need to exist yet.
+func NewRoot(dir string) (*Root, error) {
+	resolved, err := resolve(dir)
+	if err != nil {
+		return nil, fmt.Errorf("media root %s: %w", dir, err)
+	}
+	return &Root{dir: resolved}, nil
+}
+
+// Dir is the directory of the root, absolute and with its symlinks resolved.
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// within tells whether path is dir or lies under it, both resolved.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (r *Root) contains(resolved string) bool {
+	return within(r.dir, resolved)
+}
+
+// Join returns the path of name inside the root, with its symlinks resolved.
+// Open that path rather than name, it is the one which was checked.
+func (r *Root) Join(name string) (string, error) {
+	if err := CheckName(name); err != nil {
+		return "", err
+	}
+	path, err := resolve(filepath.Join(r.dir, name))
+	if err != nil {
+		return "", err
+	}
+	if !r.contains(path) {
+		return "", fmt.Errorf("%w: %s", ErrOutside, name)
+	}
+	return path, nil
+}
+
+func (r *Root) Open(name string) (*os.File, error) {
+	path, err := r.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Create creates or truncates the file name in the root.
+func (r *Root) Create(name string) (*os.File, error) {
+	path, err := r.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (r *Root) Remove(name string) error {
+	path, err := r.Join(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Set is the allow-list of the media roots, for the paths stored in the
+// database and those sent by clients.
+type Set struct {
+	roots []*Root
+}
+
+func NewSet(dirs ...string) (*Set, error) {
+	s := &Set{}
+	for _, dir := range dirs {
+		root, err := NewRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.roots = append(s.roots, root)
+	}
+	return s, nil
+}
+
+// Resolve checks that path, absolute or relative to the working directory like
+// the paths stored in the database, lies inside one of the roots. It returns the
+// path with its symlinks resolved, which is the one to open.
+func (s *Set) Resolve(path string) (string, error) {
+	if path == "" || strings.ContainsRune(path, 0) || hasDotDot(path) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, path)
+	}
+	resolved, err := resolve(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		if root.contains(resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrOutside, path)
+}
+
+// Join resolves the file name, sent by a client, in the directory dir.
+func (s *Set) Join(dir, name string) (string, error) {
+	if err := CheckName(name); err != nil {
+		return "", err
+	}
+	return s.Resolve(filepath.Join(dir, name))
+}
+
+func (s *Set) Open(path string) (*os.File, error) {
+	resolved, err := s.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(resolved)
+}
+
+// Create creates or truncates the file name in the directory dir.
+func (s *Set) Create(dir, name string) (*os.File, error) {
+	path, err := s.Join(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// MkdirAll creates the directory name in dir, and its missing parents.
+func (s *Set) MkdirAll(dir, name string) (string, error) {
+	path, err := s.Join(dir, name)
+	if err != nil {
+		return "", err
+	}
+	return path, os.MkdirAll(path, os.ModePerm)
+}
+
+func (s *Set) Remove(path string) error {
+	resolved, err := s.removable(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(resolved)
+}
+
+// RemoveAll removes path and what it holds, never a root itself.
+func (s *Set) RemoveAll(path string) error {
+	resolved, err := s.removable(path)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(resolved)
+}
+
+func (s *Set) removable(path string) (string, error) {
+	resolved, err := s.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		// a root inside the one being removed, like the series in the movies
+		if within(resolved, root.dir) {
+			return "", fmt.Errorf("%w: %s holds the media root %s", ErrOutside, path, root.dir)
+		}
+	}
+	return resolved, nil
+}
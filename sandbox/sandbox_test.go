@@ -0,0 +1,266 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tree lays out a media root next to the files it must never reach:
+//
+//	media/file.mkv
+//	media/sub/episode.mkv
+//	media/link-in -> media/sub
+//	media/link-out -> secret
+//	media/link-file -> secret/passwd
+//	media/dangling -> secret/missing
+//	media/link-evil -> media-evil
+//	media-evil/file.mkv, a sibling sharing the prefix of the root
+//	secret/passwd
+func tree(t testing.TB) (media, secret string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	media = filepath.Join(base, "media")
+	secret = filepath.Join(base, "secret")
+	for _, dir := range []string{filepath.Join(media, "sub"), secret, filepath.Join(base, "media-evil")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{
+		filepath.Join(media, "file.mkv"),
+		filepath.Join(media, "sub", "episode.mkv"),
+		filepath.Join(base, "media-evil", "file.mkv"),
+		filepath.Join(secret, "passwd"),
+	} {
+		if err := os.WriteFile(file, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"link-in":   filepath.Join(media, "sub"),
+		"link-out":  secret,
+		"link-file": filepath.Join(secret, "passwd"),
+		"dangling":  filepath.Join(secret, "missing"),
+		"link-evil": filepath.Join(base, "media-evil"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(media, name)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	return media, secret
+}
+
+// inside fails unless path, as returned by the sandbox, is dir or below it and
+// holds no symlink left to follow.
+func inside(t *testing.T, dir, input, path string) {
+	t.Helper()
+	if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		t.Fatalf("%q resolved to %s, outside %s", input, path, dir)
+	}
+	canonical, err := resolve(path)
+	if err != nil {
+		t.Fatalf("%q resolved to %s, which does not resolve: %v", input, path, err)
+	}
+	if canonical != path {
+		t.Fatalf("%q resolved to %s, which still leads to %s", input, path, canonical)
+	}
+}
+
+var escapes = []string{
+	"",
+	".",
+	"..",
+	"../secret/passwd",
+	"sub/../../secret/passwd",
+	`..\secret\passwd`,
+	`sub\..\..\secret`,
+	"/etc/passwd",
+	"link-out",
+	"link-out/passwd",
+	"link-file",
+	"link-in/../../secret/passwd",
+	"dangling",
+	"dangling/child",
+	"../media-evil/file.mkv",
+	"link-evil/file.mkv",
+	"file.mkv\x00.txt",
+}
+
+var allowed = []string{
+	"file.mkv",
+	"sub/episode.mkv",
+	"sub/new.mkv",
+	"new/dir/file.mkv",
+	"link-in/episode.mkv",
+	"./file.mkv",
+	"sub//episode.mkv",
+}
+
+func TestRootJoin(t *testing.T) {
+	media, _ := tree(t)
+	root, err := NewRoot(media)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range escapes {
+		if path, err := root.Join(name); err == nil {
+			t.Errorf("Join(%q) = %s, want an error", name, path)
+		}
+	}
+	for _, name := range allowed {
+		path, err := root.Join(name)
+		if err != nil {
+			t.Errorf("Join(%q): %v", name, err)
+			continue
+		}
+		inside(t, media, name, path)
+	}
+
+	if path, _ := root.Join("link-in/episode.mkv"); path != filepath.Join(media, "sub", "episode.mkv") {
+		t.Errorf("the symlink is not resolved: %s", path)
+	}
+}
+
+func TestSetResolve(t *testing.T) {
+	media, secret := tree(t)
+	series := filepath.Join(media, "sub")
+	set, err := NewSet(media, series)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range escapes {
+		// appended to the root, these stay in it
+		if name == "" || name == "." || name == "/etc/passwd" {
+			continue
+		}
+		path := media + "/" + name
+		if resolved, err := set.Resolve(path); err == nil {
+			t.Errorf("Resolve(%q) = %s, want an error", path, resolved)
+		}
+	}
+	for _, name := range allowed {
+		path := media + "/" + name
+		resolved, err := set.Resolve(path)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", path, err)
+			continue
+		}
+		inside(t, media, path, resolved)
+	}
+
+	if _, err := set.Resolve(filepath.Join(secret, "passwd")); !errors.Is(err, ErrOutside) {
+		t.Errorf("Resolve of a file outside the roots: %v", err)
+	}
+	for _, root := range []string{media, series} {
+		if err := set.RemoveAll(root); !errors.Is(err, ErrOutside) {
+			t.Errorf("RemoveAll(%s): %v, a root must never be removed", root, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(series, "episode.mkv")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "a/../..", `a\..\..`, "/abs", `\abs`, "nul\x00"} {
+		if err := CheckName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CheckName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"movie.mkv", "Season 1/e01.mkv", "..movie", "movie..mkv"} {
+		if err := CheckName(name); err != nil {
+			t.Errorf("CheckName(%q) = %v", name, err)
+		}
+	}
+}
+
+func addSeeds(f *testing.F) {
+	for _, name := range append(append([]string{}, escapes...), allowed...) {
+		f.Add(name)
+	}
+}
+
+func FuzzRootJoin(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, name string) {
+		media, _ := tree(t)
+		root, err := NewRoot(media)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := root.Join(name)
+		if err != nil {
+			return
+		}
+		inside(t, media, name, path)
+	})
+}
+
+func FuzzSetResolve(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, name string) {
+		media, _ := tree(t)
+		set, err := NewSet(media)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// appended rather than joined, which would clean the ".." away
+		path := media + "/" + name
+		resolved, err := set.Resolve(path)
+		if err != nil {
+			return
+		}
+		inside(t, media, path, resolved)
+	})
+}
+
+func FuzzSetJoin(f *testing.F) {
+	for _, name := range append(append([]string{}, escapes...), allowed...) {
+		f.Add("sub", name)
+		f.Add("link-in", name)
+	}
+	f.Add("link-out", "passwd")
+	f.Add("..", "secret")
+	f.Fuzz(func(t *testing.T, dir, name string) {
+		media, _ := tree(t)
+		set, err := NewSet(media)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := set.Join(media+"/"+dir, name)
+		if err != nil {
+			return
+		}
+		inside(t, media, dir+" "+name, path)
+	})
+}
+
+func FuzzCheckName(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, name string) {
+		if CheckName(name) != nil {
+			return
+		}
+		// a valid name stays in whatever directory it is joined to, on any system
+		for _, part := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+			if part == ".." {
+				t.Fatalf("CheckName accepted %q, which climbs", name)
+			}
+		}
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+			t.Fatalf("CheckName accepted the absolute %q", name)
+		}
+		if joined := filepath.Join("/media", name); !strings.HasPrefix(joined, "/media/") {
+			t.Fatalf("CheckName accepted %q, joined to %s", name, joined)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// table describes how the full-text vector of a table is built. Titles weigh
+// more than file names, which weigh more than descriptions. The search_indexes
+// migration indexes these very expressions, keep them in step.
+type table struct {
+	name    string
+	kind    string
+	columns string
+	vector  string
+}
+
+// words splits the file name of a path column into words, to_tsvector keeps a
+// file name as a single token. The directories above it are left out, they are
+// the same for every file and tell the layout of the server.
+func words(column string) string {
+	return fmt.Sprintf("translate(regexp_replace(coalesce(%s, ''), '^.*/', ''), '._-', '   ')", column)
+}
+
+var tables = []table{
+	{
+		name:    "movies",
+		kind:    TypeMovie,
+		columns: "id, 0 AS series_id, title, description, path",
+		vector: "setweight(to_tsvector('simple', coalesce(title, '')), 'A') || " +
+			"setweight(to_tsvector('simple', " + words("path") + "), 'B') || " +
+			"setweight(to_tsvector('simple', coalesce(description, '')), 'C')",
+	},
+	{
+		name:    "series",
+		kind:    TypeSeries,
+		columns: "id, 0 AS series_id, title, description, base_dir AS path",
+		vector: "setweight(to_tsvector('simple', coalesce(title, '')), 'A') || " +
+			"setweight(to_tsvector('simple', " + words("base_dir") + "), 'B') || " +
+			"setweight(to_tsvector('simple', coalesce(description, '')), 'C')",
+	},
+	{
+		name:    "episodes",
+		kind:    TypeEpisode,
+		columns: "id, series_id, '' AS title, '' AS description, path",
+		vector:  "setweight(to_tsvector('simple', " + words("path") + "), 'B')",
+	},
+}
+
+// prefixQuery matches every term, as the beginning of a word so results come while typing.
+// Terms are letters and digits only, nothing in them has a meaning to to_tsquery.
+func prefixQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+type ranked struct {
+	document
+	Score float64
+}
+
+func fullText(db *gorm.DB, terms []string, limit int) ([]Hit, error) {
+	query := prefixQuery(terms)
+
+	hits := []Hit{}
+	for _, t := range tables {
+		var rows []ranked
+
+		where := "deleted_at IS NULL AND (" + t.vector + ") @@ to_tsquery('simple', ?)"
+		if t.name != "series" {
+			where += " AND missing = false"
+		}
+		err := db.Table(t.name).
+			Select(t.columns+", ts_rank(("+t.vector+"), to_tsquery('simple', ?)) AS score", query).
+			Where(where, query).
+			Order("score DESC").
+			Limit(limit).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			row.Type = t.kind
+			hits = append(hits, row.hit(row.Score, snippet(row.document, func(word string) bool {
+				return matchesPrefix(word, terms)
+			})))
+		}
+	}
+	return hits, nil
+}
+
+func matchesPrefix(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TypeMovie   = "Movie"
+	TypeSeries  = "Series"
+	TypeEpisode = "Episode"
+)
+
+// maxTerms bounds the work a single query can ask for
+const maxTerms = 8
+
+// Hit is a movie, a series or an episode matching a query. Snippet is HTML with
+// the matched words in <mark>, everything else escaped.
+type Hit struct {
+	Type     string  `json:"Type"`
+	ID       uint    `json:"ID"`
+	SeriesID uint    `json:"SeriesID,omitempty"`
+	Title    string  `json:"Title"`
+	Snippet  string  `json:"Snippet"`
+	Score    float64 `json:"Score"`
+}
+
+// document is what a hit is searched in, the fields in decreasing weight
+type document struct {
+	Type        string
+	ID          uint
+	SeriesID    uint
+	Title       string
+	Description string
+	Path        string
+}
+
+func (d document) hit(score float64, snippet string) Hit {
+	title := d.Title
+	if title == "" {
+		title = filepath.Base(d.Path)
+	}
+	return Hit{Type: d.Type, ID: d.ID, SeriesID: d.SeriesID, Title: title, Snippet: snippet, Score: score}
+}
+
+// Search finds the movies, series and episodes matching q, best first. PostgreSQL
+// uses its full-text indexes, other databases a trigram match in memory.
+func Search(ctx context.Context, db *gorm.DB, q string, limit int) ([]Hit, error) {
+	terms := Terms(q)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+
+	db = db.WithContext(ctx)
+	var (
+		hits []Hit
+		err  error
+	)
+	if db.Dialector.Name() == "postgres" {
+		hits, err = fullText(db, terms, limit)
+	} else {
+		hits, err = trigram(db, terms, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(hits, func(a, b Hit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// Terms splits a query into lower case words, dots, dashes and slashes of
+// file names separating words as spaces do.
+func Terms(q string) []string {
+	terms := tokenize(q)
+	slices.Sort(terms)
+	terms = slices.Compact(terms)
+	if len(terms) > maxTerms {
+		terms = terms[:maxTerms]
+	}
+	return terms
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// documents loads what is searched in, missing media left out.
+func documents(db *gorm.DB) ([]document, error) {
+	var movies, series, episodes []document
+
+	err := db.Table("movies").
+		Select("id, title, description, path").
+		Where("deleted_at IS NULL AND missing = ?", false).
+		Scan(&movies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Table("series").
+		Select("id, title, description, base_dir AS path").
+		Where("deleted_at IS NULL").
+		Scan(&series).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Table("episodes").
+		Select("id, series_id, path").
+		Where("deleted_at IS NULL AND missing = ?", false).
+		Scan(&episodes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]document, 0, len(movies)+len(series)+len(episodes))
+	for _, d := range movies {
+		d.Type = TypeMovie
+		docs = append(docs, d)
+	}
+	for _, d := range series {
+		d.Type = TypeSeries
+		docs = append(docs, d)
+	}
+	for _, d := range episodes {
+		d.Type = TypeEpisode
+		docs = append(docs, d)
+	}
+	return docs, nil
+}
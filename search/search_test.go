@@ -0,0 +1,197 @@
+package search
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-cinema/database/dbtest"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+)
+
+func TestTerms(t *testing.T) {
+	cases := map[string][]string{
+		"":                           nil,
+		"  ":                         nil,
+		"The Matrix":                 {"matrix", "the"},
+		"matrix MATRIX Matrix":       {"matrix"},
+		"/srv/movies/the.matrix.mkv": {"matrix", "mkv", "movies", "srv", "the"},
+		"Blade-Runner_2049":          {"2049", "blade", "runner"},
+		"amélie":                     {"amélie"},
+		"a:* & b | !c":               {"a", "b", "c"},
+		"one two three four five six seven eight nine ten": {"eight", "five", "four", "nine", "one", "seven", "six", "ten"},
+	}
+	for q, want := range cases {
+		if got := Terms(q); !slices.Equal(got, want) {
+			t.Errorf("Terms(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	if got := prefixQuery([]string{"blade", "run"}); got != "blade:* & run:*" {
+		t.Errorf("prefixQuery = %q", got)
+	}
+}
+
+// TestIndexExpressions keeps the vectors searched in step with the indexes of
+// the search_indexes migration, which the planner only uses for the same expression.
+func TestIndexExpressions(t *testing.T) {
+	migration, err := os.ReadFile("../migrations/sql/0004_search_indexes.postgres.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compared without the line breaks of the migration
+	flat := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	for _, table := range tables {
+		if !strings.Contains(flat(string(migration)), flat(table.vector)) {
+			t.Errorf("the vector of %s is not indexed: %s", table.name, table.vector)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	term := "matrix"
+	grams := trigrams(term)
+	if got := similarity(term, grams, "matrix"); got != 1 {
+		t.Errorf("the term itself scores %v", got)
+	}
+	if got := similarity(term, grams, "matrixes"); got != 0.9 {
+		t.Errorf("a word the term begins scores %v", got)
+	}
+	typo := similarity(term, grams, "matrx")
+	if typo < threshold || typo >= 0.9 {
+		t.Errorf("a typo scores %v", typo)
+	}
+	if got := similarity(term, grams, "lebowski"); got != 0 {
+		t.Errorf("an unrelated word scores %v", got)
+	}
+	if got := len(trigrams("ab")); got != 3 {
+		t.Errorf("%d trigrams of a two letter word, want 3 with the padding", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	matches := func(word string) bool { return word == "pill" }
+
+	doc := document{Title: "The Matrix", Description: "Take the red <pill>, or the blue pill."}
+	want := "Take the red <mark>&lt;pill&gt;,</mark> or the blue <mark>pill.</mark>"
+	if got := snippet(doc, matches); got != want {
+		t.Errorf("snippet %q, want %q", got, want)
+	}
+
+	long := document{Title: "Long", Description: strings.Repeat("word ", 30) + "pill " + strings.Repeat("word ", 30)}
+	got := snippet(long, matches)
+	if !strings.HasPrefix(got, "… ") || !strings.HasSuffix(got, " …") || len(strings.Fields(got)) != snippetWords+2 {
+		t.Errorf("window %q", got)
+	}
+	if strings.Index(got, "<mark>") > len(got)/2 {
+		t.Errorf("the match is not near the start of the window %q", got)
+	}
+
+	// no match in the description falls back to the title, then the file name
+	if got := snippet(document{Title: "Red pill", Description: "nothing"}, matches); got != "Red <mark>pill</mark>" {
+		t.Errorf("title snippet %q", got)
+	}
+	if got := snippet(document{Title: "T", Path: "/srv/movies/pill.mkv"}, matches); got != "<mark>pill.mkv</mark>" {
+		t.Errorf("file name snippet %q", got)
+	}
+	if got := snippet(document{Title: "<b>Other</b>"}, matches); got != "&lt;b&gt;Other&lt;/b&gt;" {
+		t.Errorf("snippet without a match %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := dbtest.Open(t)
+	movies := []entity.Movie{
+		{Title: "The Matrix", Path: "/srv/movies/matrix.mkv", Description: "A hacker learns the truth"},
+		{Title: "The Matrix Reloaded", Path: "/srv/movies/reloaded.mkv"},
+		{Title: "Hackers", Path: "/srv/movies/hackers.mkv", Description: "Teenagers and a matrix of phones"},
+		{Title: "Gone", Path: "/srv/movies/gone.mkv", Missing: true},
+	}
+	for i := range movies {
+		if err := repo.MovieRepository.Save(&movies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serie := entity.Series{Title: "Lost", BaseDir: "/srv/series/Lost"}
+	if err := repo.SeriesRepository.Save(&serie); err != nil {
+		t.Fatal(err)
+	}
+	episode := entity.Episode{Path: "/srv/series/Lost/Lost.S01E01.Pilot.mkv", EpisodeIndex: 1, SeriesID: serie.ID}
+	if err := repo.EpisodeRepository.Save(&episode); err != nil {
+		t.Fatal(err)
+	}
+	search := func(q string, limit int) []Hit {
+		t.Helper()
+		hits, err := Search(context.Background(), db, q, limit)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q, err)
+		}
+		return hits
+	}
+	titles := func(hits []Hit) []string {
+		var titles []string
+		for _, hit := range hits {
+			titles = append(titles, hit.Title)
+		}
+		return titles
+	}
+
+	// titles weigh more than descriptions
+	matrix := search("matrix", 10)
+	if len(matrix) != 3 || matrix[2].Title != "Hackers" {
+		t.Fatalf("matrix %v", titles(matrix))
+	}
+	for i := 1; i < len(matrix); i++ {
+		if matrix[i].Score > matrix[i-1].Score {
+			t.Errorf("hits not sorted by score: %v", matrix)
+		}
+	}
+	if !strings.Contains(matrix[2].Snippet, "<mark>matrix</mark>") {
+		t.Errorf("snippet %q", matrix[2].Snippet)
+	}
+	if got := search("matrix", 1); len(got) != 1 || got[0].Score != matrix[0].Score {
+		t.Errorf("limited to 1 %v", titles(got))
+	}
+
+	// every term has to match
+	if got := titles(search("matrix reloaded", 10)); !reflect.DeepEqual(got, []string{"The Matrix Reloaded"}) {
+		t.Errorf("matrix reloaded %v", got)
+	}
+	// while typing
+	if got := titles(search("reloa", 10)); !reflect.DeepEqual(got, []string{"The Matrix Reloaded"}) {
+		t.Errorf("reloa %v", got)
+	}
+
+	pilot := search("pilot", 10)
+	if len(pilot) != 1 || pilot[0].Type != TypeEpisode || pilot[0].SeriesID != serie.ID || pilot[0].Title != "Lost.S01E01.Pilot.mkv" {
+		t.Errorf("pilot %+v", pilot)
+	}
+	lost := search("lost", 10)
+	if len(lost) != 2 || lost[0].Type != TypeSeries {
+		t.Errorf("lost %+v, the series first by its title", lost)
+	}
+
+	// the directories above the files are not searched, only their names
+	for _, q := range []string{"srv", "movies", "series"} {
+		if got := search(q, 10); len(got) != 0 {
+			t.Errorf("%s matched the media directories: %v", q, titles(got))
+		}
+	}
+	if got := search("gone", 10); len(got) != 0 {
+		t.Errorf("missing media found %v", titles(got))
+	}
+	if got := search("  ", 10); got == nil || len(got) != 0 {
+		t.Errorf("an empty query answered %v", got)
+	}
+	if dbtest.Driver() != "postgres" {
+		if got := titles(search("matrx", 10)); len(got) == 0 || !strings.Contains(got[0], "Matrix") {
+			t.Errorf("a typo found %v", got)
+		}
+	}
+}
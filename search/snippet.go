@@ -0,0 +1,64 @@
+package search
+
+import (
+	"html"
+	"path/filepath"
+	"strings"
+)
+
+// snippetWords is the length of a snippet in words
+const snippetWords = 20
+
+// snippet shows where a document matched: a window of the description around
+// its first matching word, or else the title, or else the file name.
+func snippet(doc document, matches func(word string) bool) string {
+	for _, text := range []string{doc.Description, doc.Title, filepath.Base(doc.Path)} {
+		if s, ok := highlight(text, matches); ok {
+			return s
+		}
+	}
+	return html.EscapeString(doc.Title)
+}
+
+func highlight(text string, matches func(word string) bool) (string, bool) {
+	words := strings.Fields(text)
+	marked := make([]bool, len(words))
+	first := -1
+	for i, word := range words {
+		for _, part := range tokenize(word) {
+			if matches(part) {
+				marked[i] = true
+				break
+			}
+		}
+		if marked[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	start := max(0, first-snippetWords/4)
+	end := min(len(words), start+snippetWords)
+	start = max(0, end-snippetWords)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteByte(' ')
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(words[i]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(words[i]))
+		}
+	}
+	if end < len(words) {
+		b.WriteString(" …")
+	}
+	return b.String(), true
+}
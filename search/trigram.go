@@ -0,0 +1,105 @@
+package search
+
+import (
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// threshold is the similarity a word needs to match a term, the default of pg_trgm
+const threshold = 0.3
+
+// Weights of the fields, mirroring the A, B and C weights of the full-text vectors.
+const (
+	titleWeight       = 1.0
+	pathWeight        = 0.6
+	descriptionWeight = 0.4
+)
+
+// trigram scores every document in memory, for the databases without full-text
+// search. A document matches when each term is similar enough to one of its words.
+func trigram(db *gorm.DB, terms []string, limit int) ([]Hit, error) {
+	docs, err := documents(db)
+	if err != nil {
+		return nil, err
+	}
+
+	grams := make([]map[string]bool, len(terms))
+	for i, term := range terms {
+		grams[i] = trigrams(term)
+	}
+
+	hits := []Hit{}
+	for _, doc := range docs {
+		fields := []struct {
+			words  []string
+			weight float64
+		}{
+			{tokenize(doc.Title), titleWeight},
+			{tokenize(filepath.Base(doc.Path)), pathWeight},
+			{tokenize(doc.Description), descriptionWeight},
+		}
+
+		score := 0.0
+		for i, term := range terms {
+			best := 0.0
+			for _, field := range fields {
+				for _, word := range field.words {
+					best = max(best, field.weight*similarity(term, grams[i], word))
+				}
+			}
+			if best == 0 {
+				score = 0
+				break
+			}
+			score += best
+		}
+		if score == 0 {
+			continue
+		}
+
+		hits = append(hits, doc.hit(score/float64(len(terms)), snippet(doc, func(word string) bool {
+			for i, term := range terms {
+				if similarity(term, grams[i], word) > 0 {
+					return true
+				}
+			}
+			return false
+		})))
+	}
+	return hits, nil
+}
+
+// similarity is 1 for the term itself, 0.9 for a word it begins and the trigram
+// similarity otherwise, 0 under the threshold.
+func similarity(term string, termGrams map[string]bool, word string) float64 {
+	switch {
+	case word == term:
+		return 1
+	case len(word) > len(term) && word[:len(term)] == term:
+		return 0.9
+	}
+
+	wordGrams := trigrams(word)
+	shared := 0
+	for gram := range wordGrams {
+		if termGrams[gram] {
+			shared++
+		}
+	}
+	sim := float64(shared) / float64(len(termGrams)+len(wordGrams)-shared)
+	if sim < threshold {
+		return 0
+	}
+	return sim
+}
+
+// trigrams of a word padded as pg_trgm does, two spaces before and one after.
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	grams := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = true
+	}
+	return grams
+}
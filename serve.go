@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-cinema/cronos"
+	"go-cinema/dlna"
+	filehandler "go-cinema/io"
+	"go-cinema/lifecycle"
+	"go-cinema/theatre"
+	"net/http"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "Start the HTTP server, DLNA discovery and the library watcher. This is the default command.",
+	setup: func(fs *flag.FlagSet) runner {
+		return serve
+	},
+}
+
+func serve(a *app, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	cfg := a.cfg
+	golog.Info("Configuration: {}", fmt.Sprintf("%+v", cfg.Redacted()))
+
+	if _, err := a.database(); err != nil {
+		return err
+	}
+
+	router := theatre.SetupRoutes()
+
+	logo := `
+            __       .__  .__               .__                          
+   ____    |__|____  |  | |  | _____ _______|  |__   ___________  ____   
+  / ___\   |  \__  \ |  | |  | \__  \\_  __ \  |  \ /  _ \_  __ \/    \  
+ / /_/  >  |  |/ __ \|  |_|  |__/ __ \|  | \/   Y  (  <_> )  | \/   |  \ 
+ \___  /\__|  (____  /____/____(____  /__|  |___|  /\____/|__|  |___|  / 
+/_____/\______|    \/               \/           \/                  \/  
+
+			gjållårhðrñ - A simple HTTP router for Go
+	`
+
+	golog.Info(logo)
+
+	m := lifecycle.New(cfg.Server.ShutdownTimeout)
+	// stopped last, once nothing uses it
+	m.OnStop("database", func(context.Context) error {
+		return a.close()
+	})
+	m.OnStop("background jobs", func(context.Context) error {
+		cronos.Stop()
+		return nil
+	})
+
+	ssdp := dlna.NewSSDPServer(cfg.Server.Port)
+	m.Go("DLNA discovery", func() error {
+		if err := ssdp.ListenAndServe(); err != nil && err != dlna.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	m.OnStop("DLNA discovery", func(context.Context) error {
+		return ssdp.Close()
+	})
+
+	casting := startTask(func(ctx context.Context) {
+		theatre.InitCasting(cfg.Server.Port).Run(ctx, time.Minute)
+	})
+	m.OnStop("casting", casting.stop)
+
+	watcher := startTask(theatre.WatchLibrary)
+	m.OnStop("library watcher", func(ctx context.Context) error {
+		return watcher.stop(ctx)
+	})
+
+	m.OnReload(func() error {
+		moved, err := a.reload()
+		if err != nil || !moved {
+			return err
+		}
+		// the watcher reads the roots when it starts
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := watcher.stop(ctx); err != nil {
+			return err
+		}
+		watcher = startTask(theatre.WatchLibrary)
+		return nil
+	})
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:      router, // Use the ServeMux
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+	m.Go("HTTP server", func() error {
+		golog.Info("Started server on port {}", cfg.Server.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+		return nil
+	})
+	m.OnStop("HTTP server", func(ctx context.Context) error {
+		// waits for the open requests, and cuts the streams still going at the deadline
+		if err := server.Shutdown(ctx); err != nil {
+			golog.Warn("Closing the connections still open: {}", err.Error())
+			return server.Close()
+		}
+		return nil
+	})
+
+	return m.Run(context.Background())
+}
+
+// task is a background goroutine which can be stopped and waited for.
+type task struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startTask(run func(ctx context.Context)) *task {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &task{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		run(ctx)
+	}()
+	return t
+}
+
+func (t *task) stop(ctx context.Context) error {
+	t.cancel()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reload reads the configuration again and applies the media directories. The
+// other settings are in use until a restart, a warning tells which changed.
+// It reports whether the library roots moved.
+func (a *app) reload() (bool, error) {
+	cfg, err := a.flags.Load()
+	if err != nil {
+		return false, err
+	}
+
+	for name, changed := range map[string]bool{
+		"server":   cfg.Server != a.cfg.Server,
+		"database": cfg.Database != a.cfg.Database,
+		"auth":     cfg.Auth != a.cfg.Auth,
+		"log":      cfg.Log != a.cfg.Log,
+	} {
+		if changed {
+			golog.Warn("The {} settings changed, restart to apply them", name)
+		}
+	}
+	if cfg.Media == a.cfg.Media {
+		golog.Info("Configuration reloaded, the media directories are unchanged")
+		return false, nil
+	}
+
+	moved := cfg.Media.Movies != a.cfg.Media.Movies || cfg.Media.Series != a.cfg.Media.Series
+	applied := *a.cfg
+	applied.Media = cfg.Media
+	if err := theatre.Configure(&applied); err != nil {
+		return false, err
+	}
+	a.cfg = &applied
+	filehandler.SetUsageDataPath(a.cfg.Media.UsageData)
+	golog.Info("Configuration reloaded, media: {}", fmt.Sprintf("%+v", a.cfg.Media))
+	return moved, nil
+}
@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// dlnaOP advertises byte based seeking (Range requests) and no time based seeking.
+	dlnaOP = "DLNA.ORG_OP=01"
+	// dlnaCI marks content as not transcoded, it only goes with a profile.
+	dlnaCI = "DLNA.ORG_CI=0"
+	// dlnaFlags: streaming and background transfer modes, connection stalling and DLNA 1.5.
+	dlnaFlags = "DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+// dlnaProfile pairs a container with the MIME type and DLNA.ORG_PN renderers expect for it.
+type dlnaProfile struct {
+	mime string
+	pn   string
+}
+
+// dlnaProfiles is keyed by lower case file extension. Without codec information the
+// profile name is a best guess for the common case of each container. DLNA defines
+// no profile for Matroska, AVI and the others without one: they are still
+// announced, without DLNA.ORG_PN.
+var dlnaProfiles = map[string]dlnaProfile{
+	".mp4":  {mime: "video/mp4", pn: "AVC_MP4_HP_HD_AAC"},
+	".m4v":  {mime: "video/mp4", pn: "AVC_MP4_HP_HD_AAC"},
+	".mkv":  {mime: "video/x-matroska"},
+	".avi":  {mime: "video/x-msvideo"},
+	".mpg":  {mime: "video/mpeg", pn: "MPEG_PS_PAL"},
+	".mpeg": {mime: "video/mpeg", pn: "MPEG_PS_PAL"},
+	".ts":   {mime: "video/mp2t", pn: "MPEG_TS_HD_NA_ISO"},
+	".webm": {mime: "video/webm"},
+	".mov":  {mime: "video/quicktime"},
+	".flv":  {mime: "video/x-flv"},
+	".wmv":  {mime: "video/x-ms-wmv", pn: "WMVHIGH_FULL"},
+}
+
+// ContentType returns the MIME type used when serving and announcing a media file.
+func ContentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if profile, ok := dlnaProfiles[ext]; ok {
+		return profile.mime
+	}
+
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		return "video/mp4"
+	}
+	contentType, _, _ = strings.Cut(contentType, ";")
+	return contentType
+}
+
+// ContentFeatures builds the fourth field of a protocolInfo, which is also the
+// value of the contentFeatures.dlna.org header.
+func ContentFeatures(name string) string {
+	profile := dlnaProfiles[strings.ToLower(filepath.Ext(name))]
+	if profile.pn == "" {
+		return dlnaOP + ";" + dlnaFlags
+	}
+	return strings.Join([]string{"DLNA.ORG_PN=" + profile.pn, dlnaOP, dlnaCI, dlnaFlags}, ";")
+}
+
+// ProtocolInfo returns the UPnP protocolInfo string describing how a file is delivered.
+func ProtocolInfo(name string) string {
+	return "http-get:*:" + ContentType(name) + ":" + ContentFeatures(name)
+}
+
+// ProtocolInfos lists every protocolInfo the server can deliver, one per known container.
+func ProtocolInfos() []string {
+	seen := make(map[string]bool, len(dlnaProfiles))
+	infos := make([]string, 0, len(dlnaProfiles))
+	for ext := range dlnaProfiles {
+		info := ProtocolInfo(ext)
+		if !seen[info] {
+			seen[info] = true
+			infos = append(infos, info)
+		}
+	}
+	sort.Strings(infos)
+	return infos
+}
+
+// SetDLNAHeaders adds the transfer mode and, when the renderer asked for it, the
+// content features headers DLNA renderers require before they accept a stream.
+// The header keys are set verbatim because some renderers match them case sensitively.
+func SetDLNAHeaders(w http.ResponseWriter, r *http.Request, name string) {
+	switch mode := r.Header.Get("transferMode.dlna.org"); mode {
+	case "Streaming", "Interactive", "Background":
+		w.Header()["transferMode.dlna.org"] = []string{mode}
+	default:
+		w.Header()["transferMode.dlna.org"] = []string{"Streaming"}
+	}
+
+	if r.Header.Get("getcontentFeatures.dlna.org") == "1" {
+		w.Header()["contentFeatures.dlna.org"] = []string{ContentFeatures(name)}
+	}
+}
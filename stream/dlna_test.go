@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentFeatures(t *testing.T) {
+	cases := map[string]string{
+		"a.mp4":  "DLNA.ORG_PN=AVC_MP4_HP_HD_AAC;" + dlnaOP + ";" + dlnaCI + ";" + dlnaFlags,
+		"a.MPG":  "DLNA.ORG_PN=MPEG_PS_PAL;" + dlnaOP + ";" + dlnaCI + ";" + dlnaFlags,
+		"a.mkv":  dlnaOP + ";" + dlnaFlags,
+		"a.avi":  dlnaOP + ";" + dlnaFlags,
+		"a.webm": dlnaOP + ";" + dlnaFlags,
+		"a.xyz":  dlnaOP + ";" + dlnaFlags,
+	}
+	for name, want := range cases {
+		if got := ContentFeatures(name); got != want {
+			t.Errorf("%s: %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestProtocolInfos(t *testing.T) {
+	for _, info := range ProtocolInfos() {
+		fields := strings.Split(info, ":")
+		if len(fields) != 4 || fields[0] != "http-get" || fields[1] != "*" {
+			t.Errorf("malformed protocolInfo %s", info)
+		}
+		for _, invalid := range []string{"DLNA.ORG_PN=MATROSKA", "DLNA.ORG_PN=AVI"} {
+			if strings.Contains(info, invalid) {
+				t.Errorf("%s announces %s, which DLNA does not define", info, invalid)
+			}
+		}
+	}
+}
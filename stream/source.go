@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrIsDirectory is returned when opening a directory as a Source.
+var ErrIsDirectory = errors.New("is a directory")
+
+// Source is the content Serve answers with. It is read at any offset, so the
+// ranges of a request are served without seeking back and forth.
+type Source interface {
+	io.ReaderAt
+	// Name picks the content type and the DLNA profile, by its extension.
+	Name() string
+	Size() int64
+	// ModTime is the zero time when unknown, the response then has no validators.
+	ModTime() time.Time
+}
+
+// ETag is the strong entity tag of src, built from its size and modification
+// time so it changes whenever the file is replaced or rewritten.
+func ETag(src Source) string {
+	modtime := src.ModTime()
+	if isZeroTime(modtime) {
+		return ""
+	}
+	return `"` + strconv.FormatInt(src.Size(), 36) + "-" + strconv.FormatInt(modtime.UnixNano(), 36) + `"`
+}
+
+// File is a Source reading a file on disk.
+type File struct {
+	file *os.File
+	info os.FileInfo
+}
+
+// Open opens the file at path as a Source, close it once served.
+func Open(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := NewFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// NewFile makes an open file a Source. Closing the Source closes file.
+func NewFile(file *os.File) (*File, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s: %w", file.Name(), ErrIsDirectory)
+	}
+	return &File{file: file, info: info}, nil
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.file.ReadAt(p, off)
+}
+
+func (f *File) Name() string {
+	return filepath.Base(f.file.Name())
+}
+
+func (f *File) Size() int64 {
+	return f.info.Size()
+}
+
+func (f *File) ModTime() time.Time {
+	return f.info.ModTime()
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// seekerSource adapts an io.ReadSeeker, reads are serialized since each one
+// seeks first.
+type seekerSource struct {
+	mu      sync.Mutex
+	content io.ReadSeeker
+	name    string
+	size    int64
+	modtime time.Time
+}
+
+func newSeekerSource(name string, modtime time.Time, content io.ReadSeeker) (*seekerSource, error) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errSeeker
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, errSeeker
+	}
+	return &seekerSource{content: content, name: name, size: size, modtime: modtime}, nil
+}
+
+func (s *seekerSource) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.content.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.content, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *seekerSource) Name() string {
+	return s.name
+}
+
+func (s *seekerSource) Size() int64 {
+	return s.size
+}
+
+func (s *seekerSource) ModTime() time.Time {
+	return s.modtime
+}
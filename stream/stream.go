@@ -4,11 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,8 +19,13 @@ var (
 	errNoOverlap  = errors.New("invalid range: failed to overlap")
 )
 
-// The algorithm uses at most sniffLen bytes to make its decision.
-const sniffLen = 512
+// CacheControl is sent with the content unless the caller set its own. The
+// streams are behind authentication, shared caches must not keep them.
+const CacheControl = "private, max-age=86400"
+
+// maxRanges bounds the parts of a multipart response, more ranges than that
+// get the whole content.
+const maxRanges = 32
 
 // bufferPool manages reusable byte buffers to reduce GC pressure
 var bufferPool = sync.Pool{
@@ -42,55 +45,14 @@ func setLastModified(w http.ResponseWriter, modtime time.Time) {
 	}
 }
 
-type godebug struct {
-	value string
-}
-
-func (g *godebug) New(value string) *godebug {
-	return &godebug{value: value}
-}
-
-func (g *godebug) Value() string {
-	return g.value
-}
-
-func (g *godebug) IncNonDefault() {}
-
-var httpservecontentkeepheaders = (&godebug{}).New("httpservecontentkeepheaders")
-
-func Has(h http.Header, key string) bool {
-	_, ok := h[key]
-	return ok
-}
-
+// serveError answers with a plain text error, without the validators of the
+// content it is not.
 func serveError(w http.ResponseWriter, text string, code int) {
 	h := w.Header()
-
-	nonDefault := false
-	// Fast path for common case - avoid string iteration
-	if httpservecontentkeepheaders.Value() != "1" {
-		h.Del("Cache-Control")
-		h.Del("Content-Encoding")
-		h.Del("Etag")
-		h.Del("Last-Modified")
-	} else {
-		for _, k := range []string{
-			"Cache-Control",
-			"Content-Encoding",
-			"Etag",
-			"Last-Modified",
-		} {
-			if Has(h, k) {
-				nonDefault = true
-				break
-			}
-		}
-	}
-
-	if nonDefault {
-		httpservecontentkeepheaders.IncNonDefault()
-	}
-
+	h.Del("Cache-Control")
+	h.Del("Content-Encoding")
+	h.Del("Etag")
+	h.Del("Last-Modified")
 	http.Error(w, text, code)
 }
 
@@ -453,221 +415,114 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 	return ranges, nil
 }
 
-func serveContent(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, sizeFunc func() (int64, error), content io.ReadSeeker) {
+// Serve answers a GET or HEAD request with src: validators and conditional
+// requests, single and multiple byte ranges, and the DLNA headers. A
+// Content-Type or Cache-Control set by the caller is kept. The error returned
+// is the one of writing the body, the response has then already started.
+func Serve(w http.ResponseWriter, r *http.Request, src Source) error {
+	h := w.Header()
+	size := src.Size()
+	if size < 0 {
+		serveError(w, "negative content size", http.StatusInternalServerError)
+		return nil
+	}
+
+	modtime := src.ModTime()
 	setLastModified(w, modtime)
-	done, rangeReq := checkPreconditions(w, req, modtime)
-	if done {
-		return
-	}
-
-	code := http.StatusOK
-	ctypes, haveType := w.Header()["Content-Type"]
-	var ctype string
-	if !haveType {
-		ctype = mime.TypeByExtension(filepath.Ext(name))
-		if ctype == "" {
-			var buf [sniffLen]byte
-			n, _ := io.ReadFull(content, buf[:])
-			ctype = http.DetectContentType(buf[:n])
-			_, err := content.Seek(0, io.SeekStart)
-			if err != nil {
-				serveError(w, "seeker can't seek", http.StatusInternalServerError)
-				return
-			}
-		}
-		w.Header().Set("Content-Type", ctype)
-	} else if len(ctypes) > 0 {
-		ctype = ctypes[0]
+	if etag := ETag(src); etag != "" {
+		h.Set("Etag", etag)
 	}
+	if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", CacheControl)
+	}
+	h.Set("Accept-Ranges", "bytes")
 
-	size, err := sizeFunc()
-	if err != nil {
-		serveError(w, err.Error(), http.StatusInternalServerError)
-		return
+	done, rangeReq := checkPreconditions(w, r, modtime)
+	if done {
+		return nil
 	}
-	if size < 0 {
-		serveError(w, "negative content size computed", http.StatusInternalServerError)
-		return
+
+	SetDLNAHeaders(w, r, src.Name())
+	ctype := h.Get("Content-Type")
+	if ctype == "" {
+		ctype = ContentType(src.Name())
+		h.Set("Content-Type", ctype)
 	}
 
-	sendSize := size
 	ranges, err := parseRange(rangeReq, size)
-	switch err {
-	case nil:
-		// Valid ranges
-	case errNoOverlap:
-		if size == 0 {
-			ranges = nil
-			break
-		}
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	switch {
+	case err == errNoOverlap && size == 0:
+		// an empty file has no byte to range over, it is sent whole
+		ranges = nil
+	case err == errNoOverlap:
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
 		fallthrough
-	default:
+	case err != nil:
 		serveError(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
-		return
+		return nil
 	}
-
-	if sumRangesSize(ranges) > size {
+	// asking for more than the file is a waste, or an attack: the whole file is cheaper
+	if len(ranges) > maxRanges || sumRangesSize(ranges) > size {
 		ranges = nil
 	}
 
-	// Set common headers
-	w.Header().Set("Accept-Ranges", "bytes")
-
-	if req.Method == "HEAD" {
-		// For HEAD requests, we only need to set headers
-		switch {
-		case len(ranges) == 1:
-			ra := ranges[0]
-			w.Header().Set("Content-Range", ra.contentRange(size))
-			w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
-			w.WriteHeader(http.StatusPartialContent)
-		case len(ranges) > 1:
-			sendSize = rangesMIMESize(ranges, ctype, size)
-			w.Header().Set("Content-Type", "multipart/byteranges; boundary="+multipart.NewWriter(io.Discard).Boundary())
-			w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
-			w.WriteHeader(http.StatusPartialContent)
-		default:
-			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
-			w.WriteHeader(code)
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return nil
 		}
-		return
-	}
+		return copyRange(w, src, 0, size)
 
-	// For GET requests, we need to send content
-	switch {
-	case len(ranges) == 1:
-		// Single range request
+	case 1:
 		ra := ranges[0]
-		if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
-			serveError(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
-			return
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return nil
 		}
-		sendSize = ra.length
-		code = http.StatusPartialContent
-		w.Header().Set("Content-Range", ra.contentRange(size))
-		w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
-		w.WriteHeader(code)
-
-		// Use buffered copy for better performance
-		bufPtr := bufferPool.Get().(*[]byte)
-		buf := *bufPtr
-		defer bufferPool.Put(bufPtr)
-
-		_, err = io.CopyBuffer(w, io.LimitReader(content, sendSize), buf)
-		if err != nil {
-			// Cannot write response after headers are sent
-			return
-		}
-
-	case len(ranges) > 1:
-		// Multiple range request
-		sendSize = rangesMIMESize(ranges, ctype, size)
-		code = http.StatusPartialContent
-
-		pr, pw := io.Pipe()
-		mw := multipart.NewWriter(pw)
-		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
-		w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
-		w.WriteHeader(code)
-
-		var wg sync.WaitGroup
-		errc := make(chan error, 1)
-
-		// Start a goroutine to write the response
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer pw.Close()
-
-			for _, ra := range ranges {
-				part, err := mw.CreatePart(ra.mimeHeader(ctype, size))
-				if err != nil {
-					errc <- err
-					return
-				}
-
-				if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
-					errc <- err
-					return
-				}
-
-				// Get buffer from pool
-				bufPtr := bufferPool.Get().(*[]byte)
-				buf := *bufPtr
-
-				_, err = io.CopyBuffer(part, io.LimitReader(content, ra.length), buf)
-
-				// Return buffer to pool
-				bufferPool.Put(bufPtr)
+		return copyRange(w, src, ra.start, ra.length)
 
-				if err != nil {
-					errc <- err
-					return
-				}
-			}
-
-			if err := mw.Close(); err != nil {
-				errc <- err
+	default:
+		mw := multipart.NewWriter(w)
+		h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		h.Set("Content-Length", strconv.FormatInt(rangesMIMESize(ranges, ctype, size), 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return nil
+		}
+		for _, ra := range ranges {
+			part, err := mw.CreatePart(ra.mimeHeader(ctype, size))
+			if err != nil {
+				return err
 			}
-		}()
-
-		// Copy from pipe to response writer with buffer
-		bufPtr := bufferPool.Get().(*[]byte)
-		buf := *bufPtr
-		_, copyErr := io.CopyBuffer(w, pr, buf)
-		bufferPool.Put(bufPtr)
-
-		// Close the read side of the pipe
-		pr.Close()
-
-		// Wait for writer goroutine to finish
-		wg.Wait()
-
-		// Check for errors
-		select {
-		case err := <-errc:
-			if err != nil && copyErr == nil {
-				// Log the error (can't report to client at this point)
-				return
+			if err := copyRange(part, src, ra.start, ra.length); err != nil {
+				return err
 			}
-		default:
-		}
-
-	default:
-		// No range or invalid range
-		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
-		w.WriteHeader(code)
-
-		// Use buffered copy for better performance
-		bufPtr := bufferPool.Get().(*[]byte)
-		buf := *bufPtr
-		_, err = io.CopyBuffer(w, content, buf)
-		bufferPool.Put(bufPtr)
-
-		if err != nil {
-			// Cannot write response after headers are sent
-			return
 		}
+		return mw.Close()
 	}
 }
 
-func staticServeContent(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
-	sizeFunc := func() (int64, error) {
-		size, err := content.Seek(0, io.SeekEnd)
-		if err != nil {
-			return 0, errSeeker
-		}
-		_, err = content.Seek(0, io.SeekStart)
-		if err != nil {
-			return 0, errSeeker
-		}
-		return size, nil
+func copyRange(w io.Writer, src Source, start, length int64) error {
+	bufPtr := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufPtr)
+
+	n, err := io.CopyBuffer(w, io.NewSectionReader(src, start, length), *bufPtr)
+	if err == nil && n < length {
+		err = fmt.Errorf("%s shrank while served: %w", src.Name(), io.ErrUnexpectedEOF)
 	}
-	serveContent(w, req, name, modtime, sizeFunc, content)
+	return err
 }
 
-// Stream serves content to HTTP clients with optimized performance
-func Stream(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
-	staticServeContent(w, req, name, modtime, content)
+// Stream serves content, read through its Seek method, under name.
+func Stream(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) error {
+	src, err := newSeekerSource(name, modtime, content)
+	if err != nil {
+		serveError(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return Serve(w, req, src)
 }
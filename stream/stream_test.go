@@ -0,0 +1,32 @@
+package stream_test
+
+import (
+	"bytes"
+	"go-cinema/stream"
+	"go-cinema/stream/streamtest"
+	"net/http"
+	"testing"
+)
+
+func TestServeConformance(t *testing.T) {
+	path := streamtest.WriteFile(t, t.TempDir())
+	streamtest.Run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, err := stream.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		if err := stream.Serve(w, r, src); err != nil {
+			t.Error(err)
+		}
+	}), "/movie.mp4")
+}
+
+func TestStreamConformance(t *testing.T) {
+	streamtest.Run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := bytes.NewReader(streamtest.Content)
+		if err := stream.Stream(w, r, streamtest.Name, streamtest.ModTime, content); err != nil {
+			t.Error(err)
+		}
+	}), "/movie.mp4")
+}
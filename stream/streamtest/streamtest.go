@@ -0,0 +1,209 @@
+// Package streamtest is the conformance suite of the entrypoints serving media
+// through the stream engine: whichever one a client reaches, it gets the same
+// validators, conditional requests and byte ranges.
+package streamtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Name is the name of the file the suite expects to be served.
+const Name = "movie.mp4"
+
+// ModTime is the modification time of the file.
+var ModTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// Content is the content of the file, every byte telling its offset apart.
+var Content = func() []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 10000; i++ {
+		fmt.Fprintf(&b, "%08d\n", i)
+	}
+	return b.Bytes()[:10000]
+}()
+
+// WriteFile writes the file in dir and returns its path.
+func WriteFile(t testing.TB, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, Name)
+	if err := os.WriteFile(path, Content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, ModTime, ModTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Run checks that handler serves the file at target, a path with its query.
+func Run(t *testing.T, handler http.Handler, target string) {
+	size := len(Content)
+	do := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, status int, body []byte) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("status %d, want %d: %.200s", w.Code, status, w.Body.String())
+		}
+		if body != nil && !bytes.Equal(w.Body.Bytes(), body) {
+			t.Fatalf("body of %d bytes %.40q, want %d bytes %.40q", w.Body.Len(), w.Body.Bytes(), len(body), body)
+		}
+		if length := w.Header().Get("Content-Length"); body != nil && length != strconv.Itoa(len(body)) {
+			t.Errorf("Content-Length %s, want %d", length, len(body))
+		}
+	}
+
+	full := do(http.MethodGet, nil)
+	etag := full.Header().Get("Etag")
+	lastModified := full.Header().Get("Last-Modified")
+
+	t.Run("full", func(t *testing.T) {
+		expect(t, full, http.StatusOK, Content)
+		if full.Header().Get("Accept-Ranges") != "bytes" {
+			t.Errorf("Accept-Ranges %q", full.Header().Get("Accept-Ranges"))
+		}
+		if etag == "" || strings.HasPrefix(etag, "W/") || !strings.HasPrefix(etag, `"`) {
+			t.Errorf("ETag %q, want a strong one", etag)
+		}
+		if lastModified != ModTime.Format(http.TimeFormat) {
+			t.Errorf("Last-Modified %q, want %q", lastModified, ModTime.Format(http.TimeFormat))
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		w := do(http.MethodHead, nil)
+		expect(t, w, http.StatusOK, nil)
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD answered %d bytes", w.Body.Len())
+		}
+		if w.Header().Get("Content-Length") != strconv.Itoa(size) || w.Header().Get("Etag") != etag {
+			t.Errorf("HEAD headers %v differ from GET", w.Header())
+		}
+	})
+
+	ranges := map[string][3]int{
+		"bytes=100-199":    {100, 200, size},
+		"bytes=-100":       {size - 100, size, size},
+		"bytes=9900-":      {9900, size, size},
+		"bytes=9990-99999": {9990, size, size},
+		"bytes=0-0":        {0, 1, size},
+	}
+	for header, want := range ranges {
+		t.Run(header, func(t *testing.T) {
+			w := do(http.MethodGet, map[string]string{"Range": header})
+			expect(t, w, http.StatusPartialContent, Content[want[0]:want[1]])
+			if got, wantRange := w.Header().Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", want[0], want[1]-1, want[2]); got != wantRange {
+				t.Errorf("Content-Range %q, want %q", got, wantRange)
+			}
+		})
+	}
+
+	t.Run("ranges", func(t *testing.T) {
+		w := do(http.MethodGet, map[string]string{"Range": "bytes=0-9,5000-5009,-10"})
+		expect(t, w, http.StatusPartialContent, nil)
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type %q", w.Header().Get("Content-Type"))
+		}
+		if length := w.Header().Get("Content-Length"); length != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("Content-Length %s of a %d bytes body", length, w.Body.Len())
+		}
+		reader := multipart.NewReader(w.Body, params["boundary"])
+		for _, want := range [][2]int{{0, 10}, {5000, 5010}, {size - 10, size}} {
+			part, err := reader.NextPart()
+			if err != nil {
+				t.Fatalf("part %v: %v", want, err)
+			}
+			body, _ := io.ReadAll(part)
+			if !bytes.Equal(body, Content[want[0]:want[1]]) {
+				t.Errorf("part %v: %q", want, body)
+			}
+			if got, wantRange := part.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", want[0], want[1]-1, size); got != wantRange {
+				t.Errorf("part Content-Range %q, want %q", got, wantRange)
+			}
+		}
+		if _, err := reader.NextPart(); err != io.EOF {
+			t.Errorf("more parts than ranges: %v", err)
+		}
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		w := do(http.MethodGet, map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)})
+		expect(t, w, http.StatusRequestedRangeNotSatisfiable, nil)
+		if got := w.Header().Get("Content-Range"); got != fmt.Sprintf("bytes */%d", size) {
+			t.Errorf("Content-Range %q", got)
+		}
+		if w.Header().Get("Etag") != "" {
+			t.Error("an error answered with the validators of the content")
+		}
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=200-100"}), http.StatusRequestedRangeNotSatisfiable, nil)
+	})
+
+	t.Run("too many ranges", func(t *testing.T) {
+		var many []string
+		for i := 0; i < 100; i++ {
+			many = append(many, fmt.Sprintf("%d-%d", i, i))
+		}
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=" + strings.Join(many, ",")}), http.StatusOK, Content)
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		w := do(http.MethodGet, map[string]string{"If-None-Match": etag})
+		expect(t, w, http.StatusNotModified, nil)
+		if w.Body.Len() != 0 || w.Header().Get("Etag") != etag {
+			t.Errorf("304 with a body, or without the ETag")
+		}
+		expect(t, do(http.MethodGet, map[string]string{"If-None-Match": `"other"`}), http.StatusOK, Content)
+	})
+
+	t.Run("if-modified-since", func(t *testing.T) {
+		expect(t, do(http.MethodGet, map[string]string{"If-Modified-Since": lastModified}), http.StatusNotModified, nil)
+		before := ModTime.Add(-time.Hour).Format(http.TimeFormat)
+		expect(t, do(http.MethodGet, map[string]string{"If-Modified-Since": before}), http.StatusOK, Content)
+	})
+
+	t.Run("if-match", func(t *testing.T) {
+		expect(t, do(http.MethodGet, map[string]string{"If-Match": etag}), http.StatusOK, Content)
+		expect(t, do(http.MethodGet, map[string]string{"If-Match": `"other"`}), http.StatusPreconditionFailed, nil)
+	})
+
+	t.Run("if-range", func(t *testing.T) {
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": etag}), http.StatusPartialContent, Content[:10])
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": lastModified}), http.StatusPartialContent, Content[:10])
+		// the client holds another version, the range would mix both
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`}), http.StatusOK, Content)
+		expect(t, do(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": "W/" + etag}), http.StatusOK, Content)
+	})
+
+	t.Run("dlna", func(t *testing.T) {
+		w := do(http.MethodGet, map[string]string{"Range": "bytes=0-9", "getcontentFeatures.dlna.org": "1", "transferMode.dlna.org": "Streaming"})
+		expect(t, w, http.StatusPartialContent, Content[:10])
+		// set verbatim, Get would look for the canonical keys
+		features, mode := w.Header()["contentFeatures.dlna.org"], w.Header()["transferMode.dlna.org"]
+		if len(features) != 1 || len(mode) != 1 || mode[0] != "Streaming" {
+			t.Errorf("DLNA headers %v", w.Header())
+		}
+	})
+}
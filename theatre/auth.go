@@ -0,0 +1,243 @@
+package theatre
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"net/http"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+// AuthMiddleware attaches the user of the bearer token to the request context.
+// Reading is open to anonymous clients, anything else needs a user unless the
+// route is public, and routes declaring a permission need a user whose role grants it.
+// Requests matching no route are left to the router to answer 404 or 405.
+func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route, ok := CurrentRoute(r.Context())
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		user, err := auth.Authenticate(r)
+		if err != nil && !route.Public {
+			writeError(w, r, apperror.New(apperror.KindUnauthorized, "Invalid or expired token", err))
+			return
+		}
+		if user != nil {
+			r = r.WithContext(auth.WithUser(r.Context(), user))
+		} else if (!route.Public && !isSafeMethod(r.Method)) || route.Permission != "" {
+			writeError(w, r, apperror.Unauthorized("Authentication required"))
+			return
+		}
+
+		if route.Permission != "" && !auth.Can(user, route.Permission) {
+			golog.Warn("User {} with role {} denied {} {}", user.Username, user.Role, r.Method, r.URL.Path)
+			writeError(w, r, apperror.Forbidden(fmt.Sprintf("Forbidden: %s permission required", route.Permission)))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func Register(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /register handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	var request model.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
+		return
+	}
+
+	user, err := auth.Register(request)
+	switch {
+	case errors.Is(err, auth.ErrMissingFields), errors.Is(err, auth.ErrWeakPassword):
+		writeError(w, r, apperror.Validation(err.Error(), nil))
+		return
+	case errors.Is(err, auth.ErrUserExists):
+		writeError(w, r, apperror.Conflict(err.Error(), nil))
+		return
+	case err != nil:
+		writeError(w, r, apperror.Storage("Error creating user", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(user)
+}
+
+func Login(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /login handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	var request model.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
+		return
+	}
+
+	tokens, err := auth.Login(request.Username, request.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		writeError(w, r, apperror.Unauthorized(err.Error()))
+		return
+	}
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error logging in", err))
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// RefreshToken trades a refresh token for a new access token and a new refresh token.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /refresh-token handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	var request model.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
+		return
+	}
+
+	tokens, err := auth.Refresh(request.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		writeError(w, r, apperror.Unauthorized(err.Error()))
+		return
+	}
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error refreshing token", err))
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /logout handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	var request model.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
+		return
+	}
+
+	err := auth.Logout(request.RefreshToken)
+	if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+		writeError(w, r, apperror.Storage("Error logging out", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /me handler, method: {}", r.Method)
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	user, ok := auth.CurrentUser(r.Context())
+	if !ok {
+		writeError(w, r, apperror.Unauthorized("Authentication required"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(user)
+}
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /users handler, method: {}", r.Method)
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	users, err := repo.UserRepository.FindAll()
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving users", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(users.ToSlice())
+}
+
+// SetUserRole changes the role of a user, from the {"role": "..."} body.
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /users/:id/role handler, method: {}", r.Method)
+	if r.Method != http.MethodPut {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid user ID", err))
+		return
+	}
+
+	var request model.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
+		return
+	}
+
+	user, err := auth.SetRole(id, request.Role)
+	switch {
+	case errors.Is(err, auth.ErrInvalidRole):
+		writeError(w, r, apperror.Validation(err.Error(), nil))
+		return
+	case errors.Is(err, auth.ErrLastAdmin):
+		writeError(w, r, apperror.Conflict(err.Error(), nil))
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, r, apperror.NotFound("User not found", nil))
+		return
+	case err != nil:
+		writeError(w, r, apperror.Storage("Error updating user", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(user)
+}
+
+func writeTokens(w http.ResponseWriter, tokens *model.LoginResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,280 @@
+package theatre
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	"go-cinema/dlna"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+var caster *dlna.ControlPoint
+
+// InitCasting creates the control point used by the cast endpoints. The port is
+// the one this server listens on, renderers fetch the media from it.
+func InitCasting(httpPort int) *dlna.ControlPoint {
+	caster = dlna.NewControlPoint(httpPort)
+	return caster
+}
+
+func ListRenderers(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /renderers handler, method: {}", r.Method)
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	if r.URL.Query().Get("refresh") == "true" || len(caster.Renderers()) == 0 {
+		if err := caster.Discover(r.Context(), 3*time.Second); err != nil {
+			writeError(w, r, apperror.New(apperror.KindUpstream, "Renderer discovery failed", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(caster.Renderers())
+}
+
+func ListCasts(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /casts handler, method: {}", r.Method)
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(caster.Sessions())
+}
+
+func CastMovie(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /movies/:id/cast handler, method: {}", r.Method)
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
+		return
+	}
+
+	renderer := r.URL.Query().Get("renderer")
+	if renderer == "" {
+		writeError(w, r, apperror.Validation("Renderer is required", nil))
+		return
+	}
+
+	movie, err := repo.MovieRepository.FindByID(id)
+	if err != nil {
+		writeError(w, r, apperror.Lookup("Movie not found", err))
+		return
+	}
+
+	user, _ := auth.CurrentUser(r.Context())
+	progress, err := findProgress(user.ID, movie.ID, 0)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving watch progress", err))
+		return
+	}
+
+	session, err := caster.Cast(r.Context(), dlna.CastRequest{
+		Renderer: renderer,
+		Title:    movie.Title,
+		Kind:     auth.StreamMovie,
+		ID:       movie.ID,
+		Path:     movie.Path,
+		StartAt:  progress.Progress.ResumeAt(),
+		OnProgress: func(position, duration time.Duration) {
+			saveCastProgress(user.ID, movie.ID, 0, position, durationOr(duration, movie.DurationMs))
+		},
+	})
+	if err != nil {
+		writeCastError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// CastSerie casts an episode of a series, by default the one the series is at.
+func CastSerie(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /series/:id/cast handler, method: {}", r.Method)
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
+		return
+	}
+
+	renderer := r.URL.Query().Get("renderer")
+	if renderer == "" {
+		writeError(w, r, apperror.Validation("Renderer is required", nil))
+		return
+	}
+
+	serie, err := repo.SeriesRepository.FindByID(id)
+	if err != nil {
+		writeError(w, r, apperror.Lookup("Serie not found", err))
+		return
+	}
+
+	index := serie.CurrentIndex
+	if indexStr := r.URL.Query().Get("episode"); indexStr != "" {
+		parsed, err := strconv.ParseUint(indexStr, 10, 64)
+		if err != nil {
+			writeError(w, r, apperror.Validation("Invalid episode index", err))
+			return
+		}
+		index = uint(parsed)
+	}
+	if index == 0 {
+		index = 1
+	}
+
+	query := func(db *gorm.DB) *gorm.DB {
+		return db.Where("series_id = ? AND episode_index = ?", id, index)
+	}
+
+	episodes, err := repo.EpisodeRepository.FindByQuery(query)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving episodes", err))
+		return
+	}
+	if episodes.Size() == 0 {
+		writeError(w, r, apperror.NotFound(fmt.Sprintf("Episode %d not found", index), nil))
+		return
+	}
+	episode := episodes.ToSlice()[0]
+
+	user, _ := auth.CurrentUser(r.Context())
+	progress, err := findProgress(user.ID, 0, episode.ID)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving watch progress", err))
+		return
+	}
+
+	session, err := caster.Cast(r.Context(), dlna.CastRequest{
+		Renderer: renderer,
+		Title:    fmt.Sprintf("%s - %d", serie.Title, episode.EpisodeIndex),
+		Kind:     auth.StreamEpisode,
+		ID:       episode.ID,
+		Path:     episode.Path,
+		StartAt:  progress.Progress.ResumeAt(),
+		OnProgress: func(position, duration time.Duration) {
+			saveCastProgress(user.ID, 0, episode.ID, position, durationOr(duration, episode.DurationMs))
+		},
+	})
+	if err != nil {
+		writeCastError(w, r, err)
+		return
+	}
+
+	if serie.CurrentIndex != index {
+		serie.CurrentIndex = index
+		if err := repo.SeriesRepository.Save(serie); err != nil {
+			golog.Error("Error updating serie record: {}", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// ControlRenderer handles play, pause, seek and stop for whatever is being cast to a renderer.
+func ControlRenderer(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /renderers/:udn/:action handler, method: {}", r.Method)
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	udn := GetParam(r.Context(), "udn")
+
+	var err error
+	switch action := GetParam(r.Context(), "action"); action {
+	case "play":
+		err = caster.Play(r.Context(), udn)
+	case "pause":
+		err = caster.Pause(r.Context(), udn)
+	case "stop":
+		err = caster.Stop(r.Context(), udn)
+	case "seek":
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			writeError(w, r, apperror.Validation("Seek target is required", nil))
+			return
+		}
+		var position time.Duration
+		position, err = entity.ParsePosition(target)
+		if err != nil {
+			writeError(w, r, apperror.Validation("Invalid seek target", err))
+			return
+		}
+		err = caster.Seek(r.Context(), udn, position)
+	default:
+		writeError(w, r, apperror.NotFound(fmt.Sprintf("Unknown action: %s", action), nil))
+		return
+	}
+
+	if err != nil {
+		writeCastError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeCastError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, dlna.ErrRendererNotFound), errors.Is(err, dlna.ErrNoSession):
+		writeError(w, r, apperror.NotFound(err.Error(), nil))
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, r, apperror.New(apperror.KindTimeout, "Renderer did not answer", err))
+	default:
+		writeError(w, r, apperror.New(apperror.KindUpstream, "Cast failed", err))
+	}
+}
+
+// saveCastProgress records a position reported by a renderer for the user who cast.
+// Renderers report zero once they stop at the end, which must not undo a finished item.
+func saveCastProgress(userID, movieID, episodeID uint, position, duration time.Duration) {
+	if position == 0 {
+		return
+	}
+	if _, err := saveProgress(userID, movieID, episodeID, position, duration); err != nil {
+		golog.Error("Error updating watch progress: {}", err)
+	}
+}
+
+// durationOr falls back to the probed duration when the renderer does not know it.
+func durationOr(duration time.Duration, probedMs int64) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	return time.Duration(probedMs) * time.Millisecond
+}
@@ -0,0 +1,99 @@
+package theatre
+
+import (
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	repo "go-cinema/repository"
+	"go-cinema/upload"
+	"io"
+	"net/http"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// addMu makes the duplicate check of a file and the insert of its record one
+// step, two uploads of the same file cannot both get in.
+var addMu sync.Mutex
+
+// saveFile adds the file of size bytes a client sent to the directory dir of the
+// library, under name. The file only takes its place once complete and unlike
+// the others of the library. It returns the path and the hex SHA-256 of the
+// file, and release, to call once its record is saved or the file removed.
+func saveFile(dir, name string, r io.Reader, size int64) (string, string, func(), error) {
+	path, err := mediaRoots().Join(dir, name)
+	if err != nil {
+		return "", "", nil, fileStorage("Invalid file name", err)
+	}
+	accept, release := uniqueFile()
+	sum, err := upload.Commit(path, r, upload.Expect{Size: size, Accept: accept})
+	if err != nil {
+		release()
+		return "", "", nil, commitError(name, err)
+	}
+	return path, sum, release, nil
+}
+
+// uniqueFile returns the check of a file joining the library, which holds addMu
+// from the moment the file is complete, and the release of addMu.
+func uniqueFile() (func(sum string) error, func()) {
+	locked := false
+	accept := func(sum string) error {
+		addMu.Lock()
+		locked = true
+		return uniqueChecksum(sum)
+	}
+	release := func() {
+		if locked {
+			locked = false
+			addMu.Unlock()
+		}
+	}
+	return accept, release
+}
+
+// uniqueChecksum refuses a file whose content is in the library already, under
+// another name or not. Records whose file is missing do not count, the upload
+// brings the file back.
+func uniqueChecksum(sum string) error {
+	byChecksum := func(db *gorm.DB) *gorm.DB {
+		return db.Where("checksum = ? AND missing = ?", sum, false)
+	}
+
+	movies, err := repo.MovieRepository.FindByQuery(byChecksum)
+	if err != nil {
+		return apperror.Storage("Error looking for the file in the library", err)
+	}
+	if movies.Size() > 0 {
+		movie := movies.ToSlice()[0]
+		return apperror.Conflict(fmt.Sprintf("The file is already in the library as movie %d, %s", movie.ID, movie.Title), nil)
+	}
+
+	episodes, err := repo.EpisodeRepository.FindByQuery(byChecksum)
+	if err != nil {
+		return apperror.Storage("Error looking for the file in the library", err)
+	}
+	if episodes.Size() > 0 {
+		episode := episodes.ToSlice()[0]
+		return apperror.Conflict(fmt.Sprintf("The file is already in the library as episode %d of serie %d", episode.EpisodeIndex, episode.SeriesID), nil)
+	}
+	return nil
+}
+
+// commitError maps the errors of adding the file name to the library.
+func commitError(name string, err error) error {
+	var appErr *apperror.Error
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &appErr), errors.As(err, &tooLarge):
+		return err
+	case errors.Is(err, upload.ErrExists):
+		return apperror.Conflict(fmt.Sprintf("A file named %s already exists", name), err)
+	case errors.Is(err, upload.ErrSize):
+		return apperror.Validation("The file is not as long as announced", err)
+	case errors.Is(err, upload.ErrChecksum):
+		return apperror.New(apperror.KindChecksumMismatch, "The file does not match its checksum", err)
+	}
+	return fileStorage("Error saving file", err)
+}
@@ -0,0 +1,88 @@
+package theatre
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestConcurrentDuplicateUploads(t *testing.T) {
+	setup(t)
+	data := bytes.Repeat([]byte("the same video, uploaded at once "), 1<<15)
+
+	const uploads = 16
+	statuses := make(chan int, uploads)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, contentType := multipartFile(t, fmt.Sprintf("copy%d.mkv", i), data, map[string]string{"Title": "Copy"})
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/movies/", body)
+			r.Header.Set("Content-Type", contentType)
+			statuses <- serve(CreateMovie, r).Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status %d", status)
+		}
+	}
+	count, err := repo.Count[entity.Movie](func(db *gorm.DB) *gorm.DB { return db })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || count != 1 {
+		t.Errorf("%d uploads created, %d movies stored, want 1", created, count)
+	}
+}
+
+func TestUniqueFileWaitsForTheRecord(t *testing.T) {
+	setup(t)
+	sum := strings.Repeat("ab", 32)
+
+	accept, release := uniqueFile()
+	if err := accept(sum); err != nil {
+		t.Fatal(err)
+	}
+
+	second := make(chan error, 1)
+	go func() {
+		accept, release := uniqueFile()
+		defer release()
+		second <- accept(sum)
+	}()
+	select {
+	case err := <-second:
+		t.Fatalf("second check done before the first record was saved: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	movie := entity.Movie{Title: "First", Path: "first.mkv", Checksum: sum}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if err := <-second; !errors.Is(err, apperror.ErrConflict) {
+		t.Errorf("second check: %v, want a conflict", err)
+	}
+}
@@ -1,25 +1,128 @@
 package theatre
 
 import (
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	"go-cinema/config"
 	"go-cinema/cronos"
+	"go-cinema/dlna"
+	"go-cinema/library"
+	"go-cinema/sandbox"
+	"go-cinema/upload"
+	"net"
 	"net/http"
+	"net/netip"
+	"sync"
+)
 
-	dbs "github.com/misenkashari/goutils/db"
-	"gorm.io/gorm"
+var (
+	settingsMu sync.RWMutex
+	// media is where uploads are stored
+	media        config.Media
+	libraryRoots []library.Root
+	// allowed are the only directories handlers read and write files in
+	allowed *sandbox.Set
+	uploads *upload.Store
+	// dlnaClients are the networks the media server answers
+	dlnaClients []netip.Prefix
 )
 
-func InitDB() (db *gorm.DB, err error) {
-	return dbs.Postgres().WithUser("misen").WithHost("192.168.3.200").WithDatabase("theatre").WithPassword("root").Open()
+// Configure applies the configuration to the handlers, before SetupRoutes and
+// again on reload. The JWT secret only takes on the first call.
+func Configure(cfg *config.Config) error {
+	roots, err := sandbox.NewSet(cfg.Media.Movies, cfg.Media.Series)
+	if err != nil {
+		return err
+	}
+	clients, err := cfg.DLNA.Prefixes()
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	media = cfg.Media
+	libraryRoots = library.Roots(cfg.Media)
+	allowed = roots
+	dlnaClients = clients
+	// the store holds the locks of the uploads being written, it is kept unless it moved
+	if uploads == nil || uploads.Dir() != cfg.Media.Uploads {
+		uploads = upload.NewStore(cfg.Media.Uploads, uploadExpiry)
+	}
+	settingsMu.Unlock()
+	dlna.SetMediaRoots(roots)
+	auth.SetSecret(cfg.Auth.JWTSecret)
+	return nil
+}
+
+func currentMedia() config.Media {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return media
+}
+
+func currentRoots() []library.Root {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return libraryRoots
+}
+
+func mediaRoots() *sandbox.Set {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return allowed
+}
+
+func uploadStore() *upload.Store {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return uploads
+}
+
+// DLNAClientMiddleware turns away the clients outside the networks of
+// dlna.clients. The address is the one of the connection, the media server is
+// not meant to sit behind a proxy.
+func DLNAClientMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !dlnaClientAllowed(req.RemoteAddr) {
+			writeError(w, req, apperror.Forbidden("Forbidden: the media server only answers the local network"))
+			return
+		}
+		next(w, req)
+	}
+}
+
+func dlnaClientAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	for _, prefix := range dlnaClients {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 func CORSMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Expose-Headers", "Deprecation, Link, Location, X-Request-ID, X-Total-Count, "+
+			"Content-Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
 
-		if req.Method == "OPTIONS" {
+		// preflights end here, other OPTIONS requests reach their route, like the tus discovery
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -28,35 +131,140 @@ func CORSMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// DeprecationMiddleware marks the routes predating /api/v1, clients should move to their successors
+func DeprecationMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", `</api/v1>; rel="successor-version"`)
+		next(w, req)
+	}
+}
+
 func SetupRoutes() http.Handler {
 	router := NewRouter()
+	router.NotFound = notFound
+	router.MethodNotAllowed = methodNotAllowed
+	router.Use(RequestIDMiddleware, CORSMiddleware, AuthMiddleware)
+
+	apiRoutes(router.Group("/api/v1"))
+	legacyRoutes(router.Group("/", DeprecationMiddleware))
 
-	router.POST("/movies/create", CreateMovieLegacy)
-	router.POST("/movie_special", CreateMovieSpecial)
-	router.PUT("/movies/:id/update", EditMovie)
-	router.GET("/movies", GetMovies)
-	router.GET("/movies/:id", GetMovie)
-	router.DELETE("/movies/:id/delete", DeleteMovie)
-
-	router.GET("/video", VideoServerHandler)
-	router.POST("/last-access/:id", HandleLastAccessForMovie)
-	router.GET("/left-at", GetUsageData)
-
-	router.GET("/series", ListSeries)
-	router.POST("/series/create", CreateSerie)
-	router.GET("/series/:id", GetSerie)
-	router.PUT("/series/:id/update", EditSerie)
-	router.DELETE("/series/:id/delete", DeleteSerie)
-	router.POST("/series/:id/append", AppendEpisodeToSeries)
-	router.POST("/series/:id/special", AppendEpisodeToSeriesSpecial)
-	router.GET("/series/:id/episodes", GetSerieEpisodes)
-
-	router.POST("/episodes/:id/last-access", HandleLastAccessForEpisode)
-	router.POST("/series/:id/current/set", HandleSetSeriesIndex)
-	router.GET("/series/:id/current/get", HandleGetLastEpisodeIndex)
-
-	router.POST("/start-cronos", cronos.StartCronos)
-	router.POST("/stop-cronos", cronos.StopCronos)
+	upnp := router.Group("/dlna", DLNAClientMiddleware)
+	upnp.GET("/device.xml", dlna.DeviceDescription)
+	upnp.GET("/ContentDirectory.xml", dlna.ContentDirectorySCPD)
+	upnp.POST("/control/ContentDirectory", dlna.ContentDirectoryControl, Public())
+	upnp.Handle("/event/ContentDirectory", "SUBSCRIBE", dlna.EventSubscription, Public())
+	upnp.Handle("/event/ContentDirectory", "UNSUBSCRIBE", dlna.EventSubscription, Public())
+	upnp.GET("/ConnectionManager.xml", dlna.ConnectionManagerSCPD)
+	upnp.POST("/control/ConnectionManager", dlna.ConnectionManagerControl, Public())
+	upnp.Handle("/event/ConnectionManager", "SUBSCRIBE", dlna.EventSubscription, Public())
+	upnp.Handle("/event/ConnectionManager", "UNSUBSCRIBE", dlna.EventSubscription, Public())
 
 	return router
 }
+
+// apiRoutes registers the resource routes: collections are created with POST,
+// items are read, patched and deleted at their own path.
+func apiRoutes(api *RouteGroup) {
+	session := api.Group("/auth")
+	session.POST("/register", Register, Public())
+	session.POST("/login", Login, Public())
+	session.POST("/refresh", RefreshToken, Public())
+	session.POST("/logout", Logout, Public())
+
+	me := api.Group("/me")
+	me.GET("/", GetCurrentUser, Require(auth.PermWatch))
+	me.GET("/continue-watching", ContinueWatching, Require(auth.PermWatch))
+
+	users := api.Group("/users")
+	users.GET("/", ListUsers, Require(auth.PermAdmin))
+	users.PUT("/:id/role", SetUserRole, Require(auth.PermAdmin))
+
+	movies := api.Group("/movies")
+	movies.GET("/", GetMovies)
+	movies.POST("/", PostMovie, Require(auth.PermEdit))
+	movies.GET("/:id", GetMovie)
+	movies.PATCH("/:id", EditMovie, Require(auth.PermEdit))
+	movies.DELETE("/:id", DeleteMovie, Require(auth.PermDelete))
+	movies.PUT("/:id/progress", HandleLastAccessForMovie, Require(auth.PermWatch))
+	movies.POST("/:id/cast", CastMovie, Require(auth.PermCast))
+
+	series := api.Group("/series")
+	series.GET("/", ListSeries)
+	series.POST("/", CreateSerie, Require(auth.PermEdit))
+	series.GET("/:id", GetSerie)
+	series.PATCH("/:id", EditSerie, Require(auth.PermEdit))
+	series.DELETE("/:id", DeleteSerie, Require(auth.PermDelete))
+	series.GET("/:id/episodes", GetSerieEpisodes)
+	series.POST("/:id/episodes", PostEpisode, Require(auth.PermEdit))
+	series.GET("/:id/current", HandleGetLastEpisodeIndex)
+	series.PUT("/:id/current", HandleSetSeriesIndex, Require(auth.PermWatch))
+	series.POST("/:id/cast", CastSerie, Require(auth.PermCast))
+
+	api.PUT("/episodes/:id/progress", HandleLastAccessForEpisode, Require(auth.PermWatch))
+	api.GET("/search", Search)
+
+	// resumable uploads, in the tus protocol: HEAD is apart so it is not answered as a GET
+	uploads := api.Group("/uploads")
+	uploads.OPTIONS("/", UploadOptions)
+	uploads.POST("/", CreateUpload, Require(auth.PermEdit))
+	uploads.OPTIONS("/:id", UploadOptions)
+	uploads.HEAD("/:id", UploadOffset, Require(auth.PermEdit))
+	uploads.PATCH("/:id", PatchUpload, Require(auth.PermEdit))
+	uploads.DELETE("/:id", DeleteUpload, Require(auth.PermEdit))
+
+	// HEAD is registered apart so the stream engine sees it and skips the body
+	streams := api.Group("/stream")
+	streams.GET("/movie/:id", StreamMovie)
+	streams.HEAD("/movie/:id", StreamMovie)
+	streams.POST("/movie/:id/token", CreateMovieStreamToken, Require(auth.PermWatch))
+	streams.GET("/episode/:id", StreamEpisode)
+	streams.HEAD("/episode/:id", StreamEpisode)
+	streams.POST("/episode/:id/token", CreateEpisodeStreamToken, Require(auth.PermWatch))
+
+	// jobs and reports name files by their path on the server
+	library := api.Group("/library")
+	library.GET("/scans", ListScanJobs, Require(auth.PermEdit))
+	library.POST("/scans", ScanLibrary, Require(auth.PermEdit))
+	library.GET("/scans/:id", GetScanJob, Require(auth.PermEdit))
+	library.GET("/missing", ListMissingMedia, Require(auth.PermEdit))
+
+	// listing renderers may start a discovery, and casts point at stream tokens
+	api.GET("/renderers", ListRenderers, Require(auth.PermCast))
+	api.POST("/renderers/:udn/:action", ControlRenderer, Require(auth.PermCast))
+	api.GET("/casts", ListCasts, Require(auth.PermCast))
+
+	api.POST("/cronos/start", cronos.StartCronos, Require(auth.PermAdmin))
+	api.POST("/cronos/stop", cronos.StopCronos, Require(auth.PermAdmin))
+}
+
+// legacyRoutes registers the routes the frontend used before /api/v1. Routes
+// added since are only served under /api/v1.
+func legacyRoutes(legacy *RouteGroup) {
+	movies := legacy.Group("/movies")
+	movies.POST("/create", CreateMovie, Require(auth.PermEdit))
+	movies.PUT("/:id/update", EditMovie, Require(auth.PermEdit))
+	movies.GET("/", GetMovies)
+	movies.GET("/:id", GetMovie)
+	movies.DELETE("/:id/delete", DeleteMovie, Require(auth.PermDelete))
+	legacy.POST("/movie_special", CreateMovieSpecial, Require(auth.PermEdit))
+
+	legacy.POST("/last-access/:id", HandleLastAccessForMovie, Require(auth.PermWatch))
+
+	series := legacy.Group("/series")
+	series.GET("/", ListSeries)
+	series.POST("/create", CreateSerie, Require(auth.PermEdit))
+	series.GET("/:id", GetSerie)
+	series.PUT("/:id/update", EditSerie, Require(auth.PermEdit))
+	series.DELETE("/:id/delete", DeleteSerie, Require(auth.PermDelete))
+	series.POST("/:id/append", AppendEpisodeToSeries, Require(auth.PermEdit))
+	series.POST("/:id/special", AppendEpisodeToSeriesSpecial, Require(auth.PermEdit))
+	series.GET("/:id/episodes", GetSerieEpisodes)
+	series.POST("/:id/current/set", HandleSetSeriesIndex, Require(auth.PermWatch))
+	series.GET("/:id/current/get", HandleGetLastEpisodeIndex)
+
+	legacy.POST("/episodes/:id/last-access", HandleLastAccessForEpisode, Require(auth.PermWatch))
+
+	legacy.POST("/start-cronos", cronos.StartCronos, Require(auth.PermAdmin))
+	legacy.POST("/stop-cronos", cronos.StopCronos, Require(auth.PermAdmin))
+}
@@ -2,6 +2,9 @@ package theatre
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"strconv"
 )
 
 // contextKey is a custom type to prevent collisions in context values
@@ -13,7 +16,46 @@ func GetParam(ctx context.Context, key string) string {
 	return value
 }
 
+// uintParam reads a numeric path parameter, as the ID of /movies/:id
+func uintParam(r *http.Request, key string) (uint, error) {
+	value := GetParam(r.Context(), key)
+	if value == "" {
+		return 0, errors.New(key + " is required")
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
 // contextWithParam adds a parameter to the request context
 func contextWithParam(ctx context.Context, key, value string) context.Context {
 	return context.WithValue(ctx, contextKey(key), value)
 }
+
+// routeKey holds the matched route, apart from the parameters so no parameter name can shadow it
+type routeKey struct{}
+
+func contextWithRoute(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// CurrentRoute returns the route the request was matched to
+func CurrentRoute(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(routeKey{}).(Route)
+	return route, ok
+}
+
+// requestIDKey holds the ID of the request, echoed in X-Request-ID and in error responses
+type requestIDKey struct{}
+
+func contextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID RequestIDMiddleware gave the request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,87 @@
+package theatre
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-cinema/apperror"
+	"go-cinema/sandbox"
+	"go-cinema/stream"
+	"net/http"
+	"regexp"
+
+	"github.com/kashari/golog"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// clients may pass their own request ID, as long as it is safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware gives every request an ID, the one of the X-Request-ID header
+// when the client sent one, and answers with it.
+func RequestIDMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(contextWithRequestID(r.Context(), id)))
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeError answers with the problem+json of err. The cause is logged, clients
+// only see the message of the error and the request ID to report.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := apperror.From(err)
+	requestID := RequestID(r.Context())
+	status := appErr.Kind.Status()
+
+	if status >= http.StatusInternalServerError {
+		golog.Error("Request {} {} {} failed: {}", requestID, r.Method, r.URL.Path, appErr)
+	} else {
+		golog.Warn("Request {} {} {} refused: {}", requestID, r.Method, r.URL.Path, appErr)
+	}
+
+	if appErr.Kind == apperror.KindUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-cinema"`)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(appErr.Problem(requestID))
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apperror.NotFound("No route for "+r.URL.Path, nil))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apperror.MethodNotAllowed())
+}
+
+// fileLookup maps the errors of reading a file a client named. Paths outside the
+// media roots, and directories, are no video as far as the client knows.
+func fileLookup(message string, err error) *apperror.Error {
+	if errors.Is(err, sandbox.ErrInvalidName) || errors.Is(err, sandbox.ErrOutside) || errors.Is(err, stream.ErrIsDirectory) {
+		return apperror.NotFound(message, err)
+	}
+	return apperror.Lookup(message, err)
+}
+
+// fileStorage maps the errors of writing a file, under a name the client chose.
+func fileStorage(message string, err error) *apperror.Error {
+	if errors.Is(err, sandbox.ErrInvalidName) || errors.Is(err, sandbox.ErrOutside) {
+		return apperror.Validation("Invalid file name", err)
+	}
+	return apperror.Storage(message, err)
+}
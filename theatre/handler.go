@@ -3,159 +3,133 @@ package theatre
 import (
 	"encoding/json"
 	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/auth"
 	entity "go-cinema/entities"
 	repo "go-cinema/repository"
-	videostream "go-cinema/video"
-	"io"
+	"go-cinema/stream"
+	"mime"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/kashari/golog"
 	"gorm.io/gorm"
 )
 
-var (
-	usageData = "/home/mkashari/go-cinema/usage-data.io"
-)
-
-func stringToUint(str string) (uint, error) {
-	id, err := strconv.ParseUint(str, 10, 64)
-	if err != nil {
-		return 0, err
-	}
-	return uint(id), nil
-}
-
-// CreateMovie handles movie file upload and saves it to a specified path
-func CreateMovieLegacy(w http.ResponseWriter, r *http.Request) {
+// CreateMovie handles movie file upload and saves it to the movies directory
+func CreateMovie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /movies/create handler, method: {}", r.Method)
-	// Limit the size of the request body to 5GB (5 << 30 bytes)
-	r.Body = http.MaxBytesReader(w, r.Body, 5<<30)
 
-	// Parse the form to access file data and other fields
-	err := r.ParseMultipartForm(10 << 20) // Limit 10MB for form data
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing form: %s", err.Error()), http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	// Retrieve the file from the form
+	r.Body = http.MaxBytesReader(w, r.Body, 5<<30) // 5GB
 	file, header, err := r.FormFile("File")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving file: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Error retrieving file", err))
 		return
 	}
+
 	defer file.Close()
 
-	// Set the path to save the file
-	savePath := "/home/mkashari/UMS"
-	destinationFile, err := os.Create(savePath + "/" + header.Filename)
+	path, sum, release, err := saveFile(currentMedia().Movies, header.Filename, file, header.Size)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating file: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-	defer destinationFile.Close()
-
-	// Copy the uploaded file content to the destination file
-	if _, err := io.Copy(destinationFile, file); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving file: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	movie := entity.Movie{
 		Title:       r.FormValue("Title"),
-		Path:        savePath + "/" + header.Filename,
+		Path:        path,
 		Description: r.FormValue("Description"),
-		ResumeAt:    "00:00",
+		Checksum:    sum,
+	}
+
+	if err := movie.Probe(movie.Path); err != nil {
+		golog.Warn("Cannot read media info of {}: {}", movie.Path, err)
 	}
 
 	err = repo.MovieRepository.Save(&movie)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating movie record: %s", err.Error()), http.StatusInternalServerError)
+		_ = os.Remove(path)
+	}
+	release()
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error creating movie record", err))
 		return
 	}
 
-	// Respond with the created movie in JSON format
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(movie)
 }
 
-func CreateMovie(w http.ResponseWriter, r *http.Request) {
-	golog.Info("Request /movies/create handler, method: {}", r.Method)
-
-	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// PostMovie creates a movie from an uploaded file, or from a JSON body naming a
+// file already in the library.
+func PostMovie(w http.ResponseWriter, r *http.Request) {
+	if isJSON(r) {
+		CreateMovieSpecial(w, r)
 		return
 	}
+	CreateMovie(w, r)
+}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 5<<30) // 5GB
-	file, header, err := r.FormFile("File")
-	if err != nil {
-		golog.Error("Error retrieving file: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving file: %s", err.Error()), http.StatusBadRequest)
+func isJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+func CreateMovieSpecial(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /movie_special handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	savePath := "/home/mkashari/UMS"
-
-	destinationFile, err := os.Create(savePath + "/" + header.Filename)
+	// the movie never serializes its path, it is asked apart
+	var request struct {
+		entity.MovieRequest
+		Path string `json:"Path"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
 	if err != nil {
-		golog.Error("Error creating file: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating file: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
 		return
 	}
 
-	defer destinationFile.Close()
-
-	if _, err := io.Copy(destinationFile, file); err != nil {
-		golog.Error("Error saving file: {}", err)
-		http.Error(w, fmt.Sprintf("Error saving file: %s", err.Error()), http.StatusInternalServerError)
+	// only files of the library, never any file of the server
+	path, err := mediaRoots().Resolve(request.Path)
+	if err != nil {
+		writeError(w, r, fileLookup("Movie file not found", err))
 		return
 	}
-
-	movie := entity.Movie{
-		Title:       r.FormValue("Title"),
-		Path:        savePath + "/" + header.Filename,
-		Description: r.FormValue("Description"),
-		ResumeAt:    "00:00",
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		err = stream.ErrIsDirectory
 	}
-
-	err = repo.MovieRepository.Save(&movie)
 	if err != nil {
-		golog.Error("Error creating movie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating movie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, fileLookup("Movie file not found", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(movie)
-}
-
-func CreateMovieSpecial(w http.ResponseWriter, r *http.Request) {
-	golog.Info("Request /movie_special handler, method: {}", r.Method)
-	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
+	movie := entity.Movie{
+		Title:       request.Title,
+		Description: request.Description,
+		Path:        path,
 	}
 
-	var movie entity.Movie
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&movie)
-	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-		return
+	if err := movie.Probe(movie.Path); err != nil {
+		golog.Warn("Cannot read media info of {}: {}", movie.Path, err)
 	}
 
-	movie.ResumeAt = "00:00"
-
 	err = repo.MovieRepository.Save(&movie)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating movie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error creating movie record", err))
 		return
 	}
 
@@ -167,28 +141,20 @@ func CreateMovieSpecial(w http.ResponseWriter, r *http.Request) {
 func EditMovie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /movies/:id/update handler, method: {}", r.Method)
 
-	if r.Method != http.MethodPut {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	// Get the ID parameter from the request context
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Movie ID is required", http.StatusBadRequest)
-		return
-	}
-
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid movie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
 		return
 	}
 
 	movie, err := repo.MovieRepository.FindByID(id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Movie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Movie not found", err))
 		return
 	}
 
@@ -196,7 +162,7 @@ func EditMovie(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&movieReq)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
 		return
 	}
 
@@ -210,8 +176,7 @@ func EditMovie(w http.ResponseWriter, r *http.Request) {
 
 	err = repo.MovieRepository.Save(movie)
 	if err != nil {
-		golog.Error("Error updating movie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error updating movie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error updating movie record", err))
 		return
 	}
 
@@ -224,36 +189,31 @@ func DeleteMovie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /movies/:id/delete handler, method: {}", r.Method)
 
 	if r.Method != http.MethodDelete {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Movie ID is required", http.StatusBadRequest)
-		return
-	}
-
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid movie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
 		return
 	}
 
 	movie, err := repo.MovieRepository.FindByID(id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Movie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Movie not found", err))
 		return
 	}
 
 	err = repo.MovieRepository.DeleteByID(id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting movie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error deleting movie record", err))
 		return
 	}
 
-	os.Remove(movie.Path)
+	if err := mediaRoots().Remove(movie.Path); err != nil && !os.IsNotExist(err) {
+		golog.Error("Error deleting movie file: {}", err)
+	}
 
 	// return a string indicating success
 	w.Header().Set("Content-Type", "application/json")
@@ -261,240 +221,104 @@ func DeleteMovie(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode("Movie deleted successfully")
 }
 
+// GetMovies lists a page of movies, see listing for the paging, sorting and filtering parameters.
 func GetMovies(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /movies handler, method: {}", r.Method)
 	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	movies, err := repo.MovieRepository.FindAll()
-	if err != nil {
-		golog.Error("Error retrieving movies: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving movies: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	moviesList := movies.ToSlice()
-	if len(moviesList) == 0 {
-		http.Error(w, "No movies found", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(moviesList)
+	listPage(w, r, movieListing, repo.MovieRepository, nil)
 }
 
 func GetMovie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /movies/:id handler, method: {}", r.Method)
 
 	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Movie ID is required", http.StatusBadRequest)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid movie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
 		return
 	}
 
-	movie, err := repo.MovieRepository.FindByID(uint(id))
+	movie, err := repo.MovieRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Movie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Movie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Movie not found", err))
 		return
 	}
+	movie.Progress = progressOf(r.Context(), "movie_id", []uint{movie.ID})[movie.ID]
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(movie)
 }
 
-func VideoStreamer(w http.ResponseWriter, r *http.Request) {
-	golog.Info("Request /video handler, method: {}", r.Method)
-	fileName := r.URL.Query().Get("file")
-
-	err := videostream.StreamVideo(w, r, fileName)
-	if err != nil {
-		golog.Error("Error streaming video file: {}", err)
-		http.Error(w, fmt.Sprintf("Error streaming video file: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-}
-
-func VideoServerHandler(w http.ResponseWriter, r *http.Request) {
-	golog.Info("Request Method: {}", r.Method)
-	golog.Info("Request URL: {}", r.URL.String())
-	golog.Info("Request Headers: {}", r.Header)
-	golog.Info("Query Parameters: {}", r.URL.Query())
-	golog.Info("Request /video/legacy handler, method: {}", r.Method)
-
-	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method: {}", r.Method)
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	fileName := r.URL.Query().Get("file")
-	if fileName == "" {
-		golog.Error("File parameter is missing")
-		http.Error(w, "File parameter is missing", http.StatusBadRequest)
-		return
-	}
-
-	golog.Info("Serving video file: {}", fileName)
-
-	// Ensure ServeVideo doesn't return a nil file
-	file, err := entity.ServeVideo(fileName)
-	if err != nil {
-		golog.Error("Error opening file: {}", err)
-		http.Error(w, fmt.Sprintf("Error opening file: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-
-	if file == nil {
-		golog.Error("File returned is nil")
-		http.Error(w, "File not found or unable to serve video", http.StatusNotFound)
-		return
-	}
-
-	// Ensure the file is properly closed after handling
-	defer file.Close()
-
-	fileSize := entity.GetFileSize(file)
-	if fileSize == 0 {
-		golog.Error("File size is 0")
-		http.Error(w, "Error with file size", http.StatusInternalServerError)
-		return
-	}
-
-	// Handle range requests if applicable
-	handleRangeRequests(w, r, file, fileSize)
-}
-
-func handleRangeRequests(w http.ResponseWriter, r *http.Request, file *os.File, fileSize int64) {
-	rangeHeader := r.Header.Get("Range")
-
-	if rangeHeader == "" {
-		w.Header().Set("Content-Length", strconv.FormatInt(fileSize, 10))
-		fileInfo, err := file.Stat()
-		if err != nil {
-			golog.Error("Error getting file info: {}", err)
-			http.Error(w, "Error getting file information", http.StatusInternalServerError)
-			return
-		}
-
-		// Serve the full file if no range is specified
-		http.ServeContent(w, r, file.Name(), fileInfo.ModTime(), file)
-		return
-	}
-
-	// Handling byte-range requests for partial content
-	fileInfo, err := file.Stat()
-	if err != nil {
-		golog.Error("Error getting file info: {}", err)
-		http.Error(w, "Error getting file information", http.StatusInternalServerError)
-		return
-	}
-
-	// Serve content for the range request
-	http.ServeContent(w, r, file.Name(), fileInfo.ModTime(), file)
-}
-
-func UpdateUsageData(data []byte) {
-	file, err := os.Create(usageData)
-	if err != nil {
-		fmt.Println("Something went wrong updating the data: ", err)
-	}
-
-	file.Write(data)
-	fmt.Println("Last access watching updated.")
-}
-
 func HandleLastAccessForMovie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /last-access/:id handler, method: {}", r.Method)
-	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Movie ID is required", http.StatusBadRequest)
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
 		return
 	}
 
-	id, err := stringToUint(idParam)
+	movie, err := repo.MovieRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Invalid movie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid movie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Lookup("Movie not found", err))
 		return
 	}
 
-	movie, err := repo.MovieRepository.FindByID(uint(id))
+	position, duration, err := playbackParams(r)
 	if err != nil {
-		golog.Error("Movie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Movie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Validation("Invalid playback position", err))
 		return
 	}
+	if duration == 0 {
+		duration = time.Duration(movie.DurationMs) * time.Millisecond
+	}
 
-	movie.ResumeAt = r.URL.Query().Get("time")
-	err = repo.MovieRepository.Save(movie)
+	user, _ := auth.CurrentUser(r.Context())
+	progress, err := saveProgress(user.ID, movie.ID, 0, position, duration)
 	if err != nil {
-		golog.Error("Error updating movie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error updating movie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error updating watch progress", err))
 		return
 	}
 
-	jsonMovie, _ := json.Marshal(movie)
-	// remove the last } from the json object
-	jsonMovie = jsonMovie[:len(jsonMovie)-1]
-	jsonMovie = append(jsonMovie, []byte(`,"Type":"Movie"}`)...)
-	UpdateUsageData(jsonMovie)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(progress)
 }
 
-func GetUsageData(w http.ResponseWriter, r *http.Request) {
-	golog.Info("Request /left-at handler, method: {}", r.Method)
-	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	file, err := os.Open(usageData)
+// playbackParams reads the position from ?time= and the optional total duration
+// from ?duration=, both in seconds, mm:ss or hh:mm:ss.
+func playbackParams(r *http.Request) (time.Duration, time.Duration, error) {
+	position, err := entity.ParsePosition(r.URL.Query().Get("time"))
 	if err != nil {
-		golog.Error("Error opening file: {}", err)
-		http.Error(w, fmt.Sprintf("Error opening file: %s", err.Error()), http.StatusInternalServerError)
-		return
+		return 0, 0, err
 	}
 
-	defer file.Close()
-
-	var item map[string]interface{}
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&item)
-	if err != nil {
-		fmt.Println("Something went wrong decoding the data: ", err)
+	var duration time.Duration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		duration, err = entity.ParsePosition(value)
+		if err != nil {
+			return 0, 0, err
+		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(item)
+	return position, duration, nil
 }
 
 func GetFile(fileName string) (*os.File, error) {
-	golog.Info("Opening file: ", fileName)
-	file, err := os.Open(fileName)
+	golog.Info("Opening file: {}", fileName)
+	file, err := mediaRoots().Open(fileName)
 	if err != nil {
 		golog.Error("Error opening file: {}", err)
 		return nil, fmt.Errorf("error opening file: %w", err)
@@ -512,8 +336,7 @@ func Pop(words *[]string) string {
 func CreateSerie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/create handler, method: {}", r.Method)
 	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
@@ -521,28 +344,21 @@ func CreateSerie(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&serie)
 	if err != nil {
-		golog.Error("Invalid JSON format: {}", err)
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
 		return
 	}
 
-	basedir := "/home/mkashari/UMS/Series/"
-
-	err = os.MkdirAll(basedir+serie.Title, os.ModePerm)
+	serie.BaseDir, err = mediaRoots().MkdirAll(currentMedia().Series, serie.Title)
 	if err != nil {
-		golog.Error("Error creating directory: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating directory: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, fileStorage("Error creating directory", err))
 		return
 	}
 
-	serie.BaseDir = basedir + serie.Title
-
 	serie.CurrentIndex = 0
 
 	err = repo.SeriesRepository.Save(&serie)
 	if err != nil {
-		golog.Error("Error creating serie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating serie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error creating serie record", err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -552,50 +368,25 @@ func CreateSerie(w http.ResponseWriter, r *http.Request) {
 
 func ListSeries(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series handler, method: {}", r.Method)
-	series, err := repo.SeriesRepository.FindAll()
-	if err != nil {
-		golog.Error("Error retrieving series: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving series: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-
-	seriesList := series.ToSlice()
-	if len(seriesList) == 0 {
-		golog.Error("No series found")
-		http.Error(w, "No series found", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(seriesList)
+	listPage(w, r, seriesListing, repo.SeriesRepository, nil)
 }
 
 func GetSerie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id handler, method: {}", r.Method)
 	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Serie ID is required", http.StatusBadRequest)
-		return
-	}
-
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
@@ -607,41 +398,30 @@ func GetSerie(w http.ResponseWriter, r *http.Request) {
 func DeleteSerie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/delete handler, method: {}", r.Method)
 	if r.Method != http.MethodDelete {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Serie ID is required", http.StatusBadRequest)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
-
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
-	err = os.RemoveAll(serie.BaseDir)
+	err = mediaRoots().RemoveAll(serie.BaseDir)
 	if err != nil {
-		golog.Error("Error deleting directory: {}", err)
-		http.Error(w, fmt.Sprintf("Error deleting directory: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error deleting directory", err))
 		return
 	}
 
 	err = repo.SeriesRepository.DeleteByID(uint(id))
 	if err != nil {
-		golog.Error("Error deleting serie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error deleting serie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error deleting serie record", err))
 		return
 	}
 
@@ -652,29 +432,20 @@ func DeleteSerie(w http.ResponseWriter, r *http.Request) {
 
 func EditSerie(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/update handler, method: {}", r.Method)
-	if r.Method != http.MethodPut {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	idParam := GetParam(r.Context(), "id")
-	if idParam == "" {
-		http.Error(w, "Serie ID is required", http.StatusBadRequest)
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	id, err := stringToUint(idParam)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
@@ -683,8 +454,7 @@ func EditSerie(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&serieReq)
 	if err != nil {
-		golog.Error("Invalid JSON format: {}", err)
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid JSON format", err))
 		return
 	}
 
@@ -698,8 +468,7 @@ func EditSerie(w http.ResponseWriter, r *http.Request) {
 
 	err = repo.SeriesRepository.Save(serie)
 	if err != nil {
-		golog.Error("Error updating serie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error updating serie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error updating serie record", err))
 		return
 	}
 
@@ -711,34 +480,27 @@ func EditSerie(w http.ResponseWriter, r *http.Request) {
 func AppendEpisodeToSeries(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/append handler, method: {}", r.Method)
 	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	pattern := "/series/:id/append"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-
-	id, err := stringToUint(params["id"])
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, 20<<30) // 5GB
 	file, header, err := r.FormFile("File")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Error retrieving file", err))
+		return
+	}
+	defer file.Close()
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
@@ -749,39 +511,36 @@ func AppendEpisodeToSeries(w http.ResponseWriter, r *http.Request) {
 
 	episodes, err := repo.EpisodeRepository.FindByQuery(query)
 	if err != nil {
-		golog.Error("Error retrieving episodes: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving episodes: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error retrieving episodes", err))
 		return
 	}
 
-	destinationFile, err := os.Create(serie.BaseDir + "/" + header.Filename)
+	path, sum, release, err := saveFile(serie.BaseDir, header.Filename, file, header.Size)
 	if err != nil {
-		golog.Error("Error creating file: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating file: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-
-	defer destinationFile.Close()
-
-	if _, err := io.Copy(destinationFile, file); err != nil {
-		golog.Error("Error saving file: {}", err)
-		http.Error(w, fmt.Sprintf("Error saving file: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	currentIndex := episodes.Size()
 
 	episode := entity.Episode{
-		Path:         serie.BaseDir + "/" + header.Filename,
-		ResumeAt:     "00:00",
+		Path:         path,
 		EpisodeIndex: currentIndex + 1,
 		SeriesID:     serie.ID,
+		Checksum:     sum,
+	}
+
+	if err := episode.Probe(episode.Path); err != nil {
+		golog.Warn("Cannot read media info of {}: {}", episode.Path, err)
 	}
 
 	err = repo.EpisodeRepository.Save(&episode)
 	if err != nil {
-		golog.Error("Error creating episode record: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating episode record: %s", err.Error()), http.StatusInternalServerError)
+		_ = os.Remove(path)
+	}
+	release()
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error creating episode record", err))
 		return
 	}
 
@@ -790,36 +549,40 @@ func AppendEpisodeToSeries(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(episode)
 }
 
+// PostEpisode appends an episode to a series from an uploaded file, or from the
+// ?file= already in the series directory.
+func PostEpisode(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("file") {
+		AppendEpisodeToSeriesSpecial(w, r)
+		return
+	}
+	AppendEpisodeToSeries(w, r)
+}
+
 func AppendEpisodeToSeriesSpecial(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/special handler, method: {}", r.Method)
 	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	pattern := "/series/:id/special"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	id, err := stringToUint(params["id"])
+	filename := r.URL.Query().Get("file")
+
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
-	filename := r.URL.Query().Get("file")
-
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	path, err := mediaRoots().Join(serie.BaseDir, filename)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, fileLookup("Episode file not found", err))
 		return
 	}
 
@@ -830,24 +593,25 @@ func AppendEpisodeToSeriesSpecial(w http.ResponseWriter, r *http.Request) {
 
 	episodes, err := repo.EpisodeRepository.FindByQuery(query)
 	if err != nil {
-		golog.Error("Error retrieving episodes: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving episodes: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error retrieving episodes", err))
 		return
 	}
 
 	currentIndex := episodes.Size()
 
 	episode := entity.Episode{
-		Path:         serie.BaseDir + "/" + filename,
-		ResumeAt:     "00:00",
+		Path:         path,
 		EpisodeIndex: currentIndex + 1,
 		SeriesID:     serie.ID,
 	}
 
+	if err := episode.Probe(episode.Path); err != nil {
+		golog.Warn("Cannot read media info of {}: {}", episode.Path, err)
+	}
+
 	err = repo.EpisodeRepository.Save(&episode)
 	if err != nil {
-		golog.Error("Error creating episode record: {}", err)
-		http.Error(w, fmt.Sprintf("Error creating episode record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error creating episode record", err))
 		return
 	}
 
@@ -859,154 +623,96 @@ func AppendEpisodeToSeriesSpecial(w http.ResponseWriter, r *http.Request) {
 func GetSerieEpisodes(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/episodes handler, method: {}", r.Method)
 	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	pattern := "/series/:id/episodes"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	golog.Info("Matched: {}", matched)
-	golog.Info("Params: {}", params)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-
-	id, err := stringToUint(params["id"])
-	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	// get all episodes by querying the database
-	query := func(db *gorm.DB) *gorm.DB {
-		return db.Where("series_id = ?", id)
-	}
-
-	episodes, err := repo.EpisodeRepository.FindByQuery(query)
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Error retrieving episodes: {}", err)
-		http.Error(w, fmt.Sprintf("Error retrieving episodes: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	episodesList := episodes.ToSlice()
-	if len(episodesList) == 0 {
-		golog.Error("No episodes found")
-		http.Error(w, "No episodes found", http.StatusNotFound)
+	if _, err := repo.SeriesRepository.FindByID(id); err != nil {
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
-	MergeSortEpisodesByIndex(&episodesList)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(episodesList)
+	listPage(w, r, episodeListing, repo.EpisodeRepository, func(db *gorm.DB) *gorm.DB {
+		return db.Where("episodes.series_id = ?", id)
+	})
 }
 
 func HandleLastAccessForEpisode(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /episodes/:id/last-access handler, method: {}", r.Method)
-	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	pattern := "/episodes/:id/last-access"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-
-	id, err := stringToUint(params["id"])
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid episode ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid episode ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid episode ID", err))
 		return
 	}
 
-	episode, err := repo.EpisodeRepository.FindByID(uint(id))
+	episode, err := repo.EpisodeRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Episode not found: {}", err)
-		http.Error(w, fmt.Sprintf("Episode not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Episode not found", err))
 		return
 	}
 
-	episode.ResumeAt = r.URL.Query().Get("time")
-	err = repo.EpisodeRepository.Save(episode)
+	position, duration, err := playbackParams(r)
 	if err != nil {
-		golog.Error("Error updating episode record: {}", err)
-		http.Error(w, fmt.Sprintf("Error updating episode record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Validation("Invalid playback position", err))
 		return
 	}
+	if duration == 0 {
+		duration = time.Duration(episode.DurationMs) * time.Millisecond
+	}
 
-	serie, err := repo.SeriesRepository.FindByID(episode.SeriesID)
+	user, _ := auth.CurrentUser(r.Context())
+	progress, err := saveProgress(user.ID, 0, episode.ID, position, duration)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Storage("Error updating watch progress", err))
 		return
 	}
 
-	serie.Episodes = []entity.Episode{*episode}
-
-	jsonSerie, _ := json.Marshal(serie)
-	// append a type to the json object to differentiate between movie and serie
-	jsonSerie = jsonSerie[:len(jsonSerie)-1]
-	jsonSerie = append(jsonSerie, []byte(`,"Type":"Serie"}`)...)
-	UpdateUsageData(jsonSerie)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(progress)
 }
 
 func HandleSetSeriesIndex(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/current/set handler, method: {}", r.Method)
-	if r.Method != http.MethodPost {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	pattern := "/series/:id/current/set"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-
-	id, err := stringToUint(params["id"])
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
 	indexStr := r.URL.Query().Get("index")
 	index, err := strconv.ParseUint(indexStr, 10, 64)
 	if err != nil {
-		golog.Error("Invalid index: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid index: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid index", err))
 		return
 	}
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
 	serie.CurrentIndex = uint(index)
 	err = repo.SeriesRepository.Save(serie)
 	if err != nil {
-		golog.Error("Error updating serie record: {}", err)
-		http.Error(w, fmt.Sprintf("Error updating serie record: %s", err.Error()), http.StatusInternalServerError)
+		writeError(w, r, apperror.Storage("Error updating serie record", err))
 		return
 	}
 
@@ -1018,31 +724,19 @@ func HandleSetSeriesIndex(w http.ResponseWriter, r *http.Request) {
 func HandleGetLastEpisodeIndex(w http.ResponseWriter, r *http.Request) {
 	golog.Info("Request /series/:id/current/get handler, method: {}", r.Method)
 	if r.Method != http.MethodGet {
-		golog.Error("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	pattern := "/series/:id/current/get"
-	path := r.URL.Path
-	params, matched := matchPattern(pattern, path)
-	if !matched {
-		golog.Error("Not Found")
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeError(w, r, apperror.MethodNotAllowed())
 		return
 	}
 
-	id, err := stringToUint(params["id"])
+	id, err := uintParam(r, "id")
 	if err != nil {
-		golog.Error("Invalid serie ID: {}", err)
-		http.Error(w, fmt.Sprintf("Invalid serie ID: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, r, apperror.Validation("Invalid serie ID", err))
 		return
 	}
 
-	serie, err := repo.SeriesRepository.FindByID(uint(id))
+	serie, err := repo.SeriesRepository.FindByID(id)
 	if err != nil {
-		golog.Error("Serie not found: {}", err)
-		http.Error(w, fmt.Sprintf("Serie not found: %s", err.Error()), http.StatusNotFound)
+		writeError(w, r, apperror.Lookup("Serie not found", err))
 		return
 	}
 
@@ -1050,47 +744,3 @@ func HandleGetLastEpisodeIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(serie.CurrentIndex)
 }
-
-func MergeSortEpisodesByIndex(episodes *[]entity.Episode) {
-	if len(*episodes) <= 1 {
-		return
-	}
-
-	mid := len(*episodes) / 2
-	left := (*episodes)[:mid]
-	right := (*episodes)[mid:]
-
-	MergeSortEpisodesByIndex(&left)
-	MergeSortEpisodesByIndex(&right)
-
-	*episodes = MergeEpisodes(left, right)
-}
-
-func MergeEpisodes(left, right []entity.Episode) []entity.Episode {
-	size, i, j := len(left)+len(right), 0, 0
-	merged := make([]entity.Episode, size)
-
-	for k := 0; k < size; k++ {
-		if i > len(left)-1 && j <= len(right)-1 {
-			merged[k] = right[j]
-			j++
-			continue
-		}
-
-		if j > len(right)-1 && i <= len(left)-1 {
-			merged[k] = left[i]
-			i++
-			continue
-		}
-
-		if left[i].EpisodeIndex < right[j].EpisodeIndex {
-			merged[k] = left[i]
-			i++
-		} else {
-			merged[k] = right[j]
-			j++
-		}
-	}
-
-	return merged
-}
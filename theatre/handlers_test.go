@@ -0,0 +1,296 @@
+package theatre
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-cinema/dlna"
+	"go-cinema/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// client calls the routes of the server as a user, or anonymously without a token.
+type client struct {
+	t       *testing.T
+	handler http.Handler
+	token   string
+}
+
+func (c client) do(method, path string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	r := httptest.NewRequest(method, path, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (c client) json(method, path string, body any) *httptest.ResponseRecorder {
+	c.t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.do(method, path, bytes.NewReader(data), "application/json")
+}
+
+// expect checks the status of a response and decodes its body into out.
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, out any) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body.String(), err)
+		}
+	}
+}
+
+// signUp registers a user and logs them in.
+func signUp(t *testing.T, handler http.Handler, username string) client {
+	t.Helper()
+	anonymous := client{t: t, handler: handler}
+	password := "secret-" + username
+	expect(t, anonymous.json(http.MethodPost, "/api/v1/auth/register", model.UserRequest{
+		Username: username, Password: password, Email: username + "@example.com",
+	}), http.StatusCreated, nil)
+
+	var tokens model.LoginResponse
+	expect(t, anonymous.json(http.MethodPost, "/api/v1/auth/login", model.LoginRequest{
+		Username: username, Password: password,
+	}), http.StatusOK, &tokens)
+	return client{t: t, handler: handler, token: tokens.AccessToken}
+}
+
+func TestAuthHandlers(t *testing.T) {
+	setup(t)
+	handler := SetupRoutes()
+
+	admin := signUp(t, handler, "alice")
+	viewer := signUp(t, handler, "bob")
+
+	var me model.User
+	expect(t, admin.do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusOK, &me)
+	if me.Username != "alice" || me.Role != model.RoleAdmin {
+		t.Errorf("the first user is %s with role %s", me.Username, me.Role)
+	}
+	expect(t, viewer.do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusOK, &me)
+	if me.Role != model.RoleViewer {
+		t.Errorf("the second user has role %s", me.Role)
+	}
+
+	anonymous := client{t: t, handler: handler}
+	expect(t, anonymous.json(http.MethodPost, "/api/v1/auth/login", model.LoginRequest{Username: "alice", Password: "wrong"}),
+		http.StatusUnauthorized, nil)
+	expect(t, anonymous.json(http.MethodPost, "/api/v1/auth/register", model.UserRequest{
+		Username: "alice", Password: "another-secret", Email: "other@example.com",
+	}), http.StatusConflict, nil)
+	expect(t, anonymous.do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusUnauthorized, nil)
+	expect(t, client{t: t, handler: handler, token: "garbage"}.do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusUnauthorized, nil)
+
+	expect(t, viewer.do(http.MethodGet, "/api/v1/users", nil, ""), http.StatusForbidden, nil)
+	var users []model.User
+	expect(t, admin.do(http.MethodGet, "/api/v1/users", nil, ""), http.StatusOK, &users)
+	if len(users) != 2 {
+		t.Errorf("%d users listed", len(users))
+	}
+}
+
+func TestMovieHandlers(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+	viewer := signUp(t, handler, "bob")
+
+	form, contentType := multipartFile(t, "matrix.mkv", []byte("not really a movie"), map[string]string{
+		"Title": "The Matrix", "Description": "Red pill",
+	})
+	expect(t, viewer.do(http.MethodPost, "/api/v1/movies", form, contentType), http.StatusForbidden, nil)
+
+	form, contentType = multipartFile(t, "matrix.mkv", []byte("not really a movie"), map[string]string{
+		"Title": "The Matrix", "Description": "Red pill",
+	})
+	var created struct {
+		ID    uint
+		Title string
+		Path  string
+	}
+	expect(t, admin.do(http.MethodPost, "/api/v1/movies", form, contentType), http.StatusCreated, &created)
+	if created.Title != "The Matrix" || created.Path != "" {
+		t.Errorf("created %+v, the path must stay on the server", created)
+	}
+	file := filepath.Join(media.Movies, "matrix.mkv")
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("uploaded file: %v", err)
+	}
+
+	form, contentType = multipartFile(t, "copy.mkv", []byte("not really a movie"), map[string]string{"Title": "Copy"})
+	expect(t, admin.do(http.MethodPost, "/api/v1/movies", form, contentType), http.StatusConflict, nil)
+
+	// files already in the library are added by path
+	for _, title := range []string{"Alien", "Brazil"} {
+		existing := filepath.Join(media.Movies, strings.ToLower(title)+".mkv")
+		if err := os.WriteFile(existing, []byte(title), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, admin.json(http.MethodPost, "/api/v1/movies", map[string]string{"Title": title, "Path": existing}),
+			http.StatusCreated, nil)
+	}
+	expect(t, admin.json(http.MethodPost, "/api/v1/movies", map[string]string{"Title": "Passwords", "Path": "/etc/passwd"}),
+		http.StatusNotFound, nil)
+
+	list := viewer.do(http.MethodGet, "/api/v1/movies?sort=title&limit=2", nil, "")
+	var page []struct{ Title string }
+	expect(t, list, http.StatusOK, &page)
+	if list.Header().Get("X-Total-Count") != "3" || len(page) != 2 || page[0].Title != "Alien" || page[1].Title != "Brazil" {
+		t.Errorf("first page %+v of %s", page, list.Header().Get("X-Total-Count"))
+	}
+	if !strings.Contains(list.Header().Get("Link"), `rel="next"`) {
+		t.Errorf("no next page in %q", list.Header().Get("Link"))
+	}
+
+	path := fmt.Sprintf("/api/v1/movies/%d", created.ID)
+	expect(t, viewer.json(http.MethodPatch, path, map[string]string{"Title": "Matrix"}), http.StatusForbidden, nil)
+	var edited struct{ Title, Description string }
+	expect(t, admin.json(http.MethodPatch, path, map[string]string{"Title": "Matrix"}), http.StatusOK, &edited)
+	if edited.Title != "Matrix" || edited.Description != "Red pill" {
+		t.Errorf("edited %+v", edited)
+	}
+	expect(t, viewer.do(http.MethodGet, path, nil, ""), http.StatusOK, &edited)
+	if edited.Title != "Matrix" {
+		t.Errorf("read back %+v", edited)
+	}
+
+	var hits []struct{ Type, Title string }
+	expect(t, viewer.do(http.MethodGet, "/api/v1/search?q=matrix", nil, ""), http.StatusOK, &hits)
+	if len(hits) != 1 || hits[0].Title != "Matrix" {
+		t.Errorf("search hits %+v", hits)
+	}
+	expect(t, viewer.do(http.MethodGet, "/api/v1/search", nil, ""), http.StatusBadRequest, nil)
+
+	expect(t, viewer.do(http.MethodDelete, path, nil, ""), http.StatusForbidden, nil)
+	expect(t, admin.do(http.MethodDelete, path, nil, ""), http.StatusOK, nil)
+	expect(t, viewer.do(http.MethodGet, path, nil, ""), http.StatusNotFound, nil)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the file of the deleted movie is still there: %v", err)
+	}
+
+	expect(t, viewer.do(http.MethodGet, "/api/v1/movies/abc", nil, ""), http.StatusBadRequest, nil)
+	expect(t, viewer.do(http.MethodPut, "/api/v1/movies", nil, ""), http.StatusMethodNotAllowed, nil)
+}
+
+func TestSeriesHandlers(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+	viewer := signUp(t, handler, "bob")
+
+	var serie struct {
+		ID      uint
+		Title   string
+		BaseDir string
+	}
+	expect(t, admin.json(http.MethodPost, "/api/v1/series", map[string]string{"Title": "Lost"}), http.StatusCreated, &serie)
+	dir := filepath.Join(media.Series, "Lost")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("series directory: %v", err)
+	}
+	if serie.BaseDir != "" {
+		t.Errorf("created %+v, the directory must stay on the server", serie)
+	}
+	expect(t, admin.json(http.MethodPost, "/api/v1/series", map[string]string{"Title": "../escape"}), http.StatusBadRequest, nil)
+
+	episodes := fmt.Sprintf("/api/v1/series/%d/episodes", serie.ID)
+	for i, name := range []string{"pilot.mkv", "second.mkv"} {
+		form, contentType := multipartFile(t, name, []byte("episode "+name), nil)
+		var episode struct{ EpisodeIndex int }
+		expect(t, admin.do(http.MethodPost, episodes, form, contentType), http.StatusCreated, &episode)
+		if episode.EpisodeIndex != i+1 {
+			t.Errorf("%s has index %d", name, episode.EpisodeIndex)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "third.mkv"), []byte("episode third"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, admin.do(http.MethodPost, episodes+"?file=third.mkv", nil, ""), http.StatusCreated, nil)
+	expect(t, admin.do(http.MethodPost, episodes+"?file=../../etc/passwd", nil, ""), http.StatusNotFound, nil)
+
+	var listed []struct{ EpisodeIndex int }
+	expect(t, viewer.do(http.MethodGet, episodes, nil, ""), http.StatusOK, &listed)
+	if len(listed) != 3 {
+		t.Errorf("%d episodes listed", len(listed))
+	}
+
+	current := fmt.Sprintf("/api/v1/series/%d/current", serie.ID)
+	expect(t, viewer.do(http.MethodPut, current+"?index=2", nil, ""), http.StatusOK, nil)
+	var index struct{ CurrentIndex uint }
+	expect(t, viewer.do(http.MethodGet, fmt.Sprintf("/api/v1/series/%d", serie.ID), nil, ""), http.StatusOK, &index)
+	if index.CurrentIndex != 2 {
+		t.Errorf("current index %d", index.CurrentIndex)
+	}
+
+	expect(t, viewer.do(http.MethodGet, "/api/v1/series/999/episodes", nil, ""), http.StatusNotFound, nil)
+}
+
+func TestPrivateRoutes(t *testing.T) {
+	setup(t)
+	InitCasting(0)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+	viewer := signUp(t, handler, "bob")
+	anonymous := client{t: t, handler: handler}
+
+	// scans and reports name files by their server path, casts carry stream tokens
+	for _, path := range []string{
+		"/api/v1/casts", "/api/v1/renderers", "/api/v1/renderers?refresh=true",
+		"/api/v1/library/scans", "/api/v1/library/scans/1", "/api/v1/library/missing",
+	} {
+		expect(t, anonymous.do(http.MethodGet, path, nil, ""), http.StatusUnauthorized, nil)
+	}
+	for _, path := range []string{"/api/v1/library/scans", "/api/v1/library/scans/1", "/api/v1/library/missing"} {
+		expect(t, viewer.do(http.MethodGet, path, nil, ""), http.StatusForbidden, nil)
+	}
+	expect(t, viewer.do(http.MethodGet, "/api/v1/casts", nil, ""), http.StatusOK, nil)
+	expect(t, admin.do(http.MethodGet, "/api/v1/library/scans", nil, ""), http.StatusOK, nil)
+
+	session, err := json.Marshal(dlna.CastSession{Title: "Movie", URL: "http://host/api/v1/stream/movie/1?token=secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(session), "secret") {
+		t.Errorf("a cast session lists its stream token: %s", session)
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+
+	w := admin.do(http.MethodGet, "/movies", nil, "")
+	expect(t, w, http.StatusOK, nil)
+	if w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy route without the Deprecation header: %v", w.Header())
+	}
+
+	// the routes added with /api/v1 are not served at the legacy paths
+	for _, path := range []string{"/me", "/me/continue-watching", "/users", "/library/scans", "/library/missing", "/renderers", "/casts"} {
+		expect(t, admin.do(http.MethodGet, path, nil, ""), http.StatusNotFound, nil)
+	}
+	for _, path := range []string{"/login", "/register", "/refresh-token", "/logout", "/movies/1/cast", "/series/1/cast", "/library/scan"} {
+		expect(t, admin.do(http.MethodPost, path, nil, ""), http.StatusNotFound, nil)
+	}
+	expect(t, admin.do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusOK, nil)
+}
@@ -0,0 +1,105 @@
+package theatre
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/library"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kashari/golog"
+)
+
+// ScanLibrary starts a library scan and answers with the job to poll for its report.
+func ScanLibrary(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /library/scan handler, method: {}", r.Method)
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	job, err := library.StartScan(currentRoots())
+	status := http.StatusAccepted
+	if errors.Is(err, library.ErrScanRunning) {
+		status = http.StatusConflict
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the job lives under the path of the request, /library/scan or /api/v1/library/scans
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), job.ID))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func GetScanJob(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /library/scan/:id handler, method: {}", r.Method)
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := strconv.ParseInt(GetParam(r.Context(), "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid job ID", err))
+		return
+	}
+
+	job, ok := library.FindJob(id)
+	if !ok {
+		writeError(w, r, apperror.NotFound("Scan job not found", nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func ListScanJobs(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /library/scans handler, method: {}", r.Method)
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(library.Jobs())
+}
+
+// ListMissingMedia lists the movies and episodes whose files the watcher saw disappear.
+func ListMissingMedia(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /library/missing handler, method: {}", r.Method)
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	movies, episodes, err := library.MissingMedia()
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving missing media", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"Movies":   movies,
+		"Episodes": episodes,
+	})
+}
+
+// WatchLibrary keeps the library roots in sync with the database until the context ends.
+func WatchLibrary(ctx context.Context) {
+	if err := library.NewWatcher(currentRoots()).Run(ctx); err != nil {
+		golog.Error("Library watcher stopped: {}", err.Error())
+	}
+}
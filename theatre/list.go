@@ -0,0 +1,387 @@
+package theatre
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/misenkashari/goutils/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// subtitleTrack matches the tracks column of media with a subtitle, tracks are stored as JSON
+const subtitleTrack = `%"kind":"subtitle"%`
+
+// sortField orders a listing by column, then by ID so that cursors never skip nor repeat an item
+type sortField[T any] struct {
+	column string
+	// value is the sort value of an item, kept in the cursor
+	value func(T) string
+	// parse turns the value of a cursor back into a query argument
+	parse func(string) (any, error)
+}
+
+// listing describes a list endpoint: the table it reads, the sorts it accepts and
+// the filters that apply to it
+type listing[T any] struct {
+	table       string
+	sorts       map[string]sortField[T]
+	defaultSort string
+	// progressColumn links watch progress to the items, empty when they cannot be watched
+	progressColumn string
+	// tracks tells the items have probed tracks to filter subtitles on
+	tracks bool
+	id     func(T) uint
+	// attach fills what the table does not hold, as the progress of the current user
+	attach func(context.Context, []T)
+}
+
+// listParams is what a list request asks for, from
+// ?limit=&offset=&cursor=&sort=&watched=&since=&subtitles=
+type listParams struct {
+	limit     int
+	offset    int
+	cursor    *cursor
+	sort      string
+	desc      bool
+	watched   *bool
+	since     *time.Time
+	subtitles *bool
+}
+
+// cursor points after the last item of a page, in the order of sort
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+var movieListing = listing[entity.Movie]{
+	table: "movies",
+	sorts: map[string]sortField[entity.Movie]{
+		"title":   titleSort("movies", func(m entity.Movie) string { return m.Title }),
+		"created": timeSort("movies.created_at", func(m entity.Movie) time.Time { return m.CreatedAt }),
+		"watched": watchedSort("movies", func(m entity.Movie) time.Time { return lastActivity(m.Progress, m.CreatedAt) }),
+	},
+	defaultSort:    "title",
+	progressColumn: "movie_id",
+	tracks:         true,
+	id:             func(m entity.Movie) uint { return m.ID },
+	attach:         attachMovieProgress,
+}
+
+var seriesListing = listing[entity.Series]{
+	table: "series",
+	sorts: map[string]sortField[entity.Series]{
+		"title":   titleSort("series", func(s entity.Series) string { return s.Title }),
+		"created": timeSort("series.created_at", func(s entity.Series) time.Time { return s.CreatedAt }),
+	},
+	defaultSort: "title",
+	id:          func(s entity.Series) uint { return s.ID },
+}
+
+var episodeListing = listing[entity.Episode]{
+	table: "episodes",
+	sorts: map[string]sortField[entity.Episode]{
+		"index": {
+			column: "episodes.episode_index",
+			value:  func(e entity.Episode) string { return strconv.Itoa(e.EpisodeIndex) },
+			parse:  func(s string) (any, error) { return strconv.Atoi(s) },
+		},
+		"created": timeSort("episodes.created_at", func(e entity.Episode) time.Time { return e.CreatedAt }),
+		"watched": watchedSort("episodes", func(e entity.Episode) time.Time { return lastActivity(e.Progress, e.CreatedAt) }),
+	},
+	defaultSort:    "index",
+	progressColumn: "episode_id",
+	tracks:         true,
+	id:             func(e entity.Episode) uint { return e.ID },
+	attach:         attachEpisodeProgress,
+}
+
+func titleSort[T any](table string, title func(T) string) sortField[T] {
+	return sortField[T]{
+		column: table + ".title",
+		value:  title,
+		parse:  func(s string) (any, error) { return s, nil },
+	}
+}
+
+func timeSort[T any](column string, at func(T) time.Time) sortField[T] {
+	return sortField[T]{
+		column: column,
+		value:  func(item T) string { return at(item).Format(time.RFC3339Nano) },
+		parse: func(s string) (any, error) {
+			return time.Parse(time.RFC3339Nano, s)
+		},
+	}
+}
+
+// watchedSort orders by when the current user last played an item, items never
+// played by when they were added.
+func watchedSort[T any](table string, at func(T) time.Time) sortField[T] {
+	return timeSort(fmt.Sprintf("COALESCE(watch_progresses.progress_updated_at, %s.created_at)", table), at)
+}
+
+func lastActivity(progress entity.Progress, createdAt time.Time) time.Time {
+	if progress.UpdatedAt != nil {
+		return *progress.UpdatedAt
+	}
+	return createdAt
+}
+
+func (l listing[T]) parse(query url.Values) (listParams, error) {
+	params := listParams{limit: defaultPageSize, sort: l.defaultSort}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return params, errors.New("limit must be a positive number")
+		}
+		params.limit = min(limit, maxPageSize)
+	}
+
+	if value := query.Get("sort"); value != "" {
+		params.sort, params.desc = strings.CutPrefix(value, "-")
+		if _, ok := l.sorts[params.sort]; !ok {
+			return params, fmt.Errorf("cannot sort by %s", params.sort)
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return params, errors.New("offset must be a positive number")
+		}
+		params.offset = offset
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		if params.offset != 0 {
+			return params, errors.New("cursor and offset cannot be combined")
+		}
+		c, err := decodeCursor(value)
+		if err != nil || c.Sort != sortName(params.sort, params.desc) {
+			return params, errors.New("invalid cursor")
+		}
+		params.cursor = c
+	}
+
+	if value := query.Get("watched"); value != "" {
+		if l.progressColumn == "" {
+			return params, errors.New("watched filter is not supported here")
+		}
+		watched, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, errors.New("watched must be true or false")
+		}
+		params.watched = &watched
+	}
+
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			since, err = time.Parse(time.DateOnly, value)
+		}
+		if err != nil {
+			return params, errors.New("since must be a date or an RFC 3339 time")
+		}
+		params.since = &since
+	}
+
+	if value := query.Get("subtitles"); value != "" {
+		if !l.tracks {
+			return params, errors.New("subtitles filter is not supported here")
+		}
+		subtitles, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, errors.New("subtitles must be true or false")
+		}
+		params.subtitles = &subtitles
+	}
+
+	return params, nil
+}
+
+func sortName(sort string, desc bool) string {
+	if desc {
+		return "-" + sort
+	}
+	return sort
+}
+
+// filter narrows base down to the filters of params. Progress is that of userID,
+// anonymous requests have watched nothing.
+func (l listing[T]) filter(base func(*gorm.DB) *gorm.DB, params listParams, userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if base != nil {
+			db = base(db)
+		}
+
+		if l.progressColumn != "" && (params.watched != nil || params.sort == "watched") {
+			db = db.Joins(fmt.Sprintf("LEFT JOIN watch_progresses ON watch_progresses.%s = %s.id AND watch_progresses.user_id = ?",
+				l.progressColumn, l.table), userID)
+		}
+		if params.watched != nil {
+			if *params.watched {
+				db = db.Where("watch_progresses.progress_watched = ?", true)
+			} else {
+				db = db.Where("(watch_progresses.id IS NULL OR watch_progresses.progress_watched = ?)", false)
+			}
+		}
+
+		if params.since != nil {
+			db = db.Where(l.table+".created_at >= ?", *params.since)
+		}
+
+		if params.subtitles != nil {
+			if *params.subtitles {
+				db = db.Where(l.table+".tracks LIKE ?", subtitleTrack)
+			} else {
+				db = db.Where(fmt.Sprintf("(%[1]s.tracks IS NULL OR %[1]s.tracks NOT LIKE ?)", l.table), subtitleTrack)
+			}
+		}
+		return db
+	}
+}
+
+// listPage answers with the page of items the request asks for, base restricting
+// them as to the episodes of a series. It writes the error itself when it fails.
+func listPage[T any](w http.ResponseWriter, r *http.Request, l listing[T], items *repository.GormRepository[T, uint], base func(*gorm.DB) *gorm.DB) {
+	params, err := l.parse(r.URL.Query())
+	if err != nil {
+		writeError(w, r, apperror.Validation(err.Error(), nil))
+		return
+	}
+
+	var userID uint
+	if user, ok := auth.CurrentUser(r.Context()); ok {
+		userID = user.ID
+	}
+	filter := l.filter(base, params, userID)
+
+	total, err := repo.Count[T](filter)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error counting "+l.table, err))
+		return
+	}
+
+	sort := l.sorts[params.sort]
+	direction, after := "ASC", ">"
+	if params.desc {
+		direction, after = "DESC", "<"
+	}
+
+	var cursorValue any
+	if params.cursor != nil {
+		if cursorValue, err = sort.parse(params.cursor.Value); err != nil {
+			writeError(w, r, apperror.Validation("invalid cursor", err))
+			return
+		}
+	}
+
+	found, err := items.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		db = filter(db).Select(l.table + ".*")
+		if params.cursor != nil {
+			db = db.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND %[3]s.id %[2]s ?))", sort.column, after, l.table),
+				cursorValue, cursorValue, params.cursor.ID)
+		}
+		// one more than asked tells whether there is a next page
+		return db.Order(fmt.Sprintf("%s %s, %s.id %s", sort.column, direction, l.table, direction)).
+			Offset(params.offset).
+			Limit(params.limit + 1)
+	})
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving "+l.table, err))
+		return
+	}
+
+	page := found.ToSlice()
+	more := len(page) > params.limit
+	if more {
+		page = page[:params.limit]
+	}
+	if l.attach != nil {
+		l.attach(r.Context(), page)
+	}
+
+	var next *cursor
+	if more && len(page) > 0 {
+		last := page[len(page)-1]
+		next = &cursor{Sort: sortName(params.sort, params.desc), Value: sort.value(last), ID: l.id(last)}
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if links := pageLinks(r, params, total, next); links != "" {
+		w.Header().Add("Link", links)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// pageLinks builds the Link header. Requests paging by offset get first, prev and
+// next offsets, the others the cursor of the next page.
+func pageLinks(r *http.Request, params listParams, total int64, next *cursor) string {
+	link := func(rel string, set func(url.Values)) string {
+		query := r.URL.Query()
+		query.Del("cursor")
+		query.Del("offset")
+		set(query)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	var links []string
+	if r.URL.Query().Has("offset") {
+		links = append(links, link("first", func(url.Values) {}))
+		if params.offset > 0 {
+			prev := max(params.offset-params.limit, 0)
+			links = append(links, link("prev", func(q url.Values) { q.Set("offset", strconv.Itoa(prev)) }))
+		}
+		if int64(params.offset+params.limit) < total {
+			links = append(links, link("next", func(q url.Values) { q.Set("offset", strconv.Itoa(params.offset+params.limit)) }))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	if params.cursor != nil {
+		links = append(links, link("first", func(url.Values) {}))
+	}
+	if next != nil {
+		links = append(links, link("next", func(q url.Values) { q.Set("cursor", next.encode()) }))
+	}
+	return strings.Join(links, ", ")
+}
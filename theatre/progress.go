@@ -0,0 +1,246 @@
+package theatre
+
+import (
+	"context"
+	"encoding/json"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	continueWatchingLimit    = 20
+	maxContinueWatchingLimit = 100
+)
+
+// ContinueItem is an entry of the continue-watching list, a movie or an episode
+// together with its series.
+type ContinueItem struct {
+	Type     string          `json:"Type"`
+	Progress entity.Progress `json:"Progress"`
+	Movie    *entity.Movie   `json:"Movie,omitempty"`
+	Episode  *entity.Episode `json:"Episode,omitempty"`
+	Series   *entity.Series  `json:"Series,omitempty"`
+}
+
+// findProgress returns the progress of a user on a movie or an episode, a new
+// unsaved one when the user never played it.
+func findProgress(userID, movieID, episodeID uint) (*entity.WatchProgress, error) {
+	found, err := repo.ProgressRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND movie_id = ? AND episode_id = ?", userID, movieID, episodeID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found.Size() > 0 {
+		progress := found.ToSlice()[0]
+		return &progress, nil
+	}
+	return &entity.WatchProgress{UserID: userID, MovieID: movieID, EpisodeID: episodeID}, nil
+}
+
+// progressColumns are what a new position updates.
+var progressColumns = []string{
+	"progress_position_ms", "progress_duration_ms", "progress_percent", "progress_watched", "progress_updated_at",
+}
+
+// saveProgress records a position of a user on a movie or an episode.
+func saveProgress(userID, movieID, episodeID uint, position, duration time.Duration) (*entity.WatchProgress, error) {
+	progress, err := findProgress(userID, movieID, episodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Progress.Track(position, duration)
+	if err := storeProgress(progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// storeProgress updates the progress, or inserts it. One inserted since it was
+// looked up is updated instead.
+func storeProgress(progress *entity.WatchProgress) error {
+	if progress.ID != 0 {
+		return repo.DB.Model(progress).Select(progressColumns).Updates(progress).Error
+	}
+	return repo.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "movie_id"}, {Name: "episode_id"}},
+		DoUpdates: clause.AssignmentColumns(progressColumns),
+	}).Create(progress).Error
+}
+
+// progressOf loads the progress of the current user, keyed by movie or episode ID.
+// Anonymous requests have none.
+func progressOf(ctx context.Context, column string, ids []uint) map[uint]entity.Progress {
+	progress := make(map[uint]entity.Progress)
+
+	user, ok := auth.CurrentUser(ctx)
+	if !ok || len(ids) == 0 {
+		return progress
+	}
+
+	found, err := repo.ProgressRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", user.ID).Where(column+" IN ?", ids)
+	})
+	if err != nil {
+		golog.Error("Error retrieving watch progress: {}", err)
+		return progress
+	}
+
+	for _, item := range found.ToSlice() {
+		if column == "movie_id" {
+			progress[item.MovieID] = item.Progress
+		} else {
+			progress[item.EpisodeID] = item.Progress
+		}
+	}
+	return progress
+}
+
+func attachMovieProgress(ctx context.Context, movies []entity.Movie) {
+	ids := make([]uint, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	progress := progressOf(ctx, "movie_id", ids)
+	for i := range movies {
+		movies[i].Progress = progress[movies[i].ID]
+	}
+}
+
+func attachEpisodeProgress(ctx context.Context, episodes []entity.Episode) {
+	ids := make([]uint, len(episodes))
+	for i, episode := range episodes {
+		ids[i] = episode.ID
+	}
+
+	progress := progressOf(ctx, "episode_id", ids)
+	for i := range episodes {
+		episodes[i].Progress = progress[episodes[i].ID]
+	}
+}
+
+// ContinueWatching lists what the current user started and did not finish, the
+// most recently played first.
+func ContinueWatching(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /me/continue-watching handler, method: {}", r.Method)
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	user, ok := auth.CurrentUser(r.Context())
+	if !ok {
+		writeError(w, r, apperror.Unauthorized("Authentication required"))
+		return
+	}
+
+	limit := continueWatchingLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, r, apperror.Validation("Invalid limit", nil))
+			return
+		}
+		limit = min(parsed, maxContinueWatchingLimit)
+	}
+
+	items, err := continueItems(r.Context(), user.ID, limit)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error retrieving watch progress", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// continueItems loads the limit latest unfinished progress entries of a user, on
+// media neither deleted nor missing, then what they are about, a query per table.
+func continueItems(ctx context.Context, userID uint, limit int) ([]ContinueItem, error) {
+	db := repo.DB.WithContext(ctx)
+
+	var found []entity.WatchProgress
+	err := db.Table("watch_progresses").Select("watch_progresses.*").
+		Joins("LEFT JOIN movies ON movies.id = watch_progresses.movie_id AND movies.deleted_at IS NULL AND movies.missing = ?", false).
+		Joins("LEFT JOIN episodes ON episodes.id = watch_progresses.episode_id AND episodes.deleted_at IS NULL AND episodes.missing = ?", false).
+		Joins("LEFT JOIN series ON series.id = episodes.series_id AND series.deleted_at IS NULL").
+		Where("watch_progresses.user_id = ? AND watch_progresses.progress_position_ms > 0 AND watch_progresses.progress_watched = ?", userID, false).
+		Where("movies.id IS NOT NULL OR series.id IS NOT NULL").
+		Order("watch_progresses.progress_updated_at DESC").Order("watch_progresses.id DESC").
+		Limit(limit).
+		Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var movieIDs, episodeIDs []uint
+	for _, progress := range found {
+		if progress.MovieID != 0 {
+			movieIDs = append(movieIDs, progress.MovieID)
+		} else {
+			episodeIDs = append(episodeIDs, progress.EpisodeID)
+		}
+	}
+
+	movies := make(map[uint]*entity.Movie)
+	if len(movieIDs) > 0 {
+		var rows []entity.Movie
+		if err := db.Where("id IN ?", movieIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			movies[rows[i].ID] = &rows[i]
+		}
+	}
+
+	episodes := make(map[uint]*entity.Episode)
+	series := make(map[uint]*entity.Series)
+	if len(episodeIDs) > 0 {
+		var rows []entity.Episode
+		if err := db.Where("id IN ?", episodeIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		var seriesIDs []uint
+		for i := range rows {
+			episodes[rows[i].ID] = &rows[i]
+			seriesIDs = append(seriesIDs, rows[i].SeriesID)
+		}
+
+		var serieRows []entity.Series
+		if err := db.Where("id IN ?", seriesIDs).Find(&serieRows).Error; err != nil {
+			return nil, err
+		}
+		for i := range serieRows {
+			series[serieRows[i].ID] = &serieRows[i]
+		}
+	}
+
+	// rows deleted between the queries are left out
+	items := []ContinueItem{}
+	for _, progress := range found {
+		item := ContinueItem{Progress: progress.Progress}
+		if movie, ok := movies[progress.MovieID]; ok && progress.MovieID != 0 {
+			movie.Progress = progress.Progress
+			item.Type, item.Movie = "Movie", movie
+		} else if episode, ok := episodes[progress.EpisodeID]; ok && series[episode.SeriesID] != nil {
+			episode.Progress = progress.Progress
+			item.Type, item.Episode, item.Series = "Episode", episode, series[episode.SeriesID]
+		} else {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
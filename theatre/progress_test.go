@@ -0,0 +1,142 @@
+package theatre
+
+import (
+	"encoding/json"
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func saveUser(t *testing.T, name string) *model.User {
+	t.Helper()
+	user := model.User{Username: name, Email: name + "@example.com", Password: "hash", Role: model.RoleViewer}
+	if err := repo.UserRepository.Save(&user); err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func TestContinueWatching(t *testing.T) {
+	setup(t)
+	user := saveUser(t, "alice")
+	other := saveUser(t, "bob")
+
+	movies := make([]entity.Movie, 4)
+	for i := range movies {
+		movies[i] = entity.Movie{Title: string(rune('A' + i)), Path: string(rune('a'+i)) + ".mkv"}
+		if err := repo.MovieRepository.Save(&movies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serie := entity.Series{Title: "Serie", BaseDir: "serie"}
+	if err := repo.SeriesRepository.Save(&serie); err != nil {
+		t.Fatal(err)
+	}
+	episode := entity.Episode{Path: "serie/e01.mkv", EpisodeIndex: 1, SeriesID: serie.ID}
+	if err := repo.EpisodeRepository.Save(&episode); err != nil {
+		t.Fatal(err)
+	}
+
+	play := func(user *model.User, movieID, episodeID uint, position time.Duration) {
+		t.Helper()
+		if _, err := saveProgress(user.ID, movieID, episodeID, position, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		// the list is ordered by when the position was saved
+		time.Sleep(5 * time.Millisecond)
+	}
+	play(user, movies[0].ID, 0, time.Minute)
+	play(user, movies[1].ID, 0, time.Hour)   // watched
+	play(user, movies[2].ID, 0, time.Minute) // missing below
+	play(user, movies[3].ID, 0, time.Minute) // deleted below
+	play(user, 0, episode.ID, time.Minute)
+	play(other, movies[0].ID, 0, time.Minute)
+	play(user, movies[0].ID, 0, 2*time.Minute)
+
+	movies[2].Missing = true
+	if err := repo.MovieRepository.Save(&movies[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MovieRepository.DeleteByID(movies[3].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) []ContinueItem {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/me/continue-watching"+query, nil)
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+		w := serve(ContinueWatching, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var items []ContinueItem
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	items := list("")
+	if len(items) != 2 {
+		t.Fatalf("%d items, want 2: %+v", len(items), items)
+	}
+	if items[0].Type != "Movie" || items[0].Movie.ID != movies[0].ID || items[0].Progress.PositionMs != 120000 {
+		t.Errorf("first item %+v, want movie %d at 2m", items[0], movies[0].ID)
+	}
+	if items[1].Type != "Episode" || items[1].Episode.ID != episode.ID || items[1].Series.ID != serie.ID {
+		t.Errorf("second item %+v, want episode %d", items[1], episode.ID)
+	}
+
+	if items := list("?limit=1"); len(items) != 1 || items[0].Movie == nil {
+		t.Errorf("limited to %+v", items)
+	}
+}
+
+func TestStoreProgressInsertedMeanwhile(t *testing.T) {
+	setup(t)
+	user := saveUser(t, "alice")
+	movie := entity.Movie{Title: "A", Path: "a.mkv"}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatal(err)
+	}
+
+	// looked up by two requests before either saved it
+	first, err := findProgress(user.ID, movie.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := findProgress(user.ID, movie.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Progress.Track(time.Minute, time.Hour)
+	if err := storeProgress(first); err != nil {
+		t.Fatal(err)
+	}
+	second.Progress.Track(2*time.Minute, time.Hour)
+	if err := storeProgress(second); err != nil {
+		t.Fatalf("second insert: %v", err)
+	}
+
+	stored, err := findProgress(user.ID, movie.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ID != first.ID || stored.Progress.PositionMs != 120000 {
+		t.Errorf("stored %+v, want progress %d at 2m", stored, first.ID)
+	}
+	count, err := repo.Count[entity.WatchProgress](func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", user.ID)
+	})
+	if err != nil || count != 1 {
+		t.Errorf("%d progress rows, %v", count, err)
+	}
+}
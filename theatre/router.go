@@ -1,7 +1,10 @@
 package theatre
 
 import (
+	"fmt"
+	"go-cinema/auth"
 	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -10,124 +13,315 @@ type Route struct {
 	Pattern string
 	Handler http.HandlerFunc
 	Method  string
+	// Public routes can be called without authentication whatever their method
+	Public bool
+	// Permission the user must have, checked for every method
+	Permission auth.Permission
+
+	group *RouteGroup
+}
+
+// RouteOption configures a route when it is registered
+type RouteOption func(*Route)
+
+// Public lets anonymous clients call a mutating route, as login or DLNA control
+func Public() RouteOption {
+	return func(route *Route) {
+		route.Public = true
+	}
 }
 
-// CustomRouter holds the registered routes
+// Require restricts a route to users whose role grants permission
+func Require(permission auth.Permission) RouteOption {
+	return func(route *Route) {
+		route.Permission = permission
+	}
+}
+
+// Middleware wraps the handler of a route
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// RouteGroup registers routes under a common prefix, wrapped in the middlewares of
+// the group and of all its parents
+type RouteGroup struct {
+	router      *CustomRouter
+	parent      *RouteGroup
+	prefix      string
+	middlewares []Middleware
+}
+
+// node is a path segment of the routing tree. Static children are tried first,
+// then the parameter child, then the catch-all child.
+type node struct {
+	static   map[string]*node
+	param    *node
+	catchAll *node
+	// name of the parameter or catch-all this node captures
+	name   string
+	routes map[string]*Route
+}
+
+// CustomRouter dispatches requests through a tree of path segments, so finding a
+// route costs the same with ten routes or a thousand
 type CustomRouter struct {
-	routes []Route
+	*RouteGroup
+	// NotFound answers paths matching no route
+	NotFound http.HandlerFunc
+	// MethodNotAllowed answers known paths called with a method they do not have, the Allow header is set
+	MethodNotAllowed http.HandlerFunc
+
+	root        *node
+	middlewares []Middleware
 }
 
 // NewRouter creates a new instance of CustomRouter
 func NewRouter() *CustomRouter {
-	return &CustomRouter{
-		routes: []Route{},
+	r := &CustomRouter{
+		NotFound: http.NotFound,
+		MethodNotAllowed: func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		},
+		root: &node{},
 	}
+	r.RouteGroup = &RouteGroup{router: r}
+	return r
+}
+
+// Use adds middlewares run around every request, matched or not, the first one
+// outermost. They see the matched route through CurrentRoute.
+func (r *CustomRouter) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
 }
 
-// Handle registers a new route with the given pattern, handler and HTTP method
-func (r *CustomRouter) Handle(pattern, method string, handler http.HandlerFunc) {
-	r.routes = append(r.routes, Route{
-		Pattern: pattern,
+// Group creates a sub-group of routes under prefix
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:      g.router,
+		parent:      g,
+		prefix:      strings.TrimSuffix(g.prefix+"/"+strings.Trim(prefix, "/"), "/"),
+		middlewares: middlewares,
+	}
+}
+
+// Use adds middlewares run around the routes of the group, the first one outermost
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Handle registers a new route with the given pattern, handler and HTTP method.
+// Segments starting with ':' capture one segment, a last segment starting with
+// '*' captures the rest of the path.
+func (g *RouteGroup) Handle(pattern, method string, handler http.HandlerFunc, options ...RouteOption) {
+	route := &Route{
+		Pattern: g.prefix + "/" + strings.Trim(pattern, "/"),
 		Handler: handler,
 		Method:  method,
-	})
+		group:   g,
+	}
+	for _, option := range options {
+		option(route)
+	}
+	g.router.root.insert(route)
 }
 
 // GET registers a new GET route
-func (r *CustomRouter) GET(pattern string, handler http.HandlerFunc) {
-	r.Handle(pattern, http.MethodGet, handler)
+func (g *RouteGroup) GET(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodGet, handler, options...)
+}
+
+// HEAD registers a new HEAD route, GET routes answer HEAD requests without one
+func (g *RouteGroup) HEAD(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodHead, handler, options...)
 }
 
 // POST registers a new POST route
-func (r *CustomRouter) POST(pattern string, handler http.HandlerFunc) {
-	r.Handle(pattern, http.MethodPost, handler)
+func (g *RouteGroup) POST(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodPost, handler, options...)
 }
 
 // PUT registers a new PUT route
-func (r *CustomRouter) PUT(pattern string, handler http.HandlerFunc) {
-	r.Handle(pattern, http.MethodPut, handler)
+func (g *RouteGroup) PUT(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodPut, handler, options...)
+}
+
+// PATCH registers a new PATCH route
+func (g *RouteGroup) PATCH(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodPatch, handler, options...)
 }
 
 // DELETE registers a new DELETE route
-func (r *CustomRouter) DELETE(pattern string, handler http.HandlerFunc) {
-	r.Handle(pattern, http.MethodDelete, handler)
+func (g *RouteGroup) DELETE(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodDelete, handler, options...)
 }
 
-// OPTIONS registers a new OPTIONS route
-func (r *CustomRouter) OPTIONS(pattern string, handler http.HandlerFunc) {
-	r.Handle(pattern, http.MethodOptions, handler)
+// OPTIONS registers a new OPTIONS route, other paths answer OPTIONS with their Allow header
+func (g *RouteGroup) OPTIONS(pattern string, handler http.HandlerFunc, options ...RouteOption) {
+	g.Handle(pattern, http.MethodOptions, handler, options...)
 }
 
 // ServeHTTP implements the http.Handler interface
 func (r *CustomRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	for _, route := range r.routes {
-		// First check if HTTP method matches (unless it's OPTIONS, which we'll handle specially)
-		if req.Method != route.Method && req.Method != http.MethodOptions {
-			continue
-		}
+	ctx := req.Context()
+	handler := r.NotFound
+	method := req.Method
 
-		// Check if the route pattern matches
-		params, matched := matchPattern(route.Pattern, req.URL.Path)
-		if !matched {
-			continue
+	n, params := r.root.lookup(splitPath(req.URL.Path), nil)
+	if n != nil {
+		for _, p := range params {
+			ctx = contextWithParam(ctx, p.key, p.value)
 		}
 
-		// Store route parameters in the request context
-		ctx := req.Context()
-		for key, value := range params {
-			ctx = contextWithParam(ctx, key, value)
+		route, ok := n.routes[req.Method]
+		if !ok && req.Method == http.MethodHead {
+			// handlers see a GET, the server still drops the body of a HEAD response
+			route, ok = n.routes[http.MethodGet]
+			method = http.MethodGet
 		}
 
-		// If it's an OPTIONS request and the path matches, handle CORS
-		if req.Method == http.MethodOptions {
-			handleCORS(w)
-			return
+		switch {
+		case ok:
+			ctx = contextWithRoute(ctx, *route)
+			handler = route.chain()
+		case req.Method == http.MethodOptions:
+			handler = func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Allow", n.allow())
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			handler = func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Allow", n.allow())
+				r.MethodNotAllowed(w, req)
+			}
 		}
+	}
 
-		// Apply CORS middleware for non-OPTIONS requests
-		CORSMiddleware(route.Handler)(w, req.WithContext(ctx))
-		return
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
 	}
+	req = req.WithContext(ctx)
+	req.Method = method
+	handler(w, req)
+}
 
-	// No route matched
-	http.NotFound(w, req)
+// chain wraps the handler in the middlewares of its group and the group parents
+func (route *Route) chain() http.HandlerFunc {
+	handler := route.Handler
+	for g := route.group; g != nil; g = g.parent {
+		for i := len(g.middlewares) - 1; i >= 0; i-- {
+			handler = g.middlewares[i](handler)
+		}
+	}
+	return handler
 }
 
-// Helper function to handle CORS for OPTIONS requests
-func handleCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-	w.WriteHeader(http.StatusNoContent)
+func (n *node) insert(route *Route) {
+	current := n
+	parts := splitPath(route.Pattern)
+	for i, part := range parts {
+		switch {
+		case part[0] == ':':
+			current.param = current.child(current.param, part[1:], route)
+			current = current.param
+		case part[0] == '*':
+			if i != len(parts)-1 {
+				panic(fmt.Sprintf("router: catch-all %s must end the pattern %s", part, route.Pattern))
+			}
+			current.catchAll = current.child(current.catchAll, part[1:], route)
+			current = current.catchAll
+		default:
+			if current.static == nil {
+				current.static = make(map[string]*node)
+			}
+			next, ok := current.static[part]
+			if !ok {
+				next = &node{}
+				current.static[part] = next
+			}
+			current = next
+		}
+	}
+
+	if current.routes == nil {
+		current.routes = make(map[string]*Route)
+	}
+	if existing, ok := current.routes[route.Method]; ok {
+		panic(fmt.Sprintf("router: %s %s is already registered as %s", route.Method, route.Pattern, existing.Pattern))
+	}
+	current.routes[route.Method] = route
 }
 
-// Helper function to split a path by "/"
-func splitPath(path string) []string {
-	path = strings.Trim(path, "/")
-	if path == "" {
-		return []string{}
+// child returns the parameter or catch-all child named name, creating it when missing.
+// Two patterns naming the same segment differently would shadow each other.
+func (n *node) child(existing *node, name string, route *Route) *node {
+	if name == "" {
+		panic(fmt.Sprintf("router: unnamed parameter in %s", route.Pattern))
 	}
-	return strings.Split(path, "/")
+	if existing == nil {
+		return &node{name: name}
+	}
+	if existing.name != name {
+		panic(fmt.Sprintf("router: parameter %s in %s conflicts with %s", name, route.Pattern, existing.name))
+	}
+	return existing
 }
 
-func matchPattern(pattern, path string) (map[string]string, bool) {
-	patternParts := splitPath(pattern)
-	pathParts := splitPath(path)
+type param struct {
+	key   string
+	value string
+}
+
+// lookup finds the node routing parts, backtracking from static segments to
+// parameters when a static branch leads nowhere
+func (n *node) lookup(parts []string, params []param) (*node, []param) {
+	if len(parts) == 0 {
+		if len(n.routes) > 0 {
+			return n, params
+		}
+		if n.catchAll != nil && len(n.catchAll.routes) > 0 {
+			return n.catchAll, append(params, param{n.catchAll.name, ""})
+		}
+		return nil, nil
+	}
 
-	if len(patternParts) != len(pathParts) {
-		return nil, false
+	if next, ok := n.static[parts[0]]; ok {
+		if found, foundParams := next.lookup(parts[1:], params); found != nil {
+			return found, foundParams
+		}
+	}
+	if n.param != nil {
+		if found, foundParams := n.param.lookup(parts[1:], append(params, param{n.param.name, parts[0]})); found != nil {
+			return found, foundParams
+		}
 	}
+	if n.catchAll != nil && len(n.catchAll.routes) > 0 {
+		return n.catchAll, append(params, param{n.catchAll.name, strings.Join(parts, "/")})
+	}
+	return nil, nil
+}
 
-	params := make(map[string]string)
-	for i, part := range patternParts {
-		if len(part) > 0 && part[0] == ':' {
-			key := part[1:]
-			params[key] = pathParts[i]
-		} else if part != pathParts[i] {
-			return nil, false
+// allow lists the methods a node answers, as for the Allow header
+func (n *node) allow() string {
+	methods := make([]string, 0, len(n.routes)+2)
+	for method := range n.routes {
+		methods = append(methods, method)
+	}
+	if _, ok := n.routes[http.MethodGet]; ok {
+		if _, ok := n.routes[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
 		}
 	}
+	if _, ok := n.routes[http.MethodOptions]; !ok {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
 
-	return params, true
+// Helper function to split a path by "/"
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
 }
@@ -0,0 +1,198 @@
+package theatre
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echo answers the name of the route and the parameters it was asked for.
+func echo(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := []string{name, r.Method}
+		for _, key := range params {
+			values = append(values, key+"="+GetParam(r.Context(), key))
+		}
+		_, _ = w.Write([]byte(strings.Join(values, " ")))
+	}
+}
+
+func request(router http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestRouterMatches(t *testing.T) {
+	router := NewRouter()
+	router.GET("/movies", echo("list"))
+	router.GET("/movies/latest", echo("latest"))
+	router.GET("/movies/:id", echo("movie", "id"))
+	router.GET("/movies/:id/poster", echo("poster", "id"))
+	router.GET("/movies/latest/:count/top", echo("top", "count"))
+	router.GET("/files/*path", echo("files", "path"))
+
+	cases := map[string]string{
+		"/movies":               "list GET",
+		"/movies/":              "list GET",
+		"/movies/latest":        "latest GET",
+		"/movies/42":            "movie GET id=42",
+		"/movies/42/poster":     "poster GET id=42",
+		"/movies/latest/poster": "poster GET id=latest",
+		"/movies/latest/5/top":  "top GET count=5",
+		"/files/a/b/c.mkv":      "files GET path=a/b/c.mkv",
+		"/files":                "files GET path=",
+	}
+	for path, want := range cases {
+		w := request(router, http.MethodGet, path)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("GET %s: %d %q, want %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+
+	for _, path := range []string{"/", "/series", "/movies/42/poster/big", "/movies/latest/5"} {
+		if w := request(router, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	router := NewRouter()
+	router.GET("/movies/:id", echo("get", "id"))
+	router.DELETE("/movies/:id", echo("delete", "id"))
+	router.POST("/movies/:id/poster", echo("post", "id"))
+	router.OPTIONS("/cors", echo("options"))
+
+	w := request(router, http.MethodHead, "/movies/7")
+	if w.Code != http.StatusOK || w.Body.String() != "get GET id=7" {
+		t.Errorf("HEAD answered %d %q by the GET route", w.Code, w.Body.String())
+	}
+
+	w = request(router, http.MethodPut, "/movies/7")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("PUT: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	w = request(router, http.MethodGet, "/movies/7/poster")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "OPTIONS, POST" {
+		t.Errorf("GET poster: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	w = request(router, http.MethodOptions, "/movies/7")
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	if w := request(router, http.MethodOptions, "/cors"); w.Body.String() != "options OPTIONS" {
+		t.Errorf("OPTIONS route not called: %q", w.Body.String())
+	}
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next(w, r)
+			}
+		}
+	}
+
+	router := NewRouter()
+	router.Use(trace("router"))
+	api := router.Group("/api", trace("api"))
+	v1 := api.Group("v1/", trace("v1"), trace("v1-second"))
+	v1.GET("/movies", func(w http.ResponseWriter, r *http.Request) {
+		route, _ := CurrentRoute(r.Context())
+		calls = append(calls, route.Pattern)
+	})
+	router.GET("/health", echo("health"))
+
+	request(router, http.MethodGet, "/api/v1/movies")
+	want := "router api v1 v1-second /api/v1/movies"
+	if got := strings.Join(calls, " "); got != want {
+		t.Errorf("calls %q, want %q", got, want)
+	}
+
+	calls = nil
+	request(router, http.MethodGet, "/health")
+	request(router, http.MethodGet, "/missing")
+	if got := strings.Join(calls, " "); got != "router router" {
+		t.Errorf("calls %q, the group middlewares must only run on their routes", got)
+	}
+}
+
+func TestRouterConflicts(t *testing.T) {
+	cases := map[string]func(*CustomRouter){
+		"duplicate": func(r *CustomRouter) {
+			r.GET("/movies/:id", echo("a"))
+			r.GET("/movies/:id", echo("b"))
+		},
+		"parameter names": func(r *CustomRouter) {
+			r.GET("/movies/:id", echo("a"))
+			r.GET("/movies/:movie/poster", echo("b"))
+		},
+		"catch-all not last": func(r *CustomRouter) {
+			r.GET("/files/*path/raw", echo("a"))
+		},
+		"unnamed parameter": func(r *CustomRouter) {
+			r.GET("/movies/:", echo("a"))
+		},
+	}
+	for name, register := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("registered without panicking")
+				}
+			}()
+			register(NewRouter())
+		})
+	}
+}
+
+// routes registers a REST resource of three routes for each of n resources.
+func routes(n int) *CustomRouter {
+	router := NewRouter()
+	api := router.Group("/api/v1")
+	for i := 0; i < n; i++ {
+		resource := fmt.Sprintf("/res%d", i)
+		api.GET(resource, echo("list"))
+		api.GET(resource+"/:id", echo("get"))
+		api.POST(resource+"/:id/files/*path", echo("upload"))
+	}
+	return router
+}
+
+func BenchmarkRouter(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		router := routes(n)
+		// the last resource registered, the worst case of a scan
+		path := fmt.Sprintf("/api/v1/res%d/42", n-1)
+
+		b.Run(fmt.Sprintf("%d_resources", n), func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				router.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func BenchmarkRouterNotAllowed(b *testing.B) {
+	router := routes(1000)
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/res999/42", nil)
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package theatre
+
+import (
+	"encoding/json"
+	"go-cinema/apperror"
+	repo "go-cinema/repository"
+	"go-cinema/search"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kashari/golog"
+)
+
+const (
+	searchLimit    = 20
+	maxSearchLimit = 100
+)
+
+// Search answers /search?q= with the movies, series and episodes matching q, best first.
+func Search(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /search handler, method: {}", r.Method)
+	if r.Method != http.MethodGet {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(search.Terms(q)) == 0 {
+		writeError(w, r, apperror.Validation("Missing search query", nil))
+		return
+	}
+
+	limit := searchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, r, apperror.Validation("Invalid limit", nil))
+			return
+		}
+		limit = min(parsed, maxSearchLimit)
+	}
+
+	hits, err := search.Search(r.Context(), repo.DB, q, limit)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error searching the library", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(hits)
+}
@@ -0,0 +1,179 @@
+package theatre
+
+import (
+	"encoding/json"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	repo "go-cinema/repository"
+	"go-cinema/stream"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kashari/golog"
+)
+
+// StreamToken is a signed URL playing one movie or episode without the Authorization header.
+type StreamToken struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StreamMovie streams the file of a movie, answering HEAD and range requests.
+func StreamMovie(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /stream/movie/:id handler, method: {}", r.Method)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
+		return
+	}
+	if err := canStream(r, auth.StreamMovie, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	movie, err := repo.MovieRepository.FindByID(id)
+	if err != nil {
+		writeError(w, r, apperror.Lookup("Movie not found", err))
+		return
+	}
+	streamFile(w, r, movie.Path)
+}
+
+// StreamEpisode streams the file of an episode, answering HEAD and range requests.
+func StreamEpisode(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /stream/episode/:id handler, method: {}", r.Method)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid episode ID", err))
+		return
+	}
+	if err := canStream(r, auth.StreamEpisode, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	episode, err := repo.EpisodeRepository.FindByID(id)
+	if err != nil {
+		writeError(w, r, apperror.Lookup("Episode not found", err))
+		return
+	}
+	streamFile(w, r, episode.Path)
+}
+
+// canStream lets through the users who may watch, and the clients holding a
+// stream token for this very movie or episode.
+func canStream(r *http.Request, kind string, id uint) error {
+	if user, ok := auth.CurrentUser(r.Context()); ok {
+		if !auth.Can(user, auth.PermWatch) {
+			return apperror.Forbidden("Forbidden: " + string(auth.PermWatch) + " permission required")
+		}
+		return nil
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return apperror.Unauthorized("Authentication or a stream token required")
+	}
+	if err := auth.CheckStreamToken(token, kind, id); err != nil {
+		return apperror.New(apperror.KindUnauthorized, "Invalid or expired stream token", err)
+	}
+	return nil
+}
+
+// streamFile serves the file at path, stored in the database, as long as it
+// lies inside the media roots.
+func streamFile(w http.ResponseWriter, r *http.Request, path string) {
+	resolved, err := mediaRoots().Resolve(path)
+	if err != nil {
+		writeError(w, r, fileLookup("Video not found", err))
+		return
+	}
+
+	src, err := stream.Open(resolved)
+	if err != nil {
+		writeError(w, r, fileLookup("Video not found", err))
+		return
+	}
+	defer src.Close()
+	serveSource(w, r, src)
+}
+
+func serveSource(w http.ResponseWriter, r *http.Request, src stream.Source) {
+	// the response has started when Serve fails, only the log is left to do
+	if err := stream.Serve(w, r, src); err != nil {
+		golog.Warn("Streaming {} failed: {}", src.Name(), err.Error())
+	}
+}
+
+// CreateMovieStreamToken answers with a stream token for the movie.
+func CreateMovieStreamToken(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /stream/movie/:id/token handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid movie ID", err))
+		return
+	}
+	if _, err := repo.MovieRepository.FindByID(id); err != nil {
+		writeError(w, r, apperror.Lookup("Movie not found", err))
+		return
+	}
+	writeStreamToken(w, r, auth.StreamMovie, id)
+}
+
+// CreateEpisodeStreamToken answers with a stream token for the episode.
+func CreateEpisodeStreamToken(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /stream/episode/:id/token handler, method: {}", r.Method)
+	if r.Method != http.MethodPost {
+		writeError(w, r, apperror.MethodNotAllowed())
+		return
+	}
+
+	id, err := uintParam(r, "id")
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid episode ID", err))
+		return
+	}
+	if _, err := repo.EpisodeRepository.FindByID(id); err != nil {
+		writeError(w, r, apperror.Lookup("Episode not found", err))
+		return
+	}
+	writeStreamToken(w, r, auth.StreamEpisode, id)
+}
+
+func writeStreamToken(w http.ResponseWriter, r *http.Request, kind string, id uint) {
+	token, expiresAt, err := auth.NewStreamToken(kind, id)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error signing the stream token", err))
+		return
+	}
+
+	// the stream is the path of the request without /token
+	response := StreamToken{
+		Token:     token,
+		URL:       strings.TrimSuffix(r.URL.Path, "/token") + "?token=" + url.QueryEscape(token),
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,49 @@
+package theatre
+
+import (
+	"fmt"
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"go-cinema/stream/streamtest"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamConformance(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+
+	movie := entity.Movie{Title: "Movie", Path: streamtest.WriteFile(t, media.Movies)}
+	if err := repo.MovieRepository.Save(&movie); err != nil {
+		t.Fatal(err)
+	}
+	serie := entity.Series{Title: "Serie", BaseDir: filepath.Join(media.Series, "Serie")}
+	if err := os.MkdirAll(serie.BaseDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SeriesRepository.Save(&serie); err != nil {
+		t.Fatal(err)
+	}
+	episode := entity.Episode{Path: streamtest.WriteFile(t, serie.BaseDir), EpisodeIndex: 1, SeriesID: serie.ID}
+	if err := repo.EpisodeRepository.Save(&episode); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, id := range map[string]uint{auth.StreamMovie: movie.ID, auth.StreamEpisode: episode.ID} {
+		t.Run(kind, func(t *testing.T) {
+			token, _, err := auth.NewStreamToken(kind, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := fmt.Sprintf("/api/v1/stream/%s/%d", kind, id)
+			streamtest.Run(t, handler, target+"?token="+url.QueryEscape(token))
+
+			anonymous := client{t: t, handler: handler}
+			expect(t, anonymous.do(http.MethodGet, target, nil, ""), http.StatusUnauthorized, nil)
+		})
+	}
+}
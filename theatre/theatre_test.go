@@ -0,0 +1,63 @@
+package theatre
+
+import (
+	"bytes"
+	"go-cinema/config"
+	"go-cinema/database/dbtest"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setup empties the database and points the handlers at a media directory of
+// their own.
+func setup(t *testing.T) config.Media {
+	t.Helper()
+	dbtest.Open(t)
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Media = config.Media{
+		Movies:    dir,
+		Series:    filepath.Join(dir, "Series"),
+		UsageData: filepath.Join(dir, "usage_data.io"),
+		Uploads:   filepath.Join(dir, ".uploads"),
+	}
+	cfg.Auth.JWTSecret = strings.Repeat("s", 32)
+	if err := os.MkdirAll(cfg.Media.Series, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Media
+}
+
+// multipartFile is a form holding the file of name with data, and fields.
+func multipartFile(t *testing.T, name string, data []byte, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for key, value := range fields {
+		_ = form.WriteField(key, value)
+	}
+	part, err := form.CreateFormFile("File", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(data)
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, form.FormDataContentType()
+}
+
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
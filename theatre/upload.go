@@ -0,0 +1,380 @@
+package theatre
+
+import (
+	"errors"
+	"fmt"
+	"go-cinema/apperror"
+	"go-cinema/auth"
+	entity "go-cinema/entities"
+	repo "go-cinema/repository"
+	"go-cinema/sandbox"
+	"go-cinema/upload"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kashari/golog"
+	"gorm.io/gorm"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+	maxUploadSize = 100 << 30
+	// uploadExpiry is how long an upload nobody writes to is kept
+	uploadExpiry = 7 * 24 * time.Hour
+	// uploadIdleTimeout cuts a chunk once no byte arrived for that long, so a
+	// dropped connection does not keep the upload locked
+	uploadIdleTimeout = time.Minute
+)
+
+// UploadOptions answers the tus discovery of what the server supports.
+func UploadOptions(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /uploads handler, method: {}", r.Method)
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(upload.Algorithms, ","))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload of a movie, or of an episode when the
+// metadata has kind episode and the series_id. The file is added to the library
+// once its last byte arrives.
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /uploads handler, method: {}", r.Method)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		writeError(w, r, apperror.Validation("Upload-Defer-Length is not supported, send Upload-Length", nil))
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		writeError(w, r, apperror.Validation("Invalid Upload-Length", err))
+		return
+	}
+	if size > maxUploadSize {
+		writeError(w, r, apperror.New(apperror.KindTooLarge, "Upload-Length exceeds Tus-Max-Size", nil))
+		return
+	}
+
+	metadata, err := upload.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeError(w, r, apperror.Validation("Invalid Upload-Metadata", err))
+		return
+	}
+	// refused now rather than after the last byte
+	if _, err := uploadDestination(metadata); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, _ := auth.CurrentUser(r.Context())
+	info, err := uploadStore().Create(size, metadata, user.ID)
+	if err != nil {
+		writeError(w, r, apperror.Storage("Error creating the upload", err))
+		return
+	}
+	golog.Info("Upload {} of {} bytes created by {}", info.ID, size, user.Username)
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+info.ID)
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UploadOffset answers where an upload stands, for the client to resume from there.
+func UploadOffset(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /uploads/:id handler, method: {}", r.Method)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	info, err := findUpload(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", upload.FormatMetadata(info.Metadata))
+	}
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload appends a chunk to an upload. The one completing it moves the
+// file into the library and creates its movie or episode, which the
+// Content-Location of the response points to.
+func PatchUpload(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /uploads/:id handler, method: {}", r.Method)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, r, apperror.New(apperror.KindUnsupportedMedia, "Content-Type must be application/offset+octet-stream", nil))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, r, apperror.Validation("Invalid Upload-Offset", err))
+		return
+	}
+	var checksum *upload.Checksum
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		if checksum, err = upload.ParseChecksum(header); err != nil {
+			writeError(w, r, apperror.Validation("Invalid Upload-Checksum", err))
+			return
+		}
+	}
+
+	if _, err := findUpload(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	u, err := uploadStore().Open(GetParam(r.Context(), "id"))
+	if err != nil {
+		writeError(w, r, uploadError(err))
+		return
+	}
+	defer u.Close()
+
+	// the read timeout of the server would cut large chunks, bytes arriving keep it going instead
+	rc := http.NewResponseController(w)
+	offset, err = u.Write(offset, &idleBody{body: r.Body, rc: rc}, checksum)
+	_ = rc.SetWriteDeadline(time.Now().Add(uploadIdleTimeout))
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		writeError(w, r, uploadError(err))
+		return
+	}
+
+	info := u.Info()
+	if info.Complete() {
+		location, err := finishUpload(r, u)
+		// copying into the library on another file system takes a while
+		_ = rc.SetWriteDeadline(time.Now().Add(uploadIdleTimeout))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Location", location)
+	} else {
+		setUploadExpires(w, &info)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload drops an upload and the bytes it received.
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	golog.Info("Request /uploads/:id handler, method: {}", r.Method)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if _, err := findUpload(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := uploadStore().Remove(GetParam(r.Context(), "id")); err != nil {
+		writeError(w, r, uploadError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTusResumable answers the requests in another version of tus.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeError(w, r, apperror.New(apperror.KindPrecondition, "Unsupported Tus-Resumable version, "+tusVersion+" is", nil))
+		return false
+	}
+	return true
+}
+
+// findUpload reads the upload of the request, others than its creator and the
+// admins do not see it.
+func findUpload(r *http.Request) (*upload.Info, error) {
+	info, err := uploadStore().Get(GetParam(r.Context(), "id"))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	user, _ := auth.CurrentUser(r.Context())
+	if info.UserID != user.ID && !auth.Can(user, auth.PermAdmin) {
+		return nil, apperror.NotFound("Upload not found", nil)
+	}
+	return info, nil
+}
+
+func uploadError(err error) *apperror.Error {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		return apperror.NotFound("Upload not found", err)
+	case errors.Is(err, upload.ErrLocked):
+		return apperror.New(apperror.KindLocked, "The upload is being written by another request", err)
+	case errors.Is(err, upload.ErrOffset):
+		return apperror.Conflict("Upload-Offset does not match the offset of the upload", err)
+	case errors.Is(err, upload.ErrTooLarge):
+		return apperror.New(apperror.KindTooLarge, "The chunk goes past Upload-Length", err)
+	case errors.Is(err, upload.ErrChecksum):
+		return apperror.New(apperror.KindChecksumMismatch, "The chunk does not match Upload-Checksum", err)
+	case errors.Is(err, upload.ErrInterrupted):
+		return apperror.Validation("The chunk was cut short, resume from Upload-Offset", err)
+	}
+	return apperror.Storage("Error writing the upload", err)
+}
+
+func setUploadExpires(w http.ResponseWriter, info *upload.Info) {
+	w.Header().Set("Upload-Expires", info.UpdatedAt.Add(uploadExpiry).Format(http.TimeFormat))
+}
+
+// idleBody pushes the read deadline back on every read.
+type idleBody struct {
+	body io.Reader
+	rc   *http.ResponseController
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	_ = b.rc.SetReadDeadline(time.Now().Add(uploadIdleTimeout))
+	return b.body.Read(p)
+}
+
+// destination is where a finished upload goes in the library.
+type destination struct {
+	dir   string
+	name  string
+	serie *entity.Series
+}
+
+// uploadDestination checks the metadata of an upload: a filename, and the
+// series_id of an episode.
+func uploadDestination(metadata map[string]string) (*destination, error) {
+	name := metadata["filename"]
+	if err := sandbox.CheckName(name); err != nil {
+		return nil, apperror.Validation("Invalid filename in Upload-Metadata", err)
+	}
+
+	dest := &destination{name: name}
+	switch metadata["kind"] {
+	case "", "movie":
+		dest.dir = currentMedia().Movies
+	case "episode":
+		id, err := strconv.ParseUint(metadata["series_id"], 10, 64)
+		if err != nil {
+			return nil, apperror.Validation("Invalid series_id in Upload-Metadata", err)
+		}
+		serie, err := repo.SeriesRepository.FindByID(uint(id))
+		if err != nil {
+			return nil, apperror.Lookup("Serie not found", err)
+		}
+		dest.dir = serie.BaseDir
+		dest.serie = serie
+	default:
+		return nil, apperror.Validation("Invalid kind in Upload-Metadata, movie or episode", nil)
+	}
+
+	path, err := mediaRoots().Join(dest.dir, name)
+	if err != nil {
+		return nil, fileStorage("Invalid filename", err)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return nil, apperror.Conflict(fmt.Sprintf("A file named %s already exists", name), nil)
+	}
+	return dest, nil
+}
+
+// finishUpload moves a complete upload into the library and creates its movie
+// or episode. On failure the upload stays, an empty PATCH at its end retries,
+// but for a file already in the library, which is dropped.
+func finishUpload(r *http.Request, u *upload.Upload) (string, error) {
+	info := u.Info()
+	dest, err := uploadDestination(info.Metadata)
+	if err != nil {
+		return "", err
+	}
+	path, err := mediaRoots().Join(dest.dir, dest.name)
+	if err != nil {
+		return "", fileStorage("Invalid filename", err)
+	}
+	accept, release := uniqueFile()
+	defer release()
+	sum, err := u.Commit(path, accept)
+	if err != nil {
+		var appErr *apperror.Error
+		if errors.As(err, &appErr) && appErr.Kind == apperror.KindConflict {
+			if err := u.Remove(); err != nil {
+				golog.Warn("Cannot remove the duplicate upload {}: {}", info.ID, err.Error())
+			}
+		}
+		return "", commitError(dest.name, err)
+	}
+
+	location, err := createUploaded(r, dest, path, info.Metadata, sum)
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	if err := u.Remove(); err != nil {
+		golog.Warn("Cannot remove the finished upload {}: {}", info.ID, err.Error())
+	}
+	golog.Info("Upload {} finished into {}", info.ID, path)
+	return location, nil
+}
+
+// createUploaded creates the record of the upload finished at path, and returns its URL.
+func createUploaded(r *http.Request, dest *destination, path string, metadata map[string]string, sum string) (string, error) {
+	api, _, _ := strings.Cut(r.URL.Path, "/uploads/")
+
+	if dest.serie == nil {
+		title := metadata["title"]
+		if title == "" {
+			title = strings.TrimSuffix(dest.name, filepath.Ext(dest.name))
+		}
+		movie := entity.Movie{
+			Title:       title,
+			Path:        path,
+			Description: metadata["description"],
+			Checksum:    sum,
+		}
+		if err := movie.Probe(movie.Path); err != nil {
+			golog.Warn("Cannot read media info of {}: {}", movie.Path, err)
+		}
+		if err := repo.MovieRepository.Save(&movie); err != nil {
+			return "", apperror.Storage("Error creating movie record", err)
+		}
+		return fmt.Sprintf("%s/movies/%d", api, movie.ID), nil
+	}
+
+	episodes, err := repo.EpisodeRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("series_id = ?", dest.serie.ID)
+	})
+	if err != nil {
+		return "", apperror.Storage("Error retrieving episodes", err)
+	}
+	episode := entity.Episode{
+		Path:         path,
+		EpisodeIndex: episodes.Size() + 1,
+		SeriesID:     dest.serie.ID,
+		Checksum:     sum,
+	}
+	if err := episode.Probe(episode.Path); err != nil {
+		golog.Warn("Cannot read media info of {}: {}", episode.Path, err)
+	}
+	if err := repo.EpisodeRepository.Save(&episode); err != nil {
+		return "", apperror.Storage("Error creating episode record", err)
+	}
+	return fmt.Sprintf("%s/series/%d/episodes", api, dest.serie.ID), nil
+}
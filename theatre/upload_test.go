@@ -0,0 +1,289 @@
+package theatre
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"go-cinema/model"
+	"go-cinema/upload"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const uploadsPath = "/api/v1/uploads"
+
+// tus sends a request of the tus protocol, with headers in pairs.
+func (c client) tus(method, path string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i+1] == "" {
+			r.Header.Del(headers[i])
+		} else {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+	}
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	return w
+}
+
+// create starts an upload of size bytes and returns its URL.
+func (c client) create(size int, metadata map[string]string) string {
+	c.t.Helper()
+	w := c.tus(http.MethodPost, uploadsPath, nil,
+		"Upload-Length", strconv.Itoa(size), "Upload-Metadata", upload.FormatMetadata(metadata))
+	expect(c.t, w, http.StatusCreated, nil)
+	location := w.Header().Get("Location")
+	if filepath.Dir(location) != uploadsPath || w.Header().Get("Upload-Expires") == "" {
+		c.t.Fatalf("created at %q, expiring %q", location, w.Header().Get("Upload-Expires"))
+	}
+	return location
+}
+
+// patch sends the chunk of data starting at offset.
+func (c client) patch(location string, data []byte, offset int, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.tus(http.MethodPatch, location, data[offset:], append([]string{
+		"Content-Type", "application/offset+octet-stream", "Upload-Offset", strconv.Itoa(offset),
+	}, headers...)...)
+}
+
+func offsetOf(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+	offset, err := strconv.Atoi(w.Header().Get("Upload-Offset"))
+	if err != nil {
+		t.Fatalf("Upload-Offset %q", w.Header().Get("Upload-Offset"))
+	}
+	return offset
+}
+
+func TestUploadDiscovery(t *testing.T) {
+	setup(t)
+	handler := SetupRoutes()
+	anonymous := client{t: t, handler: handler}
+
+	w := anonymous.tus(http.MethodOptions, uploadsPath, nil, "Tus-Resumable", "")
+	expect(t, w, http.StatusNoContent, nil)
+	for header, want := range map[string]string{
+		"Tus-Resumable": tusVersion,
+		"Tus-Version":   tusVersion,
+		"Tus-Extension": tusExtensions,
+		"Tus-Max-Size":  strconv.Itoa(maxUploadSize),
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s %q, want %q", header, got, want)
+		}
+	}
+
+	admin := signUp(t, handler, "alice")
+	w = admin.tus(http.MethodPost, uploadsPath, nil, "Tus-Resumable", "0.2.2", "Upload-Length", "10")
+	expect(t, w, http.StatusPreconditionFailed, nil)
+	if w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("another version refused without Tus-Version: %v", w.Header())
+	}
+	expect(t, admin.tus(http.MethodPost, uploadsPath, nil, "Tus-Resumable", "", "Upload-Length", "10"), http.StatusPreconditionFailed, nil)
+}
+
+func TestUploadCreation(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+	viewer := signUp(t, handler, "bob")
+	if err := os.WriteFile(filepath.Join(media.Movies, "taken.mkv"), []byte("taken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	movie := upload.FormatMetadata(map[string]string{"filename": "movie.mkv"})
+	cases := map[string]struct {
+		headers []string
+		status  int
+	}{
+		"no length":      {[]string{"Upload-Metadata", movie}, http.StatusBadRequest},
+		"negative":       {[]string{"Upload-Length", "-1", "Upload-Metadata", movie}, http.StatusBadRequest},
+		"deferred":       {[]string{"Upload-Defer-Length", "1", "Upload-Metadata", movie}, http.StatusBadRequest},
+		"too large":      {[]string{"Upload-Length", strconv.Itoa(maxUploadSize + 1), "Upload-Metadata", movie}, http.StatusRequestEntityTooLarge},
+		"bad metadata":   {[]string{"Upload-Length", "10", "Upload-Metadata", "filename !!!"}, http.StatusBadRequest},
+		"no filename":    {[]string{"Upload-Length", "10"}, http.StatusBadRequest},
+		"climbing":       {[]string{"Upload-Length", "10", "Upload-Metadata", upload.FormatMetadata(map[string]string{"filename": "../movie.mkv"})}, http.StatusBadRequest},
+		"existing file":  {[]string{"Upload-Length", "10", "Upload-Metadata", upload.FormatMetadata(map[string]string{"filename": "taken.mkv"})}, http.StatusConflict},
+		"unknown kind":   {[]string{"Upload-Length", "10", "Upload-Metadata", upload.FormatMetadata(map[string]string{"filename": "a.mkv", "kind": "song"})}, http.StatusBadRequest},
+		"missing series": {[]string{"Upload-Length", "10", "Upload-Metadata", upload.FormatMetadata(map[string]string{"filename": "a.mkv", "kind": "episode", "series_id": "999"})}, http.StatusNotFound},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect(t, admin.tus(http.MethodPost, uploadsPath, nil, c.headers...), c.status, nil)
+		})
+	}
+
+	expect(t, viewer.tus(http.MethodPost, uploadsPath, nil, "Upload-Length", "10", "Upload-Metadata", movie), http.StatusForbidden, nil)
+	expect(t, client{t: t, handler: handler}.tus(http.MethodPost, uploadsPath, nil, "Upload-Length", "10", "Upload-Metadata", movie),
+		http.StatusUnauthorized, nil)
+	if entries, _ := os.ReadDir(media.Uploads); len(entries) != 0 {
+		t.Errorf("refused uploads left %d files in the staging directory", len(entries))
+	}
+}
+
+func TestUploadMovie(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+
+	data := bytes.Repeat([]byte("a movie sent in chunks "), 1000)
+	location := admin.create(len(data), map[string]string{"filename": "chunked.mkv", "title": "Chunked", "description": "In parts"})
+
+	head := admin.tus(http.MethodHead, location, nil)
+	expect(t, head, http.StatusOK, nil)
+	if offsetOf(t, head) != 0 || head.Header().Get("Upload-Length") != strconv.Itoa(len(data)) || head.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("HEAD of a new upload %v", head.Header())
+	}
+	if metadata, _ := upload.ParseMetadata(head.Header().Get("Upload-Metadata")); metadata["title"] != "Chunked" {
+		t.Errorf("metadata %v", metadata)
+	}
+
+	// a first chunk of part of the file, the client resumes from the offset HEAD answers
+	first := admin.tus(http.MethodPatch, location, data[:5000],
+		"Content-Type", "application/offset+octet-stream", "Upload-Offset", "0")
+	expect(t, first, http.StatusNoContent, nil)
+	if offsetOf(t, first) != 5000 || first.Header().Get("Content-Location") != "" {
+		t.Fatalf("first chunk %v", first.Header())
+	}
+	offset := offsetOf(t, admin.tus(http.MethodHead, location, nil))
+	if offset != 5000 {
+		t.Fatalf("resuming from %d", offset)
+	}
+
+	refused := map[string]struct {
+		offset  int
+		headers []string
+		status  int
+	}{
+		"content type": {5000, []string{"Content-Type", "application/octet-stream"}, http.StatusUnsupportedMediaType},
+		"behind":       {0, nil, http.StatusConflict},
+		"bad offset":   {5000, []string{"Upload-Offset", "-1"}, http.StatusBadRequest},
+		"checksum":     {5000, []string{"Upload-Checksum", "sha256 " + base64.StdEncoding.EncodeToString(make([]byte, 32))}, 460},
+		"algorithm":    {5000, []string{"Upload-Checksum", "crc32 AAAA"}, http.StatusBadRequest},
+	}
+	for name, c := range refused {
+		t.Run(name, func(t *testing.T) {
+			expect(t, admin.patch(location, data, c.offset, c.headers...), c.status, nil)
+			if got := offsetOf(t, admin.tus(http.MethodHead, location, nil)); got != 5000 {
+				t.Errorf("the upload moved to %d", got)
+			}
+		})
+	}
+	expect(t, admin.tus(http.MethodPatch, location, append(data[5000:], 'x'),
+		"Content-Type", "application/offset+octet-stream", "Upload-Offset", "5000"), http.StatusRequestEntityTooLarge, nil)
+
+	sum := sha256.Sum256(data[5000:])
+	last := admin.patch(location, data, 5000, "Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(sum[:]))
+	expect(t, last, http.StatusNoContent, nil)
+	if offsetOf(t, last) != len(data) {
+		t.Errorf("last chunk answered offset %d", offsetOf(t, last))
+	}
+
+	var movie struct {
+		Title, Description string
+	}
+	expect(t, admin.do(http.MethodGet, last.Header().Get("Content-Location"), nil, ""), http.StatusOK, &movie)
+	if movie.Title != "Chunked" || movie.Description != "In parts" {
+		t.Errorf("created %+v at %s", movie, last.Header().Get("Content-Location"))
+	}
+	if got, _ := os.ReadFile(filepath.Join(media.Movies, "chunked.mkv")); !bytes.Equal(got, data) {
+		t.Errorf("the library holds %d bytes of %d", len(got), len(data))
+	}
+	whole := sha256.Sum256(data)
+	if sum, _ := upload.SumFile(filepath.Join(media.Movies, "chunked.mkv")); sum != hex.EncodeToString(whole[:]) {
+		t.Errorf("file sum %s", sum)
+	}
+	expect(t, admin.tus(http.MethodHead, location, nil), http.StatusNotFound, nil)
+	if entries, _ := os.ReadDir(media.Uploads); len(entries) != 0 {
+		t.Errorf("the finished upload left %d files in the staging directory", len(entries))
+	}
+
+	// the same bytes under another name are refused once complete, and dropped
+	again := admin.create(len(data), map[string]string{"filename": "again.mkv"})
+	expect(t, admin.patch(again, data, 0), http.StatusConflict, nil)
+	expect(t, admin.tus(http.MethodHead, again, nil), http.StatusNotFound, nil)
+	if _, err := os.Stat(filepath.Join(media.Movies, "again.mkv")); !os.IsNotExist(err) {
+		t.Errorf("the duplicate reached the library: %v", err)
+	}
+}
+
+func TestUploadEpisode(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+
+	var serie struct{ ID uint }
+	expect(t, admin.json(http.MethodPost, "/api/v1/series", map[string]string{"Title": "Lost"}), http.StatusCreated, &serie)
+	data := []byte("the pilot")
+	location := admin.create(len(data), map[string]string{
+		"filename": "pilot.mkv", "kind": "episode", "series_id": strconv.Itoa(int(serie.ID)),
+	})
+	w := admin.patch(location, data, 0)
+	expect(t, w, http.StatusNoContent, nil)
+	if got := w.Header().Get("Content-Location"); got != fmt.Sprintf("/api/v1/series/%d/episodes", serie.ID) {
+		t.Errorf("Content-Location %q", got)
+	}
+
+	var episodes []struct{ EpisodeIndex int }
+	expect(t, admin.do(http.MethodGet, fmt.Sprintf("/api/v1/series/%d/episodes", serie.ID), nil, ""), http.StatusOK, &episodes)
+	if len(episodes) != 1 || episodes[0].EpisodeIndex != 1 {
+		t.Errorf("episodes %+v", episodes)
+	}
+	if got, _ := os.ReadFile(filepath.Join(media.Series, "Lost", "pilot.mkv")); !bytes.Equal(got, data) {
+		t.Errorf("episode file %q", got)
+	}
+}
+
+func TestUploadOwnerAndDelete(t *testing.T) {
+	media := setup(t)
+	handler := SetupRoutes()
+	admin := signUp(t, handler, "alice")
+	editors := make([]client, 2)
+	for i, name := range []string{"carol", "dave"} {
+		editors[i] = signUp(t, handler, name)
+		var user model.User
+		expect(t, editors[i].do(http.MethodGet, "/api/v1/me", nil, ""), http.StatusOK, &user)
+		expect(t, admin.json(http.MethodPut, fmt.Sprintf("/api/v1/users/%d/role", user.ID), map[string]string{"role": model.RoleEditor}),
+			http.StatusOK, nil)
+	}
+	owner, other := editors[0], editors[1]
+
+	data := []byte("a movie")
+	location := owner.create(len(data), map[string]string{"filename": "mine.mkv"})
+
+	// others than the creator and the admins do not see the upload
+	expect(t, other.tus(http.MethodHead, location, nil), http.StatusNotFound, nil)
+	expect(t, other.patch(location, data, 0), http.StatusNotFound, nil)
+	expect(t, other.tus(http.MethodDelete, location, nil), http.StatusNotFound, nil)
+	expect(t, admin.tus(http.MethodHead, location, nil), http.StatusOK, nil)
+
+	expect(t, owner.tus(http.MethodDelete, location, nil, "Tus-Resumable", ""), http.StatusPreconditionFailed, nil)
+	expect(t, owner.tus(http.MethodDelete, location, nil), http.StatusNoContent, nil)
+	expect(t, owner.tus(http.MethodHead, location, nil), http.StatusNotFound, nil)
+	expect(t, owner.patch(location, data, 0), http.StatusNotFound, nil)
+	expect(t, owner.tus(http.MethodDelete, location, nil), http.StatusNotFound, nil)
+	if entries, _ := os.ReadDir(media.Uploads); len(entries) != 0 {
+		t.Errorf("the deleted upload left %d files in the staging directory", len(entries))
+	}
+
+	expect(t, owner.tus(http.MethodHead, uploadsPath+"/..%2F..%2Fetc%2Fpasswd", nil), http.StatusNotFound, nil)
+	expect(t, owner.tus(http.MethodHead, uploadsPath+"/0123", nil), http.StatusNotFound, nil)
+}
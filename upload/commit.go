@@ -0,0 +1,171 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrExists is returned when a file would replace another one.
+	ErrExists = errors.New("file already exists")
+	// ErrSize is returned for a file which is not as long as announced.
+	ErrSize = errors.New("size mismatch")
+
+	errNoReplace = errors.New("the file system can neither link nor rename without replacing")
+)
+
+// Expect is what a file is checked against before it takes its place. A
+// negative Size, an empty SHA256 and a nil Accept are not checked.
+type Expect struct {
+	Size   int64
+	SHA256 []byte
+	// Accept gets the hex SHA-256 of the file, an error keeps the file out, as
+	// for a file already in the library
+	Accept func(sum string) error
+}
+
+func (want Expect) check(n int64, sum []byte) error {
+	if want.Size >= 0 && n != want.Size {
+		return fmt.Errorf("%w: %d bytes received, %d announced", ErrSize, n, want.Size)
+	}
+	if len(want.SHA256) > 0 && !bytes.Equal(sum, want.SHA256) {
+		return fmt.Errorf("%w: %x received, %x announced", ErrChecksum, sum, want.SHA256)
+	}
+	if want.Accept != nil {
+		return want.Accept(hex.EncodeToString(sum))
+	}
+	return nil
+}
+
+// Commit writes what r reads to path, through a temp file in the same
+// directory which is synced, checked against want, then linked into place. A
+// failed copy never leaves part of a file behind, and an existing file is
+// never replaced. It returns the hex SHA-256 of the file.
+func Commit(path string, r io.Reader, want Expect) (string, error) {
+	dir := filepath.Dir(path)
+	// hidden and without a video extension, the library skips it
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return "", err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", err
+	}
+	sum := h.Sum(nil)
+	if err := want.check(n, sum); err != nil {
+		return "", err
+	}
+
+	// CreateTemp makes files only the owner reads, as os.Create would
+	if err := tmp.Chmod(0o644); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := link(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	committed = true
+	syncDir(dir)
+	return hex.EncodeToString(sum), nil
+}
+
+// link gives src the name dst, failing when dst exists. A hard link does
+// that in one step, file systems without them get a rename which does too.
+// Where there is none, the file is left out rather than risk replacing another.
+func link(src, dst string) error {
+	err := os.Link(src, dst)
+	switch {
+	case err == nil:
+		return os.Remove(src)
+	case errors.Is(err, os.ErrExist):
+		return fmt.Errorf("%w: %s", ErrExists, dst)
+	}
+	return renameNoReplace(src, dst)
+}
+
+// SumFile is the hex SHA-256 of the file at path, as Commit returns it, for the
+// files which came into the library another way.
+func SumFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// syncDir makes a new name in dir last through a crash. Not every system
+// syncs directories, it is best effort.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// Commit moves the complete upload to path, which must not exist. The upload
+// is checked against its length and the SHA-256 of the chunks as they arrived,
+// then by accept when not nil. It returns the hex SHA-256 of the file, the
+// upload is left to Remove.
+func (u *Upload) Commit(path string, accept func(sum string) error) (string, error) {
+	if !u.info.Complete() {
+		return "", fmt.Errorf("upload %s: %d of %d bytes received", u.info.ID, u.info.Offset, u.info.Size)
+	}
+	sum, err := u.Sum()
+	if err != nil {
+		return "", err
+	}
+	stat, err := os.Stat(u.Path())
+	if err != nil {
+		return "", err
+	}
+	want := Expect{Size: u.info.Size, SHA256: sum, Accept: accept}
+	if err := want.check(stat.Size(), sum); err != nil {
+		return "", fmt.Errorf("upload %s: %w", u.info.ID, err)
+	}
+
+	// the staged data was synced chunk by chunk, a link is enough
+	err = os.Link(u.Path(), path)
+	switch {
+	case err == nil:
+		syncDir(filepath.Dir(path))
+		return hex.EncodeToString(sum), nil
+	case errors.Is(err, os.ErrExist):
+		return "", fmt.Errorf("%w: %s", ErrExists, path)
+	}
+
+	// another file system, the copy is checked against what was received
+	file, err := os.Open(u.Path())
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	want.Accept = nil
+	return Commit(path, file, want)
+}
@@ -0,0 +1,106 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mkv")
+	data := []byte("some video")
+	want := sha256.Sum256(data)
+
+	sum, err := Commit(path, bytes.NewReader(data), Expect{Size: int64(len(data)), SHA256: want[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != hex.EncodeToString(want[:]) {
+		t.Errorf("sum %s, want %x", sum, want)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Errorf("file holds %q", got)
+	}
+	if fileSum, err := SumFile(path); err != nil || fileSum != sum {
+		t.Errorf("SumFile = %s, %v", fileSum, err)
+	}
+}
+
+func TestCommitRefused(t *testing.T) {
+	data := []byte("some video")
+	refused := errors.New("refused")
+	cases := map[string]struct {
+		want Expect
+		err  error
+	}{
+		"short":    {Expect{Size: int64(len(data)) + 1}, ErrSize},
+		"checksum": {Expect{Size: -1, SHA256: make([]byte, sha256.Size)}, ErrChecksum},
+		"accept":   {Expect{Size: -1, Accept: func(string) error { return refused }}, refused},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := Commit(filepath.Join(dir, "a.mkv"), bytes.NewReader(data), c.want)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("err = %v, want %v", err, c.err)
+			}
+			// neither the file nor its temp file are left
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("left %v behind", entries)
+			}
+		})
+	}
+}
+
+func TestCommitNeverReplaces(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mkv")
+	if err := os.WriteFile(path, []byte("first"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Commit(path, strings.NewReader("second"), Expect{Size: -1})
+	if !errors.Is(err, ErrExists) {
+		t.Fatalf("err = %v, want ErrExists", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "first" {
+		t.Errorf("file replaced by %q", got)
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	for _, name := range []string{src, dst} {
+		if err := os.WriteFile(name, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := renameNoReplace(src, dst)
+	if errors.Is(err, errNoReplace) {
+		t.Skip(err)
+	}
+	if !errors.Is(err, ErrExists) {
+		t.Fatalf("err = %v, want ErrExists", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != dst {
+		t.Errorf("dst replaced by %q", got)
+	}
+
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := renameNoReplace(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != src {
+		t.Errorf("dst holds %q", got)
+	}
+}
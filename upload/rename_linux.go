@@ -0,0 +1,25 @@
+//go:build linux
+
+package upload
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameNoReplace renames src to dst in one step which fails when dst exists.
+func renameNoReplace(src, dst string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, src, unix.AT_FDCWD, dst, unix.RENAME_NOREPLACE)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EEXIST):
+		return fmt.Errorf("%w: %s", ErrExists, dst)
+	case errors.Is(err, unix.EINVAL), errors.Is(err, unix.ENOSYS):
+		// kernels before 3.15, and file systems without the flag
+		return fmt.Errorf("%w: %s", errNoReplace, dst)
+	}
+	return err
+}
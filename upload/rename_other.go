@@ -0,0 +1,11 @@
+//go:build !linux
+
+package upload
+
+import "fmt"
+
+// renameNoReplace would rename src to dst unless dst exists. Only Linux has a
+// rename which checks that in the same step.
+func renameNoReplace(src, dst string) error {
+	return fmt.Errorf("%w: %s", errNoReplace, dst)
+}
@@ -0,0 +1,480 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("upload not found")
+	// ErrLocked is returned while another request writes to the upload.
+	ErrLocked = errors.New("upload is being written by another request")
+	// ErrOffset is returned for a chunk which does not start where the upload stands.
+	ErrOffset = errors.New("chunk does not start at the offset of the upload")
+	// ErrTooLarge is returned for a chunk going past the length of the upload.
+	ErrTooLarge = errors.New("chunk goes past the length of the upload")
+	// ErrChecksum is returned for a chunk which does not match its checksum, it is dropped.
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrInterrupted is returned when the client stopped sending a chunk, the
+	// bytes which arrived are kept.
+	ErrInterrupted = errors.New("chunk interrupted")
+	// ErrAlgorithm is returned for a checksum in an algorithm which is not supported.
+	ErrAlgorithm = errors.New("unsupported checksum algorithm")
+)
+
+// Algorithms lists the checksum algorithms chunks can be verified with.
+var Algorithms = []string{"sha256", "sha1", "md5"}
+
+// Info is the state of an upload, saved next to its data so it is resumed
+// after a restart.
+type Info struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	// UserID is the user who created the upload, the only one who may write to it
+	UserID    uint      `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Hash is the state of the SHA-256 of the bytes received so far
+	Hash []byte `json:"hash"`
+}
+
+// Complete tells whether every byte of the upload was received.
+func (i *Info) Complete() bool {
+	return i.Offset == i.Size
+}
+
+// Store keeps the partial uploads in a staging directory: the data of each
+// one in <id>.bin, its Info in <id>.info.
+type Store struct {
+	dir    string
+	expiry time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewStore keeps the uploads in dir, which is created on the first upload.
+// Uploads left untouched for expiry are removed.
+func NewStore(dir string, expiry time.Duration) *Store {
+	return &Store{dir: dir, expiry: expiry, busy: make(map[string]bool)}
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// Create starts an upload of size bytes.
+func (s *Store) Create(size int64, metadata map[string]string, userID uint) (*Info, error) {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, err
+	}
+	s.prune()
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	state, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	info := &Info{
+		ID:        id,
+		Size:      size,
+		Metadata:  metadata,
+		UserID:    userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Hash:      state,
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	if err := s.save(info); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return info, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID keeps ids sent by clients from naming files outside the store.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Get reads the state of an upload.
+func (s *Store) Get(id string) (*Info, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("upload %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+// save replaces the info file in one rename, a crash leaves the old one or the new one.
+func (s *Store) save(info *Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.ID))
+}
+
+// Remove drops an upload and its data, unless a request is writing to it.
+func (s *Store) Remove(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	upload, err := s.Open(id)
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+	return upload.Remove()
+}
+
+func (s *Store) remove(id string) error {
+	err := os.Remove(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// prune removes the uploads nobody wrote to for the expiry duration.
+func (s *Store) prune() {
+	if s.expiry <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || s.isBusy(id) {
+			continue
+		}
+		info, err := s.Get(id)
+		if err != nil || time.Since(info.UpdatedAt) < s.expiry {
+			continue
+		}
+		_ = s.Remove(id)
+	}
+}
+
+func (s *Store) isBusy(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.busy[id]
+}
+
+// Open locks the upload for writing, until the Upload is closed. The data is
+// brought back in line with the info, should the server have stopped between
+// writing a chunk and saving its offset.
+func (s *Store) Open(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	s.mu.Lock()
+	if s.busy[id] {
+		s.mu.Unlock()
+		return nil, ErrLocked
+	}
+	s.busy[id] = true
+	s.mu.Unlock()
+
+	info, err := s.Get(id)
+	if err != nil {
+		s.unlock(id)
+		return nil, err
+	}
+	u := &Upload{store: s, info: info}
+	if err := u.recover(); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Store) unlock(id string) {
+	s.mu.Lock()
+	delete(s.busy, id)
+	s.mu.Unlock()
+}
+
+// Upload is an upload locked for writing.
+type Upload struct {
+	store *Store
+	info  *Info
+}
+
+func (u *Upload) Info() Info {
+	return *u.info
+}
+
+// Path is the file holding the bytes of the upload.
+func (u *Upload) Path() string {
+	return u.store.dataPath(u.info.ID)
+}
+
+// Close unlocks the upload.
+func (u *Upload) Close() {
+	u.store.unlock(u.info.ID)
+}
+
+// Remove drops the upload, once its data was moved into the library.
+func (u *Upload) Remove() error {
+	return u.store.remove(u.info.ID)
+}
+
+// recover truncates the bytes written after the last saved offset, or hashes
+// the data again when the file lost bytes the info counted.
+func (u *Upload) recover() error {
+	stat, err := os.Stat(u.store.dataPath(u.info.ID))
+	if err != nil {
+		return err
+	}
+	switch {
+	case stat.Size() > u.info.Offset:
+		return os.Truncate(u.store.dataPath(u.info.ID), u.info.Offset)
+	case stat.Size() < u.info.Offset:
+		file, err := os.Open(u.store.dataPath(u.info.ID))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		h := sha256.New()
+		n, err := io.Copy(h, file)
+		if err != nil {
+			return err
+		}
+		if u.info.Hash, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return err
+		}
+		u.info.Offset = n
+		return u.store.save(u.info)
+	}
+	return nil
+}
+
+func (u *Upload) hash() (hash.Hash, error) {
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.info.Hash); err != nil {
+		return nil, fmt.Errorf("upload %s: hash state: %w", u.info.ID, err)
+	}
+	return h, nil
+}
+
+// Sum is the SHA-256 of the bytes received so far, of the whole file once complete.
+func (u *Upload) Sum() ([]byte, error) {
+	h, err := u.hash()
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Checksum is the expected checksum of a chunk, from an Upload-Checksum header.
+type Checksum struct {
+	hash hash.Hash
+	sum  []byte
+}
+
+// ParseChecksum parses "<algorithm> <base64 digest>".
+func ParseChecksum(header string) (*Checksum, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, fmt.Errorf("invalid checksum %q", header)
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum %q: %w", header, err)
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrAlgorithm, algorithm)
+	}
+	return &Checksum{hash: h, sum: sum}, nil
+}
+
+// Write appends the chunk read from r, which has to start at offset. A chunk
+// cut short by the client is kept, one failing its checksum is dropped whole.
+// It returns the offset of the upload after the chunk.
+func (u *Upload) Write(offset int64, r io.Reader, checksum *Checksum) (int64, error) {
+	if offset != u.info.Offset {
+		return u.info.Offset, ErrOffset
+	}
+
+	path := u.store.dataPath(u.info.ID)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	h, err := u.hash()
+	if err != nil {
+		return offset, err
+	}
+	writers := []io.Writer{file, h}
+	if checksum != nil {
+		writers = append(writers, checksum.hash)
+	}
+
+	// one byte more than left tells a chunk going past the end
+	remaining := u.info.Size - offset
+	chunk := &chunkReader{r: io.LimitReader(r, remaining+1)}
+	n, copyErr := io.Copy(io.MultiWriter(writers...), chunk)
+
+	var failed error
+	switch {
+	case n > remaining:
+		failed = ErrTooLarge
+	case checksum != nil && copyErr != nil:
+		// the checksum is of the whole chunk, a part of it cannot be verified
+		failed = copyErr
+	case checksum != nil && !bytes.Equal(checksum.hash.Sum(nil), checksum.sum):
+		failed = ErrChecksum
+	case copyErr != nil && copyErr != chunk.err:
+		// writing to the file failed, not reading from the client
+		failed = copyErr
+	}
+	if failed != nil {
+		return offset, errors.Join(failed, file.Truncate(offset))
+	}
+
+	if err := file.Sync(); err != nil {
+		return offset, errors.Join(err, file.Truncate(offset))
+	}
+	if u.info.Hash, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return offset, err
+	}
+	u.info.Offset = offset + n
+	u.info.UpdatedAt = time.Now().UTC()
+	if err := u.store.save(u.info); err != nil {
+		return offset, err
+	}
+	if copyErr != nil {
+		return u.info.Offset, fmt.Errorf("%w: %w", ErrInterrupted, copyErr)
+	}
+	return u.info.Offset, nil
+}
+
+// chunkReader keeps the error of reading the chunk, to tell it from the
+// errors of writing it.
+type chunkReader struct {
+	r   io.Reader
+	err error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// ParseMetadata parses an Upload-Metadata header: comma separated pairs of a
+// key and its base64 value, the value can be left out.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// FormatMetadata is the Upload-Metadata header of metadata.
+func FormatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
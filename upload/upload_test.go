@@ -0,0 +1,299 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// open creates an upload of data in store and opens it.
+func open(t *testing.T, store *Store, data []byte) *Upload {
+	t.Helper()
+	info, err := store.Create(int64(len(data)), map[string]string{"filename": "movie.mkv"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := store.Open(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func checksum(t *testing.T, header string) *Checksum {
+	t.Helper()
+	c, err := ParseChecksum(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWriteInChunks(t *testing.T) {
+	store := NewStore(t.TempDir(), time.Hour)
+	data := []byte("a video sent in three chunks")
+	u := open(t, store, data)
+	defer u.Close()
+
+	for _, end := range []int{5, 20, len(data)} {
+		offset, err := u.Write(u.Info().Offset, bytes.NewReader(data[u.Info().Offset:end]), nil)
+		if err != nil || offset != int64(end) {
+			t.Fatalf("chunk to %d: offset %d, %v", end, offset, err)
+		}
+	}
+	info := u.Info()
+	if !info.Complete() {
+		t.Fatalf("upload at %d of %d", info.Offset, info.Size)
+	}
+	if got, _ := os.ReadFile(u.Path()); !bytes.Equal(got, data) {
+		t.Errorf("data %q", got)
+	}
+	want := sha256.Sum256(data)
+	if sum, err := u.Sum(); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("sum %x, %v, want %x", sum, err, want)
+	}
+}
+
+func TestWriteRefused(t *testing.T) {
+	data := []byte("0123456789")
+	sum := md5.Sum(data[:4])
+	cases := map[string]struct {
+		offset   int64
+		chunk    []byte
+		checksum string
+		err      error
+	}{
+		"offset behind":  {offset: 0, chunk: data[:4], err: ErrOffset},
+		"offset ahead":   {offset: 8, chunk: data[8:], err: ErrOffset},
+		"past the end":   {offset: 4, chunk: append(data[4:], 'x'), err: ErrTooLarge},
+		"checksum":       {offset: 4, chunk: data[4:8], checksum: "md5 " + base64.StdEncoding.EncodeToString(sum[:]), err: ErrChecksum},
+		"cut with a sum": {offset: 4, chunk: nil, checksum: "md5 " + base64.StdEncoding.EncodeToString(sum[:]), err: io.ErrUnexpectedEOF},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			store := NewStore(t.TempDir(), time.Hour)
+			u := open(t, store, data)
+			defer u.Close()
+			if _, err := u.Write(0, bytes.NewReader(data[:4]), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader = bytes.NewReader(c.chunk)
+			if c.chunk == nil {
+				r = io.MultiReader(strings.NewReader("45"), failingReader{io.ErrUnexpectedEOF})
+			}
+			var sum *Checksum
+			if c.checksum != "" {
+				sum = checksum(t, c.checksum)
+			}
+			offset, err := u.Write(c.offset, r, sum)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("Write: %v, want %v", err, c.err)
+			}
+			// the upload stands where it was, the refused bytes are dropped
+			if offset != 4 || u.Info().Offset != 4 {
+				t.Errorf("offset %d, upload at %d, want 4", offset, u.Info().Offset)
+			}
+			if got, _ := os.ReadFile(u.Path()); !bytes.Equal(got, data[:4]) {
+				t.Errorf("data %q", got)
+			}
+		})
+	}
+}
+
+// failingReader fails every read with err.
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestWriteInterrupted(t *testing.T) {
+	store := NewStore(t.TempDir(), time.Hour)
+	data := []byte("0123456789")
+	u := open(t, store, data)
+	defer u.Close()
+
+	offset, err := u.Write(0, io.MultiReader(strings.NewReader("0123"), failingReader{io.ErrUnexpectedEOF}), nil)
+	if !errors.Is(err, ErrInterrupted) || offset != 4 {
+		t.Fatalf("Write: %d, %v", offset, err)
+	}
+	// the bytes which arrived are kept, the client resumes after them
+	if _, err := u.Write(4, bytes.NewReader(data[4:]), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(data)
+	if sum, _ := u.Sum(); !bytes.Equal(sum, want[:]) {
+		t.Errorf("sum %x, want %x", sum, want)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data := []byte("a chunk")
+	sha := sha256.Sum256(data)
+	store := NewStore(t.TempDir(), time.Hour)
+	u := open(t, store, data)
+	defer u.Close()
+	if _, err := u.Write(0, bytes.NewReader(data), checksum(t, "sha256 "+base64.StdEncoding.EncodeToString(sha[:]))); err != nil {
+		t.Fatalf("a chunk matching its checksum: %v", err)
+	}
+
+	for _, header := range []string{"sha256", "sha256 !!!", "crc32 AAAA"} {
+		if _, err := ParseChecksum(header); err == nil {
+			t.Errorf("ParseChecksum(%q) accepted", header)
+		}
+	}
+	if _, err := ParseChecksum("crc32 AAAA"); !errors.Is(err, ErrAlgorithm) {
+		t.Errorf("unknown algorithm: %v", err)
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("0123456789")
+	before := NewStore(dir, time.Hour)
+	u := open(t, before, data)
+	if _, err := u.Write(0, bytes.NewReader(data[:6]), nil); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+	// the server stopped after writing bytes but before saving their offset
+	file, err := os.OpenFile(u.Path(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("more than the rest")
+	file.Close()
+
+	after := NewStore(dir, time.Hour)
+	info, err := after.Get(u.Info().ID)
+	if err != nil || info.Offset != 6 || info.Metadata["filename"] != "movie.mkv" {
+		t.Fatalf("Get after a restart: %+v, %v", info, err)
+	}
+	resumed, err := after.Open(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if _, err := resumed.Write(6, bytes.NewReader(data[6:]), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(resumed.Path()); !bytes.Equal(got, data) {
+		t.Errorf("data %q, the bytes past the offset are not dropped", got)
+	}
+	want := sha256.Sum256(data)
+	if sum, _ := resumed.Sum(); !bytes.Equal(sum, want[:]) {
+		t.Errorf("sum %x, want %x", sum, want)
+	}
+}
+
+func TestResumeLostBytes(t *testing.T) {
+	data := []byte("0123456789")
+	store := NewStore(t.TempDir(), time.Hour)
+	u := open(t, store, data)
+	if _, err := u.Write(0, bytes.NewReader(data[:6]), nil); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+	// the file system lost bytes the info counted
+	if err := os.Truncate(u.Path(), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := store.Open(u.Info().ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if resumed.Info().Offset != 3 {
+		t.Fatalf("resumed at %d, want 3", resumed.Info().Offset)
+	}
+	if _, err := resumed.Write(3, bytes.NewReader(data[3:]), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(data)
+	if sum, _ := resumed.Sum(); !bytes.Equal(sum, want[:]) {
+		t.Errorf("sum %x, want %x", sum, want)
+	}
+}
+
+func TestLockAndRemove(t *testing.T) {
+	store := NewStore(t.TempDir(), time.Hour)
+	u := open(t, store, []byte("data"))
+	id := u.Info().ID
+
+	if _, err := store.Open(id); !errors.Is(err, ErrLocked) {
+		t.Errorf("opened twice: %v", err)
+	}
+	if err := store.Remove(id); !errors.Is(err, ErrLocked) {
+		t.Errorf("removed while written: %v", err)
+	}
+	u.Close()
+
+	if err := store.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a removed upload: %v", err)
+	}
+	if _, err := os.Stat(u.Path()); !os.IsNotExist(err) {
+		t.Errorf("data left behind: %v", err)
+	}
+	for _, id := range []string{"", "../../etc/passwd", strings.Repeat("z", 32)} {
+		if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): %v", id, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store := NewStore(t.TempDir(), time.Hour)
+	stale := open(t, store, []byte("data"))
+	stale.Close()
+	info := stale.Info()
+	info.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	if err := store.save(&info); err != nil {
+		t.Fatal(err)
+	}
+	busy := open(t, store, []byte("data"))
+	defer busy.Close()
+	busyInfo := busy.Info()
+	busyInfo.UpdatedAt = info.UpdatedAt
+	if err := store.save(&busyInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// creating an upload prunes the stale ones, but for those being written
+	if _, err := store.Create(4, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(info.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the stale upload is kept: %v", err)
+	}
+	if _, err := store.Get(busyInfo.ID); err != nil {
+		t.Errorf("the upload being written is pruned: %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	metadata, err := ParseMetadata("filename bW92aWUubWt2, title VGhlIE1hdHJpeA==,is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"filename": "movie.mkv", "title": "The Matrix", "is_confidential": ""}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Errorf("metadata %v, want %v", metadata, want)
+	}
+	if back, _ := ParseMetadata(FormatMetadata(metadata)); !reflect.DeepEqual(back, want) {
+		t.Errorf("round trip %v", back)
+	}
+	if _, err := ParseMetadata("filename !!!"); err == nil {
+		t.Error("invalid base64 accepted")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"go-cinema/auth"
+	"go-cinema/model"
+	repo "go-cinema/repository"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var userCommand = &command{
+	name:    "user",
+	summary: "Manage the accounts.",
+	subcommands: []*command{
+		{
+			name:    "add",
+			args:    "USERNAME EMAIL",
+			summary: "Create an account. The password is read from the standard input unless -password is given.",
+			setup: func(fs *flag.FlagSet) runner {
+				role := fs.String("role", "", "The role: admin, editor, viewer or kid. The first account is an admin, the others viewers by default.")
+				password := fs.String("password", "", "The password, visible to other users of the machine, prefer the standard input.")
+				return func(a *app, args []string) error {
+					if len(args) != 2 {
+						return errUsage
+					}
+					if *role != "" && !model.IsRole(*role) {
+						return auth.ErrInvalidRole
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					secret, err := readPassword(*password)
+					if err != nil {
+						return err
+					}
+					user, err := auth.Register(model.UserRequest{Username: args[0], Email: args[1], Password: secret})
+					if err != nil {
+						return err
+					}
+					if *role != "" && *role != user.Role {
+						if user, err = auth.SetRole(user.ID, *role); err != nil {
+							return err
+						}
+					}
+
+					fmt.Printf("Created user %s with ID %d and role %s\n", user.Username, user.ID, user.Role)
+					return nil
+				}
+			},
+		},
+		{
+			name:    "passwd",
+			args:    "USERNAME",
+			summary: "Change the password of an account and end its sessions. The password is read from the standard input unless -password is given.",
+			setup: func(fs *flag.FlagSet) runner {
+				password := fs.String("password", "", "The new password, visible to other users of the machine, prefer the standard input.")
+				return func(a *app, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					user, err := findUser(args[0])
+					if err != nil {
+						return err
+					}
+					secret, err := readPassword(*password)
+					if err != nil {
+						return err
+					}
+					if _, err := auth.SetPassword(user.ID, secret); err != nil {
+						return err
+					}
+
+					fmt.Printf("Changed the password of %s\n", user.Username)
+					return nil
+				}
+			},
+		},
+		{
+			name:    "list",
+			summary: "List the accounts.",
+			setup: func(fs *flag.FlagSet) runner {
+				return func(a *app, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					if _, err := a.database(); err != nil {
+						return err
+					}
+
+					users, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+						return db.Order("id")
+					})
+					if err != nil {
+						return err
+					}
+
+					rows := [][]string{{"ID", "USERNAME", "EMAIL", "ROLE", "CREATED"}}
+					for _, user := range users.ToSlice() {
+						rows = append(rows, []string{
+							strconv.FormatUint(uint64(user.ID), 10), user.Username, user.Email, user.Role,
+							user.CreatedAt.Format("2006-01-02"),
+						})
+					}
+					return table(rows)
+				}
+			},
+		},
+	},
+}
+
+func findUser(username string) (*model.User, error) {
+	found, err := repo.UserRepository.FindByQuery(func(db *gorm.DB) *gorm.DB {
+		return db.Where("username = ?", username)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found.Size() == 0 {
+		return nil, fmt.Errorf("no user named %s", username)
+	}
+	user := found.ToSlice()[0]
+	return &user, nil
+}
+
+// readPassword returns the password of the flag, or else the first line of the standard input.
+func readPassword(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		if err != nil {
+			return "", fmt.Errorf("reading the password: %w", err)
+		}
+		return "", errors.New("the password is empty")
+	}
+	return line, nil
+}
@@ -1,9 +1,15 @@
 package utils
 
+import (
+	"path/filepath"
+	"strings"
+)
+
 func IsVideoFormat(filename string) bool {
-	videoFormats := []string{".mp4", ".avi", ".mkv", ".mov", ".flv", ".wmv"}
+	videoFormats := []string{".mp4", ".m4v", ".avi", ".mkv", ".mov", ".flv", ".wmv", ".webm"}
+	ext := strings.ToLower(filepath.Ext(filename))
 	for _, format := range videoFormats {
-		if format == filename[len(filename)-len(format):] {
+		if format == ext {
 			return true
 		}
 	}
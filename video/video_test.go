@@ -0,0 +1,55 @@
+package videostream
+
+import (
+	"errors"
+	"go-cinema/stream/streamtest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	path := streamtest.WriteFile(t, t.TempDir())
+	entrypoints := map[string]func(http.ResponseWriter, *http.Request, string) error{
+		"StreamVideo": StreamVideo,
+		"NetHttp":     NetHttp,
+	}
+	for name, serve := range entrypoints {
+		t.Run(name, func(t *testing.T) {
+			streamtest.Run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := serve(w, r, path); err != nil {
+					t.Error(err)
+				}
+			}), "/video")
+		})
+	}
+}
+
+func TestStreamVideoErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "folder.mkv"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		status int
+		err    error
+	}{
+		{"notes.txt", http.StatusUnsupportedMediaType, ErrUnsupportedFormat},
+		{"missing.mkv", http.StatusNotFound, ErrFileNotFound},
+		{"folder.mkv", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		err := StreamVideo(w, httptest.NewRequest(http.MethodGet, "/video", nil), filepath.Join(dir, c.name))
+		if w.Code != c.status || err == nil || (c.err != nil && !errors.Is(err, c.err)) {
+			t.Errorf("%s: %d, %v", c.name, w.Code, err)
+		}
+	}
+}